@@ -0,0 +1,13 @@
+// Command zdp manages the lifecycle of Zylisp design documents. See
+// README.md for the commands.
+package main
+
+import (
+	"os"
+
+	"github.com/zylisp/design/pkg/zdp"
+)
+
+func main() {
+	zdp.Main(os.Args[1:])
+}
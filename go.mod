@@ -0,0 +1,3 @@
+module github.com/zylisp/design
+
+go 1.22
@@ -0,0 +1,83 @@
+package zdp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchSizes are the corpus sizes each benchmark runs against
+var benchSizes = []int{1000, 10000}
+
+// benchCorpora caches the synthetic corpora by size, since writing 10k
+// documents takes longer than most of the operations measured on them
+var benchCorpora = map[int]string{}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for _, dir := range benchCorpora {
+		os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+// benchCorpus returns a directory holding a synthetic corpus of n documents
+func benchCorpus(b *testing.B, n int) string {
+	if dir, ok := benchCorpora[n]; ok {
+		return dir
+	}
+	dir, err := os.MkdirTemp("", "zdp-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchCorpora[n] = dir
+	if err := writeBenchCorpus(dir, n); err != nil {
+		b.Fatalf("writing a %d document corpus: %v", n, err)
+	}
+	return dir
+}
+
+// runBenchmark times op over each corpus size, calling reset with the
+// timer stopped before every run
+func runBenchmark(b *testing.B, op string, reset func(b *testing.B, dir string)) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			dir := benchCorpus(b, n)
+			savedConfig, savedRoot, savedStdout := config, repoRoot, stdout
+			defer func() { config, repoRoot, stdout = savedConfig, savedRoot, savedStdout }()
+			setConfig(defaultConfig())
+			repoRoot, stdout = dir, io.Discard
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if reset != nil {
+					b.StopTimer()
+					reset(b, dir)
+					b.StartTimer()
+				}
+				if err := runBenchOperation(context.Background(), op); err != nil {
+					b.Fatalf("%s: %v", op, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUpdateIndex(b *testing.B) {
+	runBenchmark(b, "update-index", func(b *testing.B, dir string) {
+		if err := os.WriteFile(filepath.Join(dir, config.IndexFile), []byte(benchIndex), 0644); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkValidate(b *testing.B) {
+	runBenchmark(b, "validate", nil)
+}
+
+func BenchmarkSearch(b *testing.B) {
+	runBenchmark(b, "search", nil)
+}
@@ -0,0 +1,490 @@
+package zdp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blindMappingFile is the sealed mapping written into a blinded bundle
+const blindMappingFile = "mapping.sealed"
+
+// blindFields are the frontmatter fields a blinded copy keeps; the rest,
+// including author, champion, and dates, could identify the authors
+var blindFields = []string{"title", "state", "type", "component", "tags"}
+
+// blindEntry links a blinded copy to the document it came from
+type blindEntry struct {
+	ID       string   `json:"id"`
+	File     string   `json:"file"`
+	Number   string   `json:"number"`
+	Path     string   `json:"path"`
+	Title    string   `json:"title"`
+	Authors  []string `json:"authors"`
+	Champion string   `json:"champion,omitempty"`
+}
+
+// blindMapping is the content of a sealed mapping file
+type blindMapping struct {
+	Created   string       `json:"created"`
+	Documents []blindEntry `json:"documents"`
+}
+
+// sealedFile is a sealed mapping: the content encrypted with AES-GCM
+// under a random key, and that key encrypted with RSA-OAEP for each
+// maintainer's public key
+type sealedFile struct {
+	Version    int             `json:"version"`
+	Recipients []sealRecipient `json:"recipients"`
+	Nonce      string          `json:"nonce"`
+	Ciphertext string          `json:"ciphertext"`
+}
+
+// sealRecipient is the content key encrypted for one maintainer
+type sealRecipient struct {
+	Fingerprint string `json:"fingerprint"`
+	Key         string `json:"key"`
+}
+
+// blindCommand parses the arguments of "blind <doc|number>... [--out dir]"
+// and "blind unseal <mapping.sealed> --key private.pem [--format text|json]"
+func blindCommand(args []string) error {
+	if len(args) > 0 && args[0] == "unseal" {
+		return unsealCommand(args[1:])
+	}
+
+	usage := "Usage: zdp blind <doc.md|number>... [--out dir]"
+	outDir := "blind-review"
+	var targets []string
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			outDir = value
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			return errorf(exitUsage, "%s", usage)
+		}
+		targets = append(targets, args[i])
+	}
+	if len(targets) == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+	if len(config.MaintainerKeys) == 0 {
+		return errorf(exitUsage, "No maintainer keys to seal the mapping with; list their public keys under review.maintainer-keys in .zdp.yaml")
+	}
+
+	var docs []*Document
+	for _, target := range targets {
+		doc, err := findDocument(target)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := blindBundle(docs, outDir); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Wrote %d blinded document(s) to %s/\n", len(docs), outDir)
+	fmt.Fprintf(stdout, "Sealed the mapping for %d maintainer key(s) in %s\n", len(config.MaintainerKeys), filepath.Join(outDir, blindMappingFile))
+	return nil
+}
+
+// blindBundle writes a blinded copy of each document to outDir, in random
+// order under the IDs A, B, C, ..., and seals the mapping back to the
+// documents for the maintainers. outDir must be new or empty.
+func blindBundle(docs []*Document, outDir string) error {
+	var keys []*rsa.PublicKey
+	for _, path := range config.MaintainerKeys {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return errorf(exitEnvironment, "review.maintainer-keys: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if entries, err := os.ReadDir(repoPath(outDir)); err == nil && len(entries) > 0 {
+		return errorf(exitConflict, "Refusing to write into %s: it is not empty", outDir)
+	}
+	if err := os.MkdirAll(repoPath(outDir), 0755); err != nil {
+		return errorf(exitEnvironment, "Failed to create %s: %v", outDir, err)
+	}
+
+	// Shuffle, so the order of IDs says nothing about numbers or dates
+	shuffled := append([]*Document{}, docs...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to shuffle documents: %v", err)
+		}
+		j := int(n.Int64())
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+
+	mapping := blindMapping{Created: time.Now().Format("2006-01-02")}
+	for i, doc := range shuffled {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		id := blindID(i)
+		entry := blindEntry{ID: id, File: "proposal-" + id + ".md", Number: doc.Number, Path: filepath.ToSlash(doc.Path),
+			Title: displayTitle(doc.Title), Authors: append([]string{}, doc.Authors...)}
+		if champion := doc.Fields["champion"]; champion != "" && !strings.EqualFold(champion, "none") {
+			entry.Champion = champion
+		}
+
+		path := filepath.Join(outDir, entry.File)
+		if err := os.WriteFile(repoPath(path), []byte(blindDocument(doc, string(content), id)), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to write %s: %v", path, err)
+		}
+		opResult.recordWrite(path)
+		mapping.Documents = append(mapping.Documents, entry)
+	}
+
+	plain, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to encode the mapping: %v", err)
+	}
+	sealed, err := seal(plain, keys)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to seal the mapping: %v", err)
+	}
+	path := filepath.Join(outDir, blindMappingFile)
+	if err := os.WriteFile(repoPath(path), sealed, 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", path, err)
+	}
+	opResult.recordWrite(path)
+	return nil
+}
+
+// blindID returns the ID of the i-th blinded document: A to Z, then AA,
+// AB, and so on
+func blindID(i int) string {
+	id := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		id = string(rune('A'+(i-1)%26)) + id
+	}
+	return id
+}
+
+// blindDocument returns a copy of a document for anonymous review. Only
+// the fields in blindFields are kept. In the title and body, the names,
+// emails, and handles of its authors and champion become "[author]", its
+// number and file name become its blinded ID, and HTML comments, which
+// often carry notes to self, are dropped.
+func blindDocument(doc *Document, content, id string) string {
+	body := frontmatterRe.ReplaceAllString(content, "")
+	body = regexp.MustCompile(`(?s)<!--.*?-->\n?`).ReplaceAllString(body, "")
+
+	var people []string
+	people = append(people, doc.Authors...)
+	if champion := doc.Fields["champion"]; champion != "" && !strings.EqualFold(champion, "none") {
+		people = append(people, champion)
+	}
+	var identifiers []string
+	for _, person := range people {
+		p := parsePerson(person)
+		for _, s := range []string{p.Email, p.Handle, strings.TrimPrefix(p.Handle, "@"), p.Name} {
+			if len(s) > 1 && !strings.EqualFold(s, "unknown") && !containsString(identifiers, s) {
+				identifiers = append(identifiers, s)
+			}
+		}
+	}
+	replacements := map[string]string{}
+	for _, s := range identifiers {
+		replacements[strings.ToLower(s)] = "[author]"
+	}
+	replacements[strings.ToLower(strings.TrimSuffix(filepath.Base(doc.Path), ".md"))] = "proposal-" + id
+
+	redact := func(text string) string {
+		text = replaceWords(text, replacements)
+		number := regexp.MustCompile(`\[\[0*` + strings.TrimLeft(doc.Number, "0") + `(\|[^\]\n]*)?\]\]|\b` + doc.Number + `\b`)
+		return number.ReplaceAllString(text, "Proposal "+id)
+	}
+
+	header := []string{"---"}
+	for _, field := range blindFields {
+		value := doc.Fields[field]
+		if field == "title" {
+			value = `"` + strings.ReplaceAll(redact(displayTitle(doc.Title)), `"`, `\"`) + `"`
+		}
+		if value != "" {
+			header = append(header, field+": "+value)
+		}
+	}
+	header = append(header, "blind-id: "+id, "---", "")
+	return strings.Join(header, "\n") + strings.TrimLeft(redact(body), "\n")
+}
+
+// replaceWords replaces every occurrence in text of a key of
+// replacements, ignoring case, with its value. A key only matches where
+// it isn't part of a longer word, and doesn't follow "@" or ".", so that
+// a name inside an email or a handle is left to the longer identifier.
+// The text is scanned once, left to right, so a replacement is never
+// matched again, even when it contains a key, and the longest key wins
+// where several start at the same place.
+func replaceWords(text string, replacements map[string]string) string {
+	var keys []string
+	for key := range replacements {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if i == 0 || !isWordByte(text[i-1]) && !strings.ContainsRune("@.-", rune(text[i-1])) {
+			matched := false
+			for _, key := range keys {
+				end := i + len(key)
+				if end <= len(text) && strings.EqualFold(text[i:end], key) && (end == len(text) || !isWordByte(text[end]) && text[end] != '-') {
+					out.WriteString(replacements[key])
+					i, matched = end, true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+		out.WriteByte(text[i])
+		i++
+	}
+	return out.String()
+}
+
+// isWordByte reports whether c is a letter, digit, or underscore, like
+// \w in a regular expression
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// loadPublicKey reads an RSA public key from a PEM file, as written by
+// "openssl rsa -pubout" (PKIX) or in PKCS #1 form
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(repoPath(path))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: not a PEM file", path)
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return rsaKey, nil
+}
+
+// loadPrivateKey reads an RSA private key from a PEM file in PKCS #1 or
+// PKCS #8 form
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(repoPath(path))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: not a PEM file", path)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+	return rsaKey, nil
+}
+
+// keyFingerprint identifies a public key by the SHA-256 of its PKIX form
+func keyFingerprint(key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// seal encrypts plain so that any of the given keys can open it
+func seal(plain []byte, keys []*rsa.PublicKey) ([]byte, error) {
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := sealedFile{
+		Version:    1,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plain, nil)),
+	}
+	for _, key := range keys {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, contentKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Recipients = append(sealed.Recipients, sealRecipient{Fingerprint: keyFingerprint(key), Key: base64.StdEncoding.EncodeToString(wrapped)})
+	}
+	out, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// unseal opens a sealed file with a maintainer's private key
+func unseal(data []byte, key *rsa.PrivateKey) ([]byte, error) {
+	var sealed sealedFile
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("not a sealed mapping: %v", err)
+	}
+	if sealed.Version != 1 {
+		return nil, fmt.Errorf("unsupported sealed mapping version %d", sealed.Version)
+	}
+
+	fingerprint := keyFingerprint(&key.PublicKey)
+	var contentKey []byte
+	for _, r := range sealed.Recipients {
+		if r.Fingerprint != fingerprint {
+			continue
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		if contentKey, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, wrapped, nil); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if contentKey == nil {
+		return nil, fmt.Errorf("the mapping was not sealed for this key (%s)", fingerprint)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed nonce")
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// unsealCommand parses the arguments of "blind unseal <mapping.sealed>
+// --key private.pem [--format text|json]"
+func unsealCommand(args []string) error {
+	usage := "Usage: zdp blind unseal <mapping.sealed> --key private.pem [--format text|json]"
+	var path, keyPath string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--key"); err != nil {
+			return err
+		} else if ok {
+			keyPath = value
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || path != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		path = args[i]
+	}
+	if path == "" || keyPath == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read the private key: %v", err)
+	}
+	data, err := os.ReadFile(repoPath(path))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read %s: %v", path, err)
+	}
+	plain, err := unseal(data, key)
+	if err != nil {
+		return errorf(exitConflict, "Failed to unseal %s: %v", path, err)
+	}
+	var mapping blindMapping
+	if err := json.Unmarshal(plain, &mapping); err != nil {
+		return errorf(exitEnvironment, "Failed to read the mapping: %v", err)
+	}
+
+	if asJSON {
+		return printJSON(mapping)
+	}
+	fmt.Fprintf(stdout, "Blinded on %s:\n", mapping.Created)
+	for _, e := range mapping.Documents {
+		by := strings.Join(e.Authors, ", ")
+		if e.Champion != "" {
+			by += "; champion " + e.Champion
+		}
+		fmt.Fprintf(stdout, "  %-4s %s  %s (%s)\n", e.ID, e.Number, e.Title, by)
+	}
+	return nil
+}
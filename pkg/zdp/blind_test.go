@@ -0,0 +1,35 @@
+package zdp
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBlindDocument checks the redaction of a document's authors,
+// including identifiers that appear in the replacement text itself
+func TestBlindDocument(t *testing.T) {
+	doc := &Document{
+		Path:    "01-draft/0042-tail-calls.md",
+		Number:  "0042",
+		Title:   `"Tail calls by Ada Lovelace"`,
+		Authors: []string{"Ada Lovelace <ada@example.com>", "Author (@author)"},
+		Fields:  map[string]string{"title": `"Tail calls by Ada Lovelace"`, "state": "Draft"},
+	}
+	content := "---\ntitle: \"Tail calls by Ada Lovelace\"\n---\n\n# Tail calls\n\n" +
+		"Written by Ada Lovelace and @author (ada@example.com, Author).\n" +
+		"Ada Lovelace Ada Lovelace, author author. See 0042-tail-calls and [[42]].\n" +
+		"Adapters and ada.lovelace stay.\n"
+
+	got := blindDocument(doc, content, "B")
+
+	for _, want := range []string{
+		`title: "Tail calls by [author]"`,
+		"Written by [author] and [author] ([author], [author]).",
+		"[author] [author], [author] [author]. See proposal-B and Proposal B.",
+		"Adapters and ada.lovelace stay.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
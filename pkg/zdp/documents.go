@@ -0,0 +1,1263 @@
+package zdp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Document is a design document's location and frontmatter metadata
+type Document struct {
+	Path    string
+	Number  string
+	Title   string
+	State   string
+	Author  string   // the author field, or the names in authors
+	Authors []string // each person in authors, or in author
+	Created string
+	Updated string
+	Fields  map[string]string      // every frontmatter field, raw
+	Meta    map[string]interface{} // custom (non-core) fields, decoded
+}
+
+// frontmatterRe matches the YAML frontmatter block, capturing its contents
+var frontmatterRe = regexp.MustCompile(`(?s)^---\n(.*?)\n---\n`)
+
+// maxFrontmatterBytes bounds how far readFrontmatter looks for the end
+// of the header, so a document missing its closing --- isn't read whole
+const maxFrontmatterBytes = 1 << 20
+
+// readFrontmatter reads only the frontmatter block at the start of a
+// file, for metadata operations that don't need the body. It returns ""
+// when the file does not start with "---".
+func readFrontmatter(path string) (string, error) {
+	f, err := os.Open(repoPath(path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var header strings.Builder
+	for header.Len() < maxFrontmatterBytes {
+		line, err := r.ReadString('\n')
+		first := header.Len() == 0
+		header.WriteString(line)
+		if first && line != "---\n" {
+			return "", nil
+		}
+		if !first && line == "---\n" {
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return header.String(), nil
+}
+
+// scanLines calls fn with each line of a file and its 1-based number,
+// reading through a buffer rather than loading the file at once. It stops
+// early when fn returns false.
+func scanLines(path string, fn func(n int, line string) bool) error {
+	f, err := os.Open(repoPath(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxFrontmatterBytes*16)
+	for n := 1; scanner.Scan(); n++ {
+		if !fn(n, scanner.Text()) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// frontmatterBlockRe matches a frontmatter block, including an empty one
+var frontmatterBlockRe = regexp.MustCompile(`(?s)^---\n(.*?\n)?---\n`)
+
+// frontmatterEntry is one top-level field of a frontmatter block as raw
+// lines: the comments and blank lines before it, its "key: value" line,
+// and any indented lines of a nested or multiline value. Comments after
+// the last field form an entry with an empty key.
+type frontmatterEntry struct {
+	Key   string
+	Lines []string
+}
+
+// frontmatterBlock is a document's frontmatter kept line for line, so
+// edits change only the fields they touch and leave the order, comments,
+// and unknown fields of the rest as written
+type frontmatterBlock struct {
+	entries []frontmatterEntry
+}
+
+// isTopLevelYAMLKey reports whether a frontmatter line starts a field
+func isTopLevelYAMLKey(line string) (string, bool) {
+	if line == "" || strings.ContainsRune(" \t-#", rune(line[0])) {
+		return "", false
+	}
+	key, _, ok := splitYAMLPair(stripYAMLComment(line))
+	return key, ok
+}
+
+// parseFrontmatterBlock splits content into its frontmatter block and the
+// body that follows it
+func parseFrontmatterBlock(content string) (*frontmatterBlock, string, error) {
+	m := frontmatterBlockRe.FindStringSubmatchIndex(content)
+	if m == nil {
+		return nil, content, fmt.Errorf("could not find YAML frontmatter")
+	}
+
+	block := &frontmatterBlock{}
+	var pending []string
+	if m[2] >= 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(content[m[2]:m[3]], "\n"), "\n") {
+			if key, ok := isTopLevelYAMLKey(line); ok {
+				block.entries = append(block.entries, frontmatterEntry{Key: key, Lines: append(pending, line)})
+				pending = nil
+				continue
+			}
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") || len(block.entries) == 0 {
+				pending = append(pending, line)
+				continue
+			}
+			last := &block.entries[len(block.entries)-1]
+			last.Lines = append(append(last.Lines, pending...), line)
+			pending = nil
+		}
+	}
+	if len(pending) > 0 {
+		block.entries = append(block.entries, frontmatterEntry{Lines: pending})
+	}
+	return block, content[m[1]:], nil
+}
+
+// text returns the block's YAML without the --- delimiters
+func (b *frontmatterBlock) text() string {
+	var lines []string
+	for _, e := range b.entries {
+		lines = append(lines, e.Lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// String renders the block with its --- delimiters
+func (b *frontmatterBlock) String() string {
+	if text := b.text(); text != "" {
+		return "---\n" + text + "\n---\n"
+	}
+	return "---\n---\n"
+}
+
+// index returns the position of key's entry, or -1
+func (b *frontmatterBlock) index(key string) int {
+	for i, e := range b.entries {
+		if e.Key == key && key != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// raw returns a field's value as written: the text after "key:" for
+// inline values, or a flow rendering of a nested or multiline value
+func (b *frontmatterBlock) raw(key string) (string, bool) {
+	i := b.index(key)
+	if i < 0 {
+		return "", false
+	}
+	for _, line := range b.entries[i].Lines {
+		if k, ok := isTopLevelYAMLKey(line); ok && k == key {
+			_, value, _ := splitYAMLPair(stripYAMLComment(line))
+			if value != "" && value != "|" && value != ">" && value != "|-" && value != ">-" {
+				return value, true
+			}
+			break
+		}
+	}
+
+	doc, err := parseConfigYAML(strings.Join(b.entries[i].Lines, "\n"))
+	if err != nil {
+		return "", true
+	}
+	return yamlFlow(doc[key]), true
+}
+
+// set gives key the raw YAML value, keeping the field's place, preceding
+// comments, and trailing comment. A new core field goes after the core
+// fields that precede it in coreFields; other new fields go last.
+func (b *frontmatterBlock) set(key, value string) {
+	line := key + ": " + value
+	if i := b.index(key); i >= 0 {
+		e := &b.entries[i]
+		for j, l := range e.Lines {
+			if k, ok := isTopLevelYAMLKey(l); ok && k == key {
+				comment := ""
+				if stripped := stripYAMLComment(l); strings.Contains(l[len(stripped):], "#") {
+					comment = l[len(stripped):]
+				}
+				e.Lines = append(e.Lines[:j:j], line+comment)
+				return
+			}
+		}
+	}
+
+	pos := len(b.entries)
+	if pos > 0 && b.entries[pos-1].Key == "" {
+		pos--
+	}
+	if rank := coreFieldRank(key); rank >= 0 {
+		pos = 0
+		for i, e := range b.entries {
+			if r := coreFieldRank(e.Key); r >= 0 && r < rank {
+				pos = i + 1
+			}
+		}
+	}
+	entry := frontmatterEntry{Key: key, Lines: []string{line}}
+	b.entries = append(b.entries[:pos], append([]frontmatterEntry{entry}, b.entries[pos:]...)...)
+}
+
+// appendItem adds a mapping, given as "field: value" lines, to the end of
+// key's block sequence, adding the field when it is missing. A sequence
+// written in flow style is rewritten as a block first.
+func (b *frontmatterBlock) appendItem(key string, fields []string) {
+	var item []string
+	for i, field := range fields {
+		if i == 0 {
+			item = append(item, "  - "+field)
+		} else {
+			item = append(item, "    "+field)
+		}
+	}
+
+	i := b.index(key)
+	if i < 0 {
+		b.set(key, "")
+		i = b.index(key)
+		b.entries[i].Lines = []string{key + ":"}
+	}
+	e := &b.entries[i]
+	for j, l := range e.Lines {
+		k, ok := isTopLevelYAMLKey(l)
+		if !ok || k != key {
+			continue
+		}
+		if _, value, _ := splitYAMLPair(stripYAMLComment(l)); value != "" {
+			// Rewrite [{date: ..., to: ...}] as block items
+			var items []string
+			if doc, err := parseConfigYAML(strings.Join(e.Lines[j:], "\n")); err == nil {
+				list, _ := doc[key].([]interface{})
+				for _, entry := range list {
+					m, _ := entry.(map[string]interface{})
+					var keys []string
+					for field := range m {
+						keys = append(keys, field)
+					}
+					sort.Strings(keys)
+					for n, field := range keys {
+						prefix := "    "
+						if n == 0 {
+							prefix = "  - "
+						}
+						items = append(items, prefix+field+": "+yamlFlowItem(m[field]))
+					}
+				}
+			}
+			e.Lines = append(e.Lines[:j:j], key+":")
+			e.Lines = append(e.Lines, items...)
+		}
+		break
+	}
+	e.Lines = append(e.Lines, item...)
+}
+
+// coreFieldRank returns the position of key in coreFields, or -1
+func coreFieldRank(key string) int {
+	for i, field := range coreFields {
+		if field == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlFlow renders a decoded YAML value on one line: sequences as
+// [a, b], mappings as {k: v}, and multiline strings quoted
+func yamlFlow(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, yamlFlowItem(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var pairs []string
+		for _, k := range keys {
+			pairs = append(pairs, yamlFlowItem(k)+": "+yamlFlowItem(v[k]))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case string:
+		if v = strings.TrimRight(v, "\n"); strings.Contains(v, "\n") {
+			return strconv.Quote(v)
+		}
+		return v
+	}
+	return ""
+}
+
+// yamlFlowItem renders a value inside a flow collection, quoting strings
+// that would otherwise end or split the item
+func yamlFlowItem(value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		return yamlFlow(value)
+	}
+	if s == "" || strings.ContainsAny(s, ",[]{}:#\"'\n") || strings.TrimSpace(s) != s {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// parseYAML reads a document's frontmatter into a map of field values.
+// Scalars are decoded, so quoted values lose their quotes; lists keep
+// their flow text, and nested and multiline values are rendered on one
+// line (see yamlFlow). customMetadata decodes them fully.
+func parseYAML(content string) (map[string]string, error) {
+	block, _, err := parseFrontmatterBlock(content)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseConfigYAML(block.text())
+	if err != nil {
+		// The block starts after the opening --- on line 1 of the file
+		if e, ok := err.(*yamlError); ok {
+			e.Line++
+		}
+		return nil, fmt.Errorf("invalid YAML frontmatter: %w", err)
+	}
+
+	metadata := make(map[string]string)
+	for _, e := range block.entries {
+		if e.Key == "" {
+			continue
+		}
+		value, scalar := parsed[e.Key].(string)
+		if value = strings.TrimRight(value, "\n"); scalar && !strings.Contains(value, "\n") {
+			metadata[e.Key] = value
+		} else {
+			metadata[e.Key], _ = block.raw(e.Key)
+		}
+	}
+	return metadata, nil
+}
+
+// updateYAML updates the state and updated fields in YAML frontmatter
+func updateYAML(content, newState string) (string, error) {
+	block, body, err := parseFrontmatterBlock(content)
+	if err != nil {
+		return content, err
+	}
+	block.set("state", newState)
+	block.set("updated", time.Now().Format("2006-01-02"))
+	return block.String() + body, nil
+}
+
+// setFrontmatterField sets key to value in a document's YAML frontmatter,
+// appending the field when it is missing. The body is left untouched.
+func setFrontmatterField(content, key, value string) (string, error) {
+	block, body, err := parseFrontmatterBlock(content)
+	if err != nil {
+		return content, err
+	}
+	block.set(key, value)
+	return block.String() + body, nil
+}
+
+// extractDocMetadata extracts number, title, state, and updated date from a document
+func extractDocMetadata(docPath string) (*Document, error) {
+	if docCache != nil {
+		return docCache.get(docPath)
+	}
+	header, err := readFrontmatter(docPath)
+	if err != nil {
+		return nil, err
+	}
+	return docMetadataFromContent(docPath, header)
+}
+
+// docCache, when set, keeps parsed metadata between commands run in one
+// process, such as a repl session
+var docCache *metadataCache
+
+// metadataCache holds documents' metadata, keyed by absolute path and
+// valid while the file's size and modification time are unchanged
+type metadataCache struct {
+	entries map[string]cachedDoc
+}
+
+// cachedDoc is a cached document and the file it was read from
+type cachedDoc struct {
+	size    int64
+	modTime time.Time
+	doc     *Document
+}
+
+// get returns a copy of a document's cached metadata, reading the file
+// again if it changed. Callers may edit the copy's fields freely.
+func (c *metadataCache) get(docPath string) (*Document, error) {
+	abs, err := filepath.Abs(repoPath(docPath))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(repoPath(docPath))
+	if err != nil {
+		delete(c.entries, abs)
+		return nil, err
+	}
+	entry, ok := c.entries[abs]
+	if !ok || entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		header, err := readFrontmatter(docPath)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := docMetadataFromContent(docPath, header)
+		if err != nil {
+			return nil, err
+		}
+		entry = cachedDoc{size: info.Size(), modTime: info.ModTime(), doc: doc}
+		c.entries[abs] = entry
+	}
+
+	doc := *entry.doc
+	doc.Path = docPath
+	doc.Authors = append([]string(nil), doc.Authors...)
+	doc.Fields = make(map[string]string, len(entry.doc.Fields))
+	for k, v := range entry.doc.Fields {
+		doc.Fields[k] = v
+	}
+	doc.Meta = make(map[string]interface{}, len(entry.doc.Meta))
+	for k, v := range entry.doc.Meta {
+		doc.Meta[k] = v
+	}
+	return &doc, nil
+}
+
+// docMetadataFromContent builds a document's metadata from content already read
+func docMetadataFromContent(docPath, content string) (*Document, error) {
+	metadata, err := parseYAML(content)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := metaList(metadata["authors"])
+	if len(authors) == 0 {
+		authors = metaList(metadata["author"])
+	}
+	author := metadata["author"]
+	if author == "" && len(authors) > 0 {
+		var names []string
+		for _, person := range authors {
+			names = append(names, parsePerson(person).Name)
+		}
+		author = strings.Join(names, ", ")
+	}
+
+	return &Document{
+		Path:    docPath,
+		Number:  metadata["number"],
+		Title:   metadata["title"],
+		State:   metadata["state"],
+		Author:  author,
+		Authors: authors,
+		Created: metadata["created"],
+		Updated: metadata["updated"],
+		Fields:  metadata,
+		Meta:    customMetadata(content, metadata),
+	}, nil
+}
+
+// customMetadata decodes the frontmatter fields outside coreFields.
+// Nested lists and mappings are decoded when the frontmatter parses as
+// YAML; otherwise each field falls back to its raw one-line value.
+func customMetadata(content string, fields map[string]string) map[string]interface{} {
+	meta := make(map[string]interface{})
+	if m := frontmatterRe.FindStringSubmatch(content); m != nil {
+		if doc, err := parseConfigYAML(m[1]); err == nil {
+			for key, value := range doc {
+				if !isCoreField(key) {
+					meta[key] = value
+				}
+			}
+			return meta
+		}
+	}
+
+	for key, raw := range fields {
+		if !isCoreField(key) {
+			meta[key] = displayTitle(raw)
+		}
+	}
+	return meta
+}
+
+// scanDocuments loads the metadata of every document in the state
+// directories, ordered by path. Unreadable documents are reported as
+// warnings and skipped.
+func scanDocuments() []*Document {
+	return scanDocumentsIn(".")
+}
+
+// scanDocumentsIn is scanDocuments for the repository at root
+func scanDocumentsIn(root string) []*Document {
+	var docs []*Document
+	for _, dir := range sortedStateDirs() {
+		files, err := os.ReadDir(repoPath(filepath.Join(root, dir)))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".md") {
+				continue
+			}
+			docPath := filepath.Join(root, dir, file.Name())
+			meta, err := extractDocMetadata(docPath)
+			if err != nil {
+				skipDocument(docPath, err)
+				continue
+			}
+			docs = append(docs, meta)
+		}
+	}
+	return docs
+}
+
+// skippedDocs lists the documents that could not be parsed during this
+// run, in the order they were found; skippedErrs holds their errors
+var (
+	skippedDocs []string
+	skippedErrs = map[string]error{}
+)
+
+// skipDocument records a document that could not be parsed, so the
+// command can carry on with the others. Each document is recorded once.
+func skipDocument(docPath string, err error) {
+	if _, seen := skippedErrs[docPath]; seen {
+		return
+	}
+	skippedDocs = append(skippedDocs, docPath)
+	skippedErrs[docPath] = err
+	warn("Skipped %s: %v", docPath, err)
+}
+
+// reportSkippedDocs prints every document skipped during the run, so
+// all parse errors are seen together rather than stopping at the first
+func reportSkippedDocs() {
+	if len(skippedDocs) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "⚠ Skipped %d document(s) that could not be parsed:\n", len(skippedDocs))
+	for _, docPath := range skippedDocs {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", docPath, skippedErrs[docPath])
+	}
+}
+
+// findDocument resolves a document argument given as a path, as a
+// document number such as "31" or "0031", or as a short ID such as
+// "tail-calls"
+func findDocument(arg string) (*Document, error) {
+	docPath, err := resolveDocPath(arg)
+	if err != nil {
+		return nil, err
+	}
+	return extractDocMetadata(docPath)
+}
+
+// docNumberArgRe matches a document given by number, such as "42" or "0042"
+var docNumberArgRe = regexp.MustCompile(`^\d{1,4}$`)
+
+// resolveDocPath resolves a document argument to its path. An existing
+// path is returned as is. A number is looked up by file name in the
+// state directories, then in the index, then by the number field of
+// each document's frontmatter. A short ID is looked up in the short-id
+// field of each document.
+func resolveDocPath(arg string) (string, error) {
+	if _, err := os.Stat(repoPath(arg)); err == nil {
+		return arg, nil
+	}
+	if shortIDRe.MatchString(arg) {
+		return shortIDPath(arg)
+	}
+	if !docNumberArgRe.MatchString(arg) {
+		return "", fmt.Errorf("no such document: %s", arg)
+	}
+	n, _ := strconv.Atoi(arg)
+	if n <= 0 {
+		return "", fmt.Errorf("no such document: %s", arg)
+	}
+	number := fmt.Sprintf("%04d", n)
+
+	var found []string
+	for _, dir := range sortedStateDirs() {
+		files, _ := os.ReadDir(repoPath(dir))
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".md") && extractNumberFromFilename(file.Name()) == number {
+				found = append(found, filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+	default:
+		return "", fmt.Errorf("several documents are numbered %s: %s", number, strings.Join(found, ", "))
+	}
+
+	if idx, _, err := loadIndex(config.IndexFile); err == nil {
+		for _, section := range idx.Sections {
+			for _, link := range section.Links {
+				if link.Number != number {
+					continue
+				}
+				if _, err := os.Stat(repoPath(link.Path)); err == nil {
+					return link.Path, nil
+				}
+			}
+		}
+	}
+	for _, doc := range scanDocuments() {
+		if doc.Number == number {
+			return doc.Path, nil
+		}
+	}
+	return "", fmt.Errorf("no document numbered %s", number)
+}
+
+// shortIDRe matches a short ID: lowercase words joined by hyphens. It
+// starts with a letter, so it can't be taken for a number.
+var shortIDRe = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// shortIDStopWords are left out of generated short IDs
+var shortIDStopWords = []string{"a", "an", "and", "for", "in", "of", "on", "or", "the", "to", "with"}
+
+// docShortID returns a document's short ID, or ""
+func docShortID(doc *Document) string {
+	return strings.TrimSpace(doc.Fields["short-id"])
+}
+
+// shortIDPath returns the path of the document with the given short ID
+func shortIDPath(id string) (string, error) {
+	var found []string
+	for _, doc := range scanDocuments() {
+		if docShortID(doc) == id {
+			found = append(found, doc.Path)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no such document: %s", id)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("several documents have the short ID %s: %s", id, strings.Join(found, ", "))
+}
+
+// takenShortIDs returns the short IDs the documents use
+func takenShortIDs(docs []*Document) map[string]bool {
+	taken := make(map[string]bool)
+	for _, doc := range docs {
+		if id := docShortID(doc); id != "" {
+			taken[id] = true
+		}
+	}
+	return taken
+}
+
+// generateShortID makes a short ID from a document's slug: its first two
+// words other than stop words, then more of its words, then a numeric
+// suffix, until it is not taken
+func generateShortID(slug string, taken map[string]bool) string {
+	var words []string
+	for _, word := range strings.Split(strings.ToLower(slug), "-") {
+		if containsString(shortIDStopWords, word) || !shortIDRe.MatchString(strings.Join(append(words, word), "-")) {
+			continue
+		}
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		words = []string{"doc"}
+	}
+	for n := min(2, len(words)); n <= len(words); n++ {
+		if id := strings.Join(words[:n], "-"); !taken[id] {
+			return id
+		}
+	}
+	base := strings.Join(words, "-")
+	for i := 2; ; i++ {
+		if id := fmt.Sprintf("%s-%d", base, i); !taken[id] {
+			return id
+		}
+	}
+}
+
+// docSlug returns the slug of a document's file name: the name without
+// its number and extension
+func docSlug(docPath string) string {
+	name := strings.TrimSuffix(filepath.Base(docPath), ".md")
+	if hasNumberPrefix(name + ".md") {
+		if _, rest, ok := strings.Cut(name, "-"); ok {
+			return rest
+		}
+	}
+	return name
+}
+
+// shortIDsCommand parses the arguments of "short-ids [--assign]
+// [--format text|json]" and lists every document's short ID, first
+// giving one to each document without one when --assign is given
+func shortIDsCommand(args []string) error {
+	assign, asJSON := false, false
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if args[i] != "--assign" {
+			return errorf(exitUsage, "Usage: zdp short-ids [--assign] [--format text|json]")
+		}
+		assign = true
+	}
+
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	if assign {
+		taken := takenShortIDs(docs)
+		assigned := 0
+		for _, doc := range docs {
+			if docShortID(doc) != "" {
+				continue
+			}
+			content, err := os.ReadFile(repoPath(doc.Path))
+			if err != nil {
+				return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+			}
+			id := generateShortID(docSlug(doc.Path), taken)
+			updated, err := setFrontmatterField(string(content), "short-id", id)
+			if err != nil {
+				warn("Skipped %s: %v", doc.Path, err)
+				continue
+			}
+			if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+				return errorf(exitEnvironment, "Failed to update %s: %v", doc.Path, err)
+			}
+			opResult.recordFieldChanges(doc.Path, string(content), updated)
+			doc.Fields["short-id"] = id
+			taken[id] = true
+			assigned++
+		}
+		if !asJSON {
+			fmt.Fprintf(stdout, "Assigned %d short ID(s)\n\n", assigned)
+		}
+	}
+
+	if asJSON {
+		ids := []map[string]string{}
+		for _, doc := range docs {
+			ids = append(ids, map[string]string{"number": doc.Number, "short_id": docShortID(doc), "path": doc.Path})
+		}
+		return printJSON(ids)
+	}
+	for _, doc := range docs {
+		id := docShortID(doc)
+		if id == "" {
+			id = "-"
+		}
+		fmt.Fprintf(stdout, "%s  %-24s %s\n", doc.Number, id, displayTitle(doc.Title))
+	}
+	return nil
+}
+
+// docPathArg is resolveDocPath for command arguments, failing with a
+// usage error
+func docPathArg(arg string) (string, error) {
+	docPath, err := resolveDocPath(arg)
+	if err != nil {
+		return "", errorf(exitUsage, "%v", err)
+	}
+	return docPath, nil
+}
+
+// metaList splits a frontmatter list value such as "[Ada, Alan]" or
+// "Ada, Alan" into its items; "None" and empty values yield no items
+func metaList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "none") || value == "[]" {
+		return nil
+	}
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+	}
+
+	items, err := parseYAMLFlowSeq(value, 0)
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, item := range items {
+		if text, ok := item.(string); ok && text != "" {
+			result = append(result, text)
+		}
+	}
+	return result
+}
+
+// displayTitle returns a frontmatter title without its YAML quotes
+func displayTitle(title string) string {
+	if unquoted, err := unquoteYAML(title, 0); err == nil {
+		return unquoted
+	}
+	return title
+}
+
+// filenameNumberRe and filenameSlugRe match the number and the slug of a
+// document's file name
+var (
+	filenameNumberRe = regexp.MustCompile(`^(\d+)-`)
+	filenameSlugRe   = regexp.MustCompile(`^\d+-(.+)\.md$`)
+)
+
+// extractNumberFromFilename extracts and pads the number from a filename
+func extractNumberFromFilename(filename string) string {
+	matches := filenameNumberRe.FindStringSubmatch(filename)
+	if len(matches) > 1 {
+		// Pad to 4 digits
+		num := matches[1]
+		for len(num) < 4 {
+			num = "0" + num
+		}
+		return num
+	}
+	return "0000"
+}
+
+// extractTitleFromContent finds the first # heading or infers from filename
+func extractTitleFromContent(content, filename string) string {
+	// Look for first # heading
+	lines := strings.Split(content, "\n")
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "# ") {
+			return strings.TrimSpace(trimmed[2:])
+		}
+	}
+
+	// Infer from filename
+	matches := filenameSlugRe.FindStringSubmatch(filename)
+	if len(matches) > 1 {
+		return slugToTitle(matches[1])
+	}
+
+	return "Untitled Document"
+}
+
+// slugToTitle converts a filename slug like "macro-hygiene" to title case
+func slugToTitle(slug string) string {
+	return titleCase(strings.ReplaceAll(slug, "-", " "))
+}
+
+// hasYAMLFrontmatter checks if content has YAML frontmatter
+func hasYAMLFrontmatter(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "---\n")
+}
+
+// buildCompleteYAML constructs a complete YAML frontmatter block for a
+// document that has none
+func buildCompleteYAML(metadata map[string]string) string {
+	yaml := "---\n"
+	yaml += fmt.Sprintf("number: %s\n", metadata["number"])
+	yaml += fmt.Sprintf("title: \"%s\"\n", displayTitle(metadata["title"]))
+	if authors := metadata["authors"]; authors != "" {
+		yaml += fmt.Sprintf("authors: %s\n", authors)
+	} else {
+		yaml += fmt.Sprintf("author: %s\n", metadata["author"])
+	}
+	yaml += fmt.Sprintf("created: %s\n", metadata["created"])
+	yaml += fmt.Sprintf("updated: %s\n", metadata["updated"])
+	yaml += fmt.Sprintf("state: %s\n", metadata["state"])
+	yaml += fmt.Sprintf("supersedes: %s\n", metadata["supersedes"])
+	yaml += fmt.Sprintf("superseded-by: %s\n", metadata["superseded-by"])
+
+	// Optional fields such as target-release follow in a stable order
+	var optional []string
+	for key := range metadata {
+		if !isCoreField(key) && key != "authors" {
+			optional = append(optional, key)
+		}
+	}
+	sort.Slice(optional, func(i, j int) bool {
+		if (optional[i] == "target-release") != (optional[j] == "target-release") {
+			return optional[i] == "target-release"
+		}
+		return optional[i] < optional[j]
+	})
+	for _, key := range optional {
+		yaml += fmt.Sprintf("%s: %s\n", key, metadata[key])
+	}
+
+	yaml += "---\n\n"
+	return yaml
+}
+
+// coreFields are the frontmatter fields every document must carry
+var coreFields = []string{"number", "title", "author", "created", "updated", "state", "supersedes", "superseded-by"}
+
+// isCoreField reports whether key is one of coreFields
+func isCoreField(key string) bool {
+	for _, field := range coreFields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+// stateDocuments is the document files found in one state's directory
+type stateDocuments struct {
+	State string
+	Files []string
+}
+
+// listAllDocuments returns documents grouped by state, in lifecycle
+// order, and then by file name
+func listAllDocuments() []stateDocuments {
+	var result []stateDocuments
+
+	// Scan all state directories
+	for _, dir := range sortedStateDirs() {
+		files, err := os.ReadDir(repoPath(dir))
+		if err != nil {
+			continue
+		}
+
+		var docs []string
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".md") {
+				docs = append(docs, file.Name())
+			}
+		}
+
+		if len(docs) > 0 {
+			sort.Strings(docs)
+			result = append(result, stateDocuments{State: dirToState[dir], Files: docs})
+		}
+	}
+	return result
+}
+
+// listDocuments lists all documents by state
+func listDocuments() {
+	for _, group := range listAllDocuments() {
+		fmt.Fprintln(stdout, group.State)
+		for _, doc := range group.Files {
+			fmt.Fprintf(stdout, " - %s\n", doc)
+		}
+		fmt.Fprintln(stdout)
+	}
+}
+
+// defaultFieldNames returns the configured default frontmatter fields,
+// sorted
+func defaultFieldNames() []string {
+	var names []string
+	for name := range config.Defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addHeadersToDocument adds or completes YAML frontmatter for a document
+func addHeadersToDocument(docPath string) error {
+	// Validate file exists
+	if _, err := os.Stat(repoPath(docPath)); os.IsNotExist(err) {
+		return errorf(exitUsage, "File not found: %s", docPath)
+	}
+
+	// Read the file
+	content, err := os.ReadFile(repoPath(docPath))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read file: %v", err)
+	}
+
+	contentStr := string(content)
+	filename := filepath.Base(docPath)
+
+	// Extract metadata
+	number := extractNumberFromFilename(filename)
+	title := extractTitleFromContent(contentStr, filename)
+	// A document with several committers lists them all in authors
+	// instead of a single author
+	author := getGitAuthor(docPath)
+	authors := ""
+	if committers := getGitAuthors(docPath); len(committers) > 1 {
+		var items []interface{}
+		for _, person := range committers {
+			items = append(items, person)
+		}
+		authors = yamlFlow(items)
+	}
+	created := getGitCreatedDate(docPath)
+	updated := getGitUpdatedDate(docPath)
+
+	// Build metadata map with defaults
+	metadata := map[string]string{
+		"number":        number,
+		"title":         title,
+		"author":        author,
+		"created":       created,
+		"updated":       updated,
+		"state":         config.InitialState,
+		"supersedes":    "None",
+		"superseded-by": "None",
+	}
+	for _, field := range defaultFieldNames() {
+		metadata[field] = config.Defaults[field]
+	}
+	if authors != "" {
+		metadata["authors"] = authors
+	}
+
+	var newContent string
+	var addedFields []string
+
+	if hasYAMLFrontmatter(contentStr) {
+		// Fill in missing fields in place; existing values, custom
+		// fields, comments, and field order are kept as written
+		existing, err := parseYAML(contentStr)
+		if err != nil {
+			return errorf(exitFindings, "Failed to parse existing YAML: %v", err)
+		}
+		block, body, err := parseFrontmatterBlock(contentStr)
+		if err != nil {
+			return errorf(exitFindings, "Failed to parse existing YAML: %v", err)
+		}
+
+		for _, field := range append(append([]string{}, coreFields...), defaultFieldNames()...) {
+			if value, ok := existing[field]; ok && (value != "" || !isCoreField(field)) {
+				metadata[field] = value
+				continue
+			}
+			if field == "author" && existing["authors"] != "" {
+				continue
+			}
+			if field == "author" && authors != "" {
+				field = "authors"
+			}
+			if field == "title" {
+				block.set(field, strconv.Quote(displayTitle(metadata[field])))
+			} else {
+				block.set(field, metadata[field])
+			}
+			addedFields = append(addedFields, field)
+		}
+		newContent = block.String() + body
+	} else {
+		// No frontmatter exists, add it
+		for _, field := range append(append([]string{}, coreFields...), defaultFieldNames()...) {
+			if field == "author" && authors != "" {
+				field = "authors"
+			}
+			addedFields = append(addedFields, field)
+		}
+		newContent = buildCompleteYAML(metadata) + contentStr
+	}
+
+	// Write updated content
+	if err := os.WriteFile(repoPath(docPath), []byte(newContent), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write file: %v", err)
+	}
+	opResult.recordFieldChanges(docPath, contentStr, newContent)
+
+	// Report what was done
+	if len(addedFields) > 0 {
+		fmt.Fprintf(stdout, "Added/updated headers in %s:\n", filename)
+		for _, field := range addedFields {
+			fmt.Fprintf(stdout, "  %s: %s\n", field, metadata[field])
+		}
+	} else {
+		fmt.Fprintf(stdout, "All headers already present in %s\n", filename)
+	}
+	return nil
+}
+
+// reservationFor returns the reservation covering a number, or nil
+func reservationFor(number int) *numberReservation {
+	for i, r := range config.Reserved {
+		if number >= r.From && number <= r.To {
+			return &config.Reserved[i]
+		}
+	}
+	return nil
+}
+
+// usedDocNumbers maps every number taken by the index or a document
+// file to where it is used
+func usedDocNumbers() (map[int]string, error) {
+	idx, _, err := loadIndex(config.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[int]string)
+	for _, entry := range idx.Entries {
+		if num, err := strconv.Atoi(entry.Number); err == nil {
+			used[num] = config.IndexFile
+		}
+	}
+	for _, dir := range sortedStateDirs() {
+		files, _ := os.ReadDir(repoPath(dir))
+		for _, file := range files {
+			if !hasNumberPrefix(file.Name()) {
+				continue
+			}
+			if num, err := strconv.Atoi(file.Name()[:4]); err == nil {
+				used[num] = filepath.Join(dir, file.Name())
+			}
+		}
+	}
+	return used, nil
+}
+
+// nextDocNumber returns the number after the highest one in use, skipping
+// reserved numbers. Documents inside reserved ranges (vanity or process
+// numbers) do not advance the sequence.
+func nextDocNumber() (int, error) {
+	used, err := usedDocNumbers()
+	if err != nil {
+		return 0, err
+	}
+
+	highest := 0
+	for num := range used {
+		if num > highest && reservationFor(num) == nil {
+			highest = num
+		}
+	}
+
+	for next := highest + 1; next <= 9999; next++ {
+		if _, taken := used[next]; !taken && reservationFor(next) == nil {
+			return next, nil
+		}
+	}
+	return 0, fmt.Errorf("no free document numbers left")
+}
+
+// checkRequestedNumber validates an explicitly requested document number
+func checkRequestedNumber(number int) error {
+	if number < 1 || number > 9999 {
+		return fmt.Errorf("document number %d is outside 0001-9999", number)
+	}
+
+	if r := reservationFor(number); r != nil && !r.AllowExplicit {
+		reason := r.Reason
+		if reason == "" {
+			reason = "reserved in .zdp.yaml"
+		}
+		return fmt.Errorf("document number %04d is reserved (%s)", number, reason)
+	}
+
+	used, err := usedDocNumbers()
+	if err != nil {
+		return err
+	}
+	if where, taken := used[number]; taken {
+		return fmt.Errorf("document number %04d is already used by %s", number, where)
+	}
+	return nil
+}
+
+// hasNumberPrefix checks if a filename starts with a number prefix
+func hasNumberPrefix(filename string) bool {
+	return numberPrefixRe.MatchString(filename)
+}
+
+// numberPrefixRe matches the four-digit number a file name starts with
+var numberPrefixRe = regexp.MustCompile(`^\d{4}-`)
+
+// renameWithNumber renames a file to include a number prefix
+func renameWithNumber(filePath string, number int) (string, error) {
+	dir := filepath.Dir(filePath)
+	filename := filepath.Base(filePath)
+
+	// Format number with leading zeros (4 digits)
+	paddedNum := fmt.Sprintf("%04d", number)
+
+	// Create new filename
+	newFilename := fmt.Sprintf("%s-%s", paddedNum, filename)
+	newPath := filepath.Join(dir, newFilename)
+
+	// Rename the file
+	if err := os.Rename(repoPath(filePath), repoPath(newPath)); err != nil {
+		return "", err
+	}
+
+	opResult.recordMove(filePath, newPath)
+	return newPath, nil
+}
+
+// isInProjectDir checks if a file is within the design project directory
+func isInProjectDir(filePath string) (bool, error) {
+	// Get absolute path of the file
+	absFilePath, err := filepath.Abs(repoPath(filePath))
+	if err != nil {
+		return false, err
+	}
+
+	// Get current working directory (should be the project dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+
+	// Check if file path starts with project directory
+	return strings.HasPrefix(absFilePath, cwd), nil
+}
+
+// isInStateDir checks if a file is in one of the state directories
+func isInStateDir(filePath string) bool {
+	dir := filepath.Dir(filePath)
+	dirName := filepath.Base(dir)
+
+	// Check if the directory name matches any state directory
+	for _, stateDir := range states {
+		if dirName == stateDir {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,161 @@
+package zdp
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file when
+// the tests run with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading the golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match:\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+// runGolden runs a command on a fresh synthetic corpus of n documents,
+// returning its output
+func runGolden(t *testing.T, n int, args ...string) (dir, output string) {
+	dir = t.TempDir()
+	if err := writeBenchCorpus(dir, n); err != nil {
+		t.Fatalf("writing the corpus: %v", err)
+	}
+	output, err := runIn(dir, args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return dir, output
+}
+
+// runIn runs a command on the repository in dir, returning its output
+// and error
+func runIn(dir string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	repo := &Repo{Root: dir, Config: defaultConfig(), Output: &buf}
+	err := repo.do(func() error { return runCommand(context.Background(), args) })
+	return buf.String(), err
+}
+
+// TestListGolden checks that list prints states and documents in the same
+// order on every run
+func TestListGolden(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		_, output := runGolden(t, 24, "list")
+		checkGolden(t, "list.golden", output)
+	}
+}
+
+// TestUpdateIndexGolden checks the report and the index update-index
+// writes for a corpus missing from its index
+func TestUpdateIndexGolden(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		dir, output := runGolden(t, 24, "update-index")
+		checkGolden(t, "update-index.golden", output)
+		index, err := os.ReadFile(filepath.Join(dir, "00-index.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "update-index-index.golden", string(index))
+	}
+}
+
+// TestValidateGolden checks that a misplaced document, a number that
+// doesn't match its file name, and a document missing from the index
+// are errors, so validate exits with status 1
+func TestValidateGolden(t *testing.T) {
+	dir, _ := runGolden(t, 6, "update-index")
+
+	misplaced, err := filepath.Glob(filepath.Join(dir, "*", "0002-*.md"))
+	if err != nil || len(misplaced) != 1 {
+		t.Fatalf("finding 0002: %v %v", misplaced, err)
+	}
+	wrongDir := filepath.Join(dir, "09-withdrawn")
+	if filepath.Dir(misplaced[0]) == wrongDir {
+		wrongDir = filepath.Join(dir, "01-draft")
+	}
+	if err := os.MkdirAll(wrongDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(misplaced[0], filepath.Join(wrongDir, filepath.Base(misplaced[0]))); err != nil {
+		t.Fatal(err)
+	}
+
+	renumbered, err := filepath.Glob(filepath.Join(dir, "*", "0003-*.md"))
+	if err != nil || len(renumbered) != 1 {
+		t.Fatalf("finding 0003: %v %v", renumbered, err)
+	}
+	content, err := os.ReadFile(renumbered[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(renumbered[0], bytes.Replace(content, []byte("number: 0003"), []byte("number: 0033"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unlisted, err := filepath.Glob(filepath.Join(dir, "*", "0004-*.md"))
+	if err != nil || len(unlisted) != 1 {
+		t.Fatalf("finding 0004: %v %v", unlisted, err)
+	}
+	content, err = os.ReadFile(unlisted[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := bytes.Replace(content, []byte("number: 0004"), []byte("number: 0007"), 1)
+	if err := os.WriteFile(filepath.Join(filepath.Dir(unlisted[0]), "0007-synthetic-document.md"), copied, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runIn(dir, "validate", "--no-baseline")
+	if code := ExitCode(err); code != exitFindings {
+		t.Errorf("validate exited with %d (%v), want %d", code, err, exitFindings)
+	}
+	checkGolden(t, "validate.golden", output)
+}
+
+// TestBootstrapWithoutIndex checks that new and update-index work in a
+// repository that has no index yet, and that update-index creates one
+func TestBootstrapWithoutIndex(t *testing.T) {
+	dir := t.TempDir()
+	if output, err := exec.Command("git", "init", "--quiet", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, output)
+	}
+	if _, err := runIn(dir, "new", "First Doc"); err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "00-index.md")); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command("git", "-C", dir, "add", "-A").CombinedOutput(); err != nil {
+		t.Fatalf("git add: %v: %s", err, output)
+	}
+	if _, err := runIn(dir, "update-index"); err != nil {
+		t.Fatalf("update-index: %v", err)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "00-index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "| 0001 | First Doc | Draft |") {
+		t.Errorf("the created index does not list 0001:\n%s", index)
+	}
+}
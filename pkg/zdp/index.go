@@ -0,0 +1,1284 @@
+package zdp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updateIndex updates the 00-index.md file when a document changes state
+func updateIndex(docPath, oldState, newState string) error {
+	indexPath := config.IndexFile
+	idx, _, err := loadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Extract document metadata
+	meta, err := extractDocMetadata(docPath)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	name := filepath.Base(docPath)
+	var changes []string
+
+	// Update the table row
+	if entry := idx.Entry(meta.Number); entry != nil {
+		if entry.State != newState {
+			changes = append(changes, fmt.Sprintf("Updated state: %s (%s → %s)", name, entry.State, newState))
+		}
+		if entry.Updated != today {
+			changes = append(changes, fmt.Sprintf("Updated date: %s (%s → %s)", name, entry.Updated, today))
+		}
+		entry.State = newState
+		entry.Updated = today
+	}
+
+	// Update state sections
+	oldDir, _ := getStateDir(oldState)
+	newDir, _ := getStateDir(newState)
+
+	oldPath := filepath.Join(oldDir, filepath.Base(docPath))
+	newPath := filepath.Join(newDir, filepath.Base(docPath))
+
+	idx.RemoveLink(oldState, oldPath)
+	idx.AddLink(newState, IndexLink{Number: meta.Number, Title: meta.Title, Path: newPath})
+	changes = append(changes, fmt.Sprintf("Moved link: %s (%s → %s)", name, oldState, newState))
+
+	// Write updated index
+	if err := saveIndex(indexPath, idx); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		opResult.recordIndexChange("%s", change)
+	}
+	return nil
+}
+
+// addToIndex adds a document to the index if not already present
+func addToIndex(docPath string) error {
+	indexPath := config.IndexFile
+	idx, _, err := loadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Extract document metadata
+	meta, err := extractDocMetadata(docPath)
+	if err != nil {
+		return err
+	}
+
+	// Check if document is in table
+	tableHasDoc := idx.Entry(meta.Number) != nil
+
+	// Check if document is in state section
+	stateSectionHasDoc := idx.HasLink(docPath)
+
+	if tableHasDoc && stateSectionHasDoc {
+		fmt.Fprintln(stdout, "Document already indexed correctly")
+		return nil
+	}
+
+	// Add to table if missing
+	var changes []string
+	if !tableHasDoc {
+		idx.SetEntry(IndexEntry{Number: meta.Number, Title: meta.Title, State: meta.State, Updated: meta.Updated, SupersededBy: supersededByNote(meta)})
+		changes = append(changes, "Added: "+filepath.Base(docPath))
+	}
+
+	// Add to state section if missing
+	if !stateSectionHasDoc {
+		idx.AddLink(meta.State, IndexLink{Number: meta.Number, Title: meta.Title, Path: docPath})
+		changes = append(changes, fmt.Sprintf("Added link: %s (%s)", filepath.Base(docPath), meta.State))
+	}
+
+	// Write updated index
+	if err := saveIndex(indexPath, idx); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		opResult.recordIndexChange("%s", change)
+	}
+
+	fmt.Fprintf(stdout, "Added %s to index\n", filepath.Base(docPath))
+	return nil
+}
+
+// IndexEntry represents an entry in the index table
+type IndexEntry struct {
+	Number       string
+	Title        string
+	State        string
+	Updated      string
+	SupersededBy string // "0051" or "0051, 0052", shown after the title
+}
+
+// getGitTrackedDocs returns all git-tracked .md files in state directories
+func getGitTrackedDocs() []string {
+	var allDocs []string
+
+	// Get git-tracked files for each state directory
+	for _, dir := range sortedStateDirs() {
+		// Without core.quotePath=false git escapes non-ASCII file names
+		cmd := repoCommand("git", "-c", "core.quotePath=false", "ls-files", dir+"/*.md")
+		output, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+
+		files := strings.Split(strings.TrimSpace(string(output)), "\n")
+		for _, file := range files {
+			if file != "" {
+				allDocs = append(allDocs, file)
+			}
+		}
+	}
+
+	return allDocs
+}
+
+// Index is an order-preserving model of 00-index.md. The generated table
+// and state sections are held as typed data; everything else (headings,
+// prose, other tables) is kept verbatim in prose blocks so rendering
+// reproduces it untouched.
+//
+// When the file contains <!-- zdp:begin generated --> and
+// <!-- zdp:end generated --> markers, only the regions between them are
+// parsed and rewritten; text outside the markers is kept byte for byte.
+type Index struct {
+	Blocks    []IndexBlock   // top-level regions, in file order
+	Entries   []IndexEntry   // "All Documents by Number" rows, in file order
+	Sections  []IndexSection // "### <State>" sections, in file order
+	Protected bool           // the file uses generated-region markers
+
+	Order       string     // table order when rendering: "number", "state", or "updated"
+	RecentLimit int        // rows in the "Recently Updated" table; 0 omits it
+	Tags        []IndexTag // "Documents by Tag" sections; nil omits them
+}
+
+// Kinds of IndexBlock
+const (
+	proseBlock    = "prose"
+	tableBlock    = "table"
+	recentBlock   = "recent"
+	tagsBlock     = "tags"
+	sectionsBlock = "sections"
+	verbatimBlock = "verbatim"
+)
+
+// Generated-region markers
+const (
+	generatedBegin = "<!-- zdp:begin generated -->"
+	generatedEnd   = "<!-- zdp:end generated -->"
+)
+
+// IndexBlock is one top-level region of the index file
+type IndexBlock struct {
+	Kind  string
+	Lines []string // verbatim lines of a prose block
+	Raw   string   // exact text outside generated markers
+}
+
+// IndexSection is one state section under "Documents by State"
+type IndexSection struct {
+	State string
+	Notes []string // free-form lines, kept ahead of the links
+	Links []IndexLink
+}
+
+// IndexTag is one "### <tag>" section under "Documents by Tag", listing
+// the documents whose tags field names the tag
+type IndexTag struct {
+	Tag   string
+	Links []IndexLink
+}
+
+// IndexLink is a bullet linking to a document from a state section
+type IndexLink struct {
+	Number string
+	Title  string
+	Path   string
+}
+
+// Warning describes a malformed index line that was repaired or skipped
+type Warning struct {
+	Line    int
+	Column  int    // 1-based column of the offending text
+	Text    string // the offending line, trimmed
+	Message string
+}
+
+func (w Warning) String() string {
+	loc := fmt.Sprintf("line %d", w.Line)
+	if w.Column > 0 {
+		loc += fmt.Sprintf(", column %d", w.Column)
+	}
+	if w.Text == "" {
+		return loc + ": " + w.Message
+	}
+	return fmt.Sprintf("%s: %s: %q", loc, w.Message, w.Text)
+}
+
+var (
+	indexLinkRe   = regexp.MustCompile(`^- \[(\d+) - (.*)\]\(([^)]+)\)$`)
+	indexTargetRe = regexp.MustCompile(`\]\(([^()\[\]\s]+)\)\s*$`)
+	indexDateRe   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	indexNumRe    = regexp.MustCompile(`^\d{4}$`)
+
+	supersededNoteRe = regexp.MustCompile(`\s*\(superseded by (\d+(?:, \d+)*)\)$`)
+)
+
+// splitTableRow splits a markdown table row on unescaped pipes
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '|' {
+			cell.WriteByte('|')
+			i++
+			continue
+		}
+		if line[i] == '|' {
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+			continue
+		}
+		cell.WriteByte(line[i])
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// indexTitle is a title as the index shows it: without YAML quotes, and
+// on one line with single spaces, since rows and links are single lines
+func indexTitle(title string) string {
+	return strings.Join(strings.Fields(displayTitle(title)), " ")
+}
+
+// escapeTableCell escapes pipes so a value stays inside one table cell
+func escapeTableCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
+
+// isTableSeparator reports whether a table row is the |---|---| line
+func isTableSeparator(cells []string) bool {
+	for _, cell := range cells {
+		if strings.Trim(cell, ":-") != "" || !strings.Contains(cell, "-") {
+			return false
+		}
+	}
+	return len(cells) > 0
+}
+
+// trimBlankLines drops leading and trailing blank lines
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isHeading reports whether a line is a level-1 or level-2 heading
+func isHeading(line string) bool {
+	return strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "## ")
+}
+
+// checkGeneratedMarkers verifies that generated-region markers pair up:
+// every begin is closed before the next begin, every end has a begin,
+// and no other zdp: marker appears
+func checkGeneratedMarkers(lines []string) error {
+	open := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == generatedBegin:
+			if open > 0 {
+				return fmt.Errorf("line %d: generated region begins inside the region opened on line %d", i+1, open)
+			}
+			open = i + 1
+		case trimmed == generatedEnd:
+			if open == 0 {
+				return fmt.Errorf("line %d: generated region end without a begin marker", i+1)
+			}
+			open = 0
+		case strings.HasPrefix(trimmed, "<!-- zdp:"):
+			return fmt.Errorf("line %d: unrecognized marker %s (expected %s or %s)", i+1, trimmed, generatedBegin, generatedEnd)
+		}
+	}
+	if open > 0 {
+		return fmt.Errorf("line %d: generated region is never closed", open)
+	}
+	return nil
+}
+
+// ParseIndex parses the index, reporting malformed lines as warnings
+// instead of silently dropping them. It returns an error only when the
+// content has no "All Documents by Number" table at all.
+func ParseIndex(content string) (Index, []Warning, error) {
+	var idx Index
+	var warns []Warning
+	var prose []string
+
+	flushProse := func() {
+		if lines := trimBlankLines(prose); len(lines) > 0 {
+			idx.Blocks = append(idx.Blocks, IndexBlock{Kind: proseBlock, Lines: lines})
+		}
+		prose = nil
+	}
+
+	const (
+		outside = iota
+		inTable
+		inRecent
+		inTags
+		statesHeading
+		inStates
+	)
+	mode := outside
+	foundTable := false
+	foundRecent := false
+	foundTags := false
+	foundStates := false
+	seenNumbers := make(map[string]int)
+	seenSections := make(map[string]bool)
+
+	lines := strings.Split(content, "\n")
+	if err := checkGeneratedMarkers(lines); err != nil {
+		return idx, warns, err
+	}
+	idx.Protected = strings.Contains(content, generatedBegin)
+	inGenerated := false
+	var raw []string
+
+	startSection := func(line string, lineNum int) {
+		state := strings.TrimSpace(strings.TrimPrefix(line, "### "))
+		if _, err := getStateDir(state); err != nil {
+			warns = append(warns, Warning{Line: lineNum, Message: fmt.Sprintf("unknown state section \"%s\"", state)})
+		}
+		if seenSections[state] {
+			warns = append(warns, Warning{Line: lineNum, Message: fmt.Sprintf("duplicate state section \"%s\"", state)})
+		}
+		seenSections[state] = true
+		idx.Sections = append(idx.Sections, IndexSection{State: state})
+	}
+
+	for i, line := range lines {
+		lineNum := i + 1
+		trimmed := strings.TrimSpace(line)
+
+		if idx.Protected {
+			switch {
+			case trimmed == generatedBegin:
+				raw = append(raw, line)
+				idx.Blocks = append(idx.Blocks, IndexBlock{Kind: verbatimBlock, Raw: strings.Join(raw, "\n") + "\n"})
+				raw = nil
+				inGenerated = true
+				mode = outside
+				continue
+			case trimmed == generatedEnd:
+				flushProse()
+				raw = []string{line}
+				inGenerated = false
+				mode = outside
+				continue
+			case !inGenerated:
+				raw = append(raw, line)
+				continue
+			}
+		}
+
+		if mode == inTable {
+			if strings.HasPrefix(trimmed, "|") {
+				cells := splitTableRow(trimmed)
+				if isTableSeparator(cells) {
+					continue
+				}
+				entry, problems := parseIndexRow(cells)
+				for _, problem := range problems {
+					warns = append(warns, Warning{Line: lineNum, Message: problem})
+				}
+				if entry.Number == "" {
+					continue
+				}
+				if first, dup := seenNumbers[entry.Number]; dup {
+					warns = append(warns, Warning{Line: lineNum, Message: fmt.Sprintf("duplicate number %s (first seen on line %d)", entry.Number, first)})
+				} else {
+					seenNumbers[entry.Number] = lineNum
+				}
+				idx.Entries = append(idx.Entries, entry)
+				continue
+			}
+			mode = outside
+		}
+
+		if mode == inRecent {
+			// The "Recently Updated" table is derived, so only its size is kept
+			if strings.HasPrefix(trimmed, "|") {
+				cells := splitTableRow(trimmed)
+				if !isTableSeparator(cells) && cells[0] != "Number" {
+					idx.RecentLimit++
+				}
+				continue
+			}
+			if trimmed == "" {
+				continue
+			}
+			mode = outside
+		}
+
+		if mode == inTags {
+			// The tag sections are derived too, and end at the next h1 or h2
+			if !strings.HasPrefix(line, "# ") && !strings.HasPrefix(line, "## ") {
+				continue
+			}
+			mode = outside
+		}
+
+		if mode == inStates {
+			switch {
+			case strings.HasPrefix(line, "### "):
+				startSection(line, lineNum)
+				continue
+			case isHeading(line):
+				// Any other heading ends the state sections
+				mode = outside
+			case trimmed == "":
+				continue
+			default:
+				idx.addSectionLine(trimmed, lineNum, &warns)
+				continue
+			}
+		}
+
+		switch {
+		case !foundTable && strings.HasPrefix(trimmed, "| Number |"):
+			flushProse()
+			idx.Blocks = append(idx.Blocks, IndexBlock{Kind: tableBlock})
+			foundTable = true
+			mode = inTable
+			continue
+		case !foundRecent && trimmed == "## Recently Updated":
+			flushProse()
+			idx.Blocks = append(idx.Blocks, IndexBlock{Kind: recentBlock})
+			foundRecent = true
+			mode = inRecent
+			continue
+		case !foundTags && trimmed == "## Documents by Tag":
+			flushProse()
+			idx.Blocks = append(idx.Blocks, IndexBlock{Kind: tagsBlock})
+			foundTags = true
+			mode = inTags
+			continue
+		case !foundStates && trimmed == "## Documents by State":
+			flushProse()
+			foundStates = true
+			mode = statesHeading
+		case mode == statesHeading && strings.HasPrefix(line, "### "):
+			flushProse()
+			idx.Blocks = append(idx.Blocks, IndexBlock{Kind: sectionsBlock})
+			mode = inStates
+			startSection(line, lineNum)
+			continue
+		case mode == statesHeading && isHeading(line):
+			flushProse()
+			mode = outside
+		}
+
+		prose = append(prose, line)
+	}
+	flushProse()
+	if idx.Protected {
+		idx.Blocks = append(idx.Blocks, IndexBlock{Kind: verbatimBlock, Raw: strings.Join(raw, "\n")})
+	}
+
+	// Quote each malformed line and where its text starts
+	for i, w := range warns {
+		if w.Line >= 1 && w.Line <= len(lines) {
+			line := strings.TrimRight(lines[w.Line-1], " \r")
+			warns[i].Column = len(line) - len(strings.TrimLeft(line, " \t")) + 1
+			warns[i].Text = strings.TrimSpace(line)
+		}
+	}
+
+	if !foundTable {
+		if idx.Protected {
+			return idx, warns, fmt.Errorf("index has no \"All Documents by Number\" table inside %s markers", generatedBegin)
+		}
+		return idx, warns, fmt.Errorf("index has no \"All Documents by Number\" table")
+	}
+
+	return idx, warns, nil
+}
+
+// addSectionLine files a non-blank line into the last state section
+func (idx *Index) addSectionLine(trimmed string, lineNum int, warns *[]Warning) {
+	section := &idx.Sections[len(idx.Sections)-1]
+
+	if matches := indexLinkRe.FindStringSubmatch(trimmed); matches != nil {
+		section.Links = append(section.Links, IndexLink{Number: matches[1], Title: indexTitle(matches[2]), Path: matches[3]})
+		return
+	}
+
+	if strings.HasPrefix(trimmed, "- ") {
+		*warns = append(*warns, Warning{Line: lineNum, Message: fmt.Sprintf("malformed document link in %s section", section.State)})
+		// Keep whatever link target we can recover
+		if matches := indexTargetRe.FindStringSubmatch(trimmed); matches != nil {
+			section.Links = append(section.Links, IndexLink{
+				Number: extractNumberFromFilename(filepath.Base(matches[1])),
+				Path:   matches[1],
+			})
+			return
+		}
+	}
+
+	section.Notes = append(section.Notes, trimmed)
+}
+
+// parseIndexRow converts table cells into an entry, describing any repairs
+func parseIndexRow(cells []string) (IndexEntry, []string) {
+	var problems []string
+
+	switch {
+	case len(cells) > 4:
+		// An unescaped pipe in the title splits it across cells
+		problems = append(problems, fmt.Sprintf("expected 4 columns, found %d; treating extra cells as part of the title", len(cells)))
+		merged := strings.TrimSpace(strings.Join(cells[1:len(cells)-2], " | "))
+		cells = []string{cells[0], merged, cells[len(cells)-2], cells[len(cells)-1]}
+	case len(cells) < 4:
+		problems = append(problems, fmt.Sprintf("expected 4 columns, found %d; missing cells left empty", len(cells)))
+		for len(cells) < 4 {
+			cells = append(cells, "")
+		}
+	}
+
+	entry := IndexEntry{Number: cells[0], Title: cells[1], State: cells[2], Updated: cells[3]}
+	if m := supersededNoteRe.FindStringSubmatch(entry.Title); m != nil {
+		entry.Title, entry.SupersededBy = strings.TrimSuffix(entry.Title, m[0]), m[1]
+	}
+	entry.Title = indexTitle(entry.Title)
+	if m := supersededNoteRe.FindStringSubmatch(entry.Title); m != nil && entry.SupersededBy == "" {
+		// The note was inside the quotes
+		entry.Title, entry.SupersededBy = strings.TrimSuffix(entry.Title, m[0]), m[1]
+	}
+
+	if entry.Number == "" {
+		problems = append(problems, "row has no document number; skipped")
+		return entry, problems
+	}
+	if !indexNumRe.MatchString(entry.Number) {
+		problems = append(problems, fmt.Sprintf("document number \"%s\" is not four digits", entry.Number))
+	}
+	if _, err := getStateDir(entry.State); err != nil {
+		problems = append(problems, fmt.Sprintf("document %s has unknown state \"%s\"", entry.Number, entry.State))
+	}
+	if entry.Updated != "" && !indexDateRe.MatchString(entry.Updated) {
+		problems = append(problems, fmt.Sprintf("document %s has malformed date \"%s\"", entry.Number, entry.Updated))
+	}
+
+	return entry, problems
+}
+
+// defaultIndexBlocks is the layout used for an index built from scratch
+func defaultIndexBlocks() []IndexBlock {
+	return []IndexBlock{
+		{Kind: proseBlock, Lines: []string{"# Zylisp Design Documents Index", "", "## All Documents by Number"}},
+		{Kind: tableBlock},
+		{Kind: recentBlock},
+		{Kind: proseBlock, Lines: []string{"## Documents by State"}},
+		{Kind: sectionsBlock},
+	}
+}
+
+// RenderIndex renders an Index back to markdown
+func RenderIndex(idx Index) string {
+	blocks := idx.Blocks
+	if len(blocks) == 0 {
+		blocks = defaultIndexBlocks()
+	}
+
+	if !idx.Protected {
+		return renderIndexBlocks(idx, blocks) + "\n"
+	}
+
+	// Text outside the markers is copied exactly; each generated region
+	// is rendered between them
+	var b strings.Builder
+	var region []IndexBlock
+	for _, block := range blocks {
+		if block.Kind != verbatimBlock {
+			region = append(region, block)
+			continue
+		}
+		if rendered := renderIndexBlocks(idx, region); rendered != "" {
+			b.WriteString(rendered + "\n")
+		}
+		region = nil
+		b.WriteString(block.Raw)
+	}
+	return b.String()
+}
+
+// renderIndexBlocks renders generated and prose blocks separated by blank lines
+func renderIndexBlocks(idx Index, blocks []IndexBlock) string {
+	var parts []string
+	for _, block := range blocks {
+		switch block.Kind {
+		case tableBlock:
+			parts = append(parts, renderIndexTable(sortedEntries(idx.Entries, idx.Order)))
+		case recentBlock:
+			if idx.RecentLimit > 0 {
+				recent := sortedEntries(idx.Entries, "updated")
+				if len(recent) > idx.RecentLimit {
+					recent = recent[:idx.RecentLimit]
+				}
+				parts = append(parts, "## Recently Updated\n\n"+renderIndexTable(recent))
+			}
+		case sectionsBlock:
+			if rendered := renderIndexSections(idx.Sections); rendered != "" {
+				parts = append(parts, rendered)
+			}
+		case tagsBlock:
+			if len(idx.Tags) > 0 {
+				parts = append(parts, "## Documents by Tag\n\n"+renderIndexTags(idx.Tags))
+			}
+		default:
+			if lines := trimBlankLines(block.Lines); len(lines) > 0 {
+				parts = append(parts, strings.Join(lines, "\n"))
+			}
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// sortedEntries returns a copy of the entries in the given order:
+// "number", "state" (lifecycle order, then number), or "updated"
+// (most recent first, then number)
+func sortedEntries(entries []IndexEntry, order string) []IndexEntry {
+	sorted := append([]IndexEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch order {
+		case "state":
+			rankA, rankB := stateRank(a.State), stateRank(b.State)
+			if rankA != rankB {
+				return rankA < rankB
+			}
+		case "updated":
+			if a.Updated != b.Updated {
+				return a.Updated > b.Updated
+			}
+		}
+		return docNumberLess(a.Number, b.Number)
+	})
+	return sorted
+}
+
+// stateRank orders states by their position in the lifecycle
+func stateRank(state string) string {
+	if dir, err := getStateDir(state); err == nil {
+		for i, d := range sortedStateDirs() {
+			if d == dir {
+				return fmt.Sprintf("%03d", i)
+			}
+		}
+	}
+	return "999"
+}
+
+// renderIndexTable renders an index table with the standard columns
+func renderIndexTable(entries []IndexEntry) string {
+	lines := []string{
+		"| Number | Title | State | Updated |",
+		"|--------|-------|-------|---------|",
+	}
+	for _, e := range entries {
+		title := indexTitle(e.Title)
+		if e.SupersededBy != "" {
+			title += " (superseded by " + e.SupersededBy + ")"
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |", escapeTableCell(e.Number), escapeTableCell(title), escapeTableCell(e.State), escapeTableCell(e.Updated)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderIndexSections renders the "### <State>" sections
+func renderIndexSections(sections []IndexSection) string {
+	var parts []string
+	for _, section := range sections {
+		part := "### " + section.State
+		if len(section.Notes) > 0 {
+			part += "\n\n" + strings.Join(section.Notes, "\n")
+		}
+		if len(section.Links) > 0 {
+			var links []string
+			for _, link := range section.Links {
+				links = append(links, fmt.Sprintf("- [%s - %s](%s)", link.Number, indexTitle(link.Title), link.Path))
+			}
+			part += "\n\n" + strings.Join(links, "\n")
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// renderIndexTags renders the "### <tag>" sections
+func renderIndexTags(tags []IndexTag) string {
+	var parts []string
+	for _, tag := range tags {
+		var links []string
+		for _, link := range tag.Links {
+			links = append(links, fmt.Sprintf("- [%s - %s](%s)", link.Number, indexTitle(link.Title), link.Path))
+		}
+		parts = append(parts, "### "+tag.Tag+"\n\n"+strings.Join(links, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// indexTags groups documents by the tags in their tags field, ignoring
+// case; each tag is spelled as it first appears. Tags are sorted by name
+// and their documents by number.
+func indexTags(docs []*Document) []IndexTag {
+	byKey := make(map[string]*IndexTag)
+	var keys []string
+	for _, doc := range docs {
+		for _, tag := range metaList(doc.Fields["tags"]) {
+			key := strings.ToLower(tag)
+			if byKey[key] == nil {
+				byKey[key] = &IndexTag{Tag: tag}
+				keys = append(keys, key)
+			}
+			links := &byKey[key].Links
+			if len(*links) == 0 || (*links)[len(*links)-1].Path != doc.Path {
+				*links = append(*links, IndexLink{Number: doc.Number, Title: doc.Title, Path: doc.Path})
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	var tags []IndexTag
+	for _, key := range keys {
+		tag := *byKey[key]
+		sort.SliceStable(tag.Links, func(i, j int) bool { return docNumberLess(tag.Links[i].Number, tag.Links[j].Number) })
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// docNumberLess orders document numbers numerically. Malformed numbers
+// sort after well-formed ones, by text, so the order is the same whatever
+// order the numbers come in.
+func docNumberLess(a, b string) bool {
+	numA, errA := strconv.Atoi(a)
+	numB, errB := strconv.Atoi(b)
+	switch {
+	case errA != nil || errB != nil:
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return a < b
+	case numA != numB:
+		return numA < numB
+	}
+	return a < b
+}
+
+// Entry returns the table row for a document number, or nil
+func (idx *Index) Entry(number string) *IndexEntry {
+	for i := range idx.Entries {
+		if idx.Entries[i].Number == number {
+			return &idx.Entries[i]
+		}
+	}
+	return nil
+}
+
+// SetEntry replaces the row for entry.Number, inserting it in number order
+// if the table has no such row yet
+func (idx *Index) SetEntry(entry IndexEntry) {
+	if existing := idx.Entry(entry.Number); existing != nil {
+		*existing = entry
+		return
+	}
+
+	pos := len(idx.Entries)
+	for i, e := range idx.Entries {
+		if docNumberLess(entry.Number, e.Number) {
+			pos = i
+			break
+		}
+	}
+	idx.Entries = append(idx.Entries[:pos], append([]IndexEntry{entry}, idx.Entries[pos:]...)...)
+}
+
+// InsertEntries adds rows for numbers the table does not have yet, each
+// placed as SetEntry would, in one merge pass rather than one scan per row
+func (idx *Index) InsertEntries(entries []IndexEntry) {
+	pending := append([]IndexEntry(nil), entries...)
+	sort.SliceStable(pending, func(i, j int) bool { return docNumberLess(pending[i].Number, pending[j].Number) })
+
+	merged := make([]IndexEntry, 0, len(idx.Entries)+len(pending))
+	for _, e := range idx.Entries {
+		for len(pending) > 0 && docNumberLess(pending[0].Number, e.Number) {
+			merged = append(merged, pending[0])
+			pending = pending[1:]
+		}
+		merged = append(merged, e)
+	}
+	idx.Entries = append(merged, pending...)
+}
+
+// RemoveEntry deletes the row for a document number
+func (idx *Index) RemoveEntry(number string) bool {
+	for i, e := range idx.Entries {
+		if e.Number == number {
+			idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Section returns the section for a state, or nil
+func (idx *Index) Section(state string) *IndexSection {
+	for i := range idx.Sections {
+		if normalizeState(idx.Sections[i].State) == normalizeState(state) {
+			return &idx.Sections[i]
+		}
+	}
+	return nil
+}
+
+// HasLink reports whether any state section links to path
+func (idx *Index) HasLink(path string) bool {
+	for _, section := range idx.Sections {
+		for _, link := range section.Links {
+			if link.Path == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddLink adds a link to a state's section in number order, creating the
+// section at the top of "Documents by State" if it does not exist
+func (idx *Index) AddLink(state string, link IndexLink) {
+	section := idx.Section(state)
+	if section == nil {
+		idx.ensureSectionsBlock()
+		idx.Sections = append([]IndexSection{{State: getTitleCaseState(state)}}, idx.Sections...)
+		section = &idx.Sections[0]
+	}
+
+	pos := len(section.Links)
+	for i, existing := range section.Links {
+		if docNumberLess(link.Number, existing.Number) {
+			pos = i
+			break
+		}
+	}
+	section.Links = append(section.Links[:pos], append([]IndexLink{link}, section.Links[pos:]...)...)
+}
+
+// RemoveLink removes the link to path from a state's section, dropping the
+// section once it has nothing left in it
+func (idx *Index) RemoveLink(state, path string) bool {
+	for i := range idx.Sections {
+		section := &idx.Sections[i]
+		if normalizeState(section.State) != normalizeState(state) {
+			continue
+		}
+		for j, link := range section.Links {
+			if link.Path != path {
+				continue
+			}
+			section.Links = append(section.Links[:j], section.Links[j+1:]...)
+			if len(section.Links) == 0 && len(section.Notes) == 0 {
+				idx.Sections = append(idx.Sections[:i], idx.Sections[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ensureRecentBlock places the "Recently Updated" table after the main one
+func (idx *Index) ensureRecentBlock() {
+	for _, block := range idx.Blocks {
+		if block.Kind == recentBlock {
+			return
+		}
+	}
+	for i, block := range idx.Blocks {
+		if block.Kind == tableBlock {
+			idx.Blocks = append(idx.Blocks[:i+1], append([]IndexBlock{{Kind: recentBlock}}, idx.Blocks[i+1:]...)...)
+			return
+		}
+	}
+}
+
+// ensureTagsBlock places the "Documents by Tag" sections after the state
+// sections
+func (idx *Index) ensureTagsBlock() {
+	for _, block := range idx.Blocks {
+		if block.Kind == tagsBlock {
+			return
+		}
+	}
+	for i, block := range idx.Blocks {
+		if block.Kind == sectionsBlock {
+			idx.Blocks = append(idx.Blocks[:i+1], append([]IndexBlock{{Kind: tagsBlock}}, idx.Blocks[i+1:]...)...)
+			return
+		}
+	}
+}
+
+// ensureSectionsBlock makes sure the layout has a place to render sections
+func (idx *Index) ensureSectionsBlock() {
+	if len(idx.Blocks) == 0 {
+		return
+	}
+	for _, block := range idx.Blocks {
+		if block.Kind == sectionsBlock {
+			return
+		}
+	}
+
+	sections := IndexBlock{Kind: sectionsBlock}
+	for i, block := range idx.Blocks {
+		if block.Kind == proseBlock && len(block.Lines) > 0 && block.Lines[0] == "## Documents by State" {
+			idx.Blocks = append(idx.Blocks[:i+1], append([]IndexBlock{sections}, idx.Blocks[i+1:]...)...)
+			return
+		}
+	}
+	added := []IndexBlock{{Kind: proseBlock, Lines: []string{"## Documents by State"}}, sections}
+	if idx.Protected {
+		// Keep the new blocks inside the last generated region
+		last := len(idx.Blocks) - 1
+		idx.Blocks = append(idx.Blocks[:last], append(added, idx.Blocks[last])...)
+		return
+	}
+	idx.Blocks = append(idx.Blocks, added...)
+}
+
+// loadIndex reads and parses the index file, applying the configured
+// table order and "Recently Updated" size. A missing or blank index is
+// an empty one, rendered with the default layout, so a new repository
+// can start without one.
+func loadIndex(indexPath string) (Index, []Warning, error) {
+	content, err := os.ReadFile(repoPath(indexPath))
+	if err != nil && !os.IsNotExist(err) {
+		return Index{}, nil, err
+	}
+
+	var idx Index
+	var warns []Warning
+	if strings.TrimSpace(string(content)) != "" {
+		idx, warns, err = ParseIndex(string(content))
+		if err != nil {
+			return idx, warns, err
+		}
+	}
+
+	idx.Order = config.IndexSort
+	idx.RecentLimit = config.RecentlyUpdated
+	if idx.RecentLimit > 0 {
+		idx.ensureRecentBlock()
+	}
+	idx.refreshTags()
+	return idx, warns, nil
+}
+
+// refreshTags regenerates the tag sections from the documents when
+// index.tag-sections is set
+func (idx *Index) refreshTags() {
+	if !config.TagSections {
+		return
+	}
+	if len(idx.Blocks) == 0 {
+		idx.Blocks = defaultIndexBlocks()
+	}
+	idx.ensureSectionsBlock()
+	idx.ensureTagsBlock()
+	idx.Tags = indexTags(scanDocuments())
+}
+
+// saveIndex renders the index and writes it back to disk
+func saveIndex(indexPath string, idx Index) error {
+	idx.refreshTags()
+	if err := os.WriteFile(repoPath(indexPath), []byte(RenderIndex(idx)), 0644); err != nil {
+		return err
+	}
+	opResult.recordWrite(indexPath)
+	return nil
+}
+
+// syncIndexTable synchronizes the table with git-tracked documents
+func syncIndexTable(ctx context.Context, idx *Index, gitDocs []string, prog *progress) ([]string, error) {
+	var changes []string
+
+	currentEntries := make(map[string]int)
+	for i, entry := range idx.Entries {
+		currentEntries[entry.Number] = i
+	}
+	var added []IndexEntry
+	defer func() { idx.InsertEntries(added) }()
+
+	// Process each git-tracked document
+	for _, docPath := range gitDocs {
+		// Stop between documents so the current one is never half-applied
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+		prog.step(filepath.Base(docPath))
+
+		meta, err := extractDocMetadata(docPath)
+		if err != nil {
+			skipDocument(docPath, err)
+			continue
+		}
+
+		pos, exists := currentEntries[meta.Number]
+
+		if !exists {
+			// Add new entry to table; new rows are merged in at the end
+			added = append(added, IndexEntry{Number: meta.Number, Title: meta.Title, State: meta.State, Updated: meta.Updated, SupersededBy: supersededByNote(meta)})
+			currentEntries[meta.Number] = -1
+			changes = append(changes, fmt.Sprintf("  ✓ Added: %s", filepath.Base(docPath)))
+			continue
+		}
+		if pos < 0 {
+			continue
+		}
+
+		existing := idx.Entries[pos]
+		note := supersededByNote(meta)
+		if existing.Updated == meta.Updated && existing.State == meta.State && existing.SupersededBy == note {
+			continue
+		}
+
+		entry := &idx.Entries[pos]
+		entry.State = meta.State
+		entry.Updated = meta.Updated
+		entry.SupersededBy = note
+
+		if existing.SupersededBy != note {
+			changes = append(changes, fmt.Sprintf("  ✓ Updated superseded-by: %s (%s → %s)", filepath.Base(docPath), noneIfEmpty(existing.SupersededBy), noneIfEmpty(note)))
+		}
+
+		// Check if updated date differs
+		if existing.Updated != meta.Updated {
+			changes = append(changes, fmt.Sprintf("  ✓ Updated date: %s (%s → %s)", filepath.Base(docPath), existing.Updated, meta.Updated))
+		}
+		// Check if state differs
+		if existing.State != meta.State {
+			changes = append(changes, fmt.Sprintf("  ✓ Updated state: %s (%s → %s)", filepath.Base(docPath), existing.State, meta.State))
+		}
+	}
+
+	return changes, nil
+}
+
+// syncStateSection synchronizes a state section with its directory
+func syncStateSection(idx *Index, state, stateDir string) []string {
+	var changes []string
+
+	// Get files in directory
+	dirFiles, err := os.ReadDir(repoPath(stateDir))
+	if err != nil {
+		return changes
+	}
+
+	var dirDocs []string
+	for _, file := range dirFiles {
+		if strings.HasSuffix(file.Name(), ".md") {
+			dirDocs = append(dirDocs, filepath.Join(stateDir, file.Name()))
+		}
+	}
+
+	// Get files in section
+	var sectionFiles []string
+	if section := idx.Section(state); section != nil {
+		for _, link := range section.Links {
+			sectionFiles = append(sectionFiles, link.Path)
+		}
+	}
+
+	// Find files in directory but not in section (need to add)
+	sectionFileSet := make(map[string]bool)
+	for _, f := range sectionFiles {
+		sectionFileSet[f] = true
+	}
+
+	for _, docPath := range dirDocs {
+		if !sectionFileSet[docPath] {
+			// Extract metadata and add to section
+			meta, err := extractDocMetadata(docPath)
+			if err != nil {
+				changes = append(changes, fmt.Sprintf("  ⚠ Skipped %s: %v", filepath.Base(docPath), err))
+				warn("Skipped %s: %v", docPath, err)
+				continue
+			}
+			idx.AddLink(state, IndexLink{Number: meta.Number, Title: meta.Title, Path: docPath})
+			changes = append(changes, fmt.Sprintf("  ✓ Added: %s", filepath.Base(docPath)))
+		}
+	}
+
+	// Find files in section but not in directory (need to remove)
+	dirFileSet := make(map[string]bool)
+	for _, f := range dirDocs {
+		dirFileSet[f] = true
+	}
+
+	for _, docPath := range sectionFiles {
+		if !dirFileSet[docPath] {
+			idx.RemoveLink(state, docPath)
+			changes = append(changes, fmt.Sprintf("  ✗ Removed: %s (file not found)", filepath.Base(docPath)))
+		}
+	}
+
+	return changes
+}
+
+// updateIndexCommand synchronizes the index with git-tracked documents
+func updateIndexCommand(ctx context.Context) error {
+	fmt.Fprintln(stdout, "Synchronizing index with git-tracked documents...")
+	fmt.Fprintln(stdout)
+
+	// Get all git-tracked docs
+	gitDocs := getGitTrackedDocs()
+	prog := newProgress("Syncing index", len(gitDocs)+len(states))
+
+	// Read current index
+	indexPath := config.IndexFile
+	content, err := os.ReadFile(repoPath(indexPath))
+	if err != nil && !os.IsNotExist(err) {
+		return errorf(exitEnvironment, "Failed to read index: %v", err)
+	}
+
+	// Report malformed index lines before touching anything
+	idx, parseWarnings, err := loadIndex(indexPath)
+	if err != nil {
+		return errorf(exitFindings, "Failed to parse index: %v", err)
+	}
+	if len(parseWarnings) > 0 {
+		fmt.Fprintln(stdout, "Index Warnings:")
+		for _, w := range parseWarnings {
+			fmt.Fprintf(stdout, "  ⚠ %s\n", w)
+			warn("%s: %s", indexPath, w)
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	// Re-rendering the untouched model shows whether only formatting
+	// differs; a missing or blank index is created instead
+	created := strings.TrimSpace(string(content)) == ""
+	formattingChanged := !created && RenderIndex(idx) != string(content)
+
+	// Sync the table
+	var allChanges []string
+	tableChanges, err := syncIndexTable(ctx, &idx, gitDocs, prog)
+	if err != nil {
+		return abortOperation(prog, err, "index left unchanged")
+	}
+	if len(tableChanges) > 0 {
+		prog.clear()
+		fmt.Fprintln(stdout, "Table Updates:")
+		for _, change := range tableChanges {
+			fmt.Fprintln(stdout, change)
+		}
+		fmt.Fprintln(stdout)
+		allChanges = append(allChanges, tableChanges...)
+	}
+
+	// Sync each state section
+	for _, stateDir := range sortedStateDirs() {
+		if err := ctx.Err(); err != nil {
+			return abortOperation(prog, err, "index left unchanged")
+		}
+		prog.step(stateDir)
+
+		titleCaseState := dirToState[stateDir]
+		sectionChanges := syncStateSection(&idx, titleCaseState, stateDir)
+
+		if len(sectionChanges) > 0 {
+			prog.clear()
+			fmt.Fprintf(stdout, "Section Updates (%s):\n", titleCaseState)
+			for _, change := range sectionChanges {
+				fmt.Fprintln(stdout, change)
+			}
+			fmt.Fprintln(stdout)
+			allChanges = append(allChanges, sectionChanges...)
+		}
+	}
+
+	prog.finish()
+
+	// Report on changes
+	if len(allChanges) == 0 && !formattingChanged && !created {
+		fmt.Fprintln(stdout, "Index is already up to date!")
+	}
+
+	if created {
+		fmt.Fprintf(stdout, "Created %s\n\n", indexPath)
+	}
+	if formattingChanged {
+		fmt.Fprintln(stdout, "Formatting Cleanup:")
+		fmt.Fprintln(stdout, "  ✓ Normalized table order, section spacing, and bullet list formatting")
+		fmt.Fprintln(stdout)
+	}
+
+	// Write updated index if there were any changes
+	if len(allChanges) > 0 || formattingChanged || created {
+		if err := saveIndex(indexPath, idx); err != nil {
+			return errorf(exitEnvironment, "Failed to write index: %v", err)
+		}
+		for _, change := range allChanges {
+			// Drop the display marker, keeping just the description
+			opResult.recordIndexChange("%s", strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(change), "✓✗⚠")))
+		}
+
+		if len(allChanges) > 0 {
+			fmt.Fprintf(stdout, "Summary: %d content changes made to index\n", len(allChanges))
+		}
+		if formattingChanged && len(allChanges) == 0 {
+			fmt.Fprintln(stdout, "Summary: Formatting cleanup applied to index")
+		}
+		appendJournal("index-sync", []string{indexPath}, map[string]string{"changes": strconv.Itoa(len(allChanges))})
+	}
+
+	// Keep the risk register current once it has been generated
+	if _, err := os.Stat(repoPath(riskRegisterPath)); err == nil {
+		if changed, count, err := writeRiskRegister(riskRegisterPath); err != nil {
+			return err
+		} else if changed {
+			fmt.Fprintf(stdout, "Refreshed %s (%d risk(s))\n", riskRegisterPath, count)
+		}
+	}
+	if _, err := os.Stat(repoPath(deprecationRegistryPath)); err == nil {
+		if changed, count, err := writeDeprecationRegistry(deprecationRegistryPath); err != nil {
+			return err
+		} else if changed {
+			fmt.Fprintf(stdout, "Refreshed %s (%d deprecation(s))\n", deprecationRegistryPath, count)
+		}
+	}
+	return nil
+}
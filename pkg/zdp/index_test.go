@@ -0,0 +1,91 @@
+package zdp
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// byNumber returns the entries sorted by number and then title, since
+// rendering puts the table in its configured order
+func byNumber(entries []IndexEntry) []IndexEntry {
+	sorted := append([]IndexEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Number != sorted[j].Number {
+			return sorted[i].Number < sorted[j].Number
+		}
+		return sorted[i].Title < sorted[j].Title
+	})
+	return sorted
+}
+
+// indexSeeds returns the repository's own index along with small indexes
+// exercising the parser's warnings, protected regions, and missing table
+func indexSeeds(t testing.TB) []string {
+	content, err := os.ReadFile("../../00-index.md")
+	if err != nil {
+		t.Fatalf("reading the seed index: %v", err)
+	}
+	return []string{
+		string(content),
+		"# Index\n\n## All Documents by Number\n\n| Number | Title | State | Updated |\n|--------|-------|-------|---------|\n| 0001 | \"A | B\" | Final | 2025-10-04 |\n| 0002 | Short |\n| 0003 | Dated | Draft | yesterday |\n\n## Documents by State\n\n### Draft\n\n- [0003 - Dated](01-draft/0003-dated.md)\n- not a link\n",
+		"# Index\n\n" + generatedBegin + "\n## All Documents by Number\n\n| Number | Title | State | Updated |\n|--------|-------|-------|---------|\n| 0001 | One (superseded by 0002) | Superseded | 2025-10-04 |\n" + generatedEnd + "\n\nHand-written notes.\n",
+		"# Index\n\nNo table here.\n",
+		// Found by FuzzParseIndex: a blank quoted title, malformed numbers
+		// out of order, and a superseded note inside the quotes
+		"| Number | Title | State | Updated |\n|---|---|---|---|\n| 0001 | \" \" | Draft | 2025-10-04 |\n",
+		"| Number | Title | State | Updated |\n|---|---|---|---|\n| 8 | | | |\n| 7A | | | |\n| 100 | | | |\n| 00 | | | |\n| 0 | | | |\n",
+		"| Number | Title | State | Updated |\n|---|---|---|---|\n| 0001 | \"Old (superseded by 0002)\" | Superseded | 2025-10-04 |\n",
+	}
+}
+
+// TestIndexRoundTrip checks that rendering a parsed index and parsing it
+// again gives the same entries and sections, and that rendering is stable
+func TestIndexRoundTrip(t *testing.T) {
+	for i, seed := range indexSeeds(t) {
+		idx, _, err := ParseIndex(seed)
+		if err != nil {
+			continue
+		}
+		rendered := RenderIndex(idx)
+		again, _, err := ParseIndex(rendered)
+		if err != nil {
+			t.Fatalf("seed %d: parsing the rendered index: %v\n%s", i, err, rendered)
+		}
+		if !reflect.DeepEqual(byNumber(idx.Entries), byNumber(again.Entries)) {
+			t.Errorf("seed %d: entries changed in the round trip:\n%#v\n%#v", i, idx.Entries, again.Entries)
+		}
+		if !reflect.DeepEqual(idx.Sections, again.Sections) {
+			t.Errorf("seed %d: sections changed in the round trip:\n%#v\n%#v", i, idx.Sections, again.Sections)
+		}
+		if twice := RenderIndex(again); twice != rendered {
+			t.Errorf("seed %d: rendering is not stable:\n%s\n---\n%s", i, rendered, twice)
+		}
+	}
+}
+
+// FuzzParseIndex checks that ParseIndex never panics and that whatever it
+// accepts renders to an index that parses back to the same model
+func FuzzParseIndex(f *testing.F) {
+	for _, seed := range indexSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		idx, _, err := ParseIndex(content)
+		if err != nil {
+			return
+		}
+		rendered := RenderIndex(idx)
+		again, _, err := ParseIndex(rendered)
+		if err != nil {
+			t.Fatalf("parsing the rendered index: %v\n%s", err, rendered)
+		}
+		if twice := RenderIndex(again); twice != rendered {
+			t.Fatalf("rendering is not stable:\n%q\n%q", rendered, twice)
+		}
+		if before, after := byNumber(idx.Entries), byNumber(again.Entries); !reflect.DeepEqual(before, after) {
+			t.Fatalf("entries changed in the round trip:\n%#v\n%#v", before, after)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package zdp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Repo is a design repository opened from Go code. Its methods run the
+// same operations as the zdp commands but return errors instead of
+// exiting, and write what the commands print to Output. ExitCode
+// classifies the errors the way the command line's exit status does.
+//
+// Documents are addressed relative to Root, and git runs in Root, without
+// changing the process working directory or os.Stdout. Operations on all
+// Repos share the active configuration, so they are serialized.
+type Repo struct {
+	Root   string    // absolute path of the repository
+	Config Config    // settings from .zdp.yaml
+	Output io.Writer // receives what operations print; nil discards it
+}
+
+// repoMu serializes Repo operations, which share the repository root,
+// output, and active configuration
+var repoMu sync.Mutex
+
+// Open opens the design repository at root, reading its .zdp.yaml
+func Open(root string) (*Repo, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", root)
+	}
+	cfg, err := loadConfig(filepath.Join(abs, ".zdp.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid .zdp.yaml: %v", err)
+	}
+	return &Repo{Root: abs, Config: cfg}, nil
+}
+
+// do runs fn against the repository with its configuration active,
+// returning its error
+func (r *Repo) do(fn func() error) error {
+	repoMu.Lock()
+	defer repoMu.Unlock()
+
+	savedConfig, savedWarnings := config, warnings
+	savedRoot, savedStdout := repoRoot, stdout
+	setConfig(r.Config)
+	repoRoot, stdout = r.Root, r.Output
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	defer func() {
+		setConfig(savedConfig)
+		warnings = savedWarnings
+		repoRoot, stdout = savedRoot, savedStdout
+	}()
+	return fn()
+}
+
+// Documents returns every document in the state directories, ordered by
+// number. Paths are relative to Root.
+func (r *Repo) Documents() ([]*Document, error) {
+	var docs []*Document
+	err := r.do(func() error {
+		docs = scanDocuments()
+		sort.SliceStable(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+		return nil
+	})
+	return docs, err
+}
+
+// Document returns the document with the given number ("31" or "0031")
+// or path relative to Root
+func (r *Repo) Document(ref string) (*Document, error) {
+	var doc *Document
+	err := r.do(func() error {
+		var err error
+		if doc, err = findDocument(ref); err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		return nil
+	})
+	return doc, err
+}
+
+// States returns the workflow state names in lifecycle order
+func (r *Repo) States() []string {
+	var names []string
+	r.do(func() error {
+		for _, dir := range sortedStateDirs() {
+			names = append(names, dirToState[dir])
+		}
+		return nil
+	})
+	return names
+}
+
+// Transition moves a document, given by number or path, to a new state:
+// it updates the state header, moves the file with git mv, and updates
+// the index, as "zdp transition" does. Moves the workflow doesn't allow
+// are refused.
+func (r *Repo) Transition(ref, state string) error {
+	return r.do(func() error {
+		doc, err := findDocument(ref)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		return transitionDocument(doc.Path, state, transitionOptions{})
+	})
+}
+
+// Index parses the repository's index file
+func (r *Repo) Index() (*Index, error) {
+	var idx Index
+	err := r.do(func() error {
+		var err error
+		idx, _, err = loadIndex(config.IndexFile)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// UpdateIndex syncs the index with the git-tracked documents, as
+// "zdp update-index" does
+func (r *Repo) UpdateIndex(ctx context.Context) error {
+	return r.do(func() error {
+		return updateIndexCommand(ctx)
+	})
+}
@@ -0,0 +1,958 @@
+package zdp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// State mapping between names and directories
+var states = map[string]string{
+	"draft":        "01-draft",
+	"under review": "02-under-review",
+	"revised":      "03-revised",
+	"accepted":     "04-accepted",
+	"active":       "05-active",
+	"final":        "06-final",
+	"deferred":     "07-deferred",
+	"rejected":     "08-rejected",
+	"withdrawn":    "09-withdrawn",
+	"superseded":   "10-superseded",
+}
+
+// typicalTransitions lists the next states allowed from each state in the
+// built-in workflow, as described under "State Transitions" in README.md.
+// transitions.allowed in .zdp.yaml replaces it; transition refuses other
+// moves unless forced.
+var typicalTransitions = map[string][]string{
+	"draft":        {"Under Review", "Withdrawn"},
+	"under review": {"Revised", "Accepted", "Rejected", "Deferred", "Withdrawn"},
+	"revised":      {"Under Review", "Withdrawn"},
+	"accepted":     {"Active", "Deferred"},
+	"active":       {"Final", "Withdrawn"},
+	"deferred":     {"Under Review", "Rejected", "Withdrawn"},
+	"final":        {"Superseded"},
+	"withdrawn":    {"Draft"},
+}
+
+// Reverse mapping: directory to state name
+var dirToState = map[string]string{
+	"01-draft":        "Draft",
+	"02-under-review": "Under Review",
+	"03-revised":      "Revised",
+	"04-accepted":     "Accepted",
+	"05-active":       "Active",
+	"06-final":        "Final",
+	"07-deferred":     "Deferred",
+	"08-rejected":     "Rejected",
+	"09-withdrawn":    "Withdrawn",
+	"10-superseded":   "Superseded",
+}
+
+// sortedStateDirs returns the state directories in lifecycle order.
+// Ranging over states directly visits them in a different order on every
+// run, so anything that prints or writes uses this instead.
+func sortedStateDirs() []string {
+	if stateOrder != nil {
+		return append([]string(nil), stateOrder...)
+	}
+	var dirs []string
+	for _, dir := range states {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// normalizeState converts input to lowercase with spaces
+func normalizeState(input string) string {
+	// Convert to lowercase and replace hyphens with spaces
+	normalized := strings.ToLower(input)
+	normalized = strings.ReplaceAll(normalized, "-", " ")
+	return strings.TrimSpace(normalized)
+}
+
+// getStateDir returns the directory for a given state name
+func getStateDir(stateName string) (string, error) {
+	normalized := normalizeState(stateName)
+	if dir, ok := states[normalized]; ok {
+		return dir, nil
+	}
+	return "", fmt.Errorf("unsupported state")
+}
+
+// getTitleCaseState returns the title case version of a state
+func getTitleCaseState(stateName string) string {
+	normalized := normalizeState(stateName)
+	if dir, ok := states[normalized]; ok {
+		if name := dirToState[dir]; name != "" {
+			return name
+		}
+		return titleCase(normalized)
+	}
+	return stateName
+}
+
+// titleSmallWords stay lowercase inside a title
+var titleSmallWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true,
+	"by": true, "for": true, "from": true, "in": true, "into": true, "nor": true,
+	"of": true, "on": true, "or": true, "per": true, "the": true, "to": true,
+	"via": true, "vs": true, "with": true,
+}
+
+// titleAcronyms are written in capitals wherever they appear in a title;
+// titles.acronyms in .zdp.yaml adds more
+var titleAcronyms = []string{
+	"API", "AST", "CLI", "CPU", "DSL", "FFI", "GC", "HTML", "HTTP", "ID",
+	"IDE", "IO", "IR", "JIT", "JSON", "LSP", "REPL", "SSA", "TCO", "UI",
+	"URL", "UTF", "VM", "YAML",
+}
+
+// titleAcronym returns the capitalized form of word if it is an acronym
+func titleAcronym(word string) (string, bool) {
+	for _, list := range [][]string{titleAcronyms, config.Acronyms} {
+		for _, acronym := range list {
+			if strings.EqualFold(acronym, word) {
+				return acronym, true
+			}
+		}
+	}
+	return "", false
+}
+
+// titleCase capitalizes a title: small words stay lowercase except first,
+// last, and after a colon; acronyms are capitalized; words already
+// containing capitals, like "McCarthy" or "macOS", are kept as written
+func titleCase(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		// Leading and trailing punctuation, as in "(draft)" or "AST:"
+		start := strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+		if start < 0 {
+			continue
+		}
+		end := strings.LastIndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+		_, size := utf8.DecodeRuneInString(word[end:])
+		end += size
+		core := word[start:end]
+
+		afterColon := i > 0 && strings.HasSuffix(words[i-1], ":")
+		if acronym, ok := titleAcronym(core); ok {
+			core = acronym
+		} else if strings.ToLower(core) == core && !(i > 0 && i < len(words)-1 && !afterColon && titleSmallWords[core]) {
+			r, size := utf8.DecodeRuneInString(core)
+			core = string(unicode.ToTitle(r)) + core[size:]
+		}
+		words[i] = word[:start] + core + word[end:]
+	}
+	return strings.Join(words, " ")
+}
+
+// getCurrentState reads the state from a document
+func getCurrentState(filePath string) (string, error) {
+	header, err := readFrontmatter(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := parseYAML(header)
+	if err != nil {
+		return "", err
+	}
+
+	state, ok := metadata["state"]
+	if !ok {
+		return "", fmt.Errorf("no 'state' field found in document metadata")
+	}
+
+	return state, nil
+}
+
+// moveDocument moves a file from source to destination using git mv
+func moveDocument(srcPath, dstPath string) error {
+	// Ensure destination directory exists
+	dstDir := filepath.Dir(dstPath)
+	if err := os.MkdirAll(repoPath(dstDir), 0755); err != nil {
+		return err
+	}
+
+	// Use git mv to preserve history
+	cmd := repoCommand("git", "mv", srcPath, dstPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git mv failed: %v\nOutput: %s", err, string(output))
+	}
+
+	opResult.recordMove(srcPath, dstPath)
+	return nil
+}
+
+// allowedTransitions returns the states a document in state may move to,
+// and whether the workflow restricts its moves at all
+func allowedTransitions(state string) ([]string, bool) {
+	if config.Transitions == nil {
+		return nil, false
+	}
+	return config.Transitions[normalizeState(state)], true
+}
+
+// nextStates returns the states a document in state may move to: the
+// allowed transitions, or every other state when the workflow has none
+func nextStates(state string) []string {
+	next, restricted := allowedTransitions(state)
+	if !restricted {
+		for _, dir := range sortedStateDirs() {
+			if name := dirToState[dir]; normalizeState(name) != normalizeState(state) {
+				next = append(next, name)
+			}
+		}
+	}
+	if next == nil {
+		next = []string{}
+	}
+	return next
+}
+
+// nextStatesText describes the allowed next states for an error message
+func nextStatesText(state string, next []string) string {
+	if len(next) == 0 {
+		return fmt.Sprintf("%s documents don't move to another state", getTitleCaseState(state))
+	}
+	return "allowed next states: " + strings.Join(next, ", ")
+}
+
+// transitionOptions are the choices a transition is made with
+type transitionOptions struct {
+	Force  bool   // allow a move outside the allowed transitions
+	Reason string // why the document moved, kept in its state-history
+
+	// Record, when set, collects the journal entry, hooks, and unblocking
+	// of the transition instead of running them, so a command with steps
+	// after it can run them only once everything has succeeded
+	Record *[]func() error
+}
+
+// commitNotes are lines for the body of the commit a staged command or
+// repl session makes, such as the reasons given for transitions
+var commitNotes []string
+
+// withCommitNotes adds the collected commit notes to a commit message
+func withCommitNotes(message string) string {
+	if len(commitNotes) == 0 {
+		return message
+	}
+	return strings.TrimRight(message, "\n") + "\n\n" + strings.Join(commitNotes, "\n")
+}
+
+// transitionPlan is what checkTransition found out about a transition
+type transitionPlan struct {
+	From      string   // the document's current state
+	Forced    bool     // the move is outside the allowed transitions
+	Estimated bool     // an Accepted document has an estimate
+	Blockers  []string // blocked-by entries a forced move to Active ignores
+}
+
+// checkTransition checks everything that would make transitionDocument
+// refuse a move, without changing anything, so that commands moving
+// several documents can refuse before writing any of them
+func checkTransition(docPath, newState string, opts transitionOptions) (transitionPlan, error) {
+	var plan transitionPlan
+
+	// Get current state
+	currentState, err := getCurrentState(docPath)
+	if err != nil {
+		return plan, errorf(exitFindings, "Could not parse YAML frontmatter in %s", docPath)
+	}
+	plan.From = currentState
+
+	// Normalize and validate new state
+	normalized := normalizeState(newState)
+	if _, err := getStateDir(newState); err != nil {
+		// List supported states
+		var supported []string
+		for state := range states {
+			supported = append(supported, getTitleCaseState(state))
+		}
+		sort.Strings(supported)
+		return plan, errorf(exitUsage, "Unsupported state \"%s\". Supported states are:\n%s", newState, strings.Join(supported, ", "))
+	}
+
+	// Check if already in that state
+	if normalizeState(currentState) == normalized {
+		return plan, errorf(exitConflict, "Document is already in state \"%s\"", currentState)
+	}
+
+	// Only moves in the transition table are allowed, unless forced
+	if next, restricted := allowedTransitions(currentState); restricted && !containsString(next, getTitleCaseState(newState)) {
+		if !opts.Force {
+			return plan, errorf(exitConflict, "%s → %s is not an allowed transition; %s (pass --force to override)", currentState, getTitleCaseState(newState), nextStatesText(currentState, next))
+		}
+		plan.Forced = true
+	}
+
+	// Documents waiting on blocked-by stay Accepted until every blocker is too
+	if normalized == "active" {
+		if doc, err := extractDocMetadata(docPath); err == nil && len(docRefs(doc.Fields["blocked-by"])) > 0 {
+			byNumber := make(map[string]*Document)
+			for _, other := range scanDocuments() {
+				byNumber[other.Number] = other
+			}
+			if pending := pendingBlockers(doc, byNumber); len(pending) > 0 {
+				if !opts.Force {
+					return plan, errorf(exitConflict, "%s is blocked by %s; it can become Active once they are Accepted (pass --force to override)", doc.Number, strings.Join(pending, ", "))
+				}
+				plan.Blockers = pending
+			}
+		}
+	}
+
+	// Accepted proposals are planned with their estimates, and feature
+	// proposals must say whether they break compatibility
+	content, _ := os.ReadFile(repoPath(docPath))
+	if normalized == "accepted" {
+		metadata, _ := parseYAML(string(content))
+		_, ok, err := parseEstimate(metadata["estimate"])
+		if err != nil {
+			return plan, errorf(exitFindings, "%s: %v", docPath, err)
+		}
+		plan.Estimated = ok
+		if err := checkCompatImpact(metadata, needsCompatImpact(metadata["type"])); err != nil {
+			return plan, errorf(exitFindings, "%s: %v", docPath, err)
+		}
+		if config.ResolveComments {
+			if err := checkCommentsResolved(extractNumberFromFilename(filepath.Base(docPath))); err != nil {
+				return plan, errorf(exitFindings, "%s: %v", docPath, err)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// transitionDocument transitions a document to a new state and records
+// the move, with its reason, in the document's state-history. Moves
+// outside the allowed transitions are refused unless opts.Force is set;
+// lifecycle commands with rules of their own, such as supersede, decide,
+// and expire, force theirs.
+func transitionDocument(docPath, newState string, opts transitionOptions) error {
+	// Validate file exists
+	if _, err := os.Stat(repoPath(docPath)); os.IsNotExist(err) {
+		return errorf(exitUsage, "File not found: %s", docPath)
+	}
+
+	// Check if document has headers, add them if missing
+	content, _ := os.ReadFile(repoPath(docPath))
+	if !hasYAMLFrontmatter(string(content)) {
+		fmt.Fprintln(stdout, "Document missing headers, adding them automatically...")
+		if err := addHeadersToDocument(docPath); err != nil {
+			return err
+		}
+	}
+
+	plan, err := checkTransition(docPath, newState, opts)
+	if err != nil {
+		return err
+	}
+	currentState, forced, estimated := plan.From, plan.Forced, plan.Estimated
+	if len(plan.Blockers) > 0 {
+		warn("%s: made Active while blocked by %s", docPath, strings.Join(plan.Blockers, ", "))
+	}
+	newStateDir, _ := getStateDir(newState)
+	normalized := normalizeState(newState)
+	content, _ = os.ReadFile(repoPath(docPath))
+
+	// Read and update document
+	newStateTitleCase := getTitleCaseState(newState)
+	updatedContent, err := updateYAML(string(content), newStateTitleCase)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to update YAML: %v", err)
+	}
+	updatedContent = appendStateHistory(updatedContent, currentState, newStateTitleCase, opts.Reason)
+
+	// Optionally record late edits when finalizing
+	if normalized == "final" && config.FinalChanges && !strings.Contains(updatedContent, "\n## Changes Since Acceptance\n") {
+		if section := changesSinceAcceptance(docPath); section != "" {
+			updatedContent = strings.TrimRight(updatedContent, "\n") + "\n\n" + section + "\n"
+			fmt.Fprintln(stdout, "Added \"Changes Since Acceptance\" section")
+		} else {
+			fmt.Fprintln(stdout, "⚠ No acceptance found in git history; skipped \"Changes Since Acceptance\"")
+		}
+	}
+	change := currentState + " → " + newStateTitleCase
+	if opts.Reason != "" {
+		change += ": " + opts.Reason
+	}
+	updatedContent = appendRevision(updatedContent, change)
+
+	// Write updated content back to the same file first
+	if err := os.WriteFile(repoPath(docPath), []byte(updatedContent), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to update file: %v", err)
+	}
+	opResult.recordFieldChanges(docPath, string(content), updatedContent)
+
+	// Now use git mv to move to new location
+	filename := filepath.Base(docPath)
+	newPath := filepath.Join(newStateDir, filename)
+
+	if err := moveDocument(docPath, newPath); err != nil {
+		return errorf(exitEnvironment, "Failed to move document: %v", err)
+	}
+
+	// Update index
+	if err := updateIndex(newPath, currentState, newStateTitleCase); err != nil {
+		return errorf(exitEnvironment, "Failed to update index: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "Moved %s from %s to %s\n", filename, currentState, newStateTitleCase)
+	fmt.Fprintln(stdout, "Updated index")
+	if opts.Reason != "" {
+		note := fmt.Sprintf("%s: %s → %s: %s", extractNumberFromFilename(filename), currentState, newStateTitleCase, opts.Reason)
+		commitNotes = append(commitNotes, note)
+		if !staging {
+			fmt.Fprintf(stdout, "Recorded the reason in state-history; for the commit message: %s\n", note)
+		}
+	}
+	if forced {
+		fmt.Fprintf(stdout, "⚠ Forced %s → %s, which is not an allowed transition\n", currentState, newStateTitleCase)
+		warn("%s: forced %s → %s, which is not an allowed transition", newPath, currentState, newStateTitleCase)
+	}
+	if normalized == "accepted" && config.ReleaseNotesDir != "" {
+		if path, err := writeReleaseNote(newPath); err != nil {
+			fmt.Fprintf(stdout, "⚠ Failed to write the release-notes fragment: %v\n", err)
+			warn("Failed to write the release-notes fragment for %s: %v", newPath, err)
+		} else {
+			fmt.Fprintf(stdout, "Wrote release-notes fragment %s\n", path)
+		}
+	}
+	// Documents under review need someone to shepherd them
+	if normalized == "under review" {
+		if metadata, err := parseYAML(updatedContent); err == nil {
+			if champion := metadata["champion"]; champion == "" || strings.EqualFold(champion, "none") {
+				fmt.Fprintf(stdout, "⚠ %s has no champion; assign one with: zdp champion %s <person>\n", filename, newPath)
+				warn("%s is Under Review without a champion", newPath)
+			}
+		}
+	}
+	if normalized == "accepted" && !estimated {
+		fmt.Fprintf(stdout, "⚠ %s has no estimate; add \"estimate: S|M|L\" or a number of weeks for zdp effort\n", filename)
+		warn("%s is Accepted without an estimate", newPath)
+	}
+
+	details := map[string]string{"from": currentState, "to": newStateTitleCase}
+	if opts.Reason != "" {
+		details["reason"] = opts.Reason
+	}
+	if forced {
+		details["forced"] = "true"
+	}
+	record := func() error {
+		appendJournal("transition", []string{docPath, newPath}, details)
+		runHooks("transition", newPath, details)
+		return unblockAfterTransition(extractNumberFromFilename(filename), newStateTitleCase)
+	}
+	if opts.Record != nil {
+		*opts.Record = append(*opts.Record, record)
+		return nil
+	}
+	return record()
+}
+
+// appendStateHistory adds a transition to a document's state-history
+// frontmatter list: the date, the states it moved from and to, and the
+// reason when one was given
+func appendStateHistory(content, from, to, reason string) string {
+	block, body, err := parseFrontmatterBlock(content)
+	if err != nil {
+		return content
+	}
+	fields := []string{
+		"date: " + time.Now().Format("2006-01-02"),
+		"from: " + yamlFlowItem(from),
+		"to: " + yamlFlowItem(to),
+	}
+	if reason != "" {
+		fields = append(fields, "reason: "+yamlFlowItem(reason))
+	}
+	block.appendItem("state-history", fields)
+	return block.String() + body
+}
+
+// revisionHistoryHeading starts the table appendRevision maintains
+const revisionHistoryHeading = "## Revision History"
+
+// revisionHistorySpan finds the "Revision History" section in lines: its
+// heading, the line after it ends, and its last table row, or -1 for
+// those it lacks
+func revisionHistorySpan(lines []string) (start, end, last int) {
+	start, end, last = -1, len(lines), -1
+	var fence codeFence
+	for i, line := range lines {
+		if fence.inCode(line) {
+			continue
+		}
+		if start < 0 {
+			if strings.TrimSpace(line) == revisionHistoryHeading {
+				start = i
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "## ") {
+			end = i
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "|") {
+			last = i
+		}
+	}
+	if start < 0 {
+		end = -1
+	}
+	return start, end, last
+}
+
+// withoutRevisionHistory removes the heading and table of the "Revision
+// History" section, which zdp keeps up to date even in frozen documents.
+// Any other text in the section is kept.
+func withoutRevisionHistory(content string) string {
+	lines := strings.Split(content, "\n")
+	if start, end, _ := revisionHistorySpan(lines); start >= 0 {
+		kept := append([]string{}, lines[:start]...)
+		for _, line := range lines[start+1 : end] {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "|") {
+				kept = append(kept, line)
+			}
+		}
+		lines = append(kept, lines[end:]...)
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+// appendRevision adds a row with today's date, the change, and the
+// current identity to the "Revision History" table of a document,
+// adding the section at the end when it is missing
+func appendRevision(content, change string) string {
+	row := fmt.Sprintf("| %s | %s | %s |", time.Now().Format("2006-01-02"), escapeTableCell(change), escapeTableCell(currentIdentity().Name))
+	table := []string{"| Date | Change | Author |", "|------|--------|--------|", row}
+
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	start, end, last := revisionHistorySpan(lines)
+
+	var insert []string
+	pos := last + 1
+	switch {
+	case start < 0:
+		return strings.Join(lines, "\n") + "\n\n" + revisionHistoryHeading + "\n\n" + strings.Join(table, "\n") + "\n"
+	case last >= 0:
+		insert = []string{row}
+	default:
+		// The section has no table yet
+		pos = end
+		for pos > start+1 && strings.TrimSpace(lines[pos-1]) == "" {
+			pos--
+		}
+		insert = append([]string{""}, table...)
+		if pos < len(lines) && strings.TrimSpace(lines[pos]) != "" {
+			insert = append(insert, "")
+		}
+	}
+	lines = append(lines[:pos], append(insert, lines[pos:]...)...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// abstractHeadingRe matches the headings releaseNote takes a summary from
+var abstractHeadingRe = regexp.MustCompile(`(?i)^(abstract|summary|overview|tl;?dr)$`)
+
+// docAbstract returns the first paragraph of a document's Abstract,
+// Summary, or Overview section, or else of its introduction, as one line
+func docAbstract(body string) string {
+	paragraph := func(lines []string) string {
+		var text []string
+		var fence codeFence
+		for _, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if fence.inCode(line) || strings.HasPrefix(trimmed, "<!--") {
+				continue
+			}
+			if trimmed == "" {
+				if len(text) > 0 {
+					break
+				}
+				continue
+			}
+			text = append(text, trimmed)
+		}
+		return strings.Join(text, " ")
+	}
+
+	sections := splitSections(body)
+	for _, section := range sections {
+		if abstractHeadingRe.MatchString(sectionNumberRe.ReplaceAllString(section.Heading, "")) {
+			if text := paragraph(section.Lines); text != "" {
+				return text
+			}
+		}
+	}
+	// The introduction follows the title heading
+	for i, section := range sections {
+		if i <= 1 && section.Key != "(introduction)" {
+			if text := paragraph(section.Lines); text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// releaseNoteFile is the fragment file for a document: NNNN.md, or
+// NNNN.<type>.md when release-notes.type is set
+func releaseNoteFile(number string) string {
+	name := number + ".md"
+	if config.ReleaseNotesType != "" {
+		name = number + "." + config.ReleaseNotesType + ".md"
+	}
+	return filepath.Join(config.ReleaseNotesDir, name)
+}
+
+// writeReleaseNote writes a towncrier news fragment for a newly accepted
+// document to release-notes.dir: its title, number, and authors, then its
+// abstract. towncrier takes the issue number from the file name. A
+// fragment inside the repository is staged with git add.
+func writeReleaseNote(docPath string) (string, error) {
+	doc, err := extractDocMetadata(docPath)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(repoPath(docPath))
+	if err != nil {
+		return "", err
+	}
+
+	title := "**" + displayTitle(doc.Title) + "**"
+	if config.ReleaseNotesURL != "" {
+		title = fmt.Sprintf("[%s](%s/%s)", title, strings.TrimRight(config.ReleaseNotesURL, "/"), filepath.ToSlash(docPath))
+	}
+	note := fmt.Sprintf("Accepted design %s: %s", doc.Number, title)
+	if doc.Author != "" && !strings.EqualFold(doc.Author, "unknown") {
+		note += " by " + doc.Author
+	}
+	note += "."
+	if abstract := docAbstract(frontmatterRe.ReplaceAllString(string(content), "")); abstract != "" {
+		note += " " + abstract
+	}
+
+	path := releaseNoteFile(doc.Number)
+	if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(repoPath(path), []byte(note+"\n"), 0644); err != nil {
+		return "", err
+	}
+	opResult.recordWrite(path)
+	if rel, err := filepath.Rel(".", path); err == nil && !strings.HasPrefix(rel, "..") {
+		if repoCommand("git", "add", path).Run() == nil {
+			opResult.recordStaged(path)
+		}
+	}
+	return path, nil
+}
+
+// transitionJSON transitions a document and prints the result as JSON,
+// with the command's usual output as the log
+func transitionJSON(docPath, newState string, opts transitionOptions) error {
+	from, _ := getCurrentState(docPath)
+	before := len(warnings)
+	var err error
+	log := captureStdout(func() { err = transitionDocument(docPath, newState, opts) })
+	if err != nil {
+		return err
+	}
+
+	stateDir, _ := getStateDir(newState)
+	newPath := filepath.Join(stateDir, filepath.Base(docPath))
+	doc, err := extractDocMetadata(newPath)
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	return printJSON(map[string]interface{}{
+		"document":      newListedDoc(doc),
+		"from":          from,
+		"to":            doc.State,
+		"previous_path": docPath,
+		"warnings":      append([]string{}, warnings[before:]...),
+		"log":           strings.Split(strings.TrimRight(log, "\n"), "\n"),
+	})
+}
+
+// explainCheck is one rule explain found to apply to a transition
+type explainCheck struct {
+	Kind    string `json:"kind"`   // transition, field, validate, approval, guard, or hook
+	Status  string `json:"status"` // ok, refused, error, warning, or info
+	Message string `json:"message"`
+}
+
+// explanation is what explain reports about a transition
+type explanation struct {
+	Document listedDoc      `json:"document"`
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Allowed  bool           `json:"allowed"`
+	Checks   []explainCheck `json:"checks"`
+}
+
+// explainTransition works out which rules apply to moving doc to
+// newState, and which of them it fails, without changing anything.
+// Refusals are what transition itself enforces; findings are what
+// validate would report once the document is in its new directory.
+func explainTransition(doc *Document, newState string) explanation {
+	e := explanation{Document: newListedDoc(doc), From: doc.State, To: newState, Allowed: true}
+	check := func(kind, status, format string, args ...interface{}) {
+		e.Checks = append(e.Checks, explainCheck{kind, status, fmt.Sprintf(format, args...)})
+		if status == "refused" {
+			e.Allowed = false
+		}
+	}
+
+	newDir, err := getStateDir(newState)
+	if err != nil {
+		check("transition", "refused", "\"%s\" is not a state of this workflow; run zdp states", newState)
+		return e
+	}
+	e.To = getTitleCaseState(newState)
+	normalized := normalizeState(newState)
+	check("transition", "ok", "%s is a state of this workflow (%s/)", e.To, newDir)
+	if normalizeState(doc.State) == normalized {
+		check("transition", "refused", "the document is already %s", doc.State)
+		return e
+	}
+	if next, restricted := allowedTransitions(doc.State); restricted && !containsString(next, e.To) {
+		check("transition", "refused", "%s → %s is not an allowed transition; %s (transition --force overrides this)", doc.State, e.To, nextStatesText(doc.State, next))
+	} else if restricted {
+		check("transition", "ok", "%s → %s is an allowed transition", doc.State, e.To)
+	}
+
+	if normalized == "accepted" {
+		if _, ok, err := parseEstimate(doc.Fields["estimate"]); err != nil {
+			check("field", "refused", "estimate: %v", err)
+		} else if ok {
+			check("field", "ok", "estimate %s can be planned with zdp effort", doc.Fields["estimate"])
+		} else {
+			check("field", "warning", "no estimate; the transition warns, and zdp effort leaves the document out")
+		}
+		if err := checkCompatImpact(doc.Fields, needsCompatImpact(doc.Fields["type"])); err != nil {
+			check("field", "refused", "%v", err)
+		} else if impact := doc.Fields["compat-impact"]; impact != "" {
+			check("field", "ok", "compat-impact is %s", impact)
+		}
+		if config.ResolveComments {
+			if err := checkCommentsResolved(doc.Number); err != nil {
+				check("approval", "refused", "%v (transitions.resolve-comments)", err)
+			} else {
+				check("approval", "ok", "every comment thread is resolved")
+			}
+		}
+	}
+	if normalized == "active" {
+		if blockers := docRefs(doc.Fields["blocked-by"]); len(blockers) > 0 {
+			byNumber := make(map[string]*Document)
+			for _, other := range scanDocuments() {
+				byNumber[other.Number] = other
+			}
+			if pending := pendingBlockers(doc, byNumber); len(pending) > 0 {
+				check("field", "refused", "blocked by %s, which must be Accepted first (transition --force overrides this)", strings.Join(pending, ", "))
+			} else {
+				check("field", "ok", "every proposal in blocked-by (%s) is Accepted", strings.Join(blockers, ", "))
+			}
+		}
+	}
+	if normalized == "final" && config.FinalChanges {
+		check("field", "info", "a \"Changes Since Acceptance\" section will be added (transitions.changes-since-acceptance)")
+	}
+
+	// What validate reports on the moved document
+	if content, err := os.ReadFile(repoPath(doc.Path)); err != nil {
+		check("validate", "error", "%v", err)
+	} else if moved, err := updateYAML(string(content), e.To); err == nil {
+		tmp, err := os.MkdirTemp("", "zdp-explain-")
+		if err == nil {
+			defer os.RemoveAll(repoPath(tmp))
+			movedPath := filepath.Join(tmp, newDir, filepath.Base(doc.Path))
+			if err = os.MkdirAll(repoPath(filepath.Dir(movedPath)), 0755); err == nil {
+				err = os.WriteFile(repoPath(movedPath), []byte(moved), 0644)
+			}
+			if err == nil {
+				diags, _ := filterSuppressed(doc.Path, validateDocument(movedPath))
+				for _, d := range diags {
+					check("validate", d.Severity, "[%s] %s", d.Code, d.Message)
+				}
+				if len(diags) == 0 {
+					check("validate", "ok", "validate would report no findings")
+				}
+			}
+		}
+		if err != nil {
+			check("validate", "error", "%v", err)
+		}
+	}
+
+	// Votes are expected while a document is under review
+	if normalizeState(doc.State) == "under review" && len(config.Voters) > 0 {
+		voted := metaList(doc.Fields["voted"])
+		var pending []string
+		for _, voter := range config.Voters {
+			if !parsePerson(voter).matchesAny(voted) {
+				pending = append(pending, voter)
+			}
+		}
+		if len(pending) == 0 {
+			check("approval", "ok", "all %d voter(s) in review.voters have voted", len(config.Voters))
+		} else {
+			check("approval", "warning", "%d of %d voter(s) in review.voters have not voted: %s", len(pending), len(config.Voters), strings.Join(pending, ", "))
+		}
+	}
+
+	if containsString(config.Guard, normalized) {
+		check("guard", "info", "%s documents are frozen: once committed, edits to the body need %s in the commit message", e.To, config.OverrideToken)
+	}
+	if containsString(config.Guard, normalizeState(doc.State)) {
+		check("guard", "info", "%s documents are frozen; the guard allows moving this one out", doc.State)
+	}
+	for _, command := range config.Hooks["transition"] {
+		check("hook", "info", "runs the transition hook: %s", command)
+	}
+	return e
+}
+
+// explainCommand prints which rules apply to a transition and which the
+// document doesn't yet satisfy. A transition that would be refused exits
+// with findings.
+func explainCommand(args []string) error {
+	var positional []string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		positional = append(positional, args[i])
+	}
+	if err := exactArgs("explain", positional, 2); err != nil {
+		return err
+	}
+	doc, err := findDocument(positional[0])
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+
+	e := explainTransition(doc, positional[1])
+	if asJSON {
+		if err := printJSON(e); err != nil {
+			return err
+		}
+	} else {
+		marks := map[string]string{"ok": "✓", "refused": "✗", "error": "✗", "warning": "⚠", "info": "·"}
+		fmt.Fprintf(stdout, "%s  %s (%s → %s)\n\n", doc.Number, displayTitle(doc.Title), e.From, e.To)
+		for _, c := range e.Checks {
+			fmt.Fprintf(stdout, "  %s %-11s %s\n", marks[c.Status], c.Kind, c.Message)
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	errors, warnings := 0, 0
+	for _, c := range e.Checks {
+		switch c.Status {
+		case "error":
+			errors++
+		case "warning":
+			warnings++
+		}
+	}
+	if !e.Allowed {
+		return errorf(exitFindings, "The transition would be refused")
+	}
+	if !asJSON {
+		fmt.Fprintf(stdout, "The transition is allowed, with %d error(s) and %d warning(s) to address\n", errors, warnings)
+	}
+	return nil
+}
+
+// moveToMatchHeader moves a document to the directory matching its header state
+func moveToMatchHeader(docPath string) error {
+	// Validate file exists
+	if _, err := os.Stat(repoPath(docPath)); os.IsNotExist(err) {
+		return errorf(exitUsage, "File not found: %s", docPath)
+	}
+
+	// Check if document has headers, add them if missing
+	content, _ := os.ReadFile(repoPath(docPath))
+	if !hasYAMLFrontmatter(string(content)) {
+		fmt.Fprintln(stdout, "Document missing headers, adding them automatically...")
+		if err := addHeadersToDocument(docPath); err != nil {
+			return err
+		}
+	}
+
+	// Get state from header
+	headerState, err := getCurrentState(docPath)
+	if err != nil {
+		return errorf(exitFindings, "Could not parse YAML frontmatter in %s", docPath)
+	}
+
+	// Get directory for that state
+	stateDir, err := getStateDir(headerState)
+	if err != nil {
+		var supported []string
+		for state := range states {
+			supported = append(supported, getTitleCaseState(state))
+		}
+		sort.Strings(supported)
+		return errorf(exitUsage, "Unsupported state \"%s\". Supported states are:\n%s", headerState, strings.Join(supported, ", "))
+	}
+
+	// Check if already in correct directory
+	currentDir := filepath.Dir(docPath)
+	if currentDir == stateDir {
+		return errorf(exitConflict, "Document is already in the correct directory for state \"%s\"", headerState)
+	}
+
+	// Move the file
+	filename := filepath.Base(docPath)
+	newPath := filepath.Join(stateDir, filename)
+
+	if err := moveDocument(docPath, newPath); err != nil {
+		return errorf(exitEnvironment, "Failed to move document: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "Moved %s to %s (state: %s)\n", filename, stateDir, headerState)
+	return nil
+}
+
+// listStates lists all supported states in lifecycle order
+func listStates() {
+	for _, dir := range sortedStateDirs() {
+		fmt.Fprintln(stdout, dirToState[dir])
+	}
+}
+
+// listStatesJSON prints the states in lifecycle order with their
+// directories and allowed next states
+func listStatesJSON() error {
+	type stateInfo struct {
+		Name      string   `json:"name"`
+		Directory string   `json:"directory"`
+		Next      []string `json:"next"`
+	}
+	list := []stateInfo{}
+	for _, dir := range sortedStateDirs() {
+		name := dirToState[dir]
+		list = append(list, stateInfo{Name: name, Directory: dir, Next: nextStates(name)})
+	}
+	return printJSON(list)
+}
@@ -0,0 +1,406 @@
+package zdp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Storage is a read-only source of a design repository's files. The
+// working tree is one; the others let read-only commands such as export
+// and serve run where no checkout exists. Paths are slash-separated and
+// relative to the repository root.
+type Storage interface {
+	// Files lists every file in the repository
+	Files(ctx context.Context) ([]string, error)
+	// ReadFile returns the content of the file at name
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+}
+
+// storageBackends open a Storage from the location in a --storage source
+// "scheme:location", by scheme
+var storageBackends = map[string]func(ctx context.Context, location string) (Storage, error){
+	"dir": openDirStorage,
+	"git": openGitStorage,
+	"tar": openTarStorage,
+	"s3":  openS3Storage,
+}
+
+// OpenStorage opens the storage named by source: "dir:PATH" for a working
+// tree, "git:PATH[#REV]" for a (bare) git repository at a revision,
+// "tar:PATH" for a .tar or .tar.gz snapshot, or "s3://BUCKET/PREFIX" for a
+// publicly readable S3 mirror. A plain path is a directory or a tarball.
+func OpenStorage(ctx context.Context, source string) (Storage, error) {
+	scheme, location, found := strings.Cut(source, ":")
+	open, known := storageBackends[scheme]
+	if !found || !known {
+		scheme, location = "dir", source
+		if isTarball(source) {
+			scheme = "tar"
+		}
+		open = storageBackends[scheme]
+	}
+	if location == "" {
+		return nil, fmt.Errorf("no location in storage %q", source)
+	}
+	return open(ctx, location)
+}
+
+// isTarball reports whether name looks like a tar archive
+func isTarball(name string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirStorage is a working tree on disk
+type dirStorage struct {
+	root string
+}
+
+func openDirStorage(ctx context.Context, location string) (Storage, error) {
+	if info, err := os.Stat(location); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", location)
+	}
+	return dirStorage{root: location}, nil
+}
+
+func (s dirStorage) Files(ctx context.Context) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			rel, _ := filepath.Rel(s.root, p)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return ctx.Err()
+	})
+	return names, err
+}
+
+func (s dirStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(name)))
+}
+
+// tarStorage is a snapshot held in memory, read from a tarball or from
+// git archive
+type tarStorage struct {
+	files map[string][]byte
+}
+
+func openTarStorage(ctx context.Context, location string) (Storage, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(location, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", location, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	s, err := readTarball(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	return s, nil
+}
+
+// readTarball loads the regular files of a tar stream. A snapshot made
+// with a single top-level directory, as git archive --prefix and most
+// release tarballs are, is read from inside that directory.
+func readTarball(r io.Reader) (tarStorage, error) {
+	s := tarStorage{files: map[string][]byte{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return s, fmt.Errorf("unsafe path in archive: %s", hdr.Name)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return s, err
+		}
+		s.files[name] = content
+	}
+
+	if _, ok := s.files[".zdp.yaml"]; ok {
+		return s, nil
+	}
+	prefix := ""
+	for name := range s.files {
+		top, _, nested := strings.Cut(name, "/")
+		if !nested || (prefix != "" && top != prefix) {
+			return s, nil
+		}
+		prefix = top
+	}
+	if prefix != "" {
+		stripped := map[string][]byte{}
+		for name, content := range s.files {
+			stripped[strings.TrimPrefix(name, prefix+"/")] = content
+		}
+		s.files = stripped
+	}
+	return s, nil
+}
+
+func (s tarStorage) Files(ctx context.Context) ([]string, error) {
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s tarStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	content, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return content, nil
+}
+
+// gitStorage is a revision of a git repository, which may be bare. Its
+// files are read once with git archive; gitDir lets commands that read
+// history run git against the repository.
+type gitStorage struct {
+	tarStorage
+	gitDir string
+}
+
+func openGitStorage(ctx context.Context, location string) (Storage, error) {
+	repo, rev, _ := strings.Cut(location, "#")
+	if rev == "" {
+		rev = "HEAD"
+	}
+	gitDir, err := exec.CommandContext(ctx, "git", "-C", repo, "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %s", repo)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "archive", "--format=tar", rev)
+	cmd.Stderr = &stderr
+	archive, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git archive %s failed: %s", rev, strings.TrimSpace(stderr.String()))
+	}
+	files, err := readTarball(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	return gitStorage{tarStorage: files, gitDir: strings.TrimSpace(string(gitDir))}, nil
+}
+
+// s3Storage is a mirror of the repository in an S3 bucket, one object
+// per file under prefix. Objects are read anonymously, so the bucket must
+// allow public reads. ZDP_S3_ENDPOINT selects an S3-compatible service
+// instead of AWS.
+type s3Storage struct {
+	base   string // URL of the bucket, ending in a slash
+	prefix string // key prefix, empty or ending in a slash
+}
+
+func openS3Storage(ctx context.Context, location string) (Storage, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "//"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in s3:%s", location)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	base := "https://" + bucket + ".s3.amazonaws.com/"
+	if endpoint := os.Getenv("ZDP_S3_ENDPOINT"); endpoint != "" {
+		base = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/"
+	}
+	return s3Storage{base: base, prefix: prefix}, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response zdp reads
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s s3Storage) Files(ctx context.Context) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := s.get(ctx, s.base+"?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("invalid bucket listing: %v", err)
+		}
+		for _, obj := range result.Contents {
+			if name := strings.TrimPrefix(obj.Key, s.prefix); name != "" && !strings.HasSuffix(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s s3Storage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return s.get(ctx, s.base+(&url.URL{Path: s.prefix + name}).EscapedPath())
+}
+
+// get fetches a URL of the bucket
+func (s s3Storage) get(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return body, nil
+}
+
+// storageCommands are the commands --storage supports. They only read
+// the repository, so they can run on a copy of it.
+var storageCommands = []string{"compare", "effort", "export", "get", "health", "list", "read", "search", "serve", "show", "states", "validate"}
+
+// storagePathFlags are flags naming files outside the repository, which
+// are resolved against the working directory before the command runs in
+// the copy
+var storagePathFlags = []string{"--badge", "--out"}
+
+// withStorage runs a command against a copy of the repository in source,
+// written to a scratch directory. Files the command writes there, such as
+// the search index, are discarded afterwards.
+func withStorage(ctx context.Context, source string, args []string, run func(args []string) error) error {
+	s, err := OpenStorage(ctx, source)
+	if err != nil {
+		return errorf(exitEnvironment, "Cannot open storage %s: %v", source, err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	args = append([]string{}, args...)
+	for i, arg := range args {
+		for _, name := range storagePathFlags {
+			if value, ok := strings.CutPrefix(arg, name+"="); ok && !filepath.IsAbs(value) {
+				args[i] = name + "=" + filepath.Join(cwd, value)
+			} else if arg == name && i+1 < len(args) && !filepath.IsAbs(args[i+1]) {
+				args[i+1] = filepath.Join(cwd, args[i+1])
+			}
+		}
+	}
+
+	scratch, err := os.MkdirTemp("", "zdp-storage-")
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	defer os.RemoveAll(scratch)
+	if err := copyStorage(ctx, s, scratch); err != nil {
+		return errorf(exitEnvironment, "Cannot read storage %s: %v", source, err)
+	}
+
+	if g, ok := s.(gitStorage); ok {
+		for name, value := range map[string]string{"GIT_DIR": g.gitDir, "GIT_WORK_TREE": scratch} {
+			saved, had := os.LookupEnv(name)
+			os.Setenv(name, value)
+			defer func(name, saved string, had bool) {
+				if had {
+					os.Setenv(name, saved)
+				} else {
+					os.Unsetenv(name)
+				}
+			}(name, saved, had)
+		}
+	}
+	if err := os.Chdir(scratch); err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	defer os.Chdir(cwd)
+
+	cfg, err := loadConfig(".zdp.yaml")
+	if err != nil {
+		return errorf(exitEnvironment, "Invalid .zdp.yaml in %s: %v", source, err)
+	}
+	setConfig(cfg)
+	return run(args)
+}
+
+// copyStorage writes every file of s under dir
+func copyStorage(ctx context.Context, s Storage, dir string) error {
+	names, err := s.Files(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		content, err := s.ReadFile(ctx, name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("unsafe path: %s", name)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
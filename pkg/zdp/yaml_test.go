@@ -0,0 +1,44 @@
+package zdp
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseConfigYAMLUnsupported checks that YAML outside the supported
+// subset is an error instead of being read as plain text
+func TestParseConfigYAMLUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		yaml, want string
+	}{
+		{"a: {b: 1}", "flow mappings"},
+		{"a:\n  - {b: 1}", "flow mappings"},
+		{"a: [{b: 1}]", "flow mappings"},
+		{"a: [1, [2]]", "nested flow sequences"},
+		{"a: &x 1\nb: *x", "anchors"},
+		{"b: *x", "aliases"},
+		{"a: !!str 1", "tags"},
+		{"a: |+\n  text", "block scalar indicators"},
+		{"a: - 1", "sequences inside a line"},
+		{"? a\n: 1", "complex keys"},
+		{"a: 1\n---\nb: 2", "multiple YAML documents"},
+	} {
+		_, err := parseConfigYAML(tc.yaml)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("parseConfigYAML(%q) = %v, want an error about %s", tc.yaml, err, tc.want)
+		}
+	}
+
+	for _, yaml := range []string{
+		"---\na: 1",
+		`a: "*quoted"`,
+		"a: '&quoted'",
+		"a: x*y",
+		`a: [x, "y, z"]`,
+		"a: |-\n  text",
+	} {
+		if _, err := parseConfigYAML(yaml); err != nil {
+			t.Errorf("parseConfigYAML(%q): %v", yaml, err)
+		}
+	}
+}
@@ -0,0 +1,15320 @@
+// Package zdp manages a repository of Zylisp design documents: the
+// document store and its frontmatter, the lifecycle state machine, and
+// the generated index. Repo is the entry point for Go programs; Main runs
+// the zdp command line.
+package zdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Exit codes (documented under "Exit Status" in README.md)
+const (
+	exitOK          = 0  // success
+	exitFindings    = 1  // validation findings, or warnings under --strict
+	exitUsage       = 2  // bad arguments or unknown state/file
+	exitEnvironment = 3  // filesystem, git, interruption, or timeout failure
+	exitConflict    = 4  // requested change conflicts with repository state
+	exitInternal    = 70 // a bug in zdp itself (EX_SOFTWARE in sysexits.h)
+)
+
+// cliError carries a failure message and its exit code up to main
+type cliError struct {
+	code int
+	msg  string
+}
+
+// Error returns the failure message
+func (e cliError) Error() string {
+	return e.msg
+}
+
+// errorf returns a failure carrying an exit code, for operations that
+// report errors to their caller
+func errorf(code int, format string, args ...interface{}) error {
+	return cliError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// ExitCode returns the exit status zdp uses for err: 0 for nil, the code
+// an operation attached to the error, or 3 (environment) for any other
+// error. The codes are listed under "Exit Status" in README.md.
+func ExitCode(err error) int {
+	var e cliError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &e):
+		return e.code
+	}
+	return exitEnvironment
+}
+
+// warnings collects non-fatal problems reported during a command
+var warnings []string
+
+// warn records a non-fatal problem; --strict turns these into failures
+func warn(format string, args ...interface{}) {
+	warnings = append(warnings, fmt.Sprintf(format, args...))
+}
+
+// stdout receives what commands print: os.Stdout for the command line,
+// and whatever a Repo was given otherwise
+var stdout io.Writer = os.Stdout
+
+// repoRoot is the repository root relative paths are resolved against.
+// It is empty for the command line, which runs in the repository, and
+// set by Repo, which mustn't change the process working directory.
+var repoRoot string
+
+// repoPath resolves a path relative to the repository root
+func repoPath(name string) string {
+	if repoRoot == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(repoRoot, name)
+}
+
+// repoCommand is exec.Command run in the repository root
+func repoCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = repoRoot
+	return cmd
+}
+
+// repoCommandContext is exec.CommandContext run in the repository root
+func repoCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = repoRoot
+	return cmd
+}
+
+// yamlLine is one significant line of a YAML document
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlError is a syntax error in YAML, located by line and column and
+// quoting the offending line
+type yamlError struct {
+	Line    int
+	Column  int // 1-based; 0 when unknown
+	Snippet string
+	Msg     string
+	near    string // text the error is about, for locating its column
+}
+
+// Error returns the location, message, and offending line
+func (e *yamlError) Error() string {
+	loc := fmt.Sprintf("line %d", e.Line)
+	if e.Column > 0 {
+		loc += fmt.Sprintf(", column %d", e.Column)
+	}
+	if e.Snippet == "" {
+		return loc + ": " + e.Msg
+	}
+	return fmt.Sprintf("%s: %s: %q", loc, e.Msg, e.Snippet)
+}
+
+// parseConfigYAML parses the subset of YAML used by .zdp.yaml and
+// frontmatter: nested block mappings and sequences, flow sequences of
+// scalars, and plain, quoted, or block (|, |-, > and >-) scalars, in a
+// single document. Mappings decode to map[string]interface{}, sequences
+// to []interface{}, and scalars to string. Other YAML, such as flow
+// mappings, anchors and aliases, and tags, is an error rather than being
+// read as plain text.
+func parseConfigYAML(content string) (map[string]interface{}, error) {
+	var lines []yamlLine
+	raw := strings.Split(strings.ReplaceAll(content, "\t", "    "), "\n")
+	for i, line := range raw {
+		text := stripYAMLComment(line)
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if marker := strings.TrimSpace(text); marker == "---" || marker == "..." {
+			if len(lines) > 0 {
+				return nil, &yamlError{Line: i + 1, Column: 1, Snippet: marker, Msg: "multiple YAML documents are not supported"}
+			}
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		lines = append(lines, yamlLine{num: i + 1, indent: indent, text: strings.TrimSpace(text)})
+	}
+
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	p := &yamlParser{lines: lines, raw: raw}
+	value, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, p.locate(err)
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.locate(p.errorAt(p.lines[p.pos], "unexpected indentation"))
+	}
+
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("top level must be a mapping")
+	}
+	return doc, nil
+}
+
+// stripYAMLComment removes a trailing # comment that is not inside quotes
+func stripYAMLComment(line string) string {
+	inSingle, inDouble := false, false
+	for i, r := range line {
+		switch {
+		case r == '\'' && !inDouble:
+			inSingle = !inSingle
+		case r == '"' && !inSingle:
+			inDouble = !inDouble
+		case r == '#' && !inSingle && !inDouble && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return strings.TrimRight(line, " \r")
+}
+
+// yamlParser walks significant lines, tracking the current position
+type yamlParser struct {
+	lines []yamlLine
+	raw   []string
+	pos   int
+}
+
+// errorAt returns a yamlError for the start of line's text
+func (p *yamlParser) errorAt(line yamlLine, format string, args ...interface{}) error {
+	return &yamlError{Line: line.num, Column: line.indent + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// locate fills in the offending line of a yamlError, and its column when
+// only the text it is about is known
+func (p *yamlParser) locate(err error) error {
+	e, ok := err.(*yamlError)
+	if !ok || e.Line < 1 || e.Line > len(p.raw) {
+		return err
+	}
+	line := strings.TrimRight(p.raw[e.Line-1], " \r")
+	if e.Column == 0 && e.near != "" {
+		if i := strings.Index(line, e.near); i >= 0 {
+			e.Column = i + 1
+		}
+	}
+	e.Snippet = strings.TrimSpace(line)
+	return e
+}
+
+// parseBlock parses the mapping or sequence starting at the given indent
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// isYAMLSeqItem reports whether a line starts a block sequence item
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseSequence parses "- item" lines at the given indent
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isYAMLSeqItem(line.text) {
+			break
+		}
+
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if rest == "" {
+			p.pos++
+			value, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+
+		if _, _, isPair := splitYAMLPair(rest); isPair && !strings.ContainsAny(rest[:1], "\"'[{*&!-") {
+			// "- key: value" opens a mapping indented past the dash
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + 2, text: rest}
+			value, err := p.parseMapping(indent + 2)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+
+		value, err := p.parseScalar(rest, line)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// parseMapping parses "key: value" lines at the given indent
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorAt(line, "unexpected indentation")
+		}
+		if isYAMLSeqItem(line.text) {
+			break
+		}
+		if strings.HasPrefix(line.text, "? ") {
+			return nil, p.errorAt(line, "complex keys (? key) are not supported")
+		}
+		if !strings.ContainsAny(line.text[:1], "\"'") {
+			if err := checkPlainYAML(line.text, line.num); err != nil {
+				return nil, err
+			}
+		}
+
+		key, rest, ok := splitYAMLPair(line.text)
+		if !ok {
+			return nil, p.errorAt(line, "expected \"key: value\"")
+		}
+		if _, dup := result[key]; dup {
+			return nil, p.errorAt(line, "duplicate key %q", key)
+		}
+
+		if rest == "" {
+			p.pos++
+			value, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+			continue
+		}
+
+		value, err := p.parseScalar(rest, line)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// parseNested parses the block under a key or dash with an empty value
+func (p *yamlParser) parseNested(parentIndent int) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return "", nil
+	}
+	next := p.lines[p.pos]
+	switch {
+	case next.indent > parentIndent:
+		return p.parseBlock(next.indent)
+	case next.indent == parentIndent && isYAMLSeqItem(next.text):
+		// Sequences may sit at the same indent as their key
+		return p.parseSequence(parentIndent)
+	}
+	return "", nil
+}
+
+// parseScalar decodes an inline value and advances past its line(s)
+func (p *yamlParser) parseScalar(text string, line yamlLine) (interface{}, error) {
+	p.pos++
+
+	switch {
+	case text == "|" || text == ">" || text == "|-" || text == ">-":
+		return p.parseBlockScalar(text, line), nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, &yamlError{Line: line.num, Msg: "unterminated flow sequence", near: text}
+		}
+		return parseYAMLFlowSeq(text[1:len(text)-1], line.num)
+	}
+	if err := checkPlainYAML(text, line.num); err != nil {
+		return nil, err
+	}
+	return unquoteYAML(text, line.num)
+}
+
+// checkPlainYAML rejects an unquoted value that YAML would read as
+// something other than text, which parseConfigYAML does not support
+func checkPlainYAML(text string, lineNum int) error {
+	if text == "" {
+		return nil
+	}
+	unsupported := func(what, hint string) error {
+		return &yamlError{Line: lineNum, Msg: what + " are not supported; " + hint, near: text}
+	}
+	switch text[0] {
+	case '{':
+		return unsupported("flow mappings ({...})", "write the mapping as indented \"key: value\" lines")
+	case '[':
+		return unsupported("nested flow sequences", "write the list as indented \"- item\" lines")
+	case '*':
+		return unsupported("aliases (*name)", "repeat the value, or quote it if it starts with *")
+	case '&':
+		return unsupported("anchors (&name)", "quote the value if it starts with &")
+	case '!':
+		return unsupported("tags (!name)", "quote the value if it starts with !")
+	case '|', '>':
+		return unsupported("block scalar indicators other than |, |-, > and >-", "quote the value if it starts with "+text[:1])
+	case '-':
+		if isYAMLSeqItem(text) {
+			return unsupported("sequences inside a line", "put each \"- item\" on its own line")
+		}
+	}
+	return nil
+}
+
+// parseBlockScalar collects the raw lines of a | or > block scalar
+func (p *yamlParser) parseBlockScalar(style string, line yamlLine) string {
+	var body []string
+	minIndent := -1
+	rawIdx := line.num // raw is 0-based, so this is the line after
+	for ; rawIdx < len(p.raw); rawIdx++ {
+		text := strings.TrimRight(p.raw[rawIdx], " \r")
+		if strings.TrimSpace(text) == "" {
+			body = append(body, "")
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		if indent <= line.indent {
+			break
+		}
+		if minIndent < 0 || indent < minIndent {
+			minIndent = indent
+		}
+		body = append(body, text)
+	}
+
+	// Skip the significant lines the block consumed
+	for p.pos < len(p.lines) && p.lines[p.pos].num <= rawIdx {
+		p.pos++
+	}
+
+	body = trimBlankLines(body)
+	for i, text := range body {
+		if len(text) >= minIndent {
+			body[i] = text[minIndent:]
+		}
+	}
+
+	sep := "\n"
+	if strings.HasPrefix(style, ">") {
+		sep = " "
+	}
+	value := strings.Join(body, sep)
+	if !strings.HasSuffix(style, "-") {
+		value += "\n"
+	}
+	return value
+}
+
+// splitYAMLPair splits "key: value", ignoring colons inside quotes
+func splitYAMLPair(text string) (string, string, bool) {
+	inSingle, inDouble := false, false
+	for i, r := range text {
+		switch {
+		case r == '\'' && !inDouble:
+			inSingle = !inSingle
+		case r == '"' && !inSingle:
+			inDouble = !inDouble
+		case r == ':' && !inSingle && !inDouble && (i == len(text)-1 || text[i+1] == ' '):
+			key, err := unquoteYAML(strings.TrimSpace(text[:i]), 0)
+			if err != nil || key == "" {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLFlowSeq parses the inside of a [a, "b", c] flow sequence
+func parseYAMLFlowSeq(text string, lineNum int) ([]interface{}, error) {
+	items := []interface{}{}
+	var current strings.Builder
+	inSingle, inDouble := false, false
+	flush := func() error {
+		item := strings.TrimSpace(current.String())
+		current.Reset()
+		if item == "" {
+			return nil
+		}
+		if err := checkPlainYAML(item, lineNum); err != nil {
+			return err
+		}
+		value, err := unquoteYAML(item, lineNum)
+		if err != nil {
+			return err
+		}
+		items = append(items, value)
+		return nil
+	}
+
+	for _, r := range text {
+		switch {
+		case r == '\'' && !inDouble:
+			inSingle = !inSingle
+		case r == '"' && !inSingle:
+			inDouble = !inDouble
+		case r == ',' && !inSingle && !inDouble:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// unquoteYAML decodes a plain, 'single', or "double" quoted scalar
+func unquoteYAML(text string, lineNum int) (string, error) {
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", &yamlError{Line: lineNum, Msg: "invalid quoted string", near: text}
+		}
+		return value, nil
+	}
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return "", &yamlError{Line: lineNum, Msg: "unterminated quoted string", near: text}
+	}
+	return text, nil
+}
+
+// Config holds repository settings loaded from .zdp.yaml
+type Config struct {
+	IndexSort          string              // primary table order: "number", "state", or "updated"
+	RecentlyUpdated    int                 // rows in the "Recently Updated" table; 0 omits it
+	TagSections        bool                // add "Documents by Tag" sections to the index
+	Reserved           []numberReservation // numbers skipped by automatic allocation
+	Voters             []string            // people expected to vote on Under Review docs
+	StaleDays          int                 // days without updates before a draft is stale
+	ReviewSLA          int                 // days a document may stay Under Review; 0 disables the check
+	ChampionIdle       int                 // days without commits before a champion is inactive
+	FinalEdits         int                 // commits to a Final doc before it should be revised; 0 disables
+	MaxDeferral        int                 // days a document may stay Deferred; 0 disables expiry
+	ExpiryGrace        int                 // days between the expiry notice and withdrawal
+	BlockOpen          bool                // refuse release tags while targeted docs are open
+	TagPrefix          string              // prefix for release snapshot tags
+	FinalChanges       bool                // add "Changes Since Acceptance" on Final
+	ResolveComments    bool                // refuse Accepted while comment threads are unresolved
+	AutoUnblock        bool                // make blocked documents Active once their blockers are Accepted
+	Federation         []federatedRepo     // design repositories combined by federate
+	Team               []string            // members expected to acknowledge process docs
+	Renames            map[string]string   // outdated terms and their replacements
+	Policies           []policy            // custom validation rules
+	Suppressions       []suppression       // validate findings hidden by code
+	Renderers          []exportRenderer    // external export formats
+	Slugs              string              // non-ASCII titles: "transliterate" or "keep"
+	Acronyms           []string            // extra acronyms capitalized in titles
+	GitHubHosts        []string            // GitHub Enterprise hosts import may fetch from
+	Stylesheet         string              // CSS appended to the exported site's style
+	IndexFile          string              // the index document, 00-index.md by default
+	States             []stateDef          // workflow states in lifecycle order; nil for the built-in ones
+	InitialState       string              // state new documents start in
+	Defaults           map[string]string   // frontmatter fields added to new documents
+	Guard              []string            // normalized states whose content is frozen
+	OverrideToken      string              // commit message token allowing frozen edits
+	Images             imageOptions        // image optimization during export
+	Affiliations       map[string]string   // author names to affiliations in export headers
+	Aliases            map[string]string   // other names and emails of people, mapped to "Name <email>"
+	Hooks              map[string][]string // commands run after lifecycle events, by event
+	Zylisp             string              // interpreter command for .zl hooks and renderers
+	SpecFile           string              // where assemble-spec writes the specification
+	SpecTitle          string              // its title
+	SpecChapters       []specChapter       // its chapters, in order
+	TestRepo           string              // checkout of the main repository, for conformance
+	TestDir            string              // directory in it searched for test IDs
+	ReleaseNotesDir    string              // where accepted documents get a news fragment; "" for none
+	ReleaseNotesType   string              // towncrier fragment type in the file name, if any
+	ReleaseNotesURL    string              // base URL the fragment links documents to, if any
+	ComponentReviewers map[string][]string // reviewers suggested for each component
+	ReviewMaxLoad      int                 // open reviews at which no more are suggested; 0 for no limit
+	MaintainerKeys     []string            // public keys that can open blinded review mappings
+	Transitions        map[string][]string // allowed next states by normalized state; nil allows any move
+}
+
+// imageOptions controls how export prepares images (export.images)
+type imageOptions struct {
+	Optimize            bool  // scale and re-encode PNG and JPEG files
+	MaxWidth, MaxHeight int   // largest size of the main file, in pixels
+	Quality             int   // JPEG quality, 1 to 100
+	Widths              []int // widths of the srcset copies
+}
+
+// stateDef is one workflow state from layout.states in .zdp.yaml
+type stateDef struct {
+	Name string // as written in headers and the index, e.g. "Under Review"
+	Dir  string // directory holding documents in this state
+}
+
+// parseStates reads the layout.states list from .zdp.yaml
+func parseStates(items []interface{}) ([]stateDef, error) {
+	var defs []stateDef
+	names, dirs := map[string]bool{}, map[string]bool{}
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("layout.states: expected a mapping with name and dir, found %v", item)
+		}
+		var def stateDef
+		def.Name, _ = entry["name"].(string)
+		def.Dir, _ = entry["dir"].(string)
+		def.Name, def.Dir = strings.TrimSpace(def.Name), strings.TrimSpace(def.Dir)
+		if def.Name == "" || def.Dir == "" {
+			return nil, fmt.Errorf("layout.states: entry %v needs a name and a dir", item)
+		}
+		if strings.ContainsAny(def.Dir, `/\`) || def.Dir == "." || def.Dir == ".." {
+			return nil, fmt.Errorf("layout.states: dir %q must be a single directory name", def.Dir)
+		}
+		if names[normalizeState(def.Name)] || dirs[def.Dir] {
+			return nil, fmt.Errorf("layout.states: duplicate state %q or dir %q", def.Name, def.Dir)
+		}
+		names[normalizeState(def.Name)], dirs[def.Dir] = true, true
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("layout.states: list at least one state")
+	}
+	return defs, nil
+}
+
+// policy is a custom validation rule from the policies list in .zdp.yaml.
+// Documents matching When (all documents when it is empty) must satisfy
+// Require, both written in the list --where language.
+type policy struct {
+	ID       string
+	Severity string // "error" or "warning"
+	Message  string
+	When     whereExpr
+	Require  whereExpr
+	Field    string // first field Require mentions, for locating findings
+}
+
+// parsePolicies reads the policies list from .zdp.yaml
+func parsePolicies(items []interface{}) ([]policy, error) {
+	var policies []policy
+	seen := make(map[string]bool)
+	for i, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("policies[%d]: expected a mapping with id and require", i)
+		}
+		text := func(key string) string {
+			s, _ := entry[key].(string)
+			return strings.TrimSpace(s)
+		}
+
+		p := policy{ID: text("id"), Severity: text("severity"), Message: text("message")}
+		if p.ID == "" {
+			return nil, fmt.Errorf("policies[%d]: id is required", i)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("policies: duplicate id %q", p.ID)
+		}
+		if validationRuleCodeRe.MatchString(p.ID) {
+			return nil, fmt.Errorf("policies: id %q is reserved for built-in checks", p.ID)
+		}
+		seen[p.ID] = true
+
+		switch p.Severity {
+		case "":
+			p.Severity = "error"
+		case "error", "warning":
+		default:
+			return nil, fmt.Errorf("policy %s: severity must be error or warning, found %q", p.ID, p.Severity)
+		}
+
+		require := text("require")
+		if require == "" {
+			return nil, fmt.Errorf("policy %s: require is required", p.ID)
+		}
+		var err error
+		if p.Require, err = parseWhere(require); err != nil {
+			return nil, fmt.Errorf("policy %s: require: %v", p.ID, err)
+		}
+		if when := text("when"); when != "" {
+			if p.When, err = parseWhere(when); err != nil {
+				return nil, fmt.Errorf("policy %s: when: %v", p.ID, err)
+			}
+		}
+		if p.Message == "" {
+			p.Message = "must satisfy: " + require
+		}
+		if tokens, err := lexWhere(require); err == nil {
+			for _, t := range tokens {
+				if t.kind == "field" {
+					p.Field = strings.TrimPrefix(t.text, "meta.")
+					if p.Field == "age" {
+						p.Field = "updated"
+					}
+					break
+				}
+			}
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// exportRenderer is an external export format listed under export.renderers
+type exportRenderer struct {
+	Name      string
+	Command   string
+	Extension string // file extension; derived from the mime type if empty
+}
+
+// parseRenderers reads the export.renderers list from .zdp.yaml
+func parseRenderers(items []interface{}) ([]exportRenderer, error) {
+	var renderers []exportRenderer
+	seen := map[string]bool{"html": true}
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("export.renderers: expected a mapping with name and command, found %v", item)
+		}
+		var r exportRenderer
+		r.Name, _ = entry["name"].(string)
+		r.Command, _ = entry["command"].(string)
+		r.Extension, _ = entry["extension"].(string)
+		if r.Name == "" || strings.TrimSpace(r.Command) == "" {
+			return nil, fmt.Errorf("export.renderers: entry %v needs a name and a command", item)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("export.renderers: duplicate or reserved name %q", r.Name)
+		}
+		seen[r.Name] = true
+		renderers = append(renderers, r)
+	}
+	return renderers, nil
+}
+
+// specChapter is one chapter of the assembled specification, listed
+// under spec.chapters: sections of a Final document
+type specChapter struct {
+	Title    string
+	Document string   // document number
+	Sections []string // headings to include; the whole body when empty
+}
+
+// parseSpecChapters reads the spec.chapters list from .zdp.yaml
+func parseSpecChapters(items []interface{}) ([]specChapter, error) {
+	var chapters []specChapter
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.chapters: expected a mapping with title and document, found %v", item)
+		}
+		var c specChapter
+		c.Title, _ = entry["title"].(string)
+		if entry["document"] != nil {
+			c.Document = strings.TrimSpace(fmt.Sprint(entry["document"]))
+		}
+		refs := docRefs(c.Document)
+		if strings.TrimSpace(c.Title) == "" || len(refs) != 1 {
+			return nil, fmt.Errorf("spec.chapters: entry %v needs a title and a document number", item)
+		}
+		c.Document = refs[0]
+		switch sections := entry["sections"].(type) {
+		case nil:
+		case string:
+			c.Sections = []string{sections}
+		case []interface{}:
+			for _, s := range sections {
+				c.Sections = append(c.Sections, fmt.Sprint(s))
+			}
+		default:
+			return nil, fmt.Errorf("spec.chapters: sections of %q must be a list of headings", c.Title)
+		}
+		chapters = append(chapters, c)
+	}
+	return chapters, nil
+}
+
+// hookEvents are the lifecycle events that run hooks
+var hookEvents = []string{"adopt", "transition"}
+
+// parseHooks reads the hooks mapping from .zdp.yaml: each event names one
+// command or a list of them
+func parseHooks(value interface{}) (map[string][]string, error) {
+	events, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hooks: expected a mapping of events to commands")
+	}
+	hooks := make(map[string][]string)
+	for event, raw := range events {
+		if !containsString(hookEvents, event) {
+			return nil, fmt.Errorf("hooks: unknown event %q (use %s)", event, strings.Join(hookEvents, ", "))
+		}
+		items, isList := raw.([]interface{})
+		if !isList {
+			items = []interface{}{raw}
+		}
+		for _, item := range items {
+			command, isString := item.(string)
+			if !isString || strings.TrimSpace(command) == "" {
+				return nil, fmt.Errorf("hooks.%s: expected commands, found %v", event, item)
+			}
+			hooks[event] = append(hooks[event], command)
+		}
+	}
+	return hooks, nil
+}
+
+// federatedRepo is one design repository listed under federation.repos
+type federatedRepo struct {
+	Name string
+	Path string
+}
+
+// numberReservation is a range of document numbers set aside in .zdp.yaml
+type numberReservation struct {
+	From          int
+	To            int
+	Reason        string
+	AllowExplicit bool // may still be requested with --number
+}
+
+// config is the active configuration, loaded by main
+var config = defaultConfig()
+
+// defaultConfig returns the settings used when .zdp.yaml is absent
+func defaultConfig() Config {
+	return Config{
+		IndexSort:     "number",
+		StaleDays:     30,
+		ChampionIdle:  60,
+		ReviewMaxLoad: 3,
+		FinalEdits:    5,
+		ExpiryGrace:   30,
+		BlockOpen:     true,
+		TagPrefix:     "design-",
+		Slugs:         "transliterate",
+		IndexFile:     "00-index.md",
+		InitialState:  "Draft",
+		Guard:         []string{"superseded", "rejected", "withdrawn"},
+		OverrideToken: "[allow-frozen-edit]",
+		Images:        imageOptions{Optimize: true, MaxWidth: 1600, MaxHeight: 1600, Quality: 80, Widths: []int{480, 960}},
+		Zylisp:        "zylisp run",
+		SpecFile:      "SPEC.md",
+		SpecTitle:     "Zylisp Language Specification",
+		TestRepo:      "../zylisp",
+		TestDir:       "tests",
+		Transitions:   typicalTransitions,
+	}
+}
+
+// builtinStates and builtinDirs are the default workflow, kept while
+// layout.states in .zdp.yaml replaces states and dirToState
+var builtinStates, builtinDirs = states, dirToState
+
+// stateOrder lists the state directories in lifecycle order when
+// layout.states sets it; the built-in directories sort by their prefix
+var stateOrder []string
+
+// setConfig makes cfg the active configuration, including its workflow
+// states
+func setConfig(cfg Config) {
+	config = cfg
+	states, dirToState, stateOrder = builtinStates, builtinDirs, nil
+	if len(cfg.States) == 0 {
+		return
+	}
+	states, dirToState = map[string]string{}, map[string]string{}
+	for _, def := range cfg.States {
+		states[normalizeState(def.Name)] = def.Dir
+		dirToState[def.Dir] = def.Name
+		stateOrder = append(stateOrder, def.Dir)
+	}
+}
+
+// configString reads a string setting at a dotted path such as "index.sort"
+func configString(doc map[string]interface{}, path string) (string, bool, error) {
+	node, ok, err := configValue(doc, path)
+	if err != nil || !ok {
+		return "", ok, err
+	}
+	value, ok := node.(string)
+	if !ok {
+		return "", false, fmt.Errorf("%s: expected a single value", path)
+	}
+	return value, true, nil
+}
+
+// configValue looks up the raw setting at a dotted path
+func configValue(doc map[string]interface{}, path string) (interface{}, bool, error) {
+	var node interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("%s: expected a mapping", path)
+		}
+		if node, ok = m[key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return node, true, nil
+}
+
+// configList reads a sequence setting at a dotted path
+func configList(doc map[string]interface{}, path string) ([]interface{}, bool, error) {
+	node, ok, err := configValue(doc, path)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if node == "" {
+		return nil, true, nil
+	}
+	list, ok := node.([]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("%s: expected a list", path)
+	}
+	return list, true, nil
+}
+
+// parseNumberRange parses "0666" or "0001-0009" into an inclusive range
+func parseNumberRange(text string) (int, int, error) {
+	parts := strings.SplitN(text, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number range %q", text)
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, fmt.Errorf("invalid number range %q", text)
+		}
+	}
+	if from < 1 || to < from || to > 9999 {
+		return 0, 0, fmt.Errorf("invalid number range %q", text)
+	}
+	return from, to, nil
+}
+
+// parseReservations decodes numbers.reserved, accepting either plain
+// "0001-0009" strings or mappings with range, reason, and allow-explicit
+func parseReservations(items []interface{}) ([]numberReservation, error) {
+	var reservations []numberReservation
+	for _, item := range items {
+		var r numberReservation
+		var rangeText string
+
+		switch v := item.(type) {
+		case string:
+			rangeText = v
+		case map[string]interface{}:
+			rangeText, _ = v["range"].(string)
+			r.Reason, _ = v["reason"].(string)
+			explicit, _ := v["allow-explicit"].(string)
+			r.AllowExplicit = explicit == "true"
+		default:
+			return nil, fmt.Errorf("numbers.reserved: unexpected entry %v", item)
+		}
+
+		from, to, err := parseNumberRange(rangeText)
+		if err != nil {
+			return nil, fmt.Errorf("numbers.reserved: %v", err)
+		}
+		r.From, r.To = from, to
+		reservations = append(reservations, r)
+	}
+	return reservations, nil
+}
+
+// parseFederation decodes federation.repos, accepting either plain paths
+// or mappings with name and path. A plain path is named after its last
+// element.
+func parseFederation(items []interface{}) ([]federatedRepo, error) {
+	var repos []federatedRepo
+	seen := make(map[string]bool)
+	for _, item := range items {
+		var repo federatedRepo
+		switch v := item.(type) {
+		case string:
+			repo.Path = v
+		case map[string]interface{}:
+			repo.Name, _ = v["name"].(string)
+			repo.Path, _ = v["path"].(string)
+		default:
+			return nil, fmt.Errorf("federation.repos: unexpected entry %v", item)
+		}
+
+		if repo.Path == "" {
+			return nil, fmt.Errorf("federation.repos: entry %v has no path", item)
+		}
+		if repo.Name == "" {
+			repo.Name = filepath.Base(filepath.Clean(repo.Path))
+		}
+		if seen[repo.Name] {
+			return nil, fmt.Errorf("federation.repos: duplicate name %q", repo.Name)
+		}
+		seen[repo.Name] = true
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// configInt reads a non-negative integer setting at a dotted path
+func configInt(doc map[string]interface{}, path string) (int, bool, error) {
+	value, ok, err := configString(doc, path)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false, fmt.Errorf("%s: expected a non-negative number, found %q", path, value)
+	}
+	return n, true, nil
+}
+
+// configBool reads a true/false setting at a dotted path
+func configBool(doc map[string]interface{}, path string) (bool, bool, error) {
+	value, ok, err := configString(doc, path)
+	if err != nil || !ok {
+		return false, ok, err
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, true, nil
+	case "false", "no", "off":
+		return false, true, nil
+	}
+	return false, false, fmt.Errorf("%s: expected true or false, found %q", path, value)
+}
+
+// loadConfig reads .zdp.yaml from the repository root, if present
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	content, err := os.ReadFile(repoPath(path))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	doc, err := parseConfigYAML(string(content))
+	if err != nil {
+		return cfg, err
+	}
+
+	if sortOrder, ok, err := configString(doc, "index.sort"); err != nil {
+		return cfg, err
+	} else if ok {
+		switch sortOrder {
+		case "number", "state", "updated":
+			cfg.IndexSort = sortOrder
+		default:
+			return cfg, fmt.Errorf("index.sort: must be number, state, or updated, found %q", sortOrder)
+		}
+	}
+
+	if n, ok, err := configInt(doc, "index.recently-updated"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.RecentlyUpdated = n
+	}
+
+	if tags, ok, err := configBool(doc, "index.tag-sections"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.TagSections = tags
+	}
+
+	if items, ok, err := configList(doc, "review.voters"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Voters = nil
+		for _, item := range items {
+			voter, isString := item.(string)
+			if !isString {
+				return cfg, fmt.Errorf("review.voters: expected names, found %v", item)
+			}
+			cfg.Voters = append(cfg.Voters, voter)
+		}
+	}
+
+	if n, ok, err := configInt(doc, "review.stale-days"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.StaleDays = n
+	}
+
+	if n, ok, err := configInt(doc, "review.champion-inactive-days"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.ChampionIdle = n
+	}
+
+	for _, setting := range []struct {
+		path   string
+		target *int
+	}{{"deferral.max-days", &cfg.MaxDeferral}, {"deferral.grace-days", &cfg.ExpiryGrace}, {"review.sla-days", &cfg.ReviewSLA}, {"review.max-load", &cfg.ReviewMaxLoad}} {
+		if n, ok, err := configInt(doc, setting.path); err != nil {
+			return cfg, err
+		} else if ok {
+			if n < 0 {
+				return cfg, fmt.Errorf("%s: must not be negative", setting.path)
+			}
+			*setting.target = n
+		}
+	}
+
+	if items, ok, err := configList(doc, "review.maintainer-keys"); err != nil {
+		return cfg, err
+	} else if ok {
+		for _, item := range items {
+			path, isString := item.(string)
+			if !isString || path == "" {
+				return cfg, fmt.Errorf("review.maintainer-keys: expected paths to public key files, found %v", item)
+			}
+			cfg.MaintainerKeys = append(cfg.MaintainerKeys, path)
+		}
+	}
+
+	if value, ok, err := configValue(doc, "review.components"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		components, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("review.components: expected a mapping of components to reviewers")
+		}
+		cfg.ComponentReviewers = make(map[string][]string)
+		for component, raw := range components {
+			items, isList := raw.([]interface{})
+			if !isList {
+				items = []interface{}{raw}
+			}
+			for _, item := range items {
+				person, isString := item.(string)
+				if !isString || person == "" {
+					return cfg, fmt.Errorf("review.components: expected reviewers for %q, found %v", component, raw)
+				}
+				cfg.ComponentReviewers[component] = append(cfg.ComponentReviewers[component], person)
+			}
+		}
+	}
+
+	if n, ok, err := configInt(doc, "review.final-edit-limit"); err != nil {
+		return cfg, err
+	} else if ok {
+		if n < 0 {
+			return cfg, fmt.Errorf("review.final-edit-limit: must not be negative")
+		}
+		cfg.FinalEdits = n
+	}
+
+	if block, ok, err := configBool(doc, "release.block-open"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.BlockOpen = block
+	}
+
+	if enabled, ok, err := configBool(doc, "transitions.changes-since-acceptance"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.FinalChanges = enabled
+	}
+
+	if enabled, ok, err := configBool(doc, "transitions.resolve-comments"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.ResolveComments = enabled
+	}
+
+	if enabled, ok, err := configBool(doc, "transitions.auto-unblock"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.AutoUnblock = enabled
+	}
+
+	if prefix, ok, err := configString(doc, "release.tag-prefix"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.TagPrefix = prefix
+	}
+
+	if items, ok, err := configList(doc, "team.members"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Team = nil
+		for _, item := range items {
+			member, isString := item.(string)
+			if !isString {
+				return cfg, fmt.Errorf("team.members: expected names, found %v", item)
+			}
+			cfg.Team = append(cfg.Team, member)
+		}
+	}
+
+	if value, ok, err := configValue(doc, "identity.aliases"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		aliases, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("identity.aliases: expected a mapping of names or emails to people")
+		}
+		cfg.Aliases = make(map[string]string)
+		for alias, raw := range aliases {
+			person, isString := raw.(string)
+			if !isString || alias == "" || person == "" {
+				return cfg, fmt.Errorf("identity.aliases: expected a person for %q, found %v", alias, raw)
+			}
+			cfg.Aliases[alias] = person
+		}
+	}
+
+	if items, ok, err := configList(doc, "titles.acronyms"); err != nil {
+		return cfg, err
+	} else if ok {
+		for _, item := range items {
+			acronym, isString := item.(string)
+			if !isString || strings.TrimSpace(acronym) == "" {
+				return cfg, fmt.Errorf("titles.acronyms: expected words, found %v", item)
+			}
+			cfg.Acronyms = append(cfg.Acronyms, strings.TrimSpace(acronym))
+		}
+	}
+
+	if items, ok, err := configList(doc, "github.hosts"); err != nil {
+		return cfg, err
+	} else if ok {
+		for _, item := range items {
+			host, isString := item.(string)
+			if !isString || strings.TrimSpace(host) == "" || strings.Contains(host, "/") {
+				return cfg, fmt.Errorf("github.hosts: expected host names such as github.example.com, found %v", item)
+			}
+			cfg.GitHubHosts = append(cfg.GitHubHosts, strings.ToLower(strings.TrimSpace(host)))
+		}
+	}
+
+	if name, ok, err := configString(doc, "layout.index"); err != nil {
+		return cfg, err
+	} else if ok {
+		if strings.TrimSpace(name) == "" || filepath.Ext(name) != ".md" {
+			return cfg, fmt.Errorf("layout.index: expected a markdown file name, found %q", name)
+		}
+		cfg.IndexFile = name
+	}
+
+	if items, ok, err := configList(doc, "layout.states"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.States, err = parseStates(items); err != nil {
+			return cfg, err
+		}
+		cfg.InitialState = cfg.States[0].Name
+	}
+
+	if name, ok, err := configString(doc, "layout.initial-state"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.InitialState = name
+	}
+	known := false
+	for _, def := range cfg.States {
+		if normalizeState(def.Name) == normalizeState(cfg.InitialState) {
+			cfg.InitialState, known = def.Name, true
+		}
+	}
+	if _, builtin := builtinStates[normalizeState(cfg.InitialState)]; !known && (len(cfg.States) > 0 || !builtin) {
+		return cfg, fmt.Errorf("layout.initial-state: %q is not one of the states", cfg.InitialState)
+	}
+
+	if value, ok, err := configValue(doc, "frontmatter.defaults"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		fields, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("frontmatter.defaults: expected a mapping of field names to values")
+		}
+		cfg.Defaults = make(map[string]string)
+		for key, raw := range fields {
+			if isCoreField(key) {
+				return cfg, fmt.Errorf("frontmatter.defaults: %s is set by zdp and can't have a default", key)
+			}
+			value, isString := raw.(string)
+			if !isString {
+				return cfg, fmt.Errorf("frontmatter.defaults: expected a single value for %s, found %v", key, raw)
+			}
+			cfg.Defaults[key] = value
+		}
+	}
+
+	// The built-in transitions name built-in states, so a custom workflow
+	// needs its own
+	if len(cfg.States) > 0 {
+		cfg.Transitions = nil
+	}
+	if value, ok, err := configValue(doc, "transitions.allowed"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		table, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("transitions.allowed: expected a mapping of states to their next states")
+		}
+		known := map[string]string{}
+		for normalized, dir := range builtinStates {
+			known[normalized] = builtinDirs[dir]
+		}
+		if len(cfg.States) > 0 {
+			known = map[string]string{}
+			for _, def := range cfg.States {
+				known[normalizeState(def.Name)] = def.Name
+			}
+		}
+		cfg.Transitions = map[string][]string{}
+		for from, raw := range table {
+			if known[normalizeState(from)] == "" {
+				return cfg, fmt.Errorf("transitions.allowed: %q is not one of the states", from)
+			}
+			items, isList := raw.([]interface{})
+			if !isList && raw != nil {
+				items = []interface{}{raw}
+			}
+			next := []string{}
+			for _, item := range items {
+				to, isString := item.(string)
+				if !isString || known[normalizeState(to)] == "" {
+					return cfg, fmt.Errorf("transitions.allowed: %v, listed after %s, is not one of the states", item, from)
+				}
+				next = append(next, known[normalizeState(to)])
+			}
+			cfg.Transitions[normalizeState(from)] = next
+		}
+	}
+
+	if items, ok, err := configList(doc, "guard.states"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Guard = nil
+		for _, item := range items {
+			name, isString := item.(string)
+			_, known := builtinStates[normalizeState(name)]
+			if len(cfg.States) > 0 {
+				known = false
+				for _, def := range cfg.States {
+					known = known || normalizeState(def.Name) == normalizeState(name)
+				}
+			}
+			if !isString || !known {
+				return cfg, fmt.Errorf("guard.states: %v is not one of the states", item)
+			}
+			cfg.Guard = append(cfg.Guard, normalizeState(name))
+		}
+	}
+
+	if token, ok, err := configString(doc, "guard.override-token"); err != nil {
+		return cfg, err
+	} else if ok {
+		if strings.TrimSpace(token) == "" {
+			return cfg, fmt.Errorf("guard.override-token: must not be empty")
+		}
+		cfg.OverrideToken = strings.TrimSpace(token)
+	}
+
+	if mode, ok, err := configString(doc, "new.slugs"); err != nil {
+		return cfg, err
+	} else if ok {
+		switch mode {
+		case "transliterate", "keep":
+			cfg.Slugs = mode
+		default:
+			return cfg, fmt.Errorf("new.slugs: must be transliterate or keep, found %q", mode)
+		}
+	}
+
+	if value, ok, err := configValue(doc, "terminology.renames"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		renames, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("terminology.renames: expected a mapping of old terms to new ones")
+		}
+		var olds []string
+		for old := range renames {
+			olds = append(olds, old)
+		}
+		// Sorted, so the same bad entry is reported on every run
+		sort.Strings(olds)
+		cfg.Renames = make(map[string]string)
+		for _, old := range olds {
+			replacement := renames[old]
+			text, isString := replacement.(string)
+			if !isString || old == "" {
+				return cfg, fmt.Errorf("terminology.renames: expected a new term for %q, found %v", old, replacement)
+			}
+			cfg.Renames[old] = text
+		}
+	}
+
+	if items, ok, err := configList(doc, "federation.repos"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.Federation, err = parseFederation(items); err != nil {
+			return cfg, err
+		}
+	}
+
+	if path, ok, err := configString(doc, "export.stylesheet"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Stylesheet = path
+	}
+
+	if value, ok, err := configValue(doc, "authors.affiliations"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		people, isMap := value.(map[string]interface{})
+		if !isMap {
+			return cfg, fmt.Errorf("authors.affiliations: expected a mapping of author names to affiliations")
+		}
+		cfg.Affiliations = make(map[string]string)
+		for name, raw := range people {
+			affiliation, isString := raw.(string)
+			if !isString {
+				return cfg, fmt.Errorf("authors.affiliations: expected a single affiliation for %s, found %v", name, raw)
+			}
+			cfg.Affiliations[name] = affiliation
+		}
+	}
+
+	if enabled, ok, err := configBool(doc, "export.images.optimize"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Images.Optimize = enabled
+	}
+	for key, target := range map[string]*int{"max-width": &cfg.Images.MaxWidth, "max-height": &cfg.Images.MaxHeight, "quality": &cfg.Images.Quality} {
+		if n, ok, err := configInt(doc, "export.images."+key); err != nil {
+			return cfg, err
+		} else if ok {
+			if n < 1 || (key == "quality" && n > 100) {
+				return cfg, fmt.Errorf("export.images.%s: out of range, found %d", key, n)
+			}
+			*target = n
+		}
+	}
+	if items, ok, err := configList(doc, "export.images.widths"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.Images.Widths = nil
+		for _, item := range items {
+			text, _ := item.(string)
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("export.images.widths: expected pixel widths, found %v", item)
+			}
+			cfg.Images.Widths = append(cfg.Images.Widths, n)
+		}
+		sort.Ints(cfg.Images.Widths)
+	}
+
+	if value, ok, err := configValue(doc, "hooks"); err != nil {
+		return cfg, err
+	} else if ok && value != nil {
+		if cfg.Hooks, err = parseHooks(value); err != nil {
+			return cfg, err
+		}
+	}
+
+	if command, ok, err := configString(doc, "scripting.zylisp"); err != nil {
+		return cfg, err
+	} else if ok {
+		if strings.TrimSpace(command) == "" {
+			return cfg, fmt.Errorf("scripting.zylisp: expected an interpreter command")
+		}
+		cfg.Zylisp = command
+	}
+
+	if value, ok, err := configString(doc, "conformance.repo"); err != nil {
+		return cfg, err
+	} else if ok && strings.TrimSpace(value) != "" {
+		cfg.TestRepo = value
+	}
+	if value, ok, err := configString(doc, "conformance.dir"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.TestDir = value
+	}
+
+	if value, ok, err := configString(doc, "release-notes.dir"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.ReleaseNotesDir = strings.TrimSpace(value)
+	}
+	if value, ok, err := configString(doc, "release-notes.type"); err != nil {
+		return cfg, err
+	} else if ok {
+		if value = strings.TrimSpace(value); strings.ContainsAny(value, `./\ `) {
+			return cfg, fmt.Errorf("release-notes.type: %q must be a single word, such as feature", value)
+		}
+		cfg.ReleaseNotesType = value
+	}
+	if value, ok, err := configString(doc, "release-notes.url"); err != nil {
+		return cfg, err
+	} else if ok {
+		cfg.ReleaseNotesURL = strings.TrimSpace(value)
+	}
+
+	if value, ok, err := configString(doc, "spec.output"); err != nil {
+		return cfg, err
+	} else if ok && strings.TrimSpace(value) != "" {
+		cfg.SpecFile = value
+	}
+	if value, ok, err := configString(doc, "spec.title"); err != nil {
+		return cfg, err
+	} else if ok && strings.TrimSpace(value) != "" {
+		cfg.SpecTitle = value
+	}
+	if items, ok, err := configList(doc, "spec.chapters"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.SpecChapters, err = parseSpecChapters(items); err != nil {
+			return cfg, err
+		}
+	}
+
+	if items, ok, err := configList(doc, "export.renderers"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.Renderers, err = parseRenderers(items); err != nil {
+			return cfg, err
+		}
+	}
+
+	if items, ok, err := configList(doc, "policies"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.Policies, err = parsePolicies(items); err != nil {
+			return cfg, err
+		}
+	}
+
+	if items, ok, err := configList(doc, "validation.suppress"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.Suppressions, err = parseSuppressions(items); err != nil {
+			return cfg, err
+		}
+		for _, sup := range cfg.Suppressions {
+			if !knownFindingCode(sup.Code, cfg.Policies) {
+				return cfg, fmt.Errorf("validation.suppress: unknown code %q (run zdp validate --rules)", sup.Code)
+			}
+		}
+	}
+
+	if items, ok, err := configList(doc, "numbers.reserved"); err != nil {
+		return cfg, err
+	} else if ok {
+		if cfg.Reserved, err = parseReservations(items); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// getGitAuthor extracts the author from git history
+func getGitAuthor(filePath string) string {
+	cmd := repoCommand("git", "log", "--format=%an", "--reverse", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return "Unknown"
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > 0 && lines[0] != "" {
+		return lines[0]
+	}
+	return "Unknown"
+}
+
+// getGitAuthors lists everyone who committed to a file, as "Name <email>"
+// after .mailmap, in the order of their first commit
+func getGitAuthors(filePath string) []string {
+	output, err := repoCommand("git", "log", "--format=%aN <%aE>", "--reverse", "--", filePath).Output()
+	if err != nil {
+		return nil
+	}
+	var authors []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !parsePerson(line).matchesAny(authors) {
+			authors = append(authors, line)
+		}
+	}
+	return authors
+}
+
+// getGitUserName returns the configured git user name
+func getGitUserName() string {
+	output, err := repoCommand("git", "config", "user.name").Output()
+	if err != nil || strings.TrimSpace(string(output)) == "" {
+		return "Unknown"
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// identity is the person zdp acts on behalf of
+type identity struct {
+	Name   string
+	Email  string
+	Handle string // e.g. "@ada", from "Name (@ada)"
+}
+
+// actingAs is the identity given with --as, when a maintainer acts on
+// someone else's behalf
+var actingAs *identity
+
+// personHandleRe matches the handle after a person's name, as in
+// "Ada Lovelace (@ada)"
+var personHandleRe = regexp.MustCompile(`\s*\((@[^()\s]+)\)$`)
+
+// parsePerson reads a person field: "Name <email>", an email, or a name,
+// any of them optionally followed by a handle such as "(@ada)"
+func parsePerson(person string) identity {
+	person = strings.TrimSpace(person)
+	handle := ""
+	if m := personHandleRe.FindStringSubmatch(person); m != nil && len(m[0]) < len(person) {
+		handle, person = m[1], strings.TrimSpace(strings.TrimSuffix(person, m[0]))
+	}
+	if open := strings.Index(person, "<"); open >= 0 && strings.HasSuffix(person, ">") {
+		return identity{Name: strings.TrimSpace(person[:open]), Email: strings.TrimSpace(person[open+1 : len(person)-1]), Handle: handle}
+	}
+	if strings.Contains(person, "@") {
+		return identity{Name: person, Email: person, Handle: handle}
+	}
+	return identity{Name: person, Handle: handle}
+}
+
+// String formats the identity as "Name <email> (@handle)", leaving out
+// the parts it doesn't have
+func (id identity) String() string {
+	s := id.Name
+	if id.Email != "" && id.Email != id.Name {
+		s = fmt.Sprintf("%s <%s>", id.Name, id.Email)
+	}
+	if id.Handle != "" {
+		s += " (" + id.Handle + ")"
+	}
+	return s
+}
+
+// withAliases replaces the identity with the person identity.aliases
+// maps it to, if any. The sorted scan keeps the choice stable when more
+// than one alias matches.
+func (id identity) withAliases() (identity, bool) {
+	var aliases []string
+	for alias := range config.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if id.matches(alias) {
+			return parsePerson(config.Aliases[alias]), true
+		}
+	}
+	return id, false
+}
+
+// gitIdentity resolves the user from git config, then the repository's
+// mailmap, then identity.aliases in .zdp.yaml. It also returns the
+// sources that shaped the result, in order.
+func gitIdentity() (identity, []string) {
+	id := identity{Name: getGitUserName()}
+	if output, err := repoCommand("git", "config", "user.email").Output(); err == nil {
+		id.Email = strings.TrimSpace(string(output))
+	}
+	sources := []string{"git config"}
+
+	if id.Email != "" {
+		if output, err := repoCommand("git", "check-mailmap", id.String()).Output(); err == nil {
+			if mapped := parsePerson(strings.TrimSpace(string(output))); mapped != id && mapped.Name != "" {
+				id = mapped
+				sources = append(sources, ".mailmap")
+			}
+		}
+	}
+	if aliased, ok := id.withAliases(); ok {
+		id = aliased
+		sources = append(sources, "identity.aliases in .zdp.yaml")
+	}
+	return id, sources
+}
+
+// currentIdentity is the person zdp records in authored fields, claims,
+// votes, and acknowledgments: the --as identity if given, otherwise the
+// one resolved from git
+func currentIdentity() identity {
+	if actingAs != nil {
+		return *actingAs
+	}
+	id, _ := gitIdentity()
+	return id
+}
+
+// recordedBy returns who actually ran zdp when acting with --as, so
+// records made on someone's behalf say so, or "" otherwise
+func recordedBy() string {
+	if actingAs == nil {
+		return ""
+	}
+	id, _ := gitIdentity()
+	if id.matches(actingAs.String()) {
+		return ""
+	}
+	return id.String()
+}
+
+// whoami prints the identity zdp acts as and where it came from
+func whoami() {
+	id, sources := gitIdentity()
+	if actingAs != nil {
+		fmt.Fprintf(stdout, "Acting as: %s (--as)\n", actingAs.String())
+		fmt.Fprintf(stdout, "Recorded by: %s\n", id.String())
+		return
+	}
+	fmt.Fprintf(stdout, "Name:   %s\n", id.Name)
+	if id.Email != "" {
+		fmt.Fprintf(stdout, "Email:  %s\n", id.Email)
+	}
+	fmt.Fprintf(stdout, "Source: %s\n", strings.Join(sources, ", then "))
+}
+
+// matches reports whether a person field ("Name", "email", or
+// "Name <email>") refers to this identity
+func (id identity) matches(person string) bool {
+	person = strings.TrimSpace(person)
+	if person == "" {
+		return false
+	}
+	if m := personHandleRe.FindStringSubmatch(person); m != nil && len(m[0]) < len(person) {
+		if id.Handle != "" && strings.EqualFold(m[1], id.Handle) {
+			return true
+		}
+		person = strings.TrimSpace(strings.TrimSuffix(person, m[0]))
+	}
+	if open := strings.Index(person, "<"); open >= 0 && strings.HasSuffix(person, ">") {
+		email := person[open+1 : len(person)-1]
+		if id.Email != "" && strings.EqualFold(email, id.Email) {
+			return true
+		}
+		person = strings.TrimSpace(person[:open])
+	}
+	return strings.EqualFold(person, id.Name) || (id.Email != "" && strings.EqualFold(person, id.Email))
+}
+
+// matchesAny reports whether any listed person refers to this identity
+func (id identity) matchesAny(people []string) bool {
+	for _, person := range people {
+		if id.matches(person) {
+			return true
+		}
+	}
+	return false
+}
+
+// getGitLastActivity returns the date of a person's most recent commit
+// in the repository, or "" if they have none
+func getGitLastActivity(person string) string {
+	// --author matches "Name <email>", so a name or an email both work
+	pattern := regexp.QuoteMeta(strings.Trim(strings.TrimSpace(person), "<>"))
+	cmd := repoCommand("git", "log", "-1", "--format=%cs", "--regexp-ignore-case", "--author="+pattern)
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// gitChange is one commit touching a document
+type gitChange struct {
+	Hash    string
+	Date    string
+	Author  string
+	Subject string
+	Path    string // the document's path at that commit
+}
+
+// getGitChanges lists the commits touching a file, newest first,
+// following renames
+func getGitChanges(filePath string) []gitChange {
+	cmd := repoCommand("git", "-c", "core.quotePath=false", "log", "--follow", "--name-only", "--format=%x1e%h%x1f%cs%x1f%an%x1f%s", "--", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+
+	var changes []gitChange
+	for _, record := range strings.Split(string(output), "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		fields := strings.Split(lines[0], "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		change := gitChange{Hash: fields[0], Date: fields[1], Author: fields[2], Subject: fields[3]}
+		for _, line := range lines[1:] {
+			if line = strings.TrimSpace(line); line != "" {
+				change.Path = line
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// finalEdits returns the commits made to a document since it last
+// reached the Final directory, newest first, and the commit that moved it
+// there. ok is false when git has no record of the document in Final.
+func finalEdits(docPath string) (edits []gitChange, finalized gitChange, ok bool) {
+	finalDir, known := states["final"]
+	if !known {
+		return nil, gitChange{}, false
+	}
+	changes := getGitChanges(docPath)
+	final := stateEntry(changes, finalDir)
+	if final < 0 {
+		return nil, gitChange{}, false
+	}
+	return changes[:final], changes[final], true
+}
+
+// stateEntry returns the index in changes (newest first) of the commit
+// that last moved a document into dir: the oldest commit of the newest
+// run of commits in that directory. It returns -1 when git has no record
+// of the document in dir.
+func stateEntry(changes []gitChange, dir string) int {
+	entry := -1
+	for i, change := range changes {
+		if strings.HasPrefix(change.Path, dir+"/") {
+			entry = i
+		} else if entry >= 0 {
+			break
+		}
+	}
+	return entry
+}
+
+// finalChurnDiagnostics warns about Final documents edited more than
+// review.final-edit-limit times since they became Final, which should be
+// revised or superseded instead. Findings are keyed by document path.
+func finalChurnDiagnostics(docs []*Document) map[string][]diagnostic {
+	found := map[string][]diagnostic{}
+	if config.FinalEdits == 0 {
+		return found
+	}
+	for _, doc := range docs {
+		if normalizeState(doc.State) != "final" {
+			continue
+		}
+		edits, finalized, ok := finalEdits(doc.Path)
+		if !ok || len(edits) <= config.FinalEdits {
+			continue
+		}
+		key := filepath.Clean(doc.Path)
+		found[key] = append(found[key], diagnostic{frontmatterFieldLine(doc.Path, "state"), "warning", "ZDP026",
+			fmt.Sprintf("edited %d times since it became Final on %s; revise or supersede it instead", len(edits), finalized.Date)})
+	}
+	return found
+}
+
+// churnReport lists Final documents by the number of commits made since
+// they became Final, most edited first, marking those over
+// review.final-edit-limit
+func churnReport(limit int) {
+	type churned struct {
+		doc       *Document
+		edits     int
+		finalized string
+	}
+	var rows []churned
+	for _, doc := range scanDocuments() {
+		if normalizeState(doc.State) != "final" {
+			continue
+		}
+		if edits, finalized, ok := finalEdits(doc.Path); ok && len(edits) > 0 {
+			rows = append(rows, churned{doc, len(edits), finalized.Date})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].edits != rows[j].edits {
+			return rows[i].edits > rows[j].edits
+		}
+		return docNumberLess(rows[i].doc.Number, rows[j].doc.Number)
+	})
+	if len(rows) == 0 {
+		fmt.Fprintln(stdout, "No Final document has been edited since it became Final")
+		return
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	over := 0
+	for _, row := range rows {
+		mark := " "
+		if config.FinalEdits > 0 && row.edits > config.FinalEdits {
+			mark = "⚠"
+			over++
+		}
+		fmt.Fprintf(stdout, "%s %s  %3d edit(s) since %s  %s\n", mark, row.doc.Number, row.edits, row.finalized, displayTitle(row.doc.Title))
+	}
+	if over > 0 {
+		fmt.Fprintf(stdout, "\n%d document(s) edited more than %d times since becoming Final; consider revising or superseding them\n", over, config.FinalEdits)
+	}
+}
+
+// changesSinceAcceptance renders a "Changes Since Acceptance" section
+// from the commits made after the document first reached the Accepted
+// (or Active) directory. It returns "" when git has no record of the
+// acceptance.
+func changesSinceAcceptance(docPath string) string {
+	changes := getGitChanges(docPath)
+
+	// Walk back from the newest commit to the oldest one in the
+	// Accepted or Active directory; everything newer came after acceptance
+	accepted := -1
+	for i, change := range changes {
+		if strings.HasPrefix(change.Path, states["accepted"]+"/") || strings.HasPrefix(change.Path, states["active"]+"/") {
+			accepted = i
+		} else if accepted >= 0 {
+			break
+		}
+	}
+	if accepted < 0 {
+		return ""
+	}
+
+	acceptance := changes[accepted]
+	lines := []string{
+		"## Changes Since Acceptance",
+		"",
+		fmt.Sprintf("Accepted on %s (%s).", acceptance.Date, acceptance.Hash),
+		"",
+	}
+	if accepted == 0 {
+		lines = append(lines, "No changes were made between acceptance and finalization.")
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines, "Commits touching this document before it became Final:", "",
+		"| Date | Author | Commit | Subject |",
+		"|------|--------|--------|---------|")
+	for i := accepted - 1; i >= 0; i-- {
+		c := changes[i]
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |", c.Date, escapeTableCell(c.Author), c.Hash, escapeTableCell(c.Subject)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getGitCreatedDate extracts the creation date from git history
+func getGitCreatedDate(filePath string) string {
+	cmd := repoCommand("git", "log", "--format=%ai", "--reverse", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return time.Now().Format("2006-01-02")
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > 0 && lines[0] != "" {
+		// Extract just the date portion (YYYY-MM-DD)
+		parts := strings.Fields(lines[0])
+		if len(parts) > 0 {
+			return parts[0]
+		}
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+// getGitUpdatedDate extracts the last modified date from git history
+func getGitUpdatedDate(filePath string) string {
+	cmd := repoCommand("git", "log", "--format=%ai", "-1", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return time.Now().Format("2006-01-02")
+	}
+
+	dateStr := strings.TrimSpace(string(output))
+	if dateStr != "" {
+		// Extract just the date portion (YYYY-MM-DD)
+		parts := strings.Fields(dateStr)
+		if len(parts) > 0 {
+			return parts[0]
+		}
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+// fileMove records a file moved or renamed by a command
+type fileMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// fieldChange records a frontmatter field changed by a command
+type fieldChange struct {
+	File  string `json:"file"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// operationResult is the machine-readable record written by --output
+type operationResult struct {
+	Command       string        `json:"command"`
+	Args          []string      `json:"args"`
+	Moved         []fileMove    `json:"moved"`
+	FieldsChanged []fieldChange `json:"fields_changed"`
+	FilesWritten  []string      `json:"files_written"`
+	GitStaged     []string      `json:"git_staged"`
+	Commits       []string      `json:"commits"`
+	IndexChanges  []string      `json:"index_changes"`
+}
+
+// opResult collects the effects of the running command
+var opResult = &operationResult{}
+
+// recordMove notes a file move
+func (r *operationResult) recordMove(from, to string) {
+	r.Moved = append(r.Moved, fileMove{From: from, To: to})
+}
+
+// recordWrite notes a file write, once per path
+func (r *operationResult) recordWrite(path string) {
+	for _, written := range r.FilesWritten {
+		if written == path {
+			return
+		}
+	}
+	r.FilesWritten = append(r.FilesWritten, path)
+}
+
+// recordIndexChange notes a change written to the index, described as
+// update-index reports it, e.g. "Added: 0042-macros.md"
+func (r *operationResult) recordIndexChange(format string, args ...interface{}) {
+	r.IndexChanges = append(r.IndexChanges, fmt.Sprintf(format, args...))
+}
+
+// recordStaged notes a file staged with git add
+func (r *operationResult) recordStaged(path string) {
+	r.GitStaged = append(r.GitStaged, path)
+}
+
+// recordFieldChanges diffs the frontmatter of two versions of a document
+func (r *operationResult) recordFieldChanges(path, before, after string) {
+	r.recordWrite(path)
+
+	oldMeta, _ := parseYAML(before)
+	newMeta, err := parseYAML(after)
+	if err != nil {
+		return
+	}
+
+	var fields []string
+	for field := range newMeta {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if oldMeta[field] != newMeta[field] {
+			r.FieldsChanged = append(r.FieldsChanged, fieldChange{
+				File:  path,
+				Field: field,
+				Old:   oldMeta[field],
+				New:   newMeta[field],
+			})
+		}
+	}
+}
+
+// writeOperationResult writes the collected record as JSON
+func writeOperationResult(path string, args []string) error {
+	opResult.Args = args
+	if opResult.Command == "" {
+		opResult.Command = "list"
+	}
+
+	// Emit empty lists rather than null so consumers can iterate blindly
+	if opResult.Moved == nil {
+		opResult.Moved = []fileMove{}
+	}
+	if opResult.FieldsChanged == nil {
+		opResult.FieldsChanged = []fieldChange{}
+	}
+	for _, list := range []*[]string{&opResult.Args, &opResult.FilesWritten, &opResult.GitStaged, &opResult.Commits, &opResult.IndexChanges} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+
+	data, err := json.MarshalIndent(opResult, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(repoPath(path), append(data, '\n'), 0644)
+}
+
+// progress renders a single-line progress bar with an ETA on stderr
+type progress struct {
+	label   string
+	total   int
+	done    int
+	start   time.Time
+	enabled bool
+	drawn   bool
+}
+
+// newProgress creates a progress bar, drawn only when stderr is a terminal
+func newProgress(label string, total int) *progress {
+	enabled := false
+	if info, err := os.Stderr.Stat(); err == nil {
+		enabled = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progress{label: label, total: total, start: time.Now(), enabled: enabled}
+}
+
+// step advances the bar by one item and redraws it
+func (p *progress) step(item string) {
+	p.done++
+	if !p.enabled || p.total == 0 {
+		return
+	}
+
+	const width = 30
+	filled := width * p.done / p.total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+	eta := "--"
+	if p.done > 1 {
+		elapsed := time.Since(p.start)
+		remaining := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		eta = remaining.Round(time.Second).String()
+	}
+
+	if len(item) > 30 {
+		item = item[:27] + "..."
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%s [%s] %d/%d ETA %s %s", p.label, bar, p.done, p.total, eta, item)
+	p.drawn = true
+}
+
+// clear erases the bar so regular output can be printed cleanly
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// finish removes the bar once the operation is complete
+func (p *progress) finish() {
+	p.clear()
+}
+
+// operationContext returns a context cancelled by Ctrl-C, SIGTERM, or the timeout
+func operationContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// abortOperation returns the error a cancelled bulk operation fails with,
+// first clearing its progress bar unless it is nil
+func abortOperation(prog *progress, err error, outcome string) error {
+	if prog != nil {
+		prog.clear()
+	}
+	reason := "Interrupted"
+	if err == context.DeadlineExceeded {
+		reason = "Timed out"
+	}
+	return errorf(exitEnvironment, "%s: %s", reason, outcome)
+}
+
+// addDocument adds a new document to the repository with full processing
+func addDocument(docPath string) error {
+	fmt.Fprintf(stdout, "Adding document: %s\n\n", docPath)
+
+	// Validate file exists
+	if _, err := os.Stat(repoPath(docPath)); os.IsNotExist(err) {
+		return errorf(exitUsage, "File not found: %s", docPath)
+	}
+
+	// Step 1: Number Assignment (FIRST priority)
+	original := docPath
+	filename := filepath.Base(docPath)
+	if !hasNumberPrefix(filename) {
+		fmt.Fprintln(stdout, "File does not have a numbered prefix, assigning number...")
+
+		// Get next free number from the index and state directories
+		nextNum, err := nextDocNumber()
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to allocate number: %v", err)
+		}
+
+		fmt.Fprintf(stdout, "Assigning number: %04d\n", nextNum)
+
+		// Rename file with number
+		newPath, err := renameWithNumber(docPath, nextNum)
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to rename file: %v", err)
+		}
+
+		appendJournal("renumber", []string{docPath, newPath}, map[string]string{"number": fmt.Sprintf("%04d", nextNum)})
+		docPath = newPath
+		filename = filepath.Base(docPath)
+		fmt.Fprintf(stdout, "Renamed to: %s\n\n", filename)
+	}
+
+	// Step 2: Move to Project Directory
+	inProject, err := isInProjectDir(docPath)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to check project directory: %v", err)
+	}
+
+	if !inProject {
+		fmt.Fprintln(stdout, "File is outside project directory, moving to project root...")
+
+		cwd, _ := os.Getwd()
+		newPath := filepath.Join(cwd, filename)
+
+		if err := os.Rename(repoPath(docPath), repoPath(newPath)); err != nil {
+			return errorf(exitEnvironment, "Failed to move file to project: %v", err)
+		}
+		opResult.recordMove(docPath, newPath)
+
+		docPath = newPath
+		fmt.Fprintf(stdout, "Moved to: %s\n\n", docPath)
+	}
+
+	// Step 3: State Directory Placement
+	if !isInStateDir(docPath) {
+		draftDir, _ := getStateDir(config.InitialState)
+		fmt.Fprintf(stdout, "File is not in a state directory, moving to %s...\n", draftDir)
+
+		newPath := filepath.Join(draftDir, filename)
+
+		// Ensure draft directory exists
+		if err := os.MkdirAll(repoPath(draftDir), 0755); err != nil {
+			return errorf(exitEnvironment, "Failed to create draft directory: %v", err)
+		}
+
+		if err := os.Rename(repoPath(docPath), repoPath(newPath)); err != nil {
+			return errorf(exitEnvironment, "Failed to move file to draft: %v", err)
+		}
+		opResult.recordMove(docPath, newPath)
+
+		docPath = newPath
+		fmt.Fprintf(stdout, "Moved to: %s\n\n", docPath)
+	}
+
+	// Step 4: Add YAML Frontmatter Headers
+	content, _ := os.ReadFile(repoPath(docPath))
+	if !hasYAMLFrontmatter(string(content)) || strings.Contains(string(content), "number: NNNN") {
+		fmt.Fprintln(stdout, "Adding/updating YAML frontmatter headers...")
+		if err := addHeadersToDocument(docPath); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	// Give the document a short ID unless it has one
+	if doc, err := extractDocMetadata(docPath); err == nil && docShortID(doc) == "" {
+		content, _ := os.ReadFile(repoPath(docPath))
+		id := generateShortID(docSlug(docPath), takenShortIDs(scanDocuments()))
+		if updated, err := setFrontmatterField(string(content), "short-id", id); err == nil {
+			if err := os.WriteFile(repoPath(docPath), []byte(updated), 0644); err != nil {
+				return errorf(exitEnvironment, "Failed to write file: %v", err)
+			}
+			opResult.recordFieldChanges(docPath, string(content), updated)
+			fmt.Fprintf(stdout, "Assigned short ID: %s\n\n", id)
+		}
+	}
+
+	// Step 5: Sync State Header with Directory
+	// Get directory-based state
+	dir := filepath.Dir(docPath)
+	dirName := filepath.Base(dir)
+	dirState, exists := dirToState[dirName]
+
+	if exists {
+		// Check current state in document
+		currentState, err := getCurrentState(docPath)
+		if err == nil && normalizeState(currentState) != normalizeState(dirState) {
+			fmt.Fprintf(stdout, "State header mismatch, updating to match directory: %s\n", dirState)
+
+			content, _ := os.ReadFile(repoPath(docPath))
+			updatedContent, err := updateYAML(string(content), dirState)
+			if err != nil {
+				return errorf(exitEnvironment, "Failed to update YAML: %v", err)
+			}
+
+			if err := os.WriteFile(repoPath(docPath), []byte(updatedContent), 0644); err != nil {
+				return errorf(exitEnvironment, "Failed to write file: %v", err)
+			}
+			opResult.recordFieldChanges(docPath, string(content), updatedContent)
+			fmt.Fprintln(stdout)
+		}
+	}
+
+	// Step 6: Git Add
+	fmt.Fprintln(stdout, "Adding file to git...")
+	cmd := repoCommand("git", "add", docPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errorf(exitEnvironment, "git add failed: %v\nOutput: %s", err, string(output))
+	}
+	opResult.recordStaged(docPath)
+	fmt.Fprintf(stdout, "Git staged: %s\n\n", docPath)
+
+	// Step 7: Update Index
+	fmt.Fprintln(stdout, "Updating index...")
+	if err := addToIndex(docPath); err != nil {
+		return errorf(exitEnvironment, "Failed to update index: %v", err)
+	}
+
+	paths := []string{docPath}
+	if original != docPath {
+		paths = []string{original, docPath}
+	}
+	appendJournal("add", paths, map[string]string{"number": extractNumberFromFilename(filename)})
+	fmt.Fprintf(stdout, "\nSuccessfully added document: %s\n", filename)
+	return nil
+}
+
+// slugRe matches the slug part of a document filename
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// unicodeSlugRe matches slugs kept in their own script (new.slugs: keep)
+var unicodeSlugRe = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{Lm}0-9]+(-[\p{Ll}\p{Lo}\p{Lm}0-9]+)*$`)
+
+// maxSlugBytes keeps generated file names well inside every file
+// system's 255-byte limit
+const maxSlugBytes = 80
+
+// transliterations spells non-ASCII lowercase letters in ASCII: Latin
+// letters with diacritics and ligatures, Greek, and Cyrillic
+var transliterations = map[rune]string{}
+
+func init() {
+	for _, t := range []struct{ from, to string }{
+		{"àáâãäåāăąǎ", "a"}, {"çćĉċč", "c"}, {"ďđð", "d"}, {"èéêëēĕėęě", "e"},
+		{"ĝğġģ", "g"}, {"ĥħ", "h"}, {"ìíîïĩīĭįıǐ", "i"}, {"ĵ", "j"}, {"ķ", "k"},
+		{"ĺļľŀł", "l"}, {"ñńņňŉ", "n"}, {"òóôõöøōŏőǒ", "o"}, {"ŕŗř", "r"},
+		{"śŝşšș", "s"}, {"ţťŧț", "t"}, {"ùúûüũūŭůűųǔ", "u"}, {"ŵ", "w"},
+		{"ýÿŷ", "y"}, {"źżž", "z"},
+		{"ß", "ss"}, {"æ", "ae"}, {"œ", "oe"}, {"þ", "th"}, {"ĳ", "ij"},
+		// Greek
+		{"αά", "a"}, {"β", "v"}, {"γ", "g"}, {"δ", "d"}, {"εέ", "e"}, {"ζ", "z"},
+		{"ηή", "i"}, {"θ", "th"}, {"ιίϊΐ", "i"}, {"κ", "k"}, {"λ", "l"}, {"μ", "m"},
+		{"ν", "n"}, {"ξ", "x"}, {"οό", "o"}, {"π", "p"}, {"ρ", "r"}, {"σς", "s"},
+		{"τ", "t"}, {"υύϋΰ", "y"}, {"φ", "f"}, {"χ", "ch"}, {"ψ", "ps"}, {"ωώ", "o"},
+		// Cyrillic
+		{"а", "a"}, {"б", "b"}, {"в", "v"}, {"гґ", "g"}, {"д", "d"}, {"еэ", "e"},
+		{"ё", "yo"}, {"є", "ye"}, {"ж", "zh"}, {"з", "z"}, {"иі", "i"}, {"ї", "yi"},
+		{"й", "y"}, {"к", "k"}, {"л", "l"}, {"м", "m"}, {"н", "n"}, {"о", "o"},
+		{"п", "p"}, {"р", "r"}, {"с", "s"}, {"т", "t"}, {"у", "u"}, {"ф", "f"},
+		{"х", "kh"}, {"ц", "ts"}, {"ч", "ch"}, {"ш", "sh"}, {"щ", "shch"},
+		{"ъь", ""}, {"ы", "y"}, {"ю", "yu"}, {"я", "ya"},
+	} {
+		for _, r := range t.from {
+			transliterations[r] = t.to
+		}
+	}
+}
+
+// slugify derives a file name slug from a title. In transliterate mode
+// letters are spelled in ASCII where transliterations knows how; letters
+// it can't spell, such as CJK ideographs, are dropped and returned so the
+// caller can say so. In keep mode letters of every script are kept.
+func slugify(title string, keep bool) (slug string, dropped string) {
+	var b, lost strings.Builder
+	sep := false
+	write := func(text string) {
+		if text == "" {
+			return
+		}
+		if sep && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		sep = false
+		b.WriteString(text)
+	}
+
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r == '\'' || r == '’':
+			// "Go's" becomes "gos", not "go-s"
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			write(string(r))
+		case unicode.Is(unicode.Mn, r):
+			// Combining accents of decomposed letters
+		case keep && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			write(string(r))
+		case unicode.IsLetter(r):
+			if ascii, ok := transliterations[r]; ok {
+				write(ascii)
+			} else {
+				lost.WriteRune(r)
+				sep = true
+			}
+		default:
+			sep = true
+		}
+	}
+
+	slug = b.String()
+	if len(slug) > maxSlugBytes {
+		cut := strings.LastIndex(slug[:maxSlugBytes+1], "-")
+		if cut <= 0 {
+			cut = maxSlugBytes
+			for !utf8.RuneStart(slug[cut]) {
+				cut--
+			}
+		}
+		slug = slug[:cut]
+	}
+	return slug, lost.String()
+}
+
+// windowsReservedRe matches names Windows reserves for devices
+var windowsReservedRe = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])$`)
+
+// filenamePortabilityProblem describes why a file name can't be checked
+// out on every common operating system, or returns ""
+func filenamePortabilityProblem(name string) string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	switch {
+	case !utf8.ValidString(name):
+		return "is not valid UTF-8"
+	case len(name) > 255:
+		return fmt.Sprintf("is %d bytes long; most file systems allow 255", len(name))
+	case strings.ContainsAny(name, `<>:"/\|?*`):
+		return "contains one of <>:\"/\\|?*, which Windows does not allow"
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		return "ends with a dot or space, which Windows drops"
+	case windowsReservedRe.MatchString(base):
+		return fmt.Sprintf("is the Windows device name %s", strings.ToUpper(base))
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "contains control characters"
+		}
+		if unicode.Is(unicode.Mn, r) {
+			return "contains combining accents, which macOS and Linux may normalize differently"
+		}
+	}
+	return ""
+}
+
+// builtinDocTypes are the document types shipped with templates; any
+// other templates/<type>.md adds a type of its own
+var builtinDocTypes = []string{"rfc", "adr", "process"}
+
+// docTemplate is a template from templates/: the body skeleton that new
+// renders and the sections validate requires of documents of its type
+type docTemplate struct {
+	Type     string
+	Path     string
+	Body     string   // without the frontmatter
+	Required []string // headings every document of the type must have
+}
+
+// templatePath returns the template file for a document type. rfc keeps
+// the original design-doc.md name.
+func templatePath(docType string) string {
+	if docType == "rfc" {
+		return filepath.Join("templates", "design-doc.md")
+	}
+	return filepath.Join("templates", docType+".md")
+}
+
+// loadTemplate reads the template for a document type, or returns nil
+// when templates/ has none. Required sections are listed in the
+// template's frontmatter as required-sections.
+func loadTemplate(docType string) (*docTemplate, error) {
+	path := templatePath(docType)
+	content, err := os.ReadFile(repoPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &docTemplate{Type: docType, Path: path, Body: string(content)}
+	if m := frontmatterRe.FindStringSubmatchIndex(tmpl.Body); m != nil {
+		doc, err := parseConfigYAML(tmpl.Body[m[2]:m[3]])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		items, _, err := configList(doc, "required-sections")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, item := range items {
+			section, ok := item.(string)
+			if !ok || section == "" {
+				return nil, fmt.Errorf("%s: required-sections: expected section names", path)
+			}
+			tmpl.Required = append(tmpl.Required, section)
+		}
+		tmpl.Body = strings.TrimLeft(tmpl.Body[m[1]:], "\n")
+	}
+	return tmpl, nil
+}
+
+// knownDocType reports whether docType is built in or has a template
+func knownDocType(docType string) bool {
+	if containsString(builtinDocTypes, docType) {
+		return true
+	}
+	_, err := os.Stat(repoPath(templatePath(docType)))
+	return err == nil
+}
+
+// missingSections returns the required sections that no heading of the
+// body names. Headings of any level count, and case is ignored.
+func missingSections(body string, required []string) []string {
+	present := make(map[string]bool)
+	var fence codeFence
+	for _, line := range strings.Split(body, "\n") {
+		if fence.inCode(line) {
+			continue
+		}
+		if m := markdownHeadingRe.FindStringSubmatch(line); m != nil {
+			present[strings.ToLower(strings.TrimSpace(m[2]))] = true
+		}
+	}
+
+	var missing []string
+	for _, section := range required {
+		if !present[strings.ToLower(strings.TrimSpace(section))] {
+			missing = append(missing, section)
+		}
+	}
+	return missing
+}
+
+// newCommand parses the arguments of
+// "new <slug|title> [--slug <slug>] [--number N] [--type rfc|adr|process]"
+func newCommand(args []string) error {
+	var name, slug string
+	requested := 0
+	docType := "rfc"
+	const usage = "Usage: zdp new <slug|title> [--slug <slug>] [--number N] [--type rfc|adr|process]"
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--number"); err != nil {
+			return err
+		} else if ok {
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				return errorf(exitUsage, "Invalid --number value \"%s\"", value)
+			}
+			requested = num
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--slug"); err != nil {
+			return err
+		} else if ok {
+			slug = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--type"); err != nil {
+			return err
+		} else if ok {
+			docType = strings.ToLower(value)
+			if !slugRe.MatchString(docType) || !knownDocType(docType) {
+				return errorf(exitUsage, "Unknown document type \"%s\" (use rfc, adr, process, or add templates/%s.md)", value, value)
+			}
+			continue
+		}
+		if name != "" {
+			return errorf(exitUsage, usage)
+		}
+		name = args[i]
+	}
+
+	if name == "" {
+		return errorf(exitUsage, usage)
+	}
+
+	// A slug names the document as before; anything else is a title
+	var title string
+	if slugRe.MatchString(strings.TrimSuffix(name, ".md")) {
+		if slug == "" {
+			slug = name
+		} else {
+			title = slugToTitle(strings.TrimSuffix(name, ".md"))
+		}
+	} else {
+		title = strings.TrimSpace(name)
+		if slug == "" {
+			var dropped string
+			slug, dropped = slugify(title, config.Slugs == "keep")
+			if slug == "" {
+				return errorf(exitUsage, "Cannot make a file name from \"%s\": no characters have an ASCII spelling.\nPass --slug, or set new.slugs: keep in .zdp.yaml", title)
+			}
+			if dropped != "" {
+				fmt.Fprintf(os.Stderr, "⚠ Left \"%s\" out of the file name (no ASCII spelling); pass --slug to choose one\n", dropped)
+				warn("Left \"%s\" out of the file name of \"%s\"", dropped, title)
+			}
+		}
+	}
+	return newDocument(slug, title, docType, requested)
+}
+
+// newDocument creates a draft from the template for its type. The
+// title defaults to one derived from the slug.
+func newDocument(slug, title, docType string, requested int) error {
+	slug = strings.TrimSuffix(slug, ".md")
+	if !slugRe.MatchString(slug) && !(config.Slugs == "keep" && unicodeSlugRe.MatchString(slug)) {
+		return errorf(exitUsage, "Invalid slug \"%s\": use lowercase letters, digits, and hyphens", slug)
+	}
+	if len(slug) > maxSlugBytes {
+		return errorf(exitUsage, "Slug \"%s\" is longer than %d bytes", slug, maxSlugBytes)
+	}
+	if problem := filenamePortabilityProblem(slug + ".md"); problem != "" {
+		return errorf(exitUsage, "Slug \"%s\" %s", slug, problem)
+	}
+	if title == "" {
+		title = slugToTitle(slug)
+	}
+
+	// Allocate the number
+	number := requested
+	if requested > 0 {
+		if err := checkRequestedNumber(requested); err != nil {
+			return errorf(exitConflict, "%v", err)
+		}
+	} else {
+		next, err := nextDocNumber()
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to allocate number: %v", err)
+		}
+		number = next
+	}
+
+	draftDir, _ := getStateDir(config.InitialState)
+	docPath := filepath.Join(draftDir, fmt.Sprintf("%04d-%s.md", number, slug))
+	if _, err := os.Stat(repoPath(docPath)); err == nil {
+		return errorf(exitConflict, "File already exists: %s", docPath)
+	}
+
+	// Render the template body with the title filled in
+	body := "# Title of Proposal\n"
+	if tmpl, err := loadTemplate(docType); err != nil {
+		return errorf(exitEnvironment, "Failed to read template: %v", err)
+	} else if tmpl != nil {
+		body = tmpl.Body
+	}
+	headingRe := regexp.MustCompile(`(?m)^# .*$`)
+	if loc := headingRe.FindStringIndex(body); loc != nil {
+		body = body[:loc[0]] + "# " + title + body[loc[1]:]
+	}
+
+	today := time.Now().Format("2006-01-02")
+	metadata := map[string]string{
+		"number":         fmt.Sprintf("%04d", number),
+		"title":          title,
+		"author":         currentIdentity().Name,
+		"created":        today,
+		"updated":        today,
+		"state":          config.InitialState,
+		"supersedes":     "None",
+		"superseded-by":  "None",
+		"target-release": "None",
+		"type":           docType,
+		"short-id":       generateShortID(slug, takenShortIDs(scanDocuments())),
+	}
+	for key, value := range config.Defaults {
+		metadata[key] = value
+	}
+	if by := recordedBy(); by != "" {
+		metadata["recorded-by"] = by
+	}
+	content := buildCompleteYAML(metadata) + body
+
+	if err := os.MkdirAll(repoPath(draftDir), 0755); err != nil {
+		return errorf(exitEnvironment, "Failed to create draft directory: %v", err)
+	}
+	if err := os.WriteFile(repoPath(docPath), []byte(content), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write file: %v", err)
+	}
+	opResult.recordFieldChanges(docPath, "", content)
+	fmt.Fprintf(stdout, "Created %s\n", docPath)
+
+	cmd := repoCommand("git", "add", docPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errorf(exitEnvironment, "git add failed: %v\nOutput: %s", err, string(output))
+	}
+	opResult.recordStaged(docPath)
+
+	if err := addToIndex(docPath); err != nil {
+		return errorf(exitEnvironment, "Failed to update index: %v", err)
+	}
+	return nil
+}
+
+// docComment is one review comment in a document's sidecar file
+type docComment struct {
+	ID       int    `json:"id"`
+	Author   string `json:"author"`
+	Date     string `json:"date"`
+	Text     string `json:"text"`
+	Quote    string `json:"quote,omitempty"`
+	Resolved bool   `json:"resolved"`
+
+	RecordedBy   string `json:"recorded_by,omitempty"`   // who added it on Author's behalf
+	Source       string `json:"source,omitempty"`        // where an imported comment came from
+	ResolvedBy   string `json:"resolved_by,omitempty"`   // who resolved its thread
+	ResolvedDate string `json:"resolved_date,omitempty"` // and when
+}
+
+// commentsPath returns the sidecar file holding a document's comments.
+// Sidecars are keyed by number so they survive state moves.
+func commentsPath(number string) string {
+	return filepath.Join(".zdp", "comments", number+".jsonl")
+}
+
+// loadComments reads a document's comment sidecar, if any
+func loadComments(number string) ([]docComment, error) {
+	content, err := os.ReadFile(repoPath(commentsPath(number)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []docComment
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var c docComment
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", commentsPath(number), i+1, err)
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// saveComments writes a document's comment sidecar
+func saveComments(number string, comments []docComment) error {
+	path := commentsPath(number)
+	if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, c := range comments {
+		line, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(repoPath(path), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	opResult.recordWrite(path)
+	return nil
+}
+
+// pullRequestRe matches a GitHub pull request URL, capturing the host,
+// owner, repository, and number
+var pullRequestRe = regexp.MustCompile(`^https?://([^/]+)/([^/]+)/([^/]+)/pull/(\d+)`)
+
+// prReviewComment is the part of a GitHub pull request review comment
+// that import uses
+type prReviewComment struct {
+	ID          int64  `json:"id"`
+	Path        string `json:"path"`
+	Body        string `json:"body"`
+	DiffHunk    string `json:"diff_hunk"`
+	Line        int    `json:"line"`
+	StartLine   int    `json:"start_line"`
+	InReplyToID int64  `json:"in_reply_to_id"`
+	CreatedAt   string `json:"created_at"`
+	HTMLURL     string `json:"html_url"`
+	User        struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// githubAPI returns the API base URL for a pull request host and the
+// token to send it. github.com uses GITHUB_TOKEN or GH_TOKEN; a GitHub
+// Enterprise host must be listed in github.hosts or named by GH_HOST, and
+// uses GH_ENTERPRISE_TOKEN or GITHUB_ENTERPRISE_TOKEN, so a token is never
+// sent to a host taken only from a URL.
+func githubAPI(host string) (api, token string, err error) {
+	host = strings.ToLower(host)
+	if host == "github.com" {
+		token = os.Getenv("GITHUB_TOKEN")
+		if token == "" {
+			token = os.Getenv("GH_TOKEN")
+		}
+		return "https://api.github.com", token, nil
+	}
+	if !containsString(config.GitHubHosts, host) && !strings.EqualFold(os.Getenv("GH_HOST"), host) {
+		return "", "", fmt.Errorf("%s is not a known GitHub host; list it under github.hosts in .zdp.yaml or set GH_HOST", host)
+	}
+	token = os.Getenv("GH_ENTERPRISE_TOKEN")
+	if token == "" {
+		token = os.Getenv("GITHUB_ENTERPRISE_TOKEN")
+	}
+	return "https://" + host + "/api/v3", token, nil
+}
+
+// fetchPRComments fetches every review comment of a pull request from
+// the GitHub API, authenticating as githubAPI describes
+func fetchPRComments(ctx context.Context, prURL string) ([]prReviewComment, error) {
+	m := pullRequestRe.FindStringSubmatch(prURL)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not a pull request URL such as https://github.com/zylisp/design/pull/42", prURL)
+	}
+	api, token, err := githubAPI(m[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var all []prReviewComment
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/%s/pulls/%s/comments?per_page=100&page=%d", api, m[2], m[3], m[4], page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		var batch []prReviewComment
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("GET %s: %v", url, err)
+		}
+		all = append(all, batch...)
+		if len(batch) < 100 {
+			return all, nil
+		}
+	}
+}
+
+// quotedLines returns the lines a review comment targets: the last lines
+// of its diff hunk, without their diff markers
+func quotedLines(c prReviewComment) string {
+	lines := strings.Split(strings.TrimRight(c.DiffHunk, "\n"), "\n")
+	count := 1
+	if c.StartLine > 0 && c.Line >= c.StartLine {
+		count = c.Line - c.StartLine + 1
+	}
+	if count > len(lines)-1 {
+		count = len(lines) - 1
+	}
+	var quoted []string
+	for _, line := range lines[len(lines)-count:] {
+		if strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if line != "" {
+			line = line[1:]
+		}
+		quoted = append(quoted, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(strings.Join(quoted, " "))
+}
+
+// importCommand parses the arguments of "comments import [<doc>] --pr <url>"
+func importCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp comments import [<doc.md|number>] --pr <url>"
+	var prURL, target string
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--pr"); err != nil {
+			return err
+		} else if ok {
+			prURL = value
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || target != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		target = args[i]
+	}
+	if prURL == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+	m := pullRequestRe.FindStringSubmatch(prURL)
+	if m == nil {
+		return errorf(exitUsage, "%q is not a pull request URL such as https://github.com/zylisp/design/pull/42", prURL)
+	}
+	if _, _, err := githubAPI(m[1]); err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+
+	only := ""
+	if target != "" {
+		doc, err := findDocument(target)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		only = doc.Number
+	}
+
+	fetched, err := fetchPRComments(ctx, prURL)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to fetch review comments: %v", err)
+	}
+	imported, err := importPRComments(fetched, only)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to write comments: %v", err)
+	}
+
+	var numbers []string
+	for number := range imported {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return docNumberLess(numbers[i], numbers[j]) })
+	total := 0
+	for _, number := range numbers {
+		fmt.Fprintf(stdout, "Imported %d comment(s) into %s\n", imported[number], commentsPath(number))
+		total += imported[number]
+	}
+	if total == 0 {
+		fmt.Fprintln(stdout, "No new review comments on design documents")
+	}
+	return nil
+}
+
+// importPRComments files pull request review comments into the sidecars
+// of the documents they were made on, matched by the number in the file
+// name, so comments on a document's earlier path still land. Each keeps
+// its author, date, and the lines it targeted, and records its URL as the
+// source, so importing again skips it. Replies name the comment they
+// answer. Only comments on document only are imported when it is set.
+// Returns the number imported per document.
+func importPRComments(fetched []prReviewComment, only string) (map[string]int, error) {
+	sort.SliceStable(fetched, func(i, j int) bool { return fetched[i].ID < fetched[j].ID })
+	sidecars := map[string][]docComment{}
+	localID := map[int64]int{} // GitHub comment IDs to sidecar IDs
+	imported := map[string]int{}
+
+	for _, c := range fetched {
+		base := path.Base(c.Path)
+		if !strings.HasSuffix(base, ".md") || !hasNumberPrefix(base) {
+			continue
+		}
+		number := extractNumberFromFilename(base)
+		if only != "" && number != only {
+			continue
+		}
+
+		comments, loaded := sidecars[number]
+		if !loaded {
+			var err error
+			if comments, err = loadComments(number); err != nil {
+				return nil, err
+			}
+		}
+		id := 1
+		duplicate := false
+		for _, existing := range comments {
+			if existing.ID >= id {
+				id = existing.ID + 1
+			}
+			if existing.Source != "" && existing.Source == c.HTMLURL {
+				duplicate = true
+				localID[c.ID] = existing.ID
+			}
+		}
+		sidecars[number] = comments
+		if duplicate {
+			continue
+		}
+
+		author, _ := identity{Name: "@" + c.User.Login, Handle: "@" + c.User.Login}.withAliases()
+		text := strings.TrimSpace(c.Body)
+		if reply, ok := localID[c.InReplyToID]; ok && c.InReplyToID != 0 {
+			text = fmt.Sprintf("Re #%d: %s", reply, text)
+		}
+		comment := docComment{
+			ID:         id,
+			Author:     author.Name,
+			RecordedBy: recordedBy(),
+			Date:       c.CreatedAt[:min(len(c.CreatedAt), 10)],
+			Text:       text,
+			Quote:      quotedLines(c),
+			Source:     c.HTMLURL,
+		}
+		if c.InReplyToID != 0 {
+			comment.Quote = ""
+		}
+		sidecars[number] = append(comments, comment)
+		localID[c.ID] = id
+		imported[number]++
+	}
+
+	for number, count := range imported {
+		if count == 0 {
+			continue
+		}
+		if err := saveComments(number, sidecars[number]); err != nil {
+			return nil, err
+		}
+	}
+	return imported, nil
+}
+
+// unresolvedComments returns the comments still awaiting resolution
+func unresolvedComments(comments []docComment) []docComment {
+	var open []docComment
+	for _, c := range comments {
+		if !c.Resolved {
+			open = append(open, c)
+		}
+	}
+	return open
+}
+
+// replyRe matches the "Re #N: " a reply starts with
+var replyRe = regexp.MustCompile(`^Re #(\d+): `)
+
+// commentThread is a comment and the replies to it, and to them
+type commentThread struct {
+	Root    docComment   `json:"root"`
+	Replies []docComment `json:"replies"`
+}
+
+// threadRoot returns the ID of the comment that starts c's thread
+func threadRoot(comments []docComment, c docComment) int {
+	byID := make(map[int]docComment)
+	for _, other := range comments {
+		byID[other.ID] = other
+	}
+	seen := map[int]bool{c.ID: true}
+	for {
+		m := replyRe.FindStringSubmatch(c.Text)
+		if m == nil {
+			return c.ID
+		}
+		id, _ := strconv.Atoi(m[1])
+		parent, ok := byID[id]
+		if !ok || seen[id] {
+			return c.ID
+		}
+		seen[id] = true
+		c = parent
+	}
+}
+
+// commentThreads groups comments into threads, in the order they started
+func commentThreads(comments []docComment) []commentThread {
+	var threads []commentThread
+	index := make(map[int]int) // root ID to position in threads
+	for _, c := range comments {
+		root := threadRoot(comments, c)
+		if root == c.ID {
+			index[root] = len(threads)
+			threads = append(threads, commentThread{Root: c, Replies: []docComment{}})
+			continue
+		}
+		if i, ok := index[root]; ok {
+			threads[i].Replies = append(threads[i].Replies, c)
+		}
+	}
+	return threads
+}
+
+// openThreads returns the threads with a comment still unresolved; a
+// reply added after a thread was resolved opens it again
+func openThreads(comments []docComment) []commentThread {
+	var open []commentThread
+	for _, t := range commentThreads(comments) {
+		if len(unresolvedComments(append([]docComment{t.Root}, t.Replies...))) > 0 {
+			open = append(open, t)
+		}
+	}
+	return open
+}
+
+// checkCommentsResolved returns an error naming the open comment threads
+// on a document, for transitions.resolve-comments
+func checkCommentsResolved(number string) error {
+	comments, err := loadComments(number)
+	if err != nil {
+		return err
+	}
+	open := openThreads(comments)
+	if len(open) == 0 {
+		return nil
+	}
+	var ids []string
+	for _, t := range open {
+		ids = append(ids, fmt.Sprintf("#%d", t.Root.ID))
+	}
+	return fmt.Errorf("%d comment thread(s) are unresolved (%s); list them with: zdp comments unresolved %s", len(open), strings.Join(ids, ", "), number)
+}
+
+// commentsCommand handles "comments <doc>", "comments add <doc> <text>
+// [--quote text]", "comments resolve <doc> <id>", "comments unresolved
+// <doc>", and "comments import"
+func commentsCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp comments [add|resolve|unresolved|import] <doc.md|number> ..."
+	if len(args) == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+	if args[0] == "import" {
+		return importCommand(ctx, args[1:])
+	}
+
+	action := "list"
+	if args[0] == "add" || args[0] == "resolve" || args[0] == "unresolved" {
+		action = args[0]
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	docPath, err := docPathArg(args[0])
+	if err != nil {
+		return err
+	}
+	args[0] = docPath
+	meta, err := extractDocMetadata(args[0])
+	if err != nil {
+		return errorf(exitUsage, "Could not read %s: %v", args[0], err)
+	}
+	comments, err := loadComments(meta.Number)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read comments: %v", err)
+	}
+
+	switch action {
+	case "list":
+		if len(comments) == 0 {
+			fmt.Fprintln(stdout, "No comments")
+			return nil
+		}
+		for _, c := range comments {
+			status := "open"
+			if c.Resolved {
+				status = "resolved"
+				if c.ResolvedBy != "" {
+					status += " by " + c.ResolvedBy + " on " + c.ResolvedDate
+				}
+			}
+			fmt.Fprintf(stdout, "#%d [%s] %s (%s)\n", c.ID, status, c.Author, c.Date)
+			if c.Quote != "" {
+				fmt.Fprintf(stdout, "    > %s\n", c.Quote)
+			}
+			fmt.Fprintf(stdout, "    %s\n", c.Text)
+		}
+
+	case "add":
+		var text, quote string
+		for i := 1; i < len(args); i++ {
+			if value, ok, err := flagValue(args, &i, "--quote"); err != nil {
+				return err
+			} else if ok {
+				quote = value
+				continue
+			}
+			text = strings.TrimSpace(text + " " + args[i])
+		}
+		if text == "" {
+			return errorf(exitUsage, "Usage: zdp comments add <doc.md|number> <text> [--quote text]")
+		}
+
+		id := 1
+		for _, c := range comments {
+			if c.ID >= id {
+				id = c.ID + 1
+			}
+		}
+		comments = append(comments, docComment{
+			ID:         id,
+			Author:     currentIdentity().Name,
+			RecordedBy: recordedBy(),
+			Date:       time.Now().Format("2006-01-02"),
+			Text:       text,
+			Quote:      quote,
+		})
+		if err := saveComments(meta.Number, comments); err != nil {
+			return errorf(exitEnvironment, "Failed to write comments: %v", err)
+		}
+		fmt.Fprintf(stdout, "Added comment #%d to %s\n", id, filepath.Base(args[0]))
+
+	case "resolve":
+		if len(args) != 2 {
+			return errorf(exitUsage, "Usage: zdp comments resolve <doc.md|number> <id>")
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return errorf(exitUsage, "Invalid comment id \"%s\"", args[1])
+		}
+		root := -1
+		for _, c := range comments {
+			if c.ID == id {
+				root = threadRoot(comments, c)
+			}
+		}
+		if root < 0 {
+			return errorf(exitUsage, "No comment #%d on %s", id, filepath.Base(args[0]))
+		}
+
+		// Resolving any comment of a thread resolves all of it
+		resolved := 0
+		for i, c := range comments {
+			if c.Resolved || threadRoot(comments, c) != root {
+				continue
+			}
+			comments[i].Resolved = true
+			comments[i].ResolvedBy = currentIdentity().Name
+			comments[i].ResolvedDate = time.Now().Format("2006-01-02")
+			resolved++
+		}
+		if resolved == 0 {
+			fmt.Fprintf(stdout, "Comment #%d on %s is already resolved\n", id, filepath.Base(args[0]))
+			return nil
+		}
+		if err := saveComments(meta.Number, comments); err != nil {
+			return errorf(exitEnvironment, "Failed to write comments: %v", err)
+		}
+		if resolved == 1 {
+			fmt.Fprintf(stdout, "Resolved comment #%d on %s\n", id, filepath.Base(args[0]))
+		} else {
+			fmt.Fprintf(stdout, "Resolved the thread of comment #%d on %s (%d comments)\n", root, filepath.Base(args[0]), resolved)
+		}
+
+	case "unresolved":
+		asJSON := false
+		for i := 1; i < len(args); i++ {
+			if isFlag, json, err := formatFlag(args, &i); err != nil {
+				return err
+			} else if isFlag {
+				asJSON = json
+				continue
+			}
+			return errorf(exitUsage, "Usage: zdp comments unresolved <doc.md|number> [--format text|json]")
+		}
+		open := openThreads(comments)
+		if asJSON {
+			if open == nil {
+				open = []commentThread{}
+			}
+			return printJSON(open)
+		}
+		if len(open) == 0 {
+			fmt.Fprintf(stdout, "No unresolved comments on %s\n", filepath.Base(args[0]))
+			return nil
+		}
+		fmt.Fprintf(stdout, "%d unresolved thread(s) on %s:\n", len(open), filepath.Base(args[0]))
+		for _, t := range open {
+			fmt.Fprintf(stdout, "\n#%d %s (%s)\n", t.Root.ID, t.Root.Author, t.Root.Date)
+			if t.Root.Quote != "" {
+				fmt.Fprintf(stdout, "    > %s\n", t.Root.Quote)
+			}
+			fmt.Fprintf(stdout, "    %s\n", t.Root.Text)
+			for _, reply := range t.Replies {
+				fmt.Fprintf(stdout, "    #%d %s (%s): %s\n", reply.ID, reply.Author, reply.Date, replyRe.ReplaceAllString(reply.Text, ""))
+			}
+		}
+	}
+	return nil
+}
+
+// reviewerLoad is a candidate reviewer in "review suggest"
+type reviewerLoad struct {
+	Person  string `json:"person"`
+	Current int    `json:"current"` // Under Review or Revised documents listing them
+	Past    int    `json:"past"`    // other documents listing them as reviewers
+	Note    string `json:"note,omitempty"`
+}
+
+// reviewCommand parses the arguments of "review suggest <doc|number> [--count N] [--format text|json]"
+func reviewCommand(args []string) error {
+	usage := "Usage: zdp review suggest <doc.md|number> [--count N] [--format text|json]"
+	if len(args) == 0 || args[0] != "suggest" {
+		return errorf(exitUsage, "%s", usage)
+	}
+	var target string
+	count, asJSON := 3, false
+	for i := 1; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--count"); err != nil {
+			return err
+		} else if ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errorf(exitUsage, "Invalid --count value \"%s\" (use a positive number)", value)
+			}
+			count = n
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || target != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		target = args[i]
+	}
+	if target == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	doc, err := findDocument(target)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	suggested, excluded := suggestReviewers(doc, scanDocuments())
+	if len(suggested) > count {
+		suggested = suggested[:count]
+	}
+
+	if asJSON {
+		return printJSON(struct {
+			Number    string         `json:"number"`
+			Suggested []reviewerLoad `json:"suggested"`
+			Excluded  []reviewerLoad `json:"excluded"`
+		}{doc.Number, suggested, excluded})
+	}
+
+	components := metaList(doc.Fields["component"])
+	scope := "any component"
+	if len(components) > 0 {
+		scope = strings.Join(components, ", ")
+	}
+	fmt.Fprintf(stdout, "Suggested reviewers for %s %s (%s):\n", doc.Number, displayTitle(doc.Title), scope)
+	if len(suggested) == 0 {
+		fmt.Fprintln(stdout, "  (none available)")
+	}
+	for _, r := range suggested {
+		fmt.Fprintf(stdout, "  %-30s %d open review(s), %d past\n", r.Person, r.Current, r.Past)
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintln(stdout, "\nNot suggested:")
+		for _, r := range excluded {
+			fmt.Fprintf(stdout, "  %-30s %s\n", r.Person, r.Note)
+		}
+	}
+	return nil
+}
+
+// suggestReviewers ranks the people who could review doc by their load:
+// open reviews first, then past reviews. Candidates are the reviewers
+// review.components lists for the document's components, or else the
+// authors, champions, and reviewers of other documents in those
+// components. The document's authors, its current reviewers, and anyone
+// at review.max-load open reviews are returned separately.
+func suggestReviewers(doc *Document, docs []*Document) (suggested, excluded []reviewerLoad) {
+	components := metaList(doc.Fields["component"])
+	inComponent := func(d *Document) bool {
+		if len(components) == 0 {
+			return true
+		}
+		for _, c := range metaList(d.Fields["component"]) {
+			if containsString(components, c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var people []string
+	add := func(person string) {
+		person = strings.TrimSpace(person)
+		if person == "" || strings.EqualFold(person, "none") || strings.EqualFold(person, "unknown") || parsePerson(person).matchesAny(people) {
+			return
+		}
+		people = append(people, person)
+	}
+	for _, c := range components {
+		for _, person := range config.ComponentReviewers[c] {
+			add(person)
+		}
+	}
+	if len(components) == 0 {
+		var names []string
+		for c := range config.ComponentReviewers {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		for _, c := range names {
+			for _, person := range config.ComponentReviewers[c] {
+				add(person)
+			}
+		}
+	}
+	if len(people) == 0 {
+		for _, d := range docs {
+			if d.Number == doc.Number || !inComponent(d) {
+				continue
+			}
+			for _, person := range d.Authors {
+				add(person)
+			}
+			add(d.Fields["champion"])
+			for _, person := range metaList(d.Fields["reviewers"]) {
+				add(person)
+			}
+		}
+	}
+
+	for _, person := range people {
+		id := parsePerson(person)
+		r := reviewerLoad{Person: person}
+		for _, d := range docs {
+			if d.Number == doc.Number || !id.matchesAny(metaList(d.Fields["reviewers"])) {
+				continue
+			}
+			if state := normalizeState(d.State); state == "under review" || state == "revised" {
+				r.Current++
+			} else {
+				r.Past++
+			}
+		}
+		switch {
+		case id.matchesAny(doc.Authors):
+			r.Note = "author"
+		case id.matchesAny(metaList(doc.Fields["reviewers"])):
+			r.Note = "already reviewing"
+		case config.ReviewMaxLoad > 0 && r.Current >= config.ReviewMaxLoad:
+			r.Note = fmt.Sprintf("overloaded: %d open review(s), limit %d", r.Current, config.ReviewMaxLoad)
+		}
+		if r.Note != "" {
+			excluded = append(excluded, r)
+		} else {
+			suggested = append(suggested, r)
+		}
+	}
+
+	sort.SliceStable(suggested, func(i, j int) bool {
+		a, b := suggested[i], suggested[j]
+		if a.Current != b.Current {
+			return a.Current < b.Current
+		}
+		if a.Past != b.Past {
+			return a.Past < b.Past
+		}
+		return strings.ToLower(a.Person) < strings.ToLower(b.Person)
+	})
+	if suggested == nil {
+		suggested = []reviewerLoad{}
+	}
+	if excluded == nil {
+		excluded = []reviewerLoad{}
+	}
+	return suggested, excluded
+}
+
+// daysSince returns whole days elapsed since a YYYY-MM-DD date
+func daysSince(date string) (int, bool) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return 0, false
+	}
+	return int(time.Since(t).Hours() / 24), true
+}
+
+// nextCommand prints a personalized to-do list for the current identity
+func nextCommand() {
+	me := currentIdentity()
+	docs := scanDocuments()
+
+	var stale, assigned, voting, commented []string
+	for _, doc := range docs {
+		state := normalizeState(doc.State)
+		isMine := me.matchesAny(doc.Authors)
+		line := fmt.Sprintf("  %s  %s", doc.Number, displayTitle(doc.Title))
+
+		if isMine && state == "draft" {
+			if days, ok := daysSince(doc.Updated); ok && days >= config.StaleDays {
+				stale = append(stale, fmt.Sprintf("%s (updated %s, %d days ago)", line, doc.Updated, days))
+			}
+		}
+
+		if (state == "under review" || state == "revised") && me.matchesAny(metaList(doc.Fields["reviewers"])) {
+			assigned = append(assigned, fmt.Sprintf("%s (%s)", line, doc.State))
+		}
+
+		if state == "under review" && !isMine && me.matchesAny(config.Voters) && !me.matchesAny(metaList(doc.Fields["voted"])) {
+			voting = append(voting, line)
+		}
+
+		if isMine {
+			comments, err := loadComments(doc.Number)
+			if err != nil {
+				warn("Skipped comments for %s: %v", doc.Path, err)
+			}
+			if open := len(unresolvedComments(comments)); open > 0 {
+				commented = append(commented, fmt.Sprintf("%s (%d unresolved)", line, open))
+			}
+		}
+	}
+
+	fmt.Fprintf(stdout, "Next actions for %s:\n", me.Name)
+	printTodo := func(heading string, items []string) {
+		fmt.Fprintf(stdout, "\n%s:\n", heading)
+		if len(items) == 0 {
+			fmt.Fprintln(stdout, "  (nothing)")
+		}
+		for _, item := range items {
+			fmt.Fprintln(stdout, item)
+		}
+	}
+	printTodo(fmt.Sprintf("Stale drafts you own (no update in %d+ days)", config.StaleDays), stale)
+	printTodo("Reviews assigned to you", assigned)
+	printTodo("Awaiting your vote", voting)
+	printTodo("Your documents with unresolved comments", commented)
+}
+
+var quarterRe = regexp.MustCompile(`^(\d{4})Q([1-4])$`)
+
+// docMilestone returns the release or milestone a document is planned
+// for, preferring target-release over milestone
+func docMilestone(doc *Document) string {
+	for _, field := range []string{"target-release", "milestone"} {
+		value := displayTitle(strings.TrimSpace(doc.Fields[field]))
+		if value != "" && !strings.EqualFold(value, "none") {
+			return value
+		}
+	}
+	return ""
+}
+
+// roadmapCommand parses the arguments of "roadmap --quarter YYYYQN [--out path]"
+func roadmapCommand(args []string) error {
+	usage := "Usage: zdp roadmap --quarter YYYYQN [--out path]"
+	var quarter, outPath string
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--quarter"); err != nil {
+			return err
+		} else if ok {
+			quarter = strings.ToUpper(value)
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			outPath = value
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	if quarter == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+	if !quarterRe.MatchString(quarter) {
+		return errorf(exitUsage, "Invalid quarter \"%s\": expected a form like 2025Q3", quarter)
+	}
+	if outPath == "" {
+		outPath = filepath.Join("roadmaps", quarter+".md")
+	}
+
+	return writeRoadmap(quarter, outPath)
+}
+
+// writeRoadmap renders the Accepted and Active documents planned for a
+// quarter, grouped by milestone. Documents whose milestone is itself a
+// different quarter belong to that quarter's roadmap and are left out.
+func writeRoadmap(quarter, outPath string) error {
+	m := quarterRe.FindStringSubmatch(quarter)
+	year, _ := strconv.Atoi(m[1])
+	q, _ := strconv.Atoi(m[2])
+	start := time.Date(year, time.Month(3*q-2), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 3, -1)
+
+	groups := make(map[string][]*Document)
+	var unscheduled []*Document
+	for _, doc := range scanDocuments() {
+		state := normalizeState(doc.State)
+		if state != "accepted" && state != "active" {
+			continue
+		}
+		milestone := docMilestone(doc)
+		switch {
+		case milestone == "":
+			unscheduled = append(unscheduled, doc)
+		case quarterRe.MatchString(strings.ToUpper(milestone)) && !strings.EqualFold(milestone, quarter):
+			continue
+		default:
+			groups[milestone] = append(groups[milestone], doc)
+		}
+	}
+
+	var milestones []string
+	for milestone := range groups {
+		milestones = append(milestones, milestone)
+	}
+	sort.Strings(milestones)
+
+	renderTable := func(docs []*Document) string {
+		lines := []string{
+			"| Number | Title | State | Updated |",
+			"|--------|-------|-------|---------|",
+		}
+		for _, doc := range docs {
+			// Links are relative to the roadmap file
+			target, err := filepath.Rel(filepath.Dir(outPath), doc.Path)
+			if err != nil {
+				target = doc.Path
+			}
+			link := fmt.Sprintf("[%s](%s)", escapeTableCell(displayTitle(doc.Title)), filepath.ToSlash(target))
+			lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |", doc.Number, link, doc.State, doc.Updated))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	blocks := []string{
+		fmt.Sprintf("# Roadmap %s", quarter),
+		fmt.Sprintf("Accepted and Active design documents planned for %s (%s to %s), grouped by `target-release` or `milestone`. Generated by `zdp roadmap` on %s.",
+			quarter, start.Format("2006-01-02"), end.Format("2006-01-02"), time.Now().Format("2006-01-02")),
+	}
+	for _, milestone := range milestones {
+		blocks = append(blocks, "## "+milestone, renderTable(groups[milestone]))
+	}
+	if len(milestones) == 0 {
+		blocks = append(blocks, "_No scheduled documents._")
+	}
+	if len(unscheduled) > 0 {
+		blocks = append(blocks, "## Unscheduled",
+			"> **Warning**: these documents are accepted but have no `target-release` or `milestone` assigned.",
+			renderTable(unscheduled))
+	}
+
+	if dir := filepath.Dir(outPath); dir != "." {
+		if err := os.MkdirAll(repoPath(dir), 0755); err != nil {
+			return errorf(exitEnvironment, "Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(repoPath(outPath), []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write roadmap: %v", err)
+	}
+	opResult.recordWrite(outPath)
+
+	scheduled := 0
+	for _, milestone := range milestones {
+		scheduled += len(groups[milestone])
+	}
+	fmt.Fprintf(stdout, "Wrote %s: %d scheduled document(s) across %d milestone(s)\n", outPath, scheduled, len(milestones))
+	if len(unscheduled) > 0 {
+		fmt.Fprintf(stdout, "%d accepted document(s) have no milestone assigned:\n", len(unscheduled))
+		for _, doc := range unscheduled {
+			fmt.Fprintf(stdout, "  %s  %s\n", doc.Number, displayTitle(doc.Title))
+		}
+	}
+	return nil
+}
+
+// reportCommand parses the arguments of "report annual --year YYYY [--out path]"
+func reportCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp report annual --year YYYY [--out path]"
+	if len(args) == 0 || args[0] != "annual" {
+		return errorf(exitUsage, "%s", usage)
+	}
+	year := 0
+	var outPath string
+	for i := 1; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--year"); err != nil {
+			return err
+		} else if ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1970 || n > 9999 {
+				return errorf(exitUsage, "Invalid --year value \"%s\"", value)
+			}
+			year = n
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			outPath = value
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+	if year == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+	if outPath == "" {
+		outPath = filepath.Join("reports", fmt.Sprintf("annual-%d.md", year))
+	}
+
+	return writeAnnualReport(ctx, year, outPath)
+}
+
+// annualOutcomes are the states whose arrivals the annual report counts,
+// in the order it lists them
+var annualOutcomes = []string{"Accepted", "Active", "Final", "Rejected", "Withdrawn", "Deferred", "Superseded"}
+
+// writeAnnualReport writes the skeleton of a yearly design retrospective:
+// the year's outcomes from git history grouped by component, its
+// supersessions, the drafts still open, and charts of the activity.
+// Sections meant for prose are left as prompts.
+func writeAnnualReport(ctx context.Context, year int, outPath string) error {
+	events, err := lifecycleEvents(ctx, fmt.Sprintf("--since=%d-01-01T00:00:00", year), fmt.Sprintf("--until=%d-12-31T23:59:59", year))
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+
+	docs := make(map[string]*Document)
+	all := scanDocuments()
+	for _, doc := range all {
+		docs[doc.Number] = doc
+	}
+	link := func(number, path string) string {
+		title := number
+		if doc, ok := docs[number]; ok {
+			title = displayTitle(doc.Title)
+			path = doc.Path
+		}
+		target, err := filepath.Rel(filepath.Dir(outPath), path)
+		if err != nil {
+			target = path
+		}
+		return fmt.Sprintf("[%s %s](%s)", number, escapeTableCell(title), filepath.ToSlash(target))
+	}
+
+	// A document's last transition into each outcome state during the
+	// year; documents added straight into a state don't count
+	reached := make(map[string]map[string]lifecycleEvent)
+	created := make(map[string]bool)
+	monthly := make([]int, 12)
+	for _, event := range events {
+		if month, err := strconv.Atoi(event.Date[5:7]); err == nil {
+			monthly[month-1]++
+		}
+		if event.Type == "created" {
+			created[event.Number] = true
+		}
+		if event.Type != "transitioned" || !containsString(annualOutcomes, event.To) {
+			continue
+		}
+		if reached[event.To] == nil {
+			reached[event.To] = make(map[string]lifecycleEvent)
+		}
+		reached[event.To][event.Number] = event
+	}
+
+	// byComponent groups the documents reaching a state by component
+	byComponent := func(state string) []string {
+		groups := make(map[string][]string)
+		for number, event := range reached[state] {
+			components := []string{"Other"}
+			if doc, ok := docs[number]; ok && len(metaList(doc.Fields["component"])) > 0 {
+				components = metaList(doc.Fields["component"])
+			}
+			for _, component := range components {
+				groups[component] = append(groups[component], fmt.Sprintf("- %s (%s)", link(number, event.Path), event.Date[:10]))
+			}
+		}
+		var names []string
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if (names[i] == "Other") != (names[j] == "Other") {
+				return names[j] == "Other"
+			}
+			return names[i] < names[j]
+		})
+		var blocks []string
+		for _, name := range names {
+			sort.Strings(groups[name])
+			blocks = append(blocks, "### "+name, strings.Join(groups[name], "\n"))
+		}
+		if len(blocks) == 0 {
+			blocks = append(blocks, "_None._")
+		}
+		return blocks
+	}
+
+	blocks := []string{
+		fmt.Sprintf("# State of the Language %d", year),
+		fmt.Sprintf("Design activity from %d-01-01 to %d-12-31, taken from git history. Generated by `zdp report annual` on %s; the sections in italics are prompts for the retrospective's authors.",
+			year, year, time.Now().Format("2006-01-02")),
+		"## Highlights",
+		"_What defined the year? Name the two or three decisions that mattered most and why._",
+	}
+
+	summary := []string{"| Outcome | Documents |", "|---------|-----------|", fmt.Sprintf("| New proposals | %d |", len(created))}
+	for _, state := range annualOutcomes {
+		summary = append(summary, fmt.Sprintf("| %s | %d |", state, len(reached[state])))
+	}
+	blocks = append(blocks, "## The Year in Numbers", strings.Join(summary, "\n"))
+
+	// Charts: outcomes as a pie for renderers that support Mermaid, and
+	// monthly activity as text bars that read anywhere
+	var pie []string
+	for _, state := range annualOutcomes {
+		if n := len(reached[state]); n > 0 {
+			pie = append(pie, fmt.Sprintf("    %q : %d", state, n))
+		}
+	}
+	if len(pie) > 0 {
+		blocks = append(blocks, "```mermaid\npie title Outcomes in "+strconv.Itoa(year)+"\n"+strings.Join(pie, "\n")+"\n```")
+	}
+	most := 1
+	for _, n := range monthly {
+		if n > most {
+			most = n
+		}
+	}
+	var bars []string
+	for month, n := range monthly {
+		bars = append(bars, fmt.Sprintf("%s %-30s %d", time.Month(month + 1).String()[:3], strings.Repeat("█", (n*30+most-1)/most), n))
+	}
+	blocks = append(blocks, "### Lifecycle Events per Month", "```\n"+strings.Join(bars, "\n")+"\n```")
+
+	blocks = append(blocks, "## Accepted")
+	blocks = append(blocks, byComponent("Accepted")...)
+	blocks = append(blocks, "## Rejected")
+	blocks = append(blocks, byComponent("Rejected")...)
+	blocks = append(blocks, "_What did the rejections teach us?_")
+
+	var superseded []string
+	for number, event := range reached["Superseded"] {
+		line := "- " + link(number, event.Path)
+		if doc, ok := docs[number]; ok {
+			var successors []string
+			for _, ref := range docRefs(doc.Fields["superseded-by"]) {
+				successors = append(successors, link(ref, ref))
+			}
+			if len(successors) > 0 {
+				line += " by " + strings.Join(successors, ", ")
+			}
+		}
+		superseded = append(superseded, line)
+	}
+	sort.Strings(superseded)
+	if len(superseded) == 0 {
+		superseded = []string{"_None._"}
+	}
+	blocks = append(blocks, "## Notable Supersessions", strings.Join(superseded, "\n"))
+
+	var open []string
+	for _, doc := range all {
+		switch normalizeState(doc.State) {
+		case "draft", "under review", "revised":
+			age := ""
+			if days, ok := daysSince(doc.Created); ok {
+				age = fmt.Sprintf(", open %d days", days)
+			}
+			open = append(open, fmt.Sprintf("- %s (%s%s)", link(doc.Number, doc.Path), doc.State, age))
+		}
+	}
+	sort.Strings(open)
+	if len(open) == 0 {
+		open = []string{"_None._"}
+	}
+	blocks = append(blocks, "## Outstanding Drafts", "Proposals still open when this report was generated.", strings.Join(open, "\n"))
+	blocks = append(blocks, "## Looking Ahead", "_What should next year's design work focus on?_")
+
+	if dir := filepath.Dir(outPath); dir != "." {
+		if err := os.MkdirAll(repoPath(dir), 0755); err != nil {
+			return errorf(exitEnvironment, "Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(repoPath(outPath), []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write report: %v", err)
+	}
+	opResult.recordWrite(outPath)
+	fmt.Fprintf(stdout, "Wrote %s: %d lifecycle event(s), %d accepted, %d rejected in %d\n", outPath, len(events), len(reached["Accepted"]), len(reached["Rejected"]), year)
+	return nil
+}
+
+// releaseCheckCommand parses the arguments of "release-check <release> [--tag]"
+func releaseCheckCommand(args []string) error {
+	usage := "Usage: zdp release-check <release> [--tag]"
+	var release string
+	tag := false
+
+	for _, arg := range args {
+		switch {
+		case arg == "--tag":
+			tag = true
+		case strings.HasPrefix(arg, "-") || release != "":
+			return errorf(exitUsage, "%s", usage)
+		default:
+			release = arg
+		}
+	}
+	if release == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return releaseCheck(release, tag)
+}
+
+// releaseCheck lists the documents targeted at a release that are not
+// yet Final and, with tag set, snapshots the repository as a git tag.
+// Rejected, withdrawn, and superseded documents will never reach Final,
+// so they don't hold up the release.
+func releaseCheck(release string, tag bool) error {
+	var targeted, open []*Document
+	for _, doc := range scanDocuments() {
+		if !strings.EqualFold(docMilestone(doc), release) {
+			continue
+		}
+		targeted = append(targeted, doc)
+		switch normalizeState(doc.State) {
+		case "final", "rejected", "withdrawn", "superseded":
+		default:
+			open = append(open, doc)
+		}
+	}
+
+	fmt.Fprintf(stdout, "Release %s: %d targeted document(s), %d not yet Final\n", release, len(targeted), len(open))
+	for _, doc := range open {
+		fmt.Fprintf(stdout, "  %s  %-13s %s\n", doc.Number, doc.State, displayTitle(doc.Title))
+	}
+
+	if !tag {
+		if len(open) > 0 {
+			return errorf(exitFindings, "%d document(s) targeted at %s are not yet Final", len(open), release)
+		}
+		return nil
+	}
+
+	if len(open) > 0 {
+		if config.BlockOpen {
+			return errorf(exitConflict, "Refusing to tag %s while %d targeted document(s) are open (set release.block-open: false to override)", release, len(open))
+		}
+		fmt.Fprintf(stdout, "⚠ Tagging %s with %d targeted document(s) still open\n", release, len(open))
+		warn("Tagging %s with %d targeted document(s) still open", release, len(open))
+	}
+
+	tagName := config.TagPrefix + release
+	message := fmt.Sprintf("Design snapshot for %s", release)
+	if output, err := repoCommand("git", "tag", "-a", tagName, "-m", message).CombinedOutput(); err != nil {
+		return errorf(exitEnvironment, "Failed to create tag %s: %s", tagName, strings.TrimSpace(string(output)))
+	}
+	fmt.Fprintf(stdout, "Tagged snapshot %s\n", tagName)
+	return nil
+}
+
+// compatImpacts are the values of the compat-impact field, least
+// disruptive first
+var compatImpacts = []string{"none", "minor", "breaking"}
+
+// needsCompatImpact reports whether a document of the given type is a
+// feature proposal, which must state its compat-impact to be accepted.
+// Only documents typed rfc or feature are; untyped documents aren't.
+func needsCompatImpact(docType string) bool {
+	docType = strings.ToLower(strings.TrimSpace(docType))
+	return docType == "rfc" || docType == "feature"
+}
+
+// checkCompatImpact returns the problem with a document's compat-impact
+// field, if any; a missing field is a problem only when required
+func checkCompatImpact(fields map[string]string, required bool) error {
+	value := strings.ToLower(strings.TrimSpace(fields["compat-impact"]))
+	switch {
+	case value == "" && required:
+		return fmt.Errorf("no compat-impact; feature proposals need \"compat-impact: none|minor|breaking\" to be accepted")
+	case value != "" && !containsString(compatImpacts, value):
+		return fmt.Errorf("invalid compat-impact \"%s\" (use %s)", fields["compat-impact"], strings.Join(compatImpacts, ", "))
+	}
+	return nil
+}
+
+// breakingChange is an accepted breaking change in "breaking --format json"
+type breakingChange struct {
+	listedDoc
+	Release string `json:"release"`
+}
+
+// breakingCommand parses the arguments of "breaking [--since <release>] [--format text|json]"
+func breakingCommand(args []string) error {
+	var since string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--since"); err != nil {
+			return err
+		} else if ok {
+			since = value
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp breaking [--since <release>] [--format text|json]")
+	}
+
+	changes := breakingChanges(since)
+	if asJSON {
+		return printJSON(changes)
+	}
+
+	scope := ""
+	if since != "" {
+		scope = " after " + since
+	}
+	fmt.Fprintf(stdout, "%d accepted breaking change(s)%s\n", len(changes), scope)
+	release := "\x00"
+	for _, change := range changes {
+		if change.Release != release {
+			release = change.Release
+			heading := release
+			if heading == "" {
+				heading = "Unscheduled"
+			}
+			fmt.Fprintf(stdout, "\n%s\n", heading)
+		}
+		fmt.Fprintf(stdout, "  %s  %-13s %s\n", change.Number, change.State, change.Title)
+	}
+	return nil
+}
+
+// breakingChanges returns the Accepted, Active, and Final documents with
+// "compat-impact: breaking" planned for a release after since (every
+// release when since is empty), in release order. Those without a
+// target release haven't shipped, so they are always included, last.
+func breakingChanges(since string) []breakingChange {
+	changes := []breakingChange{}
+	for _, doc := range scanDocuments() {
+		switch normalizeState(doc.State) {
+		case "accepted", "active", "final":
+		default:
+			continue
+		}
+		if !strings.EqualFold(strings.TrimSpace(doc.Fields["compat-impact"]), "breaking") {
+			continue
+		}
+		release := docMilestone(doc)
+		if since != "" && release != "" && !releaseLess(since, release) {
+			continue
+		}
+		changes = append(changes, breakingChange{listedDoc: newListedDoc(doc), Release: release})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Release != changes[j].Release {
+			return releaseLess(changes[i].Release, changes[j].Release)
+		}
+		return docNumberLess(changes[i].Number, changes[j].Number)
+	})
+	return changes
+}
+
+// docVersion is a document as it stood in one release snapshot tag
+type docVersion struct {
+	Tag   string
+	Date  string
+	State string // "" when the snapshot doesn't contain the document
+	Path  string
+}
+
+// documentVersions finds the document numbered number in every tag
+// matching pattern, oldest tag first
+func documentVersions(number, pattern string) ([]docVersion, error) {
+	output, err := repoCommand("git", "for-each-ref", "--sort=creatordate",
+		"--format=%(refname:short)%09%(creatordate:short)", "refs/tags/"+pattern).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref failed: %v", err)
+	}
+
+	var versions []docVersion
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		v := docVersion{Tag: fields[0], Date: fields[1]}
+
+		args := append([]string{"-c", "core.quotePath=false", "ls-tree", "-r", "--name-only", v.Tag, "--"}, sortedStateDirs()...)
+		files, err := repoCommand("git", args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("git ls-tree %s failed: %v", v.Tag, err)
+		}
+		for _, file := range strings.Split(string(files), "\n") {
+			if strings.HasSuffix(file, ".md") && extractNumberFromFilename(path.Base(file)) == number {
+				v.Path = file
+				break
+			}
+		}
+		if v.Path != "" {
+			v.State = dirToState[path.Dir(v.Path)]
+			if content, err := repoCommand("git", "show", v.Tag+":"+v.Path).Output(); err == nil {
+				if meta, err := parseYAML(string(content)); err == nil && meta["state"] != "" {
+					v.State = getTitleCaseState(meta["state"])
+				}
+			}
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// versionsCommand parses the arguments of "versions <doc> [--tags pattern]"
+func versionsCommand(args []string) error {
+	var docArg string
+	pattern := config.TagPrefix + "*"
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--tags"); err != nil {
+			return err
+		} else if ok {
+			pattern = value
+			continue
+		}
+		if docArg != "" || strings.HasPrefix(args[i], "--") {
+			return errorf(exitUsage, "Usage: zdp versions <doc|number> [--tags pattern]")
+		}
+		docArg = args[i]
+	}
+	if docArg == "" {
+		return errorf(exitUsage, "Usage: zdp versions <doc|number> [--tags pattern]")
+	}
+
+	// Documents deleted since can still be looked up by number
+	number, title := "", ""
+	if doc, err := findDocument(docArg); err == nil {
+		number, title = doc.Number, displayTitle(doc.Title)
+	} else if n, convErr := strconv.Atoi(docArg); convErr == nil && n > 0 {
+		number = fmt.Sprintf("%04d", n)
+	} else {
+		return errorf(exitUsage, "%v", err)
+	}
+
+	versions, err := documentVersions(number, pattern)
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	printVersions(number, title, pattern, versions)
+	return nil
+}
+
+// printVersions prints the snapshot table of one document and the
+// releases that shipped with it Accepted or Final
+func printVersions(number, title, pattern string, versions []docVersion) {
+	fmt.Fprintf(stdout, "%s\n\n", strings.TrimSpace(number+" "+title))
+	if len(versions) == 0 {
+		fmt.Fprintf(stdout, "No tags match %s\n", pattern)
+		return
+	}
+
+	width := len("Tag")
+	for _, v := range versions {
+		width = max(width, len(v.Tag))
+	}
+	fmt.Fprintf(stdout, "%-*s  %-10s  %-12s  %s\n", width, "Tag", "Date", "State", "Path")
+	var shipped []string
+	for _, v := range versions {
+		state, where := v.State, v.Path
+		if v.Path == "" {
+			state, where = "-", "(not in snapshot)"
+		}
+		fmt.Fprintf(stdout, "%-*s  %-10s  %-12s  %s\n", width, v.Tag, v.Date, state, where)
+		if n := normalizeState(v.State); n == "accepted" || n == "active" || n == "final" {
+			shipped = append(shipped, strings.TrimPrefix(v.Tag, config.TagPrefix))
+		}
+	}
+
+	fmt.Fprintln(stdout)
+	if len(shipped) == 0 {
+		fmt.Fprintln(stdout, "No release shipped with this document Accepted or Final")
+		return
+	}
+	fmt.Fprintf(stdout, "Shipped Accepted or Final in: %s\n", strings.Join(shipped, ", "))
+}
+
+// federatedDoc is a document tagged with the repository it came from
+type federatedDoc struct {
+	Repo string
+	*Document
+}
+
+// scanFederation loads the documents of every federated repository.
+// Repositories that can't be read are reported as warnings and skipped.
+func scanFederation() ([]federatedDoc, error) {
+	if len(config.Federation) == 0 {
+		return nil, errorf(exitUsage, "No federation.repos configured in .zdp.yaml")
+	}
+
+	var docs []federatedDoc
+	for _, repo := range config.Federation {
+		if info, err := os.Stat(repoPath(repo.Path)); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "⚠ Skipped repository %s: %s is not a directory\n", repo.Name, repo.Path)
+			warn("Skipped repository %s: %s is not a directory", repo.Name, repo.Path)
+			continue
+		}
+		for _, doc := range scanDocumentsIn(repo.Path) {
+			docs = append(docs, federatedDoc{Repo: repo.Name, Document: doc})
+		}
+	}
+	return docs, nil
+}
+
+// federatedState returns the state a document's directory implies
+func federatedState(doc federatedDoc) string {
+	if state, ok := dirToState[filepath.Base(filepath.Dir(doc.Path))]; ok {
+		return state
+	}
+	return doc.State
+}
+
+// federateCommand handles "federate list|search|stats|export" across
+// the repositories listed under federation.repos
+func federateCommand(args []string) error {
+	usage := "Usage: zdp federate list | search <term> | stats | export [--out path]"
+	if len(args) == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	var outPath string
+	switch args[0] {
+	case "list", "stats":
+		if len(args) != 1 {
+			return errorf(exitUsage, "%s", usage)
+		}
+	case "search":
+		if len(args) < 2 {
+			return errorf(exitUsage, "%s", usage)
+		}
+	case "export":
+		for i := 1; i < len(args); i++ {
+			if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+				return err
+			} else if ok {
+				outPath = value
+				continue
+			}
+			return errorf(exitUsage, "%s", usage)
+		}
+	default:
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	docs, err := scanFederation()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		federatedList(docs)
+	case "search":
+		federatedSearch(docs, strings.Join(args[1:], " "))
+	case "stats":
+		federatedStats(docs)
+	case "export":
+		return federatedExport(docs, outPath)
+	}
+	return nil
+}
+
+// federatedList prints every federated document grouped by state
+func federatedList(docs []federatedDoc) {
+	byState := make(map[string][]federatedDoc)
+	for _, doc := range docs {
+		state := federatedState(doc)
+		byState[state] = append(byState[state], doc)
+	}
+
+	var stateNames []string
+	for state := range byState {
+		stateNames = append(stateNames, state)
+	}
+	sort.Strings(stateNames)
+
+	for _, state := range stateNames {
+		fmt.Fprintln(stdout, state)
+		for _, doc := range byState[state] {
+			fmt.Fprintf(stdout, " - [%s] %s\n", doc.Repo, filepath.Base(doc.Path))
+		}
+		fmt.Fprintln(stdout)
+	}
+}
+
+// federatedSearch prints federated documents whose title or body
+// contains term, ignoring case
+func federatedSearch(docs []federatedDoc, term string) {
+	matches := 0
+	for _, doc := range docs {
+		hits, err := matchingLines(doc.Path, term)
+		if err != nil {
+			warn("Skipped %s: %v", doc.Path, err)
+			continue
+		}
+		if len(hits) == 0 {
+			continue
+		}
+
+		matches++
+		fmt.Fprintf(stdout, "[%s] %s  %s (%s)\n", doc.Repo, doc.Number, displayTitle(doc.Title), federatedState(doc))
+		for _, hit := range hits {
+			fmt.Fprintln(stdout, hit)
+		}
+	}
+
+	if matches == 0 {
+		fmt.Fprintf(stdout, "No documents match \"%s\"\n", term)
+	}
+}
+
+// matchingLines returns the lines of a file, frontmatter included,
+// that contain term ignoring case, formatted as "    n: line"
+func matchingLines(path, term string) ([]string, error) {
+	needle := strings.ToLower(term)
+	var hits []string
+	err := scanLines(path, func(n int, line string) bool {
+		if strings.Contains(strings.ToLower(line), needle) {
+			hits = append(hits, fmt.Sprintf("    %d: %s", n, strings.TrimSpace(line)))
+		}
+		return true
+	})
+	return hits, err
+}
+
+// searchCommand parses the arguments of
+// "search <query> [--state name] [--tag tag] [--rebuild]"
+func searchCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp search <query> [--state name] [--tag tag] [--rebuild]"
+	var words []string
+	var state, tag string
+	rebuild := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--state"); err != nil {
+			return err
+		} else if ok {
+			if _, err := getStateDir(value); err != nil {
+				return errorf(exitUsage, "Invalid state: %s", value)
+			}
+			state = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--tag"); err != nil {
+			return err
+		} else if ok {
+			tag = value
+			continue
+		}
+		if args[i] == "--rebuild" {
+			rebuild = true
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			return errorf(exitUsage, "%s", usage)
+		}
+		words = append(words, args[i])
+	}
+	if len(words) == 0 {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return search(ctx, strings.Join(words, " "), state, tag, rebuild)
+}
+
+// searchIndexPath is the persistent search index. It is a cache: it can
+// be deleted at any time and is rebuilt by the next search.
+const searchIndexPath = ".zdp/index/search.json"
+
+// searchIndexVersion changes whenever tokenizing or the file format
+// does, so older indexes are rebuilt rather than misread
+const searchIndexVersion = 1
+
+// searchIndex holds the term counts of every document, keyed by path
+type searchIndex struct {
+	Version int                    `json:"version"`
+	Docs    map[string]*indexedDoc `json:"docs"`
+}
+
+// indexedDoc is one document in the search index. ModTime and Size tell
+// whether the file changed since it was indexed.
+type indexedDoc struct {
+	ModTime int64          `json:"mtime"`
+	Size    int64          `json:"size"`
+	Length  int            `json:"length"` // number of terms, for ranking
+	Terms   map[string]int `json:"terms"`
+}
+
+// searchContextLines is how many matching lines search shows per document
+const searchContextLines = 5
+
+// searchTitleWeight is how many times a title term counts as a body term
+const searchTitleWeight = 3
+
+// searchTerms splits text into lowercase words of two or more letters
+// or digits
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) >= 2 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// indexDocument reads a document's frontmatter and body into term counts
+func indexDocument(doc *Document, info os.FileInfo) (*indexedDoc, error) {
+	entry := &indexedDoc{ModTime: info.ModTime().UnixNano(), Size: info.Size(), Terms: map[string]int{}}
+	for _, term := range searchTerms(displayTitle(doc.Title)) {
+		entry.Terms[term] += searchTitleWeight
+		entry.Length += searchTitleWeight
+	}
+	err := scanLines(doc.Path, func(n int, line string) bool {
+		for _, term := range searchTerms(line) {
+			entry.Terms[term]++
+			entry.Length++
+		}
+		return true
+	})
+	return entry, err
+}
+
+// loadSearchIndex reads the search index, starting afresh when it is
+// missing, unreadable, or from another version
+func loadSearchIndex() *searchIndex {
+	idx := &searchIndex{}
+	if data, err := os.ReadFile(repoPath(searchIndexPath)); err == nil {
+		if json.Unmarshal(data, idx) != nil || idx.Version != searchIndexVersion {
+			idx = &searchIndex{}
+		}
+	}
+	idx.Version = searchIndexVersion
+	if idx.Docs == nil {
+		idx.Docs = map[string]*indexedDoc{}
+	}
+	return idx
+}
+
+// refresh brings the index up to date with docs, re-reading
+// only the documents whose size or modification time changed and
+// dropping those that no longer exist. It reports whether anything
+// changed, and stops with ctx's error when ctx is cancelled.
+func (idx *searchIndex) refresh(ctx context.Context, docs []*Document, prog *progress) (bool, error) {
+	changed := false
+	present := make(map[string]bool)
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		prog.step(filepath.Base(doc.Path))
+		present[doc.Path] = true
+		info, err := os.Stat(repoPath(doc.Path))
+		if err != nil {
+			continue
+		}
+		if old, ok := idx.Docs[doc.Path]; ok && old.ModTime == info.ModTime().UnixNano() && old.Size == info.Size() {
+			continue
+		}
+		entry, err := indexDocument(doc, info)
+		if err != nil {
+			warn("Skipped %s: %v", doc.Path, err)
+			continue
+		}
+		idx.Docs[doc.Path] = entry
+		changed = true
+	}
+	for path := range idx.Docs {
+		if !present[path] {
+			delete(idx.Docs, path)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// save writes the index under .zdp/index/
+func (idx *searchIndex) save() error {
+	if err := os.MkdirAll(repoPath(filepath.Dir(searchIndexPath)), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(repoPath(searchIndexPath), data, 0644)
+}
+
+// rank scores the given documents against the query terms with BM25.
+// Documents missing any query term are left out. The result is ordered
+// by descending score, then by document number.
+func (idx *searchIndex) rank(docs []*Document, terms []string) []*Document {
+	const k1, b = 1.2, 0.75
+
+	total := 0
+	for _, entry := range idx.Docs {
+		total += entry.Length
+	}
+	if len(idx.Docs) == 0 {
+		return nil
+	}
+	avgLength := float64(total) / float64(len(idx.Docs))
+
+	idf := make(map[string]float64)
+	for _, term := range terms {
+		n := 0
+		for _, entry := range idx.Docs {
+			if entry.Terms[term] > 0 {
+				n++
+			}
+		}
+		idf[term] = math.Log(1 + (float64(len(idx.Docs))-float64(n)+0.5)/(float64(n)+0.5))
+	}
+
+	scores := make(map[string]float64)
+	var ranked []*Document
+	for _, doc := range docs {
+		entry, ok := idx.Docs[doc.Path]
+		if !ok {
+			continue
+		}
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(entry.Terms[term])
+			if tf == 0 {
+				score = -1
+				break
+			}
+			score += idf[term] * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(entry.Length)/avgLength))
+		}
+		if score < 0 {
+			continue
+		}
+		scores[doc.Path] = score
+		ranked = append(ranked, doc)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i].Path], scores[ranked[j].Path]
+		if si != sj {
+			return si > sj
+		}
+		return docNumberLess(ranked[i].Number, ranked[j].Number)
+	})
+	return ranked
+}
+
+// search prints the documents whose title, frontmatter or body contains
+// every word of the query, ignoring case, best matches first, with the
+// lines that match. state and tag narrow the documents searched when
+// not empty. Ranking uses the persistent index under .zdp/index/, which
+// is updated for changed documents first; rebuild discards it.
+func search(ctx context.Context, query, state, tag string, rebuild bool) error {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return errorf(exitUsage, "Search query \"%s\" has no words to look for", query)
+	}
+
+	all := scanDocuments()
+	idx := loadSearchIndex()
+	if rebuild {
+		idx.Docs = map[string]*indexedDoc{}
+	}
+	prog := newProgress("Indexing", len(all))
+	changed, err := idx.refresh(ctx, all, prog)
+	if err != nil {
+		return abortOperation(prog, err, "search index left unchanged")
+	}
+	prog.finish()
+	if changed {
+		if err := idx.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠ Could not save the search index: %v\n", err)
+			warn("Could not save the search index: %v", err)
+		}
+	}
+
+	stateDir, _ := getStateDir(state)
+	var docs []*Document
+	for _, doc := range all {
+		if state != "" && filepath.Base(filepath.Dir(doc.Path)) != stateDir {
+			continue
+		}
+		if tag != "" && !hasTag(doc, tag) {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	ranked := idx.rank(docs, terms)
+	if len(ranked) == 0 {
+		fmt.Fprintf(stdout, "No documents match \"%s\"\n", query)
+		return nil
+	}
+	for _, doc := range ranked {
+		docState := doc.State
+		if name, ok := dirToState[filepath.Base(filepath.Dir(doc.Path))]; ok {
+			docState = name
+		}
+		fmt.Fprintf(stdout, "%s  %s (%s)\n", doc.Number, displayTitle(doc.Title), docState)
+		shown, more := 0, 0
+		err := scanLines(doc.Path, func(n int, line string) bool {
+			for _, term := range searchTerms(line) {
+				if !containsString(terms, term) {
+					continue
+				}
+				if shown < searchContextLines {
+					fmt.Fprintf(stdout, "    %d: %s\n", n, strings.TrimSpace(line))
+					shown++
+				} else {
+					more++
+				}
+				break
+			}
+			return true
+		})
+		if err != nil {
+			warn("Skipped %s: %v", doc.Path, err)
+		}
+		if more > 0 {
+			fmt.Fprintf(stdout, "    ... %d more matching line(s)\n", more)
+		}
+	}
+	return nil
+}
+
+// hasTag reports whether a document's tags field lists tag, ignoring case
+func hasTag(doc *Document, tag string) bool {
+	for _, t := range metaList(doc.Fields["tags"]) {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// federatedStats prints document counts per state for each repository
+func federatedStats(docs []federatedDoc) {
+	counts := make(map[string]map[string]int)
+	totals := make(map[string]int)
+	for _, doc := range docs {
+		state := federatedState(doc)
+		if counts[state] == nil {
+			counts[state] = make(map[string]int)
+		}
+		counts[state][doc.Repo]++
+		totals[doc.Repo]++
+	}
+
+	var stateNames []string
+	for state := range counts {
+		stateNames = append(stateNames, state)
+	}
+	sort.Slice(stateNames, func(i, j int) bool {
+		return stateRank(stateNames[i]) < stateRank(stateNames[j])
+	})
+
+	fmt.Fprintf(stdout, "%-14s", "State")
+	for _, repo := range config.Federation {
+		fmt.Fprintf(stdout, " %10s", repo.Name)
+	}
+	fmt.Fprintf(stdout, " %10s\n", "Total")
+
+	row := func(label string, count func(repo string) int) {
+		fmt.Fprintf(stdout, "%-14s", label)
+		sum := 0
+		for _, repo := range config.Federation {
+			n := count(repo.Name)
+			sum += n
+			fmt.Fprintf(stdout, " %10d", n)
+		}
+		fmt.Fprintf(stdout, " %10d\n", sum)
+	}
+	for _, state := range stateNames {
+		row(state, func(repo string) int { return counts[state][repo] })
+	}
+	row("Total", func(repo string) int { return totals[repo] })
+}
+
+// federatedExport writes every federated document's metadata as JSON,
+// to outPath or stdout
+func federatedExport(docs []federatedDoc, outPath string) error {
+	type exportedDoc struct {
+		Repo    string                 `json:"repo"`
+		Number  string                 `json:"number"`
+		Title   string                 `json:"title"`
+		State   string                 `json:"state"`
+		Author  string                 `json:"author"`
+		Created string                 `json:"created"`
+		Updated string                 `json:"updated"`
+		Path    string                 `json:"path"`
+		Meta    map[string]interface{} `json:"meta"`
+	}
+
+	exported := []exportedDoc{}
+	for _, doc := range docs {
+		exported = append(exported, exportedDoc{
+			Repo:    doc.Repo,
+			Number:  doc.Number,
+			Title:   displayTitle(doc.Title),
+			State:   federatedState(doc),
+			Author:  doc.Author,
+			Created: doc.Created,
+			Updated: doc.Updated,
+			Path:    filepath.ToSlash(doc.Path),
+			Meta:    doc.Meta,
+		})
+	}
+
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to encode export: %v", err)
+	}
+	data = append(data, '\n')
+
+	if outPath == "" {
+		stdout.Write(data)
+		return nil
+	}
+	if err := os.WriteFile(repoPath(outPath), data, 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write export: %v", err)
+	}
+	opResult.recordWrite(outPath)
+	fmt.Fprintf(stdout, "Exported %d document(s) from %d repositories to %s\n", len(exported), len(config.Federation), outPath)
+	return nil
+}
+
+// decisionSection is a "Decision" section lifted out of a document
+type decisionSection struct {
+	Heading string `json:"heading"`
+	Text    string `json:"text"`
+}
+
+// decisionRecord collects the decisions of one Accepted or Final document
+type decisionRecord struct {
+	Number    string            `json:"number"`
+	Title     string            `json:"title"`
+	State     string            `json:"state"`
+	Date      string            `json:"date"`
+	Path      string            `json:"path"`
+	Decisions []decisionSection `json:"decisions"`
+}
+
+var (
+	markdownHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	decisionHeadingRe = regexp.MustCompile(`(?i)\bdecisions?\b`)
+)
+
+// codeFence tracks fenced code blocks during a line-by-line scan. A fence
+// closes only on a bare run of the same character at least as long as
+// the opener, so fences nested inside ```` blocks stay code.
+type codeFence struct {
+	marker string
+}
+
+// inCode advances past line and reports whether it is a fence delimiter
+// or part of fenced code
+func (f *codeFence) inCode(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if f.marker == "" {
+		for _, ch := range []string{"`", "~"} {
+			if strings.HasPrefix(trimmed, ch+ch+ch) {
+				f.marker = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, ch))]
+				return true
+			}
+		}
+		return false
+	}
+	if strings.HasPrefix(trimmed, f.marker) && strings.Trim(trimmed, f.marker[:1]) == "" {
+		f.marker = ""
+	}
+	return true
+}
+
+// extractDecisionSections returns the sections of a document body whose
+// heading (level 2 or deeper) mentions a decision
+func extractDecisionSections(body string) []decisionSection {
+	return extractSections(body, func(level int, heading string) bool {
+		return level >= 2 && decisionHeadingRe.MatchString(heading)
+	})
+}
+
+// extractSections returns the sections of a document body whose heading
+// matches. Each section runs to the next heading of the same or higher
+// level, and its headings are shifted so the section itself sits at
+// level 3. Fenced code is skipped when looking for headings.
+func extractSections(body string, match func(level int, heading string) bool) []decisionSection {
+	var sections []decisionSection
+	lines := strings.Split(body, "\n")
+	var fence codeFence
+
+	for i := 0; i < len(lines); i++ {
+		if fence.inCode(lines[i]) {
+			continue
+		}
+		m := markdownHeadingRe.FindStringSubmatch(lines[i])
+		if m == nil || !match(len(m[1]), m[2]) {
+			continue
+		}
+
+		level := len(m[1])
+		shift := 3 - level
+		var text []string
+		j := i + 1
+		var inner codeFence
+		for ; j < len(lines); j++ {
+			line := lines[j]
+			if inner.inCode(line) {
+				// Code is copied as is
+			} else if sub := markdownHeadingRe.FindStringSubmatch(line); sub != nil {
+				if len(sub[1]) <= level {
+					break
+				}
+				depth := len(sub[1]) + shift
+				if depth > 6 {
+					depth = 6
+				}
+				line = strings.Repeat("#", depth) + " " + sub[2]
+			}
+			text = append(text, line)
+		}
+
+		sections = append(sections, decisionSection{
+			Heading: m[2],
+			Text:    strings.Join(trimBlankLines(text), "\n"),
+		})
+		// Nested matching headings are already part of this section
+		i = j - 1
+	}
+	return sections
+}
+
+// decisionsCommand parses the arguments of "decisions [--out path] [--json path]"
+func decisionsCommand(args []string) error {
+	mdPath := "DECISIONS.md"
+	jsonPath := "decisions.json"
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			mdPath = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--json"); err != nil {
+			return err
+		} else if ok {
+			jsonPath = value
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp decisions [--out DECISIONS.md] [--json decisions.json]")
+	}
+
+	return writeDecisions(mdPath, jsonPath)
+}
+
+// writeDecisions builds the chronological decision log from Accepted,
+// Active, and Final documents, ordered by the date each was last updated
+func writeDecisions(mdPath, jsonPath string) error {
+	records := []decisionRecord{}
+	for _, doc := range scanDocuments() {
+		switch normalizeState(doc.State) {
+		case "accepted", "active", "final":
+		default:
+			continue
+		}
+
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		sections := extractDecisionSections(frontmatterRe.ReplaceAllString(string(content), ""))
+		if len(sections) == 0 {
+			continue
+		}
+
+		records = append(records, decisionRecord{
+			Number:    doc.Number,
+			Title:     displayTitle(doc.Title),
+			State:     doc.State,
+			Date:      doc.Updated,
+			Path:      filepath.ToSlash(doc.Path),
+			Decisions: sections,
+		})
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return docNumberLess(records[i].Number, records[j].Number)
+	})
+
+	blocks := []string{
+		"# Design Decisions",
+		"A chronological log of the decisions recorded in Accepted, Active, and Final design documents, extracted by `zdp decisions`. Read the linked documents for the full reasoning and alternatives.",
+	}
+	if len(records) == 0 {
+		blocks = append(blocks, "_No decisions recorded yet._")
+	}
+	for _, record := range records {
+		blocks = append(blocks, fmt.Sprintf("## %s — %s (%s, %s)\n\nSource: [%s](%s)",
+			record.Date, record.Title, record.Number, record.State, filepath.Base(record.Path), record.Path))
+		for _, section := range record.Decisions {
+			if section.Text == "" {
+				blocks = append(blocks, "### "+section.Heading)
+				continue
+			}
+			blocks = append(blocks, "### "+section.Heading+"\n\n"+section.Text)
+		}
+	}
+
+	if err := os.WriteFile(repoPath(mdPath), []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", mdPath, err)
+	}
+	opResult.recordWrite(mdPath)
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to encode decisions: %v", err)
+	}
+	if err := os.WriteFile(repoPath(jsonPath), append(data, '\n'), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", jsonPath, err)
+	}
+	opResult.recordWrite(jsonPath)
+
+	count := 0
+	for _, record := range records {
+		count += len(record.Decisions)
+	}
+	fmt.Fprintf(stdout, "Extracted %d decision section(s) from %d document(s) into %s and %s\n", count, len(records), mdPath, jsonPath)
+	return nil
+}
+
+// assembleSpecCommand parses the arguments of
+// "assemble-spec [--out path] [--check]"
+func assembleSpecCommand(args []string) error {
+	out, check := config.SpecFile, false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			out = value
+			continue
+		}
+		if args[i] == "--check" {
+			check = true
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp assemble-spec [--out SPEC.md] [--check]")
+	}
+
+	content, err := assembleSpec(out)
+	if err != nil {
+		return err
+	}
+	if check {
+		existing, err := os.ReadFile(repoPath(out))
+		if err != nil || string(existing) != content {
+			return errorf(exitFindings, "%s is out of date; run zdp assemble-spec", out)
+		}
+		fmt.Fprintf(stdout, "%s is up to date\n", out)
+		return nil
+	}
+	if dir := filepath.Dir(out); dir != "." {
+		if err := os.MkdirAll(repoPath(dir), 0755); err != nil {
+			return errorf(exitEnvironment, "Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(repoPath(out), []byte(content), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", out, err)
+	}
+	opResult.recordWrite(out)
+	fmt.Fprintf(stdout, "Assembled %d chapter(s) into %s\n", len(config.SpecChapters), out)
+	return nil
+}
+
+// specBody returns a whole document body for a chapter: without its
+// title, and with its headings one level deeper, so level 2 sections sit
+// under the chapter at level 3
+func specBody(body string) string {
+	var text []string
+	var fence codeFence
+	titled := false
+	for _, line := range strings.Split(body, "\n") {
+		if !fence.inCode(line) {
+			if m := markdownHeadingRe.FindStringSubmatch(line); m != nil {
+				if len(m[1]) == 1 && !titled {
+					titled = true
+					continue
+				}
+				line = strings.Repeat("#", min(len(m[1])+1, 6)) + " " + m[2]
+			}
+		}
+		text = append(text, line)
+	}
+	return strings.Join(trimBlankLines(text), "\n")
+}
+
+// assembleSpec builds the language specification from the sections of
+// Final documents listed under spec.chapters. Links to the documents are
+// relative to out. Nothing is built if a chapter's document is missing
+// or not Final, or lacks a listed section.
+func assembleSpec(out string) (string, error) {
+	if len(config.SpecChapters) == 0 {
+		return "", errorf(exitUsage, "No chapters configured (spec.chapters in .zdp.yaml)")
+	}
+	byNumber := make(map[string]*Document)
+	for _, doc := range scanDocuments() {
+		byNumber[doc.Number] = doc
+	}
+
+	var problems []string
+	contents := []string{"## Contents"}
+	var chapters []string
+	for i, c := range config.SpecChapters {
+		doc, ok := byNumber[c.Document]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: document %s does not exist", c.Title, c.Document))
+			continue
+		}
+		if normalizeState(doc.State) != "final" {
+			hint := ""
+			if next := docRefs(doc.Fields["superseded-by"]); len(next) > 0 {
+				hint = fmt.Sprintf("; it is superseded by %s", strings.Join(next, ", "))
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s is %s, and only Final documents go into the specification%s", c.Title, doc.Number, doc.State, hint))
+			continue
+		}
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return "", errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		body := frontmatterRe.ReplaceAllString(string(content), "")
+
+		var sections []decisionSection
+		if len(c.Sections) == 0 {
+			sections = append(sections, decisionSection{Text: specBody(body)})
+		}
+		for _, name := range c.Sections {
+			found := extractSections(body, func(level int, heading string) bool {
+				return strings.EqualFold(sectionNumberRe.ReplaceAllString(heading, ""), strings.TrimSpace(name))
+			})
+			if len(found) == 0 {
+				problems = append(problems, fmt.Sprintf("%s: %s has no section \"%s\"", c.Title, doc.Number, name))
+				continue
+			}
+			sections = append(sections, found[0])
+		}
+
+		link, err := filepath.Rel(filepath.Dir(out), doc.Path)
+		if err != nil {
+			link = doc.Path
+		}
+		contents = append(contents, fmt.Sprintf("%d. %s", i+1, c.Title))
+		blocks := []string{
+			fmt.Sprintf("## %d. %s", i+1, c.Title),
+			fmt.Sprintf("_From [%s %s](%s)._", doc.Number, displayTitle(doc.Title), filepath.ToSlash(link)),
+		}
+		for _, section := range sections {
+			switch {
+			case section.Heading == "":
+				blocks = append(blocks, section.Text)
+			case section.Text == "":
+				blocks = append(blocks, "### "+section.Heading)
+			default:
+				blocks = append(blocks, "### "+section.Heading+"\n\n"+section.Text)
+			}
+		}
+		chapters = append(chapters, strings.Join(blocks, "\n\n"))
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "✗ %s\n", p)
+		}
+		return "", errorf(exitFindings, "%d problem(s) in spec.chapters; nothing was written", len(problems))
+	}
+
+	blocks := []string{
+		"<!-- Generated by zdp assemble-spec from the Final documents listed under spec.chapters in .zdp.yaml. Change those documents, not this file. -->",
+		"# " + config.SpecTitle,
+		strings.Join(contents, "\n"),
+	}
+	return strings.Join(append(blocks, chapters...), "\n\n") + "\n", nil
+}
+
+// ANSI styles used by the terminal renderer
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiCyan      = "\x1b[36m"
+	ansiMagenta   = "\x1b[35m"
+	ansiYellow    = "\x1b[33m"
+)
+
+var (
+	ansiRe       = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+	boldRe       = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	italicRe     = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	linkRe       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	orderedRe    = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	ruleRe       = regexp.MustCompile(`^\s*(-\s*){3,}$|^\s*(\*\s*){3,}$|^\s*(_\s*){3,}$`)
+)
+
+// termRenderer renders markdown as styled terminal text
+type termRenderer struct {
+	color bool
+	width int
+}
+
+// style wraps text in an ANSI style when color is enabled
+func (r termRenderer) style(codes, text string) string {
+	if !r.color || text == "" {
+		return text
+	}
+	return codes + text + ansiReset
+}
+
+// visibleLen returns the display width of text, ignoring ANSI styles
+func visibleLen(text string) int {
+	return len([]rune(ansiRe.ReplaceAllString(text, "")))
+}
+
+// inline applies code, bold, italic, and link styles within a line.
+// Code spans are styled last so their contents are left alone.
+func (r termRenderer) inline(text string) string {
+	var spans []string
+	text = inlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, m[1:len(m)-1])
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	text = linkRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := linkRe.FindStringSubmatch(m)
+		return r.style(ansiUnderline+ansiCyan, sub[1]) + r.style(ansiDim, " ("+sub[2]+")")
+	})
+	text = boldRe.ReplaceAllStringFunc(text, func(m string) string {
+		return r.style(ansiBold, m[2:len(m)-2])
+	})
+	text = italicRe.ReplaceAllStringFunc(text, func(m string) string {
+		return r.style(ansiItalic, m[1:len(m)-1])
+	})
+
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), r.style(ansiYellow, span), 1)
+	}
+	return text
+}
+
+// wrap breaks styled text into lines no wider than the renderer, with
+// the first line prefixed by first and the rest by rest
+func (r termRenderer) wrap(text, first, rest string) []string {
+	var lines []string
+	line := first
+	lineLen := visibleLen(first)
+	empty := true
+
+	for _, word := range strings.Fields(text) {
+		wordLen := visibleLen(word)
+		if !empty && lineLen+1+wordLen > r.width {
+			lines = append(lines, line)
+			line, lineLen, empty = rest, visibleLen(rest), true
+		}
+		if !empty {
+			line += " "
+			lineLen++
+		}
+		line += word
+		lineLen += wordLen
+		empty = false
+	}
+	return append(lines, line)
+}
+
+// table renders a block of markdown table rows with aligned columns
+func (r termRenderer) table(rows []string) []string {
+	var cells [][]string
+	var widths []int
+	for _, row := range rows {
+		cols := splitTableRow(row)
+		if isTableSeparator(cols) {
+			continue
+		}
+		for i := range cols {
+			cols[i] = r.inline(strings.ReplaceAll(cols[i], `\|`, "|"))
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := visibleLen(cols[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+		cells = append(cells, cols)
+	}
+
+	var out []string
+	for i, cols := range cells {
+		var parts []string
+		for j, width := range widths {
+			cell := ""
+			if j < len(cols) {
+				cell = cols[j]
+			}
+			padded := cell
+			if j < len(widths)-1 {
+				padded += strings.Repeat(" ", width-visibleLen(cell))
+			}
+			if i == 0 {
+				padded = r.style(ansiBold, padded)
+			}
+			parts = append(parts, padded)
+		}
+		out = append(out, "  "+strings.Join(parts, r.style(ansiDim, " │ ")))
+
+		if i == 0 {
+			var rule []string
+			for _, width := range widths {
+				rule = append(rule, strings.Repeat("─", width))
+			}
+			out = append(out, "  "+r.style(ansiDim, strings.Join(rule, "─┼─")))
+		}
+	}
+	return out
+}
+
+// render converts a markdown body into styled terminal lines
+func (r termRenderer) render(body string) string {
+	var out []string
+	lines := strings.Split(body, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			// Fenced code is shown verbatim, indented
+			var fence codeFence
+			fence.inCode(line)
+			if lang := strings.TrimLeft(trimmed, fence.marker[:1]); lang != "" {
+				out = append(out, "    "+r.style(ansiDim, lang))
+			}
+			for i++; i < len(lines); i++ {
+				fence.inCode(lines[i])
+				if fence.marker == "" {
+					break
+				}
+				out = append(out, "    "+r.style(ansiCyan, lines[i]))
+			}
+
+		case strings.HasPrefix(trimmed, "|"):
+			var rows []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, lines[i])
+			}
+			i--
+			out = append(out, r.table(rows)...)
+
+		case markdownHeadingRe.MatchString(line):
+			m := markdownHeadingRe.FindStringSubmatch(line)
+			text := r.inline(m[2])
+			plain := ansiRe.ReplaceAllString(text, "")
+			switch len(m[1]) {
+			case 1:
+				out = append(out, r.style(ansiBold+ansiUnderline+ansiMagenta, plain))
+			case 2:
+				out = append(out, r.style(ansiBold+ansiCyan, plain))
+			default:
+				out = append(out, r.style(ansiBold, plain))
+			}
+			// Without styles, underline the top two levels instead
+			if !r.color && len(m[1]) <= 2 {
+				out = append(out, strings.Repeat(map[int]string{1: "=", 2: "-"}[len(m[1])], visibleLen(plain)))
+			}
+
+		case ruleRe.MatchString(line):
+			out = append(out, r.style(ansiDim, strings.Repeat("─", r.width)))
+
+		case strings.HasPrefix(trimmed, ">"):
+			text := r.inline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+			bar := r.style(ansiDim, "│ ")
+			for _, wrapped := range r.wrap(r.style(ansiItalic, text), bar, bar) {
+				out = append(out, wrapped)
+			}
+
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			indent := strings.Repeat(" ", len(m[1]))
+			out = append(out, r.wrap(r.inline(m[2]), indent+"  • ", indent+"    ")...)
+
+		case orderedRe.MatchString(line):
+			m := orderedRe.FindStringSubmatch(line)
+			indent := strings.Repeat(" ", len(m[1]))
+			marker := indent + "  " + m[2] + " "
+			out = append(out, r.wrap(r.inline(m[3]), marker, strings.Repeat(" ", len(marker)))...)
+
+		case trimmed == "":
+			out = append(out, "")
+
+		default:
+			out = append(out, r.wrap(r.inline(trimmed), "", "")...)
+		}
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// showCommand parses the arguments of "show <doc|number> [--format text|json]"
+func showCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp show <doc|number> [--format text|json]"
+	var ref string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || ref != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		ref = args[i]
+	}
+	if ref == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	doc, err := findDocument(ref)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	return show(ctx, doc, asJSON)
+}
+
+// shownDoc is the JSON form of "show": the document, its supersession
+// links and dependencies, and its lifecycle history, oldest first
+type shownDoc struct {
+	listedDoc
+	Supersedes   []string         `json:"supersedes"`
+	SupersededBy []string         `json:"superseded_by"`
+	DependsOn    []string         `json:"depends_on"`
+	Dependents   []string         `json:"dependents"`
+	History      []lifecycleEvent `json:"history"`
+}
+
+// show prints a document's metadata, supersession links, and the
+// lifecycle events git records for its number
+func show(ctx context.Context, doc *Document, asJSON bool) error {
+	events, err := lifecycleEvents(ctx)
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	history := []lifecycleEvent{}
+	for _, e := range events {
+		if e.Number == doc.Number {
+			history = append(history, e)
+		}
+	}
+
+	shown := shownDoc{
+		listedDoc:    newListedDoc(doc),
+		Supersedes:   append([]string{}, docRefs(doc.Fields["supersedes"])...),
+		SupersededBy: append([]string{}, docRefs(doc.Fields["superseded-by"])...),
+		DependsOn:    append([]string{}, docRefs(doc.Fields["depends-on"])...),
+		Dependents:   append([]string{}, dependents(scanDocuments(), doc.Number)...),
+		History:      history,
+	}
+	if asJSON {
+		return printJSON(shown)
+	}
+
+	none := func(refs []string) string {
+		if len(refs) == 0 {
+			return "None"
+		}
+		return strings.Join(refs, ", ")
+	}
+	fmt.Fprintf(stdout, "%s  %s\n\n", doc.Number, displayTitle(doc.Title))
+	fmt.Fprintf(stdout, "Path:          %s\n", doc.Path)
+	fmt.Fprintf(stdout, "State:         %s\n", doc.State)
+	if len(doc.Authors) > 1 {
+		fmt.Fprintf(stdout, "Authors:       %s\n", strings.Join(doc.Authors, "\n               "))
+	} else {
+		fmt.Fprintf(stdout, "Author:        %s\n", doc.Author)
+	}
+	fmt.Fprintf(stdout, "Created:       %s\n", doc.Created)
+	fmt.Fprintf(stdout, "Updated:       %s\n", doc.Updated)
+	fmt.Fprintf(stdout, "Supersedes:    %s\n", none(shown.Supersedes))
+	fmt.Fprintf(stdout, "Superseded by: %s\n", none(shown.SupersededBy))
+	fmt.Fprintf(stdout, "Depends on:    %s\n", none(shown.DependsOn))
+	fmt.Fprintf(stdout, "Dependents:    %s\n", none(shown.Dependents))
+
+	var custom []string
+	for key := range doc.Fields {
+		if !isCoreField(key) && key != "authors" && key != "depends-on" {
+			custom = append(custom, key)
+		}
+	}
+	if len(custom) > 0 {
+		sort.Strings(custom)
+		fmt.Fprintln(stdout)
+		for _, key := range custom {
+			fmt.Fprintf(stdout, "%-14s %s\n", key+":", displayTitle(doc.Fields[key]))
+		}
+	}
+
+	fmt.Fprintln(stdout, "\nHistory:")
+	if len(history) == 0 {
+		fmt.Fprintln(stdout, "  No lifecycle events in git history")
+	}
+	for _, e := range history {
+		var what string
+		switch e.Type {
+		case "created":
+			what = "created in " + e.To
+		case "transitioned":
+			what = e.From + " → " + e.To
+		default:
+			what = "removed from " + e.From
+		}
+		commit := e.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		date := e.Date
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		fmt.Fprintf(stdout, "  %s  %s (%s, %s)\n", date, what, commit, e.Author)
+	}
+	return nil
+}
+
+// readCommand parses the arguments of "read <doc.md|number> [--no-pager]"
+func readCommand(args []string) error {
+	var docPath string
+	pager := true
+	for _, arg := range args {
+		switch {
+		case arg == "--no-pager":
+			pager = false
+		case strings.HasPrefix(arg, "-") || docPath != "":
+			return errorf(exitUsage, "Usage: zdp read <doc.md|number> [--no-pager]")
+		default:
+			docPath = arg
+		}
+	}
+	if docPath == "" {
+		return errorf(exitUsage, "Usage: zdp read <doc.md|number> [--no-pager]")
+	}
+
+	docPath, err := docPathArg(docPath)
+	if err != nil {
+		return err
+	}
+	return readDocument(docPath, pager)
+}
+
+// readDocument renders a document for the terminal. Styles and the pager
+// are used only when stdout is a terminal; NO_COLOR disables styles.
+func readDocument(docPath string, pager bool) error {
+	content, err := os.ReadFile(repoPath(docPath))
+	if os.IsNotExist(err) {
+		return errorf(exitUsage, "File not found: %s", docPath)
+	}
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read file: %v", err)
+	}
+
+	terminal := false
+	if info, err := os.Stdout.Stat(); err == nil {
+		terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	r := termRenderer{color: terminal && os.Getenv("NO_COLOR") == "", width: 80}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 20 {
+		r.width = columns - 2
+	}
+
+	// Summarize the frontmatter as a header line
+	var header string
+	body := string(content)
+	if metadata, err := parseYAML(body); err == nil {
+		body = frontmatterRe.ReplaceAllString(body, "")
+		details := []string{metadata["number"], metadata["state"], metadata["author"], "updated " + metadata["updated"]}
+		header = r.style(ansiDim, strings.Join(details, " · ")) + "\n\n"
+	}
+	rendered := header + r.render(strings.TrimLeft(body, "\n"))
+
+	if !terminal || !pager {
+		fmt.Fprint(stdout, rendered)
+		return nil
+	}
+
+	pagerCmd := os.Getenv("PAGER")
+	if pagerCmd == "" {
+		pagerCmd = "less -R"
+	}
+	fields := strings.Fields(pagerCmd)
+	cmd := repoCommand(fields[0], fields[1:]...)
+	cmd.Stdin = strings.NewReader(rendered)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// Fall back to printing when the pager is unavailable
+		if _, ok := err.(*exec.ExitError); !ok {
+			fmt.Fprint(stdout, rendered)
+		}
+	}
+	return nil
+}
+
+// docSection is a run of body text under one heading
+type docSection struct {
+	Key     string // normalized heading used to align documents
+	Heading string
+	Lines   []string
+}
+
+var sectionNumberRe = regexp.MustCompile(`^(\d+\.)*\d+\.?\s+`)
+
+// splitSections splits a document body at its headings, ignoring headings
+// inside fenced code. Headings are matched case-insensitively and without
+// section numbers, so "## 3. Goals" aligns with "### Goals"; repeated
+// headings are told apart by occurrence.
+func splitSections(body string) []docSection {
+	sections := []docSection{{Key: "(introduction)", Heading: "(introduction)"}}
+	seen := make(map[string]int)
+	var fence codeFence
+
+	for _, line := range strings.Split(body, "\n") {
+		if fence.inCode(line) {
+			// Headings inside code don't start sections
+		} else if m := markdownHeadingRe.FindStringSubmatch(line); m != nil {
+			key := strings.ToLower(sectionNumberRe.ReplaceAllString(m[2], ""))
+			seen[key]++
+			if seen[key] > 1 {
+				key = fmt.Sprintf("%s (%d)", key, seen[key])
+			}
+			sections = append(sections, docSection{Key: key, Heading: m[2]})
+			continue
+		}
+		last := &sections[len(sections)-1]
+		last.Lines = append(last.Lines, line)
+	}
+
+	for i := range sections {
+		sections[i].Lines = trimBlankLines(sections[i].Lines)
+	}
+	if len(sections[0].Lines) == 0 {
+		sections = sections[1:]
+	}
+	return sections
+}
+
+// diffOp is one line of a line diff: ' ' kept, '-' only in a, '+' only in b
+type diffOp struct {
+	Kind byte
+	Text string
+}
+
+// diffLines computes a line diff from the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// fitColumn truncates or pads text to exactly width runes
+func fitColumn(text string, width int) string {
+	runes := []rune(strings.ReplaceAll(text, "\t", "    "))
+	if len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+	return string(runes) + strings.Repeat(" ", width-len(runes))
+}
+
+// printSideBySide prints the changed lines of a diff in two columns,
+// pairing removals with additions and separating hunks with a rule
+func printSideBySide(ops []diffOp, width int) {
+	col := (width - 3) / 2
+	var left, right []string
+	flush := func() {
+		for k := 0; k < len(left) || k < len(right); k++ {
+			l, r, mark := "", "", "|"
+			if k < len(left) {
+				l = left[k]
+			} else {
+				mark = ">"
+			}
+			if k < len(right) {
+				r = right[k]
+			} else {
+				mark = "<"
+			}
+			fmt.Fprintln(stdout, strings.TrimRight(fitColumn(l, col)+" "+mark+" "+fitColumn(r, col), " "))
+		}
+		left, right = nil, nil
+	}
+
+	hunks := 0
+	for k, op := range ops {
+		switch op.Kind {
+		case '-':
+			left = append(left, op.Text)
+		case '+':
+			right = append(right, op.Text)
+		default:
+			if len(left) > 0 || len(right) > 0 {
+				if hunks > 0 {
+					fmt.Fprintln(stdout, strings.Repeat("·", width))
+				}
+				hunks++
+				flush()
+			}
+		}
+		if k == len(ops)-1 && (len(left) > 0 || len(right) > 0) {
+			if hunks > 0 {
+				fmt.Fprintln(stdout, strings.Repeat("·", width))
+			}
+			flush()
+		}
+	}
+}
+
+// compareCommand handles "compare <doc> <doc>", where each document is
+// a path or number
+func compareCommand(args []string) error {
+	if len(args) != 2 {
+		return errorf(exitUsage, "Usage: zdp compare <doc|number> <doc|number>")
+	}
+
+	var docs [2]*Document
+	var sections [2][]docSection
+	for i, arg := range args {
+		doc, err := findDocument(arg)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		docs[i] = doc
+		sections[i] = splitSections(frontmatterRe.ReplaceAllString(string(content), ""))
+	}
+
+	width := 100
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 40 {
+		width = columns
+	}
+
+	byKey := [2]map[string]docSection{{}, {}}
+	for i := range sections {
+		for _, section := range sections[i] {
+			byKey[i][section.Key] = section
+		}
+	}
+
+	fmt.Fprintf(stdout, "A: %s  %s (%s)\n", docs[0].Number, displayTitle(docs[0].Title), docs[0].State)
+	fmt.Fprintf(stdout, "B: %s  %s (%s)\n", docs[1].Number, displayTitle(docs[1].Title), docs[1].State)
+
+	for i, label := range []string{"A", "B"} {
+		var only []string
+		for _, section := range sections[i] {
+			if _, shared := byKey[1-i][section.Key]; !shared {
+				only = append(only, section.Heading)
+			}
+		}
+		fmt.Fprintf(stdout, "\nSections only in %s (%d):\n", label, len(only))
+		if len(only) == 0 {
+			fmt.Fprintln(stdout, "  (none)")
+		}
+		for _, heading := range only {
+			fmt.Fprintf(stdout, "  - %s\n", heading)
+		}
+	}
+
+	var same []string
+	var changed []docSection
+	for _, section := range sections[0] {
+		other, shared := byKey[1][section.Key]
+		if !shared {
+			continue
+		}
+		if strings.Join(section.Lines, "\n") == strings.Join(other.Lines, "\n") {
+			same = append(same, section.Heading)
+		} else {
+			changed = append(changed, section)
+		}
+	}
+
+	fmt.Fprintf(stdout, "\nShared sections: %d identical, %d different\n", len(same), len(changed))
+	for _, section := range changed {
+		other := byKey[1][section.Key]
+		fmt.Fprintf(stdout, "\n%s\n%s\n", section.Heading, strings.Repeat("=", width))
+		col := (width - 3) / 2
+		fmt.Fprintln(stdout, strings.TrimRight(fitColumn("A: "+docs[0].Number, col)+"   "+fitColumn("B: "+docs[1].Number, col), " "))
+		printSideBySide(diffLines(section.Lines, other.Lines), width)
+	}
+	return nil
+}
+
+// decideCommand parses the arguments of
+// "decide --group 31,47 --winner 47 [--rationale text]"
+func decideCommand(args []string) error {
+	usage := "Usage: zdp decide --group <n,n,...> --winner <n> [--rationale text]"
+	var group []string
+	var winner, rationale string
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--group"); err != nil {
+			return err
+		} else if ok {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					group = append(group, item)
+				}
+			}
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--winner"); err != nil {
+			return err
+		} else if ok {
+			winner = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--rationale"); err != nil {
+			return err
+		} else if ok {
+			rationale = value
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+	if len(group) < 2 || winner == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return decideCompetition(group, winner, rationale)
+}
+
+// decideCompetition settles a group of competing proposals: the winner is
+// accepted, the others are rejected, and every document gets
+// competes-with links plus a "Competing Proposals" section recording the
+// outcome, rationale, and how each rejected proposal compared with the
+// winner. All documents, and the transitions they make, are checked
+// before anything is changed.
+func decideCompetition(group []string, winnerArg, rationale string) error {
+	var docs []*Document
+	var winner *Document
+	seen := make(map[string]bool)
+	for _, arg := range group {
+		doc, err := findDocument(arg)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		if seen[doc.Number] {
+			return errorf(exitUsage, "Document %s is listed twice in --group", doc.Number)
+		}
+		seen[doc.Number] = true
+
+		switch normalizeState(doc.State) {
+		case "draft", "under review", "revised", "deferred":
+		default:
+			return errorf(exitConflict, "Document %s is already %s; only open proposals can compete", doc.Number, doc.State)
+		}
+		docs = append(docs, doc)
+	}
+
+	if w, err := findDocument(winnerArg); err == nil {
+		for _, doc := range docs {
+			if doc.Number == w.Number {
+				winner = doc
+			}
+		}
+	}
+	if winner == nil {
+		return errorf(exitUsage, "Winner %s is not part of --group", winnerArg)
+	}
+
+	// Outcome and final location of every document
+	outcome := make(map[string]string)
+	newPath := make(map[string]string)
+	for _, doc := range docs {
+		outcome[doc.Number] = "Rejected"
+		if doc == winner {
+			outcome[doc.Number] = "Accepted"
+		}
+		dir, _ := getStateDir(outcome[doc.Number])
+		newPath[doc.Number] = filepath.Join(dir, filepath.Base(doc.Path))
+	}
+
+	// Compare every rejected proposal with the winner
+	readBody := func(doc *Document) ([]docSection, error) {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return nil, errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		return splitSections(frontmatterRe.ReplaceAllString(string(content), "")), nil
+	}
+	winnerSections, err := readBody(winner)
+	if err != nil {
+		return err
+	}
+	var comparisons []string
+	for _, doc := range docs {
+		if doc == winner {
+			continue
+		}
+		sections, err := readBody(doc)
+		if err != nil {
+			return err
+		}
+		winnerKeys := make(map[string]string)
+		for _, section := range winnerSections {
+			winnerKeys[section.Key] = strings.Join(section.Lines, "\n")
+		}
+		shared, differ := 0, 0
+		for _, section := range sections {
+			if text, ok := winnerKeys[section.Key]; ok {
+				shared++
+				if text != strings.Join(section.Lines, "\n") {
+					differ++
+				}
+			}
+		}
+		comparisons = append(comparisons, fmt.Sprintf("- %s vs %s: %d shared sections (%d differ), %d only in %s, %d only in %s",
+			doc.Number, winner.Number, shared, differ, len(sections)-shared, doc.Number, len(winnerSections)-shared, winner.Number))
+	}
+
+	// Refuse before writing anything if a document can't make its move
+	reasons := make(map[string]string)
+	for _, doc := range docs {
+		reasons[doc.Number] = fmt.Sprintf("%s was accepted instead", winner.Number)
+		if doc == winner {
+			reasons[doc.Number] = "Chosen among competing proposals"
+			if rationale != "" {
+				reasons[doc.Number] = rationale
+			}
+		}
+		if _, err := checkTransition(doc.Path, outcome[doc.Number], transitionOptions{Force: true, Reason: reasons[doc.Number]}); err != nil {
+			return err
+		}
+	}
+
+	today := time.Now().Format("2006-01-02")
+	original := make(map[string]string)
+	updates := make(map[string]string)
+	for _, doc := range docs {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+
+		// Link to the other proposals in the group
+		competitors := metaList(doc.Fields["competes-with"])
+		for _, other := range docs {
+			if other != doc && !containsString(competitors, other.Number) {
+				competitors = append(competitors, other.Number)
+			}
+		}
+		sort.Strings(competitors)
+		updated, err := setFrontmatterField(string(content), "competes-with", "["+strings.Join(competitors, ", ")+"]")
+		if err != nil {
+			return errorf(exitFindings, "%s: %v", doc.Path, err)
+		}
+
+		section := []string{"## Competing Proposals"}
+		if doc == winner {
+			section = append(section, fmt.Sprintf("Decided %s: this proposal was accepted over the competing proposals below.", today))
+		} else {
+			section = append(section, fmt.Sprintf("Decided %s: this proposal was rejected in favor of %s (%s).", today, winner.Number, displayTitle(winner.Title)))
+		}
+
+		table := []string{"| Number | Title | Outcome |", "|--------|-------|---------|"}
+		for _, other := range docs {
+			link := filepath.ToSlash(filepath.Join("..", newPath[other.Number]))
+			table = append(table, fmt.Sprintf("| [%s](%s) | %s | %s |", other.Number, link, escapeTableCell(displayTitle(other.Title)), outcome[other.Number]))
+		}
+		section = append(section, strings.Join(table, "\n"))
+
+		if rationale != "" {
+			section = append(section, "**Rationale**: "+rationale)
+		} else {
+			section = append(section, "**Rationale**: _None recorded._")
+		}
+		section = append(section, "**Comparison** (`zdp compare`):\n\n"+strings.Join(comparisons, "\n"))
+
+		original[doc.Number] = string(content)
+		updates[doc.Number] = strings.TrimRight(updated, "\n") + "\n\n" + strings.Join(section, "\n\n") + "\n"
+	}
+
+	for _, doc := range docs {
+		if err := os.WriteFile(repoPath(doc.Path), []byte(updates[doc.Number]), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to update %s: %v", doc.Path, err)
+		}
+		opResult.recordFieldChanges(doc.Path, original[doc.Number], updates[doc.Number])
+	}
+	for _, doc := range docs {
+		if err := transitionDocument(doc.Path, outcome[doc.Number], transitionOptions{Force: true, Reason: reasons[doc.Number]}); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stdout, "Accepted %s; rejected %d competing proposal(s)\n", winner.Number, len(docs)-1)
+	return nil
+}
+
+// expiryCandidate is a Deferred document past deferral.max-days
+type expiryCandidate struct {
+	doc      *Document
+	since    string // date it was deferred
+	days     int
+	notified string // date of its expiry notice, or ""
+}
+
+// deferredPastLimit lists the Deferred documents deferred for longer than
+// deferral.max-days. The deferral date comes from git, or from the
+// updated field for documents git has no record of.
+func deferredPastLimit() ([]expiryCandidate, error) {
+	deferredDir, known := states["deferred"]
+	if !known {
+		return nil, errorf(exitUsage, "The workflow has no Deferred state")
+	}
+
+	var found []expiryCandidate
+	for _, doc := range scanDocuments() {
+		if normalizeState(doc.State) != "deferred" {
+			continue
+		}
+		since := doc.Updated
+		changes := getGitChanges(doc.Path)
+		if i := stateEntry(changes, deferredDir); i >= 0 {
+			since = changes[i].Date
+		}
+		days, ok := daysSince(since)
+		if !ok || days <= config.MaxDeferral {
+			continue
+		}
+		notified := doc.Fields["expiry-notice"]
+		if strings.EqualFold(notified, "none") {
+			notified = ""
+		}
+		found = append(found, expiryCandidate{doc, since, days, notified})
+	}
+	sort.Slice(found, func(i, j int) bool { return docNumberLess(found[i].doc.Number, found[j].doc.Number) })
+	return found, nil
+}
+
+// expireCommand parses the arguments of "expire [--notify | --apply]"
+func expireCommand(args []string) error {
+	const usage = "Usage: zdp expire [--notify | --apply]"
+	if len(args) > 1 || (len(args) == 1 && args[0] != "--notify" && args[0] != "--apply") {
+		return errorf(exitUsage, usage)
+	}
+	if config.MaxDeferral == 0 {
+		return errorf(exitUsage, "No deferral.max-days configured in .zdp.yaml")
+	}
+
+	candidates, err := deferredPastLimit()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 0:
+		return expireReport(candidates)
+	case args[0] == "--notify":
+		return expireNotify(candidates)
+	default:
+		return expireApply(candidates)
+	}
+}
+
+// expireReport lists the Deferred documents past the limit and where
+// each stands in the notice period, exiting with status 1 if there are any
+func expireReport(candidates []expiryCandidate) error {
+	if len(candidates) == 0 {
+		fmt.Fprintf(stdout, "No document has been Deferred for more than %d days\n", config.MaxDeferral)
+		return nil
+	}
+	for _, c := range candidates {
+		status := "not notified; run zdp expire --notify"
+		if c.notified != "" {
+			status = "notified " + c.notified
+			if days, ok := daysSince(c.notified); ok && days >= config.ExpiryGrace {
+				status += "; due for withdrawal"
+			}
+		}
+		fmt.Fprintf(stdout, "  %s  %s (deferred %s, %d days; %s)\n", c.doc.Number, displayTitle(c.doc.Title), c.since, c.days, status)
+	}
+	return errorf(exitFindings, "%d document(s) Deferred for more than %d days", len(candidates), config.MaxDeferral)
+}
+
+// expireNotify records an expiry notice on each document past the limit
+// that has none yet, and lists the authors to tell. Withdrawal waits
+// deferral.grace-days from the notice.
+func expireNotify(candidates []expiryCandidate) error {
+	today := time.Now().Format("2006-01-02")
+	notified := 0
+	for _, c := range candidates {
+		if c.notified != "" {
+			continue
+		}
+		content, err := os.ReadFile(repoPath(c.doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", c.doc.Path, err)
+		}
+		updated, err := setFrontmatterField(string(content), "expiry-notice", today)
+		if err != nil {
+			return errorf(exitFindings, "%s: %v", c.doc.Path, err)
+		}
+		if err := os.WriteFile(repoPath(c.doc.Path), []byte(updated), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to update %s: %v", c.doc.Path, err)
+		}
+		opResult.recordFieldChanges(c.doc.Path, string(content), updated)
+		if output, err := repoCommand("git", "add", c.doc.Path).CombinedOutput(); err != nil {
+			return errorf(exitEnvironment, "git add failed: %v\nOutput: %s", err, string(output))
+		}
+		opResult.recordStaged(c.doc.Path)
+
+		fmt.Fprintf(stdout, "  %s  %s - notify %s\n", c.doc.Number, displayTitle(c.doc.Title), c.doc.Author)
+		notified++
+	}
+	if notified == 0 {
+		fmt.Fprintln(stdout, "No new documents to notify")
+		return nil
+	}
+	fmt.Fprintf(stdout, "Recorded expiry notices on %d document(s); they can be withdrawn from %s\n",
+		notified, time.Now().AddDate(0, 0, config.ExpiryGrace).Format("2006-01-02"))
+	return nil
+}
+
+// expireApply withdraws the documents whose expiry notice is at least
+// deferral.grace-days old, adding a notice section that explains why
+func expireApply(candidates []expiryCandidate) error {
+	today := time.Now().Format("2006-01-02")
+	withdrawn := 0
+	for _, c := range candidates {
+		days, ok := daysSince(c.notified)
+		if c.notified == "" || !ok || days < config.ExpiryGrace {
+			continue
+		}
+		content, err := os.ReadFile(repoPath(c.doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", c.doc.Path, err)
+		}
+		notice := strings.Join([]string{
+			"## Expired",
+			fmt.Sprintf("Withdrawn on %s under the deferral expiry policy: this document was Deferred on %s, more than %d days earlier. Its authors were notified on %s.", today, c.since, config.MaxDeferral, c.notified),
+			"To revive it, move it back to Draft with `zdp transition`.",
+		}, "\n\n")
+		updated := strings.TrimRight(string(content), "\n") + "\n\n" + notice + "\n"
+		if err := os.WriteFile(repoPath(c.doc.Path), []byte(updated), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to update %s: %v", c.doc.Path, err)
+		}
+		opResult.recordFieldChanges(c.doc.Path, string(content), updated)
+
+		reason := fmt.Sprintf("Expired after more than %d days Deferred", config.MaxDeferral)
+		if err := transitionDocument(c.doc.Path, "Withdrawn", transitionOptions{Force: true, Reason: reason}); err != nil {
+			return err
+		}
+		withdrawn++
+	}
+	fmt.Fprintf(stdout, "Withdrew %d expired document(s)\n", withdrawn)
+	return nil
+}
+
+// hookEvent is the JSON a hook command receives on stdin
+type hookEvent struct {
+	Event    string            `json:"event"`
+	Document listedDoc         `json:"document"`
+	Details  map[string]string `json:"details"`
+	Actor    string            `json:"actor"`
+}
+
+// runHooks runs the commands configured for an event once its change is
+// made. A failing hook is reported but doesn't undo the change.
+func runHooks(event, docPath string, details map[string]string) {
+	commands := config.Hooks[event]
+	if len(commands) == 0 {
+		return
+	}
+	doc, err := extractDocMetadata(docPath)
+	if err != nil {
+		warn("Skipped %s hooks for %s: %v", event, docPath, err)
+		return
+	}
+	input, err := json.Marshal(hookEvent{Event: event, Document: newListedDoc(doc), Details: details, Actor: currentIdentity().String()})
+	if err != nil {
+		warn("Skipped %s hooks for %s: %v", event, docPath, err)
+		return
+	}
+
+	for _, command := range commands {
+		switch {
+		case staging:
+			heldHooks = append(heldHooks, heldHook{event, command, input})
+		case dryRunning:
+			fmt.Fprintf(stdout, "Would run %s hook: %s\n", event, command)
+		default:
+			runHook(event, command, input)
+		}
+	}
+}
+
+// runHook runs one hook command with the event as its input: JSON, or
+// an s-expression for a Zylisp script
+func runHook(event, command string, input []byte) {
+	argv, zylisp := scriptCommand(command)
+	if zylisp {
+		var err error
+		if input, err = jsonToSexp(input); err != nil {
+			warn("%s hook \"%s\" failed: %v", event, command, err)
+			return
+		}
+	}
+	cmd := repoCommand(argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠ %s hook \"%s\" failed: %v\n", event, command, err)
+		warn("%s hook \"%s\" failed: %v", event, command, err)
+	}
+}
+
+// scriptCommand splits a hook or renderer command into the program and
+// its arguments. A program ending in .zl is a Zylisp script, run with
+// the scripting.zylisp interpreter.
+func scriptCommand(command string) (argv []string, zylisp bool) {
+	argv = strings.Fields(command)
+	if !strings.HasSuffix(argv[0], ".zl") {
+		return argv, false
+	}
+	return append(strings.Fields(config.Zylisp), argv...), true
+}
+
+// sexpKeywordRe matches the map keys written as Zylisp keywords
+var sexpKeywordRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.?!*+<>=/-]*$`)
+
+// jsonToSexp converts JSON to a Zylisp s-expression: objects become maps
+// with keyword keys, where underscores become hyphens, arrays become
+// vectors, and null becomes nil
+func jsonToSexp(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	var write func(v interface{})
+	write = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			b.WriteByte('{')
+			for i, k := range keys {
+				if i > 0 {
+					b.WriteByte(' ')
+				}
+				if key := strings.ReplaceAll(k, "_", "-"); sexpKeywordRe.MatchString(key) {
+					b.WriteString(":" + key)
+				} else {
+					write(k)
+				}
+				b.WriteByte(' ')
+				write(v[k])
+			}
+			b.WriteByte('}')
+		case []interface{}:
+			b.WriteByte('[')
+			for i, item := range v {
+				if i > 0 {
+					b.WriteByte(' ')
+				}
+				write(item)
+			}
+			b.WriteByte(']')
+		case string:
+			b.WriteByte('"')
+			for _, r := range v {
+				switch r {
+				case '"', '\\':
+					b.WriteRune('\\')
+					b.WriteRune(r)
+				case '\n':
+					b.WriteString(`\n`)
+				case '\t':
+					b.WriteString(`\t`)
+				case '\r':
+					b.WriteString(`\r`)
+				default:
+					b.WriteRune(r)
+				}
+			}
+			b.WriteByte('"')
+		case json.Number:
+			b.WriteString(v.String())
+		case bool:
+			b.WriteString(strconv.FormatBool(v))
+		case nil:
+			b.WriteString("nil")
+		}
+	}
+	write(value)
+	b.WriteByte('\n')
+	return []byte(b.String()), nil
+}
+
+// parseSexpMap reads the map a Zylisp script prints, such as
+// {:mime "text/plain" :content "..."}, into its keys without the colon
+// and its values as text. Commas count as whitespace and ; starts a
+// comment, as in Zylisp source.
+func parseSexpMap(text string) (map[string]string, error) {
+	pos := 0
+	skip := func() {
+		for pos < len(text) {
+			switch c := text[pos]; {
+			case c == ';':
+				for pos < len(text) && text[pos] != '\n' {
+					pos++
+				}
+			case c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r':
+				pos++
+			default:
+				return
+			}
+		}
+	}
+	atom := func() (string, error) {
+		skip()
+		if pos >= len(text) {
+			return "", fmt.Errorf("unexpected end of input")
+		}
+		if text[pos] != '"' {
+			start := pos
+			for pos < len(text) && !strings.ContainsRune(" \t\r\n,;{}\"", rune(text[pos])) {
+				pos++
+			}
+			if start == pos {
+				return "", fmt.Errorf("unexpected %q at offset %d", text[pos], pos)
+			}
+			return text[start:pos], nil
+		}
+		var b strings.Builder
+		for pos++; pos < len(text); pos++ {
+			c := text[pos]
+			if c == '"' {
+				pos++
+				return b.String(), nil
+			}
+			if c == '\\' && pos+1 < len(text) {
+				pos++
+				switch c = text[pos]; c {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				case 'r':
+					c = '\r'
+				}
+			}
+			b.WriteByte(c)
+		}
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	skip()
+	if pos >= len(text) || text[pos] != '{' {
+		return nil, fmt.Errorf("expected a map such as {:mime \"text/plain\" :content \"...\"}")
+	}
+	pos++
+	fields := make(map[string]string)
+	for {
+		skip()
+		if pos < len(text) && text[pos] == '}' {
+			pos++
+			break
+		}
+		key, err := atom()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(key, ":") || len(key) == 1 {
+			return nil, fmt.Errorf("expected a keyword, found %s", key)
+		}
+		value, err := atom()
+		if err != nil {
+			return nil, err
+		}
+		fields[key[1:]] = value
+	}
+	if skip(); pos < len(text) {
+		return nil, fmt.Errorf("unexpected text after the map")
+	}
+	return fields, nil
+}
+
+// adoptCommand parses the arguments of "adopt <doc> <new-author> [--force]"
+func adoptCommand(args []string) error {
+	var positional []string
+	force := false
+	for _, arg := range args {
+		if arg == "--force" {
+			force = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) != 2 || strings.HasPrefix(positional[0], "-") {
+		return errorf(exitUsage, "Usage: zdp adopt <doc> <new-author> [--force]")
+	}
+	return adoptDocument(positional[0], positional[1], force)
+}
+
+// adoptDocument hands an abandoned draft to a new author. The original
+// authors are credited in a Provenance section, a champion who was one
+// of them is replaced too, and updated is reset so the draft is no
+// longer stale. Drafts updated within review.stale-days need force.
+func adoptDocument(docArg, newAuthor string, force bool) error {
+	newAuthor = strings.TrimSpace(newAuthor)
+	if newAuthor == "" {
+		return errorf(exitUsage, "New author must not be empty")
+	}
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	if normalizeState(doc.State) != "draft" {
+		return errorf(exitConflict, "Only drafts can be adopted; %s is %s", doc.Number, doc.State)
+	}
+	previous := doc.Authors
+	if parsePerson(newAuthor).matchesAny(previous) {
+		return errorf(exitConflict, "%s is already an author of %s", newAuthor, doc.Number)
+	}
+	days, known := daysSince(doc.Updated)
+	if !force && (!known || days < config.StaleDays) {
+		return errorf(exitConflict, "%s was updated %s, within review.stale-days (%d); pass --force to adopt it anyway", doc.Number, doc.Updated, config.StaleDays)
+	}
+
+	content, err := os.ReadFile(repoPath(doc.Path))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read file: %v", err)
+	}
+	today := time.Now().Format("2006-01-02")
+	// A list of authors is replaced too, so no original author is left
+	// in it; they are credited below
+	updated := string(content)
+	if doc.Fields["authors"] != "" {
+		updated, err = setFrontmatterField(updated, "authors", yamlFlow([]interface{}{newAuthor}))
+	}
+	if err == nil && (doc.Fields["author"] != "" || doc.Fields["authors"] == "") {
+		updated, err = setFrontmatterField(updated, "author", newAuthor)
+	}
+	if err == nil {
+		updated, err = setFrontmatterField(updated, "updated", today)
+	}
+	if champion := doc.Fields["champion"]; err == nil && parsePerson(champion).matchesAny(previous) {
+		updated, err = setFrontmatterField(updated, "champion", newAuthor)
+	}
+	if err != nil {
+		return errorf(exitFindings, "%s: %v", doc.Path, err)
+	}
+
+	original := "an unknown author"
+	if len(previous) > 0 {
+		original = strings.Join(previous, ", ")
+	}
+	note := fmt.Sprintf("Adopted by %s on %s. Originally written by %s", newAuthor, today, original)
+	if known {
+		note += fmt.Sprintf(", and last updated %s (%d days earlier).", doc.Updated, days)
+	} else {
+		note += "."
+	}
+	if by := recordedBy(); by != "" {
+		note += fmt.Sprintf(" Recorded by %s.", by)
+	}
+	if strings.Contains(updated, "\n## Provenance\n") {
+		updated = strings.TrimRight(updated, "\n") + "\n\n" + note + "\n"
+	} else {
+		updated = strings.TrimRight(updated, "\n") + "\n\n## Provenance\n\n" + note + "\n"
+	}
+
+	if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to update file: %v", err)
+	}
+	opResult.recordFieldChanges(doc.Path, string(content), updated)
+	fmt.Fprintf(stdout, "%s adopted %s from %s\n", newAuthor, doc.Number, original)
+
+	runHooks("adopt", doc.Path, map[string]string{"previous_author": doc.Author, "new_author": newAuthor})
+	return nil
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// championCommand handles "champion <doc> <person>" to assign or hand
+// off a document, and bare "champion" to report champion problems
+func championCommand(args []string) error {
+	switch len(args) {
+	case 0:
+		return championReport()
+	case 2:
+		return assignChampion(args[0], args[1])
+	default:
+		return errorf(exitUsage, "Usage: zdp champion [<doc> <person>]")
+	}
+}
+
+// assignChampion sets a document's champion, recording the handoff
+func assignChampion(docArg, person string) error {
+	person = strings.TrimSpace(person)
+	if person == "" {
+		return errorf(exitUsage, "Champion name must not be empty")
+	}
+
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	previous := doc.Fields["champion"]
+	if previous == person {
+		return errorf(exitConflict, "%s is already the champion of %s", person, doc.Number)
+	}
+
+	content, err := os.ReadFile(repoPath(doc.Path))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read file: %v", err)
+	}
+	updated, err := setFrontmatterField(string(content), "champion", person)
+	if err == nil {
+		updated, err = setFrontmatterField(updated, "updated", time.Now().Format("2006-01-02"))
+	}
+	if err != nil {
+		return errorf(exitFindings, "%s: %v", doc.Path, err)
+	}
+	if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to update file: %v", err)
+	}
+	opResult.recordFieldChanges(doc.Path, string(content), updated)
+
+	if previous == "" || strings.EqualFold(previous, "none") {
+		fmt.Fprintf(stdout, "Assigned %s as champion of %s\n", person, doc.Number)
+	} else {
+		fmt.Fprintf(stdout, "Handed off %s from %s to %s\n", doc.Number, previous, person)
+	}
+	return nil
+}
+
+// fieldNameRe matches frontmatter field names that get and set accept
+var fieldNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// indexedFields are the frontmatter fields the index shows, so setting
+// them refreshes the document's index row
+var indexedFields = []string{"title", "updated", "superseded-by"}
+
+// getField prints the value of a document's frontmatter field, unquoted.
+// A missing field fails with status 1.
+func getField(docArg, field string) error {
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	value, ok := doc.Fields[field]
+	if !ok {
+		return errorf(exitFindings, "%s has no %s field", doc.Number, field)
+	}
+	if unquoted, err := unquoteYAML(value, 0); err == nil {
+		value = unquoted
+	}
+	fmt.Fprintln(stdout, value)
+	return nil
+}
+
+// yamlFieldValue renders value for a frontmatter field. Titles are
+// always quoted, as new writes them; other values are written as given
+// when they read back unchanged, or as a flow list, and quoted otherwise.
+func yamlFieldValue(field, value string) string {
+	if field == "title" {
+		return strconv.Quote(value)
+	}
+	parsed, err := parseConfigYAML(field + ": " + value)
+	if err == nil {
+		switch v := parsed[field].(type) {
+		case string:
+			if v == value {
+				return value
+			}
+		case []interface{}:
+			return value
+		}
+	}
+	return strconv.Quote(value)
+}
+
+// setField sets a frontmatter field of a document, refreshing the
+// document's index row when the field is one the index shows. State and
+// number are refused, since they also decide where the file lives.
+func setField(docArg, field, value string) error {
+	switch field {
+	case "state":
+		return errorf(exitUsage, "Use zdp transition to change a document's state")
+	case "number":
+		return errorf(exitUsage, "A document's number can't be changed with set")
+	}
+	if !fieldNameRe.MatchString(field) {
+		return errorf(exitUsage, "Invalid field name \"%s\"", field)
+	}
+	if field == "updated" || field == "created" {
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return errorf(exitUsage, "Invalid %s date \"%s\" (use YYYY-MM-DD)", field, value)
+		}
+	}
+	if field == "short-id" && !shortIDRe.MatchString(value) {
+		return errorf(exitUsage, "Invalid short ID \"%s\": use lowercase words joined by hyphens, starting with a letter", value)
+	}
+
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	if field == "short-id" {
+		for _, other := range scanDocuments() {
+			if other.Number != doc.Number && docShortID(other) == value {
+				return errorf(exitConflict, "%s already has the short ID %s", other.Number, value)
+			}
+		}
+	}
+	content, err := os.ReadFile(repoPath(doc.Path))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read file: %v", err)
+	}
+	updated, err := setFrontmatterField(string(content), field, yamlFieldValue(field, value))
+	if err != nil {
+		return errorf(exitFindings, "%s: %v", doc.Path, err)
+	}
+	if updated == string(content) {
+		fmt.Fprintf(stdout, "%s already has %s: %s\n", doc.Number, field, value)
+		return nil
+	}
+	if _, err := parseYAML(updated); err != nil {
+		return errorf(exitUsage, "Setting %s to \"%s\" would break the frontmatter of %s: %v", field, value, doc.Path, err)
+	}
+	change := fmt.Sprintf("Set %s to %s", field, value)
+	if old, err := unquoteYAML(doc.Fields[field], 0); err == nil && old != "" {
+		change = fmt.Sprintf("Set %s: %s → %s", field, old, value)
+	}
+	updated = appendRevision(updated, change)
+	if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to update file: %v", err)
+	}
+	opResult.recordFieldChanges(doc.Path, string(content), updated)
+	fmt.Fprintf(stdout, "Set %s of %s to %s\n", field, doc.Number, value)
+
+	if containsString(indexedFields, field) {
+		changed, err := refreshIndexEntry(doc.Path)
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to update index: %v", err)
+		}
+		if changed {
+			fmt.Fprintln(stdout, "Updated index")
+		}
+	}
+	return nil
+}
+
+// refreshIndexEntry updates a document's table row and state-section
+// link titles from its frontmatter, reporting whether the index changed.
+// Documents the index doesn't list are left to update-index.
+func refreshIndexEntry(docPath string) (bool, error) {
+	idx, _, err := loadIndex(config.IndexFile)
+	if err != nil {
+		return false, err
+	}
+	doc, err := extractDocMetadata(docPath)
+	if err != nil {
+		return false, err
+	}
+	entry := idx.Entry(doc.Number)
+	if entry == nil {
+		return false, nil
+	}
+
+	var changes []string
+	name := filepath.Base(docPath)
+	if entry.Title != doc.Title {
+		changes = append(changes, fmt.Sprintf("Updated title: %s (%s → %s)", name, entry.Title, doc.Title))
+	}
+	if entry.Updated != doc.Updated {
+		changes = append(changes, fmt.Sprintf("Updated date: %s (%s → %s)", name, entry.Updated, doc.Updated))
+	}
+	if note := supersededByNote(doc); entry.SupersededBy != note {
+		changes = append(changes, fmt.Sprintf("Updated superseded-by: %s (%s → %s)", name, noneIfEmpty(entry.SupersededBy), noneIfEmpty(note)))
+	}
+	entry.Title, entry.Updated, entry.SupersededBy = doc.Title, doc.Updated, supersededByNote(doc)
+	for i := range idx.Sections {
+		for j := range idx.Sections[i].Links {
+			if link := &idx.Sections[i].Links[j]; link.Number == doc.Number && link.Title != doc.Title {
+				changes = append(changes, fmt.Sprintf("Updated link title: %s (%s → %s)", name, link.Title, doc.Title))
+				link.Title = doc.Title
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+	if err := saveIndex(config.IndexFile, idx); err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		opResult.recordIndexChange("%s", change)
+	}
+	return true, nil
+}
+
+// championReport lists Under Review documents without a champion and
+// open proposals whose champion has made no commits in
+// review.champion-inactive-days. Either finding exits with status 1.
+func championReport() error {
+	var missing, inactive []string
+	for _, doc := range scanDocuments() {
+		state := normalizeState(doc.State)
+		champion := strings.TrimSpace(doc.Fields["champion"])
+		if strings.EqualFold(champion, "none") {
+			champion = ""
+		}
+		line := fmt.Sprintf("  %s  %s (%s)", doc.Number, displayTitle(doc.Title), doc.State)
+
+		if champion == "" {
+			if state == "under review" {
+				missing = append(missing, line)
+			}
+			continue
+		}
+
+		switch state {
+		case "under review", "revised", "accepted", "active":
+		default:
+			continue
+		}
+		last := getGitLastActivity(champion)
+		if last == "" {
+			inactive = append(inactive, fmt.Sprintf("%s - %s has no commits", line, champion))
+		} else if days, ok := daysSince(last); ok && days >= config.ChampionIdle {
+			inactive = append(inactive, fmt.Sprintf("%s - %s last active %s", line, champion, last))
+		}
+	}
+
+	fmt.Fprintln(stdout, "Under Review without a champion:")
+	if len(missing) == 0 {
+		fmt.Fprintln(stdout, "  (none)")
+	}
+	for _, line := range missing {
+		fmt.Fprintln(stdout, line)
+	}
+
+	fmt.Fprintf(stdout, "\nChampions inactive for %d+ days:\n", config.ChampionIdle)
+	if len(inactive) == 0 {
+		fmt.Fprintln(stdout, "  (none)")
+	}
+	for _, line := range inactive {
+		fmt.Fprintln(stdout, line)
+	}
+
+	if len(missing)+len(inactive) > 0 {
+		return errorf(exitFindings, "%d document(s) need a champion", len(missing)+len(inactive))
+	}
+	return nil
+}
+
+// docAck records that someone has read and acknowledged a document
+type docAck struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Date  string `json:"date"`
+
+	RecordedBy string `json:"recorded_by,omitempty"` // who acknowledged on Name's behalf
+}
+
+// acksPath returns the sidecar file holding a document's acknowledgments
+func acksPath(number string) string {
+	return filepath.Join(".zdp", "acks", number+".jsonl")
+}
+
+// loadAcks reads a document's acknowledgment sidecar, if any
+func loadAcks(number string) ([]docAck, error) {
+	content, err := os.ReadFile(repoPath(acksPath(number)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var acks []docAck
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var a docAck
+		if err := json.Unmarshal([]byte(line), &a); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", acksPath(number), i+1, err)
+		}
+		acks = append(acks, a)
+	}
+	return acks, nil
+}
+
+// appendAck adds one acknowledgment to a document's sidecar
+func appendAck(number string, ack docAck) error {
+	path := acksPath(number)
+	if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(repoPath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	opResult.recordWrite(path)
+	return nil
+}
+
+// hasAcked reports whether any acknowledgment belongs to person
+func hasAcked(acks []docAck, person string) bool {
+	for _, a := range acks {
+		if (identity{Name: a.Name, Email: a.Email}).matches(person) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsAck reports whether a document asks to be acknowledged: Final
+// documents with type: process
+func needsAck(doc *Document) bool {
+	return normalizeState(doc.State) == "final" && strings.EqualFold(doc.Fields["type"], "process")
+}
+
+// ackCommand handles "ack <doc>" and "ack --report [<doc>]"
+func ackCommand(args []string) error {
+	usage := "Usage: zdp ack <doc> | zdp ack --report [<doc>]"
+	switch {
+	case len(args) == 1 && args[0] != "--report":
+		return acknowledgeDocument(args[0])
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "--report":
+		return ackReport(args[1:])
+	default:
+		return errorf(exitUsage, "%s", usage)
+	}
+}
+
+// acknowledgeDocument records the current git identity as having read a
+// Final document
+func acknowledgeDocument(docArg string) error {
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	if normalizeState(doc.State) != "final" {
+		return errorf(exitConflict, "Only Final documents can be acknowledged; %s is %s", doc.Number, doc.State)
+	}
+
+	me := currentIdentity()
+	acks, err := loadAcks(doc.Number)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read acknowledgments: %v", err)
+	}
+	if hasAcked(acks, me.Name) || (me.Email != "" && hasAcked(acks, me.Email)) {
+		return errorf(exitConflict, "%s has already acknowledged %s", me.Name, doc.Number)
+	}
+
+	ack := docAck{Name: me.Name, Email: me.Email, Date: time.Now().Format("2006-01-02"), RecordedBy: recordedBy()}
+	if err := appendAck(doc.Number, ack); err != nil {
+		return errorf(exitEnvironment, "Failed to record acknowledgment: %v", err)
+	}
+	if ack.RecordedBy != "" {
+		fmt.Fprintf(stdout, "Recorded acknowledgment of %s by %s (on their behalf, by %s)\n", doc.Number, me.Name, ack.RecordedBy)
+	} else {
+		fmt.Fprintf(stdout, "Recorded acknowledgment of %s by %s\n", doc.Number, me.Name)
+	}
+	return nil
+}
+
+// ackReport lists the team.members who haven't acknowledged each
+// process document (or just the one given), exiting with status 1 if
+// any acknowledgments are missing
+func ackReport(args []string) error {
+	if len(config.Team) == 0 {
+		return errorf(exitUsage, "No team.members configured in .zdp.yaml")
+	}
+
+	var docs []*Document
+	if len(args) == 1 {
+		doc, err := findDocument(args[0])
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		docs = append(docs, doc)
+	} else {
+		for _, doc := range scanDocuments() {
+			if needsAck(doc) {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	if len(docs) == 0 {
+		fmt.Fprintln(stdout, "No Final process documents to acknowledge")
+		return nil
+	}
+
+	outstanding := 0
+	for _, doc := range docs {
+		acks, err := loadAcks(doc.Number)
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read acknowledgments: %v", err)
+		}
+		var missing []string
+		for _, member := range config.Team {
+			if !hasAcked(acks, member) {
+				missing = append(missing, member)
+			}
+		}
+		outstanding += len(missing)
+
+		fmt.Fprintf(stdout, "%s  %s: %d/%d acknowledged\n", doc.Number, displayTitle(doc.Title), len(config.Team)-len(missing), len(config.Team))
+		for _, member := range missing {
+			fmt.Fprintf(stdout, "  - %s\n", member)
+		}
+	}
+
+	if outstanding > 0 {
+		return errorf(exitFindings, "%d acknowledgment(s) outstanding", outstanding)
+	}
+	return nil
+}
+
+// quarterOf returns the calendar quarter ("2025Q3") of a YYYY-MM-DD date
+func quarterOf(date string) (string, bool) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%dQ%d", t.Year(), (int(t.Month())-1)/3+1), true
+}
+
+// lastQuarters returns the n quarters ending with the current one, oldest first
+func lastQuarters(n int) []string {
+	now := time.Now()
+	start := time.Date(now.Year(), time.Month((int(now.Month())-1)/3*3+1), 1, 0, 0, 0, 0, time.UTC)
+	quarters := make([]string, n)
+	for i := 0; i < n; i++ {
+		q, _ := quarterOf(start.AddDate(0, -3*(n-1-i), 0).Format("2006-01-02"))
+		quarters[i] = q
+	}
+	return quarters
+}
+
+// getGitCommitDates returns the date of every commit touching a file,
+// following renames
+func getGitCommitDates(filePath string) []string {
+	output, err := repoCommand("git", "log", "--follow", "--format=%cs", "--", filePath).Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(output))
+}
+
+// heatmapCommand parses the arguments of "heatmap [--quarters N] [--svg path]"
+func heatmapCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp heatmap [--quarters N] [--svg path]"
+	quarters := 8
+	var svgPath string
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--quarters"); err != nil {
+			return err
+		} else if ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errorf(exitUsage, "Invalid --quarters value \"%s\"", value)
+			}
+			quarters = n
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--svg"); err != nil {
+			return err
+		} else if ok {
+			svgPath = value
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return heatmap(ctx, lastQuarters(quarters), svgPath)
+}
+
+// heatmap counts commits to documents per component (from the component
+// field) per quarter, printing a table and optionally writing an SVG.
+// Documents without a component are counted under "(none)".
+func heatmap(ctx context.Context, quarters []string, svgPath string) error {
+	counts := make(map[string]map[string]int)
+	inRange := make(map[string]bool)
+	for _, q := range quarters {
+		inRange[q] = true
+	}
+
+	docs := scanDocuments()
+	prog := newProgress("Reading history", len(docs))
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return abortOperation(prog, err, "no heatmap produced")
+		}
+		prog.step(filepath.Base(doc.Path))
+
+		components := metaList(doc.Fields["component"])
+		if len(components) == 0 {
+			components = []string{"(none)"}
+		}
+		for _, component := range components {
+			if counts[component] == nil {
+				counts[component] = make(map[string]int)
+			}
+		}
+		for _, date := range getGitCommitDates(doc.Path) {
+			q, ok := quarterOf(date)
+			if !ok || !inRange[q] {
+				continue
+			}
+			for _, component := range components {
+				counts[component][q]++
+			}
+		}
+	}
+	prog.finish()
+
+	var components []string
+	for component := range counts {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	maxCount := 0
+	nameWidth := len("Component")
+	for _, component := range components {
+		if len(component) > nameWidth {
+			nameWidth = len(component)
+		}
+		for _, n := range counts[component] {
+			if n > maxCount {
+				maxCount = n
+			}
+		}
+	}
+
+	// Terminal table with a shade per cell
+	shades := []string{" ", "░", "▒", "▓", "█"}
+	shade := func(n int) string {
+		if n == 0 || maxCount == 0 {
+			return shades[0]
+		}
+		return shades[1+(n-1)*(len(shades)-1)/maxCount]
+	}
+
+	fmt.Fprintf(stdout, "%-*s", nameWidth, "Component")
+	for _, q := range quarters {
+		fmt.Fprintf(stdout, "  %8s", q)
+	}
+	fmt.Fprintln(stdout)
+	for _, component := range components {
+		fmt.Fprintf(stdout, "%-*s", nameWidth, component)
+		for _, q := range quarters {
+			n := counts[component][q]
+			fmt.Fprintf(stdout, "  %s %6d", shade(n), n)
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	if svgPath != "" {
+		if err := os.WriteFile(repoPath(svgPath), []byte(renderHeatmapSVG(components, quarters, counts, maxCount)), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to write %s: %v", svgPath, err)
+		}
+		opResult.recordWrite(svgPath)
+		fmt.Fprintf(stdout, "\nWrote %s\n", svgPath)
+	}
+	return nil
+}
+
+// renderHeatmapSVG draws the component-by-quarter grid, darker cells
+// meaning more commits
+func renderHeatmapSVG(components, quarters []string, counts map[string]map[string]int, maxCount int) string {
+	const cell, labelWidth, headerHeight = 48, 180, 40
+	width := labelWidth + cell*len(quarters) + 10
+	height := headerHeight + cell*len(components) + 10
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	for i, q := range quarters {
+		fmt.Fprintf(&b, `  <text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n", labelWidth+cell*i+cell/2, headerHeight-12, q)
+	}
+	for row, component := range components {
+		y := headerHeight + cell*row
+		var name strings.Builder
+		xml.EscapeText(&name, []byte(component))
+		fmt.Fprintf(&b, `  <text x="%d" y="%d" text-anchor="end">%s</text>`+"\n", labelWidth-8, y+cell/2+4, name.String())
+		for i, q := range quarters {
+			n := counts[component][q]
+			opacity := 0.0
+			if maxCount > 0 {
+				opacity = float64(n) / float64(maxCount)
+			}
+			fmt.Fprintf(&b, `  <rect x="%d" y="%d" width="%d" height="%d" fill="#2c7be5" fill-opacity="%.2f" stroke="#ddd"><title>%s %s: %d</title></rect>`+"\n",
+				labelWidth+cell*i, y, cell, cell, opacity, name.String(), q, n)
+			if n > 0 {
+				fmt.Fprintf(&b, `  <text x="%d" y="%d" text-anchor="middle">%d</text>`+"\n", labelWidth+cell*i+cell/2, y+cell/2+4, n)
+			}
+		}
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// whereExpr is a compiled --where filter
+type whereExpr func(doc *Document) bool
+
+// whereToken is one lexical token of a --where expression
+type whereToken struct {
+	kind string // "op", "field", "literal", or "end"
+	text string
+}
+
+// wherePlainFields are the fields usable without the meta. prefix
+var wherePlainFields = map[string]bool{
+	"number": true, "title": true, "state": true, "author": true, "authors": true,
+	"created": true, "updated": true, "path": true, "age": true,
+	"supersedes": true, "superseded-by": true,
+}
+
+// lexWhere splits a --where expression into tokens
+func lexWhere(expr string) ([]whereToken, error) {
+	var tokens []whereToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string starting at column %d", i+1)
+			}
+			tokens = append(tokens, whereToken{"literal", expr[i+1 : i+1+end]})
+			i += end + 2
+		case strings.ContainsRune("=!<>&|~", rune(c)):
+			op := expr[i : i+1]
+			if i+1 < len(expr) {
+				switch two := expr[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "=~", "&&", "||":
+					op = two
+				}
+			}
+			switch op {
+			case "=", "&", "|", "~":
+				return nil, fmt.Errorf("unexpected %q at column %d", op, i+1)
+			}
+			tokens = append(tokens, whereToken{"op", op})
+			i += len(op)
+		case c == '(' || c == ')':
+			tokens = append(tokens, whereToken{"op", string(c)})
+			i++
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\"'=!<>&|~()", rune(expr[i])) {
+				i++
+			}
+			word := expr[start:i]
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, whereToken{"op", "&&"})
+			case "or":
+				tokens = append(tokens, whereToken{"op", "||"})
+			case "not":
+				tokens = append(tokens, whereToken{"op", "!"})
+			case "contains":
+				tokens = append(tokens, whereToken{"op", "contains"})
+			default:
+				if strings.HasPrefix(word, "meta.") || wherePlainFields[word] {
+					tokens = append(tokens, whereToken{"field", word})
+				} else {
+					tokens = append(tokens, whereToken{"literal", word})
+				}
+			}
+		}
+	}
+	return append(tokens, whereToken{kind: "end"}), nil
+}
+
+// whereParser is a recursive-descent parser over --where tokens
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken { return p.tokens[p.pos] }
+
+func (p *whereParser) next() whereToken {
+	t := p.tokens[p.pos]
+	if t.kind != "end" {
+		p.pos++
+	}
+	return t
+}
+
+// parseWhere compiles a filter expression such as
+// meta.complexity == "high" && state != Draft
+func parseWhere(expr string) (whereExpr, error) {
+	tokens, err := lexWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return e, nil
+}
+
+func (p *whereParser) parseOr() (whereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "op" && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc *Document) bool { return l(doc) || right(doc) }
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "op" && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(doc *Document) bool { return l(doc) && right(doc) }
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseUnary() (whereExpr, error) {
+	t := p.peek()
+	if t.kind == "op" && t.text == "!" {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(doc *Document) bool { return !inner(doc) }, nil
+	}
+	if t.kind == "op" && t.text == "(" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().text != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereExpr, error) {
+	left := p.next()
+	if left.kind != "field" && left.kind != "literal" {
+		return nil, fmt.Errorf("expected a field or value, found %q", left.text)
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == "op" && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" ||
+		t.text == ">" || t.text == ">=" || t.text == "=~" || t.text == "contains"):
+		p.next()
+	default:
+		// A bare operand tests that the field is set
+		return func(doc *Document) bool {
+			return whereTruthy(whereOperand(doc, left))
+		}, nil
+	}
+
+	right := p.next()
+	if right.kind != "field" && right.kind != "literal" {
+		return nil, fmt.Errorf("expected a value after %s", t.text)
+	}
+
+	var re *regexp.Regexp
+	if t.text == "=~" {
+		var err error
+		if re, err = regexp.Compile("(?i)" + right.text); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", right.text, err)
+		}
+	}
+
+	op := t.text
+	return func(doc *Document) bool {
+		values := whereOperand(doc, left)
+		want := whereOperand(doc, right)
+		target := ""
+		if len(want) > 0 {
+			target = want[0]
+		}
+
+		if op == "!=" {
+			for _, v := range values {
+				if strings.EqualFold(v, target) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, v := range values {
+			if whereCompare(op, v, target, re) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// whereOperand resolves a token to its values: literals are themselves,
+// and list fields yield one value per item
+func whereOperand(doc *Document, t whereToken) []string {
+	if t.kind == "literal" {
+		return []string{t.text}
+	}
+
+	name := strings.TrimPrefix(t.text, "meta.")
+	if value, ok := doc.Meta[name]; ok {
+		switch v := value.(type) {
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			return items
+		case string:
+			if strings.HasPrefix(strings.TrimSpace(v), "[") {
+				return metaList(v)
+			}
+			return []string{v}
+		default:
+			return []string{fmt.Sprint(v)}
+		}
+	}
+	if name == "path" {
+		return []string{filepath.ToSlash(doc.Path)}
+	}
+	if name == "author" || name == "authors" {
+		// Each author by name, so a comparison matches any of them
+		var names []string
+		for _, person := range doc.Authors {
+			names = append(names, parsePerson(person).Name)
+		}
+		return names
+	}
+	if name == "age" {
+		// Days since the last update
+		if days, ok := daysSince(doc.Updated); ok {
+			return []string{strconv.Itoa(days)}
+		}
+		return nil
+	}
+	if raw, ok := doc.Fields[name]; ok {
+		return []string{displayTitle(raw)}
+	}
+	return nil
+}
+
+// whereTruthy reports whether a field is set to something meaningful
+func whereTruthy(values []string) bool {
+	for _, v := range values {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "none", "false", "no", "0":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// whereCompare applies one comparison operator. Ordering compares
+// numerically when both sides are numbers and as text otherwise, which
+// also orders YYYY-MM-DD dates correctly; equality ignores case.
+func whereCompare(op, value, target string, re *regexp.Regexp) bool {
+	switch op {
+	case "==":
+		return strings.EqualFold(value, target)
+	case "=~":
+		return re.MatchString(value)
+	case "contains":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(target))
+	}
+
+	cmp := strings.Compare(value, target)
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(target, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// listedDoc is the JSON form of a document in "list --format json"
+type listedDoc struct {
+	Number  string                 `json:"number"`
+	Title   string                 `json:"title"`
+	State   string                 `json:"state"`
+	Author  string                 `json:"author"`
+	Authors []string               `json:"authors"`
+	Created string                 `json:"created"`
+	Updated string                 `json:"updated"`
+	Path    string                 `json:"path"`
+	Meta    map[string]interface{} `json:"meta"`
+}
+
+// newListedDoc returns the JSON form of a document
+func newListedDoc(doc *Document) listedDoc {
+	return listedDoc{
+		Number:  doc.Number,
+		Title:   displayTitle(doc.Title),
+		State:   doc.State,
+		Author:  doc.Author,
+		Authors: append([]string{}, doc.Authors...),
+		Created: doc.Created,
+		Updated: doc.Updated,
+		Path:    filepath.ToSlash(doc.Path),
+		Meta:    doc.Meta,
+	}
+}
+
+// formatFlag reads a "--format text|json" flag at args[*i], reporting
+// whether it was one and whether it asks for JSON
+func formatFlag(args []string, i *int) (isFlag, asJSON bool, err error) {
+	value, ok, err := flagValue(args, i, "--format")
+	if err != nil {
+		return false, false, err
+	}
+	if !ok {
+		return false, false, nil
+	}
+	switch value {
+	case "json":
+		return true, true, nil
+	case "text":
+		return true, false, nil
+	}
+	return false, false, errorf(exitUsage, "Invalid --format value \"%s\" (use text or json)", value)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return errorf(exitEnvironment, "Failed to encode JSON: %v", err)
+	}
+	return nil
+}
+
+// sortDocuments sorts docs in the given order: "number", "title"
+// (case-insensitive, then number), "state" (lifecycle order, then
+// number), or "updated" (most recent first, then number)
+func sortDocuments(docs []*Document, order string) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		switch order {
+		case "title":
+			titleA, titleB := strings.ToLower(displayTitle(a.Title)), strings.ToLower(displayTitle(b.Title))
+			if titleA != titleB {
+				return titleA < titleB
+			}
+		case "state":
+			rankA, rankB := stateRank(a.State), stateRank(b.State)
+			if rankA != rankB {
+				return rankA < rankB
+			}
+		case "updated":
+			if a.Updated != b.Updated {
+				return a.Updated > b.Updated
+			}
+		}
+		return docNumberLess(a.Number, b.Number)
+	})
+}
+
+// listCommand handles "list [--where expr]... [--state name]
+// [--author person] [--tag tag]... [--since date] [--updated-before date]
+// [--sort order] [--reverse] [--json]"
+func listCommand(args []string) error {
+	usage := "Usage: zdp list [--where expr]... [--state name] [--author person] [--tag tag]... [--since YYYY-MM-DD] [--updated-before YYYY-MM-DD] [--sort number|title|updated|state] [--reverse] [--format text|json]"
+	var filters []whereExpr
+	asJSON := false
+	order, reverse := "", false
+	date := func(flag, value string) (string, error) {
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", errorf(exitUsage, "Invalid %s value \"%s\" (use YYYY-MM-DD)", flag, value)
+		}
+		return value, nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--tag"); err != nil {
+			return err
+		} else if ok {
+			tag := value
+			filters = append(filters, func(doc *Document) bool { return hasTag(doc, tag) })
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--state"); err != nil {
+			return err
+		} else if ok {
+			if _, err := getStateDir(value); err != nil {
+				return errorf(exitUsage, "Unknown state \"%s\"; run zdp states", value)
+			}
+			state := normalizeState(value)
+			filters = append(filters, func(doc *Document) bool { return normalizeState(doc.State) == state })
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--author"); err != nil {
+			return err
+		} else if ok {
+			person := parsePerson(value)
+			filters = append(filters, func(doc *Document) bool { return person.matchesAny(doc.Authors) })
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--since"); err != nil {
+			return err
+		} else if ok {
+			since, err := date("--since", value)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, func(doc *Document) bool { return doc.Updated >= since })
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--updated-before"); err != nil {
+			return err
+		} else if ok {
+			before, err := date("--updated-before", value)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, func(doc *Document) bool { return doc.Updated != "" && doc.Updated < before })
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--where"); err != nil {
+			return err
+		} else if ok {
+			filter, err := parseWhere(value)
+			if err != nil {
+				return errorf(exitUsage, "Invalid --where expression %q: %v", value, err)
+			}
+			filters = append(filters, filter)
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--sort"); err != nil {
+			return err
+		} else if ok {
+			switch value {
+			case "number", "title", "updated", "state":
+				order = value
+			default:
+				return errorf(exitUsage, "Invalid --sort value \"%s\" (use number, title, updated, or state)", value)
+			}
+			continue
+		}
+		if args[i] == "--reverse" {
+			reverse = true
+			continue
+		}
+		if args[i] == "--json" {
+			asJSON = true
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+	if reverse && order == "" {
+		order = "number"
+	}
+
+	var matched []*Document
+	for _, doc := range scanDocuments() {
+		keep := true
+		for _, filter := range filters {
+			keep = keep && filter(doc)
+		}
+		if keep {
+			matched = append(matched, doc)
+		}
+	}
+	if order != "" {
+		sortDocuments(matched, order)
+		if reverse {
+			for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+				matched[i], matched[j] = matched[j], matched[i]
+			}
+		}
+	}
+
+	if asJSON {
+		listed := []listedDoc{}
+		for _, doc := range matched {
+			listed = append(listed, newListedDoc(doc))
+		}
+		return printJSON(listed)
+	}
+
+	// A chosen order is shown as a flat list, since grouping by state
+	// would undo it
+	if order != "" {
+		for _, doc := range matched {
+			updated := doc.Updated
+			if updated == "" {
+				updated = "-"
+			}
+			fmt.Fprintf(stdout, "%s  %-13s %-10s  %s\n", doc.Number, doc.State, updated, displayTitle(doc.Title))
+		}
+		return nil
+	}
+
+	// Same layout as the bare listing, grouped by directory state
+	byState := make(map[string][]string)
+	for _, doc := range matched {
+		state := dirToState[filepath.Base(filepath.Dir(doc.Path))]
+		byState[state] = append(byState[state], filepath.Base(doc.Path))
+	}
+	var stateNames []string
+	for state := range byState {
+		stateNames = append(stateNames, state)
+	}
+	sort.Strings(stateNames)
+
+	for _, state := range stateNames {
+		fmt.Fprintln(stdout, state)
+		for _, doc := range byState[state] {
+			fmt.Fprintf(stdout, " - %s\n", doc)
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+// diagnostic is a validation finding at a line of a document
+type diagnostic struct {
+	Line     int    `json:"line"`
+	Severity string `json:"severity"` // "error" or "warning"
+	Code     string `json:"code"`     // a validationRules code, or a policy id
+	Message  string `json:"message"`
+}
+
+// validationRule is a built-in validate check. Codes are stable across
+// releases, so findings can be suppressed by code; retired codes are
+// never reused.
+type validationRule struct {
+	Code    string
+	Summary string
+}
+
+// validationRules lists every built-in check, by code
+var validationRules = []validationRule{
+	{"ZDP001", "document has no YAML frontmatter"},
+	{"ZDP002", "frontmatter is not valid YAML"},
+	{"ZDP003", "document cannot be read"},
+	{"ZDP004", "file name cannot be checked out on Windows or macOS"},
+	{"ZDP005", "file name is not ASCII"},
+	{"ZDP006", "required frontmatter field is missing"},
+	{"ZDP007", "unknown state"},
+	{"ZDP008", "state does not match the document's directory"},
+	{"ZDP009", "created or updated is not a YYYY-MM-DD date"},
+	{"ZDP010", "updated date is before the created date"},
+	{"ZDP011", "number does not match the file name"},
+	{"ZDP012", "Under Review document has no champion"},
+	{"ZDP013", "estimate cannot be parsed"},
+	{"ZDP014", "Accepted or Active document has no estimate"},
+	{"ZDP015", "document is not listed in the index"},
+	{"ZDP016", "document type's template cannot be read"},
+	{"ZDP017", "unknown document type"},
+	{"ZDP018", "section required by the document type is missing"},
+	{"ZDP019", "supersedes or superseded-by names the document itself"},
+	{"ZDP020", "supersession names a document that does not exist"},
+	{"ZDP021", "supersession link is missing on the other document"},
+	{"ZDP022", "supersession link is contradicted by the other document"},
+	{"ZDP023", "document is superseded by several documents"},
+	{"ZDP024", "superseded document is not in the Superseded state"},
+	{"ZDP025", "supersession cycle"},
+	{"ZDP026", "Final document edited more than review.final-edit-limit times"},
+	{"ZDP027", "depends-on names the document itself or one that does not exist"},
+	{"ZDP028", "depends-on names a Rejected or Withdrawn document"},
+	{"ZDP029", "stability is not experimental, stable, or deprecated"},
+	{"ZDP030", "compat-impact is not none, minor, or breaking"},
+	{"ZDP031", "Accepted or Active feature proposal has no compat-impact"},
+	{"ZDP032", "wikilink names a document that does not exist"},
+	{"ZDP033", "short-id is malformed or used by another document"},
+	{"ZDP034", "blocked-by names the document itself or one that does not exist"},
+	{"ZDP035", "blocked-by names a Rejected or Withdrawn document"},
+}
+
+// validationRuleCodeRe matches the built-in rule codes, which policy ids
+// may not take
+var validationRuleCodeRe = regexp.MustCompile(`^ZDP\d{3}$`)
+
+// knownFindingCode reports whether code is a built-in rule or the id of
+// one of policies
+func knownFindingCode(code string, policies []policy) bool {
+	for _, r := range validationRules {
+		if r.Code == code {
+			return true
+		}
+	}
+	for _, p := range policies {
+		if p.ID == code {
+			return true
+		}
+	}
+	return false
+}
+
+// suppression hides findings with a code from validate, in the
+// documents matching Paths (all documents when empty), until an
+// optional expiry date
+type suppression struct {
+	Code   string
+	Paths  []string  // glob patterns, matched against the document path
+	Until  time.Time // zero for no expiry
+	Reason string
+}
+
+// parseSuppressions reads the validation.suppress list from .zdp.yaml
+func parseSuppressions(items []interface{}) ([]suppression, error) {
+	var list []suppression
+	for i, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("validation.suppress[%d]: expected a mapping with code", i)
+		}
+		code, _ := entry["code"].(string)
+		sup := suppression{Code: strings.TrimSpace(code)}
+		if sup.Code == "" {
+			return nil, fmt.Errorf("validation.suppress[%d]: code is required", i)
+		}
+		sup.Reason, _ = entry["reason"].(string)
+		if until, _ := entry["until"].(string); until != "" {
+			t, err := time.Parse("2006-01-02", until)
+			if err != nil {
+				return nil, fmt.Errorf("validation.suppress[%d]: until must be a YYYY-MM-DD date, found %q", i, until)
+			}
+			sup.Until = t
+		}
+		switch paths := entry["paths"].(type) {
+		case nil:
+		case string:
+			sup.Paths = []string{paths}
+		case []interface{}:
+			for _, p := range paths {
+				if s, ok := p.(string); ok {
+					sup.Paths = append(sup.Paths, s)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("validation.suppress[%d]: paths must be a glob or a list of globs", i)
+		}
+		for _, pattern := range sup.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("validation.suppress[%d]: invalid glob %q", i, pattern)
+			}
+		}
+		list = append(list, sup)
+	}
+	return list, nil
+}
+
+// expired reports whether the suppression's until date has passed
+func (sup suppression) expired(today time.Time) bool {
+	return !sup.Until.IsZero() && today.After(sup.Until)
+}
+
+// covers reports whether the suppression applies to a document path
+func (sup suppression) covers(docPath string) bool {
+	if len(sup.Paths) == 0 {
+		return true
+	}
+	docPath = filepath.ToSlash(filepath.Clean(docPath))
+	for _, pattern := range sup.Paths {
+		if ok, _ := filepath.Match(pattern, docPath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path.Base(docPath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineDisableRe matches a suppression comment in a document, such as
+// <!-- zdp:disable ZDP014, ZDP017 -->
+var inlineDisableRe = regexp.MustCompile(`<!--\s*zdp:disable\s+([^>]*?)\s*-->`)
+
+// inlineSuppressions returns the codes a document disables with
+// zdp:disable comments
+func inlineSuppressions(docPath string) []string {
+	var codes []string
+	scanLines(docPath, func(n int, line string) bool {
+		for _, m := range inlineDisableRe.FindAllStringSubmatch(line, -1) {
+			codes = append(codes, strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || r == ' ' })...)
+		}
+		return true
+	})
+	return codes
+}
+
+// filterSuppressed drops the findings a document disables inline or
+// that a current validation.suppress entry covers, returning the rest
+// and the number dropped
+func filterSuppressed(docPath string, diags []diagnostic) ([]diagnostic, int) {
+	if len(diags) == 0 {
+		return diags, 0
+	}
+	inline := inlineSuppressions(docPath)
+	today := time.Now()
+	var kept []diagnostic
+	for _, d := range diags {
+		hidden := containsString(inline, d.Code)
+		for _, sup := range config.Suppressions {
+			if sup.Code == d.Code && !sup.expired(today) && sup.covers(docPath) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			kept = append(kept, d)
+		}
+	}
+	return kept, len(diags) - len(kept)
+}
+
+// listValidationRules prints the built-in rule codes and the policy ids
+func listValidationRules() {
+	for _, r := range validationRules {
+		fmt.Fprintf(stdout, "%s  %s\n", r.Code, r.Summary)
+	}
+	for _, p := range config.Policies {
+		fmt.Fprintf(stdout, "%s  %s (policy)\n", p.ID, p.Message)
+	}
+}
+
+// validateDocument checks a document's frontmatter against its file name,
+// directory, and the index
+func validateDocument(docPath string) []diagnostic {
+	return validateDocumentWith(docPath, nil)
+}
+
+// validateDocumentWith is validateDocument against an index already
+// loaded, so bulk validation parses the index once; nil loads it
+func validateDocumentWith(docPath string, idx *Index) []diagnostic {
+	// Most checks read only the frontmatter; the body is loaded only for
+	// documents whose type requires sections
+	text, err := readFrontmatter(docPath)
+	if err != nil {
+		return []diagnostic{{Line: 1, Severity: "error", Code: "ZDP003", Message: err.Error()}}
+	}
+	if text == "" {
+		return []diagnostic{{Line: 1, Severity: "error", Code: "ZDP001", Message: "missing YAML frontmatter; run zdp add-headers"}}
+	}
+	metadata, err := parseYAML(text)
+	if err != nil {
+		line := 1
+		var yerr *yamlError
+		if errors.As(err, &yerr) {
+			line = yerr.Line
+		}
+		return []diagnostic{{Line: line, Severity: "error", Code: "ZDP002", Message: err.Error()}}
+	}
+
+	// fieldLine finds the line of a frontmatter key, or the header start
+	lines := strings.Split(text, "\n")
+	fieldLine := func(key string) int {
+		for i, line := range lines {
+			if strings.HasPrefix(line, key+":") {
+				return i + 1
+			}
+			if i > 0 && line == "---" {
+				break
+			}
+		}
+		return 1
+	}
+
+	var diags []diagnostic
+	if problem := filenamePortabilityProblem(filepath.Base(docPath)); problem != "" {
+		diags = append(diags, diagnostic{1, "error", "ZDP004", "file name " + problem})
+	} else if config.Slugs == "transliterate" && strings.IndexFunc(filepath.Base(docPath), func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		diags = append(diags, diagnostic{1, "warning", "ZDP005", "file name is not ASCII; rename it, or set new.slugs: keep in .zdp.yaml"})
+	}
+	for _, field := range coreFields {
+		if metadata[field] == "" && !(field == "author" && metadata["authors"] != "") {
+			diags = append(diags, diagnostic{fieldLine(field), "warning", "ZDP006", fmt.Sprintf("missing %s field", field)})
+		}
+	}
+
+	state := metadata["state"]
+	stateDir, err := getStateDir(state)
+	if state != "" && err != nil {
+		diags = append(diags, diagnostic{fieldLine("state"), "error", "ZDP007", fmt.Sprintf("unknown state \"%s\"", state)})
+	} else if dir := filepath.Base(filepath.Dir(docPath)); state != "" && dirToState[dir] != "" && dir != stateDir {
+		diags = append(diags, diagnostic{fieldLine("state"), "error", "ZDP008", fmt.Sprintf("state is %s but the document is in %s/", state, dir)})
+	}
+
+	dates := map[string]time.Time{}
+	for _, field := range []string{"created", "updated"} {
+		value := metadata[field]
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			diags = append(diags, diagnostic{fieldLine(field), "error", "ZDP009", fmt.Sprintf("malformed %s date \"%s\" (use YYYY-MM-DD)", field, value)})
+			continue
+		}
+		dates[field] = t
+	}
+	if created, ok := dates["created"]; ok {
+		if updated, ok := dates["updated"]; ok && updated.Before(created) {
+			diags = append(diags, diagnostic{fieldLine("updated"), "warning", "ZDP010", fmt.Sprintf("updated %s is before created %s", metadata["updated"], metadata["created"])})
+		}
+	}
+
+	if number := metadata["number"]; number != "" && hasNumberPrefix(filepath.Base(docPath)) && number != extractNumberFromFilename(filepath.Base(docPath)) {
+		diags = append(diags, diagnostic{fieldLine("number"), "error", "ZDP011", fmt.Sprintf("number %s does not match the file name", number)})
+	}
+
+	if normalizeState(state) == "under review" {
+		if champion := metadata["champion"]; champion == "" || strings.EqualFold(champion, "none") {
+			diags = append(diags, diagnostic{fieldLine("state"), "warning", "ZDP012", "Under Review document has no champion"})
+		}
+	}
+
+	if _, ok, err := parseEstimate(metadata["estimate"]); err != nil {
+		diags = append(diags, diagnostic{fieldLine("estimate"), "error", "ZDP013", err.Error()})
+	} else if !ok && (normalizeState(state) == "accepted" || normalizeState(state) == "active") {
+		diags = append(diags, diagnostic{fieldLine("state"), "warning", "ZDP014", fmt.Sprintf("%s document has no estimate", state)})
+	}
+
+	if err := checkCompatImpact(metadata, false); err != nil {
+		diags = append(diags, diagnostic{fieldLine("compat-impact"), "error", "ZDP030", err.Error()})
+	} else if metadata["compat-impact"] == "" && needsCompatImpact(metadata["type"]) && (normalizeState(state) == "accepted" || normalizeState(state) == "active") {
+		diags = append(diags, diagnostic{fieldLine("state"), "warning", "ZDP031", fmt.Sprintf("%s feature proposal has no compat-impact", state)})
+	}
+
+	if stability := metadata["stability"]; stability != "" && !containsString(stabilityLevels, strings.ToLower(stability)) {
+		diags = append(diags, diagnostic{fieldLine("stability"), "error", "ZDP029", fmt.Sprintf("unknown stability \"%s\" (use %s)", stability, strings.Join(stabilityLevels, ", "))})
+	}
+
+	if idx == nil {
+		if loaded, _, err := loadIndex(config.IndexFile); err == nil {
+			idx = &loaded
+		}
+	}
+	if idx != nil && metadata["number"] != "" && idx.Entry(metadata["number"]) == nil {
+		diags = append(diags, diagnostic{fieldLine("number"), "error", "ZDP015", "document is not listed in " + config.IndexFile})
+	}
+
+	// Custom policies from .zdp.yaml
+	if doc, err := docMetadataFromContent(docPath, text); err == nil {
+		for _, p := range config.Policies {
+			if (p.When == nil || p.When(doc)) && !p.Require(doc) {
+				diags = append(diags, diagnostic{fieldLine(p.Field), p.Severity, p.ID, p.Message})
+			}
+		}
+	}
+
+	// Sections required by the template of the declared type
+	if docType := metadata["type"]; docType != "" {
+		tmpl, err := loadTemplate(docType)
+		switch {
+		case err != nil:
+			diags = append(diags, diagnostic{fieldLine("type"), "error", "ZDP016", err.Error()})
+		case tmpl == nil && !containsString(builtinDocTypes, docType):
+			diags = append(diags, diagnostic{fieldLine("type"), "warning", "ZDP017", fmt.Sprintf("unknown document type \"%s\" (no %s)", docType, templatePath(docType))})
+		case tmpl != nil && len(tmpl.Required) > 0:
+			content, err := os.ReadFile(repoPath(docPath))
+			if err != nil {
+				diags = append(diags, diagnostic{1, "error", "ZDP003", err.Error()})
+				break
+			}
+			body := frontmatterRe.ReplaceAllString(string(content), "")
+			for _, section := range missingSections(body, tmpl.Required) {
+				diags = append(diags, diagnostic{fieldLine("type"), "error", "ZDP018", fmt.Sprintf("missing section \"%s\" required for %s documents", section, docType)})
+			}
+		}
+	}
+
+	return diags
+}
+
+// validateOptions are the flags of "validate"
+type validateOptions struct {
+	json           bool // print the findings as JSON
+	writeBaseline  bool // record the findings in validationBaselinePath
+	ignoreBaseline bool // report baselined findings as well
+}
+
+// validateCommand parses the arguments of "validate [<doc>...]
+// [--format text|json] [--write-baseline | --no-baseline]" and
+// "validate --rules"
+func validateCommand(ctx context.Context, args []string) error {
+	if len(args) == 1 && args[0] == "--rules" {
+		listValidationRules()
+		return nil
+	}
+
+	usage := "Usage: zdp validate [<doc>...] [--format text|json] [--write-baseline | --no-baseline] | zdp validate --rules"
+	var paths []string
+	var opts validateOptions
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			opts.json = json
+			continue
+		}
+		arg := args[i]
+		switch arg {
+		case "--write-baseline":
+			opts.writeBaseline = true
+			continue
+		case "--no-baseline":
+			opts.ignoreBaseline = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return errorf(exitUsage, "%s", usage)
+		}
+		if _, err := os.Stat(repoPath(arg)); err == nil {
+			paths = append(paths, arg)
+			continue
+		}
+		doc, err := findDocument(arg)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		paths = append(paths, doc.Path)
+	}
+	if opts.writeBaseline && (opts.ignoreBaseline || len(paths) > 0) {
+		return errorf(exitUsage, "--write-baseline checks every document and can't be combined with documents or --no-baseline")
+	}
+	if len(paths) == 0 {
+		docs := scanDocuments()
+		sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+		for _, doc := range docs {
+			paths = append(paths, doc.Path)
+		}
+	}
+
+	return validate(ctx, paths, opts)
+}
+
+// validationBaselinePath records the findings accepted when validation
+// was adopted; validate reports only findings that are not in it
+const validationBaselinePath = ".zdp/baseline.json"
+
+// baselineFinding is a finding in the baseline. Lines are left out so
+// edits elsewhere in a document don't make old findings look new.
+type baselineFinding struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validationBaseline is the content of validationBaselinePath
+type validationBaseline struct {
+	Created  string            `json:"created"`
+	Findings []baselineFinding `json:"findings"`
+}
+
+// newBaselineFinding returns the baseline form of a finding
+func newBaselineFinding(f validationFinding) baselineFinding {
+	return baselineFinding{Path: filepath.ToSlash(filepath.Clean(f.Path)), Code: f.Code, Message: f.Message}
+}
+
+// loadValidationBaseline reads the baseline, returning nil when there
+// is none
+func loadValidationBaseline() (*validationBaseline, error) {
+	data, err := os.ReadFile(repoPath(validationBaselinePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errorf(exitEnvironment, "Failed to read %s: %v", validationBaselinePath, err)
+	}
+	var baseline validationBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, errorf(exitEnvironment, "Invalid %s: %v", validationBaselinePath, err)
+	}
+	return &baseline, nil
+}
+
+// writeValidationBaseline records findings as the baseline
+func writeValidationBaseline(findings []validationFinding) error {
+	baseline := validationBaseline{Created: time.Now().Format("2006-01-02"), Findings: []baselineFinding{}}
+	for _, f := range findings {
+		baseline.Findings = append(baseline.Findings, newBaselineFinding(f))
+	}
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to encode baseline: %v", err)
+	}
+	if err := os.MkdirAll(repoPath(filepath.Dir(validationBaselinePath)), 0755); err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	if err := os.WriteFile(repoPath(validationBaselinePath), append(data, '\n'), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", validationBaselinePath, err)
+	}
+	opResult.recordWrite(validationBaselinePath)
+	return nil
+}
+
+// subtractBaseline removes the findings recorded in the baseline,
+// returning the new ones, the number removed, and the number of
+// baseline entries that no longer occur in the checked documents. A
+// finding recorded once hides one occurrence, not every repeat.
+func subtractBaseline(findings []validationFinding, baseline *validationBaseline, paths []string) ([]validationFinding, int, int) {
+	remaining := make(map[baselineFinding]int)
+	for _, b := range baseline.Findings {
+		remaining[b]++
+	}
+	var fresh []validationFinding
+	for _, f := range findings {
+		key := newBaselineFinding(f)
+		if remaining[key] > 0 {
+			remaining[key]--
+			continue
+		}
+		fresh = append(fresh, f)
+	}
+
+	checked := make(map[string]bool)
+	for _, path := range paths {
+		checked[filepath.ToSlash(filepath.Clean(path))] = true
+	}
+	fixed := 0
+	for key, n := range remaining {
+		if checked[key.Path] {
+			fixed += n
+		}
+	}
+	return fresh, len(findings) - len(fresh), fixed
+}
+
+// validationFinding is one finding in the JSON output of validate
+type validationFinding struct {
+	Path string `json:"path"`
+	diagnostic
+}
+
+// validate prints the findings for each document, as text or JSON.
+// Findings recorded in the baseline are left out unless
+// opts.ignoreBaseline is set. Errors fail the command; warnings fail it
+// only under --strict.
+func validate(ctx context.Context, paths []string, opts validateOptions) error {
+	// Expired suppressions stop hiding findings; say so, so the team
+	// knows why they came back
+	today := time.Now()
+	for _, sup := range config.Suppressions {
+		if sup.expired(today) {
+			fmt.Fprintf(os.Stderr, "⚠ Suppression of %s expired on %s\n", sup.Code, sup.Until.Format("2006-01-02"))
+			warn("Suppression of %s expired on %s", sup.Code, sup.Until.Format("2006-01-02"))
+		}
+	}
+
+	findings, suppressed, err := collectFindings(ctx, paths)
+	if err != nil {
+		return abortOperation(nil, err, "nothing was validated")
+	}
+	if opts.writeBaseline {
+		if err := writeValidationBaseline(findings); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Recorded %d finding(s) in %d document(s) in %s\n", len(findings), len(paths), validationBaselinePath)
+		return nil
+	}
+	baselined, fixed := 0, 0
+	if baseline, err := loadValidationBaseline(); err != nil {
+		return err
+	} else if baseline != nil && !opts.ignoreBaseline {
+		findings, baselined, fixed = subtractBaseline(findings, baseline, paths)
+		if fixed > 0 {
+			fmt.Fprintf(os.Stderr, "⚠ %d baseline finding(s) no longer occur; run zdp validate --write-baseline to drop them\n", fixed)
+		}
+	}
+
+	errs, warns := 0, 0
+	for _, f := range findings {
+		if !opts.json {
+			fmt.Fprintf(stdout, "%s:%d: %s: [%s] %s\n", f.Path, f.Line, f.Severity, f.Code, f.Message)
+		}
+		if f.Severity == "error" {
+			errs++
+		} else {
+			warns++
+			warn("%s:%d: [%s] %s", f.Path, f.Line, f.Code, f.Message)
+		}
+	}
+
+	if opts.json {
+		if err := printJSON(map[string]interface{}{
+			"documents":  len(paths),
+			"errors":     errs,
+			"warnings":   warns,
+			"suppressed": suppressed,
+			"baselined":  baselined,
+			"findings":   findings,
+		}); err != nil {
+			return err
+		}
+		if errs > 0 {
+			return errorf(exitFindings, "Validation failed")
+		}
+		return nil
+	}
+	var hidden []string
+	if suppressed > 0 {
+		hidden = append(hidden, fmt.Sprintf("%d suppressed", suppressed))
+	}
+	if baselined > 0 {
+		hidden = append(hidden, fmt.Sprintf("%d in baseline", baselined))
+	}
+	note := ""
+	if len(hidden) > 0 {
+		note = " (" + strings.Join(hidden, ", ") + ")"
+	}
+	if errs == 0 && warns == 0 {
+		fmt.Fprintf(stdout, "%d document(s) valid%s\n", len(paths), note)
+		return nil
+	}
+	fmt.Fprintf(stdout, "\n%d error(s), %d warning(s) in %d document(s)%s\n", errs, warns, len(paths), note)
+	if errs > 0 {
+		return errorf(exitFindings, "Validation failed")
+	}
+	return nil
+}
+
+// collectFindings runs every check on the documents at paths and drops
+// the suppressed findings, returning the rest and the number dropped. It
+// stops with ctx's error when ctx is cancelled.
+func collectFindings(ctx context.Context, paths []string) ([]validationFinding, int, error) {
+	var idx *Index
+	if loaded, _, err := loadIndex(config.IndexFile); err == nil {
+		idx = &loaded
+	}
+
+	docs := scanDocuments()
+	chains := supersessionDiagnostics(docs)
+	deps := dependencyDiagnostics(docs)
+
+	// Churn needs each document's git history, so only the checked ones
+	checked := make(map[string]bool)
+	for _, path := range paths {
+		checked[filepath.Clean(path)] = true
+	}
+	var churned []*Document
+	for _, doc := range docs {
+		if checked[filepath.Clean(doc.Path)] {
+			churned = append(churned, doc)
+		}
+	}
+	churn := finalChurnDiagnostics(churned)
+	wikilinks := wikilinkDiagnostics(churned, docs)
+	shortIDs := shortIDDiagnostics(docs)
+
+	suppressed := 0
+	findings := []validationFinding{}
+	prog := newProgress("Validating", len(paths))
+	defer prog.finish()
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		prog.step(filepath.Base(path))
+		found := append(validateDocumentWith(path, idx), chains[filepath.Clean(path)]...)
+		found = append(found, deps[filepath.Clean(path)]...)
+		found = append(found, wikilinks[filepath.Clean(path)]...)
+		found = append(found, shortIDs[filepath.Clean(path)]...)
+		found, hidden := filterSuppressed(path, append(found, churn[filepath.Clean(path)]...))
+		suppressed += hidden
+		for _, d := range found {
+			findings = append(findings, validationFinding{path, d})
+		}
+	}
+
+	return findings, suppressed, nil
+}
+
+// simulationIssue is a document the proposed configuration would break
+type simulationIssue struct {
+	Number  string `json:"number"`
+	Path    string `json:"path"`
+	Kind    string `json:"kind"` // stranded, misplaced, or transition
+	Message string `json:"message"`
+}
+
+// simulation compares the corpus under the current configuration with
+// the corpus under a proposed one
+type simulation struct {
+	Config      string              `json:"config"`
+	Documents   int                 `json:"documents"`
+	Issues      []simulationIssue   `json:"issues"`
+	NewFindings []validationFinding `json:"new_findings"`
+	Resolved    []validationFinding `json:"resolved_findings"`
+}
+
+// simulateConfig evaluates every document against the configuration in
+// path: documents whose state or directory it no longer has, allowed
+// transitions it drops, and the validate findings it adds or removes.
+// The current configuration is restored afterwards.
+func simulateConfig(ctx context.Context, path string) (simulation, error) {
+	if _, err := os.Stat(repoPath(path)); err != nil {
+		return simulation{}, errorf(exitUsage, "%v", err)
+	}
+	proposed, err := loadConfig(path)
+	if err != nil {
+		return simulation{}, errorf(exitUsage, "Invalid %s: %v", path, err)
+	}
+
+	current := config
+	defer setConfig(current)
+
+	docs := scanDocuments()
+	var paths []string
+	for _, doc := range docs {
+		paths = append(paths, doc.Path)
+	}
+	before, _, err := collectFindings(ctx, paths)
+	if err != nil {
+		return simulation{}, abortOperation(nil, err, "nothing was simulated")
+	}
+	next := make(map[string][]string)
+	for _, doc := range docs {
+		next[doc.Path], _ = allowedTransitions(doc.State)
+	}
+
+	setConfig(proposed)
+	sim := simulation{Config: path, Documents: len(docs), Issues: []simulationIssue{}}
+	issue := func(doc *Document, kind, format string, args ...interface{}) {
+		sim.Issues = append(sim.Issues, simulationIssue{doc.Number, doc.Path, kind, fmt.Sprintf(format, args...)})
+	}
+	var remaining []string
+	for _, doc := range docs {
+		dir := filepath.Base(filepath.Dir(doc.Path))
+		stateDir, err := getStateDir(doc.State)
+		switch {
+		case err != nil:
+			issue(doc, "stranded", "state \"%s\" does not exist in the new configuration", doc.State)
+		case dirToState[dir] == "":
+			issue(doc, "misplaced", "%s/ is not a state directory in the new configuration; %s documents belong in %s/", dir, doc.State, stateDir)
+		case dir != stateDir:
+			issue(doc, "misplaced", "%s documents move from %s/ to %s/", doc.State, dir, stateDir)
+		}
+		var lost []string
+		for _, target := range next[doc.Path] {
+			if _, err := getStateDir(target); err != nil {
+				lost = append(lost, target)
+			}
+		}
+		if err == nil && len(lost) > 0 {
+			issue(doc, "transition", "%s can no longer move to %s, which the new configuration doesn't have", doc.State, strings.Join(lost, ", "))
+		}
+		if dirToState[dir] != "" {
+			remaining = append(remaining, doc.Path)
+		}
+	}
+	after, _, err := collectFindings(ctx, remaining)
+	if err != nil {
+		return simulation{}, abortOperation(nil, err, "nothing was simulated")
+	}
+
+	// Findings are matched by path, code, and message, like the baseline
+	key := func(f validationFinding) string { return f.Path + "\x00" + f.Code + "\x00" + f.Message }
+	count := make(map[string]int)
+	for _, f := range before {
+		count[key(f)]++
+	}
+	sim.NewFindings = []validationFinding{}
+	for _, f := range after {
+		if count[key(f)] > 0 {
+			count[key(f)]--
+			continue
+		}
+		sim.NewFindings = append(sim.NewFindings, f)
+	}
+	sim.Resolved = []validationFinding{}
+	for _, f := range before {
+		if count[key(f)] > 0 && containsString(remaining, f.Path) {
+			count[key(f)]--
+			sim.Resolved = append(sim.Resolved, f)
+		}
+	}
+	return sim, nil
+}
+
+// simulateCommand handles "simulate --config file [--format text|json]".
+// It fails when a document would be stranded or misplaced, or a new
+// error found.
+func simulateCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp simulate --config <file> [--format text|json]"
+	path := ""
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--config"); err != nil {
+			return err
+		} else if ok {
+			path = value
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+	if path == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	sim, err := simulateConfig(ctx, path)
+	if err != nil {
+		return err
+	}
+	blocking := 0
+	for _, is := range sim.Issues {
+		if is.Kind != "transition" {
+			blocking++
+		}
+	}
+	newErrors := 0
+	for _, f := range sim.NewFindings {
+		if f.Severity == "error" {
+			newErrors++
+		}
+	}
+
+	if asJSON {
+		if err := printJSON(sim); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(stdout, "Simulating %s against %d document(s)\n", path, sim.Documents)
+		headings := map[string]string{
+			"stranded":   "Stranded (state no longer exists)",
+			"misplaced":  "Misplaced (would need moving)",
+			"transition": "Lost transitions",
+		}
+		for _, kind := range []string{"stranded", "misplaced", "transition"} {
+			printed := false
+			for _, is := range sim.Issues {
+				if is.Kind != kind {
+					continue
+				}
+				if !printed {
+					fmt.Fprintf(stdout, "\n%s:\n", headings[kind])
+					printed = true
+				}
+				fmt.Fprintf(stdout, "  %s  %s: %s\n", is.Number, is.Path, is.Message)
+			}
+		}
+		if len(sim.NewFindings) > 0 {
+			fmt.Fprintln(stdout, "\nNew validate findings:")
+			for _, f := range sim.NewFindings {
+				fmt.Fprintf(stdout, "  %s:%d: %s: [%s] %s\n", f.Path, f.Line, f.Severity, f.Code, f.Message)
+			}
+		}
+		if len(sim.Resolved) > 0 {
+			fmt.Fprintf(stdout, "\n%d current finding(s) would no longer be reported\n", len(sim.Resolved))
+		}
+		if len(sim.Issues) == 0 && len(sim.NewFindings) == 0 {
+			fmt.Fprintln(stdout, "\nNo document is affected")
+		}
+	}
+
+	if blocking > 0 || newErrors > 0 {
+		return errorf(exitFindings, "%d document(s) stranded or misplaced, %d new error(s) under %s", blocking, newErrors, path)
+	}
+	return nil
+}
+
+// ideRequest is one line of input to "ide --stdio"
+type ideRequest struct {
+	ID     interface{} `json:"id"`
+	Method string      `json:"method"`
+	Params struct {
+		Path   string `json:"path"`
+		State  string `json:"state"`
+		Reason string `json:"reason"`
+	} `json:"params"`
+}
+
+// ideResponse is one line of output from "ide --stdio"
+type ideResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *ideError   `json:"error,omitempty"`
+}
+
+// ideError reports a failed request; Code is the zdp exit status the
+// equivalent command would have returned
+type ideError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ideCommand serves the editor protocol: one JSON request per line on
+// stdin, one JSON response per line on stdout. Methods are metadata,
+// transitions, diagnostics, transition, and shutdown; every request but
+// shutdown takes params.path, and transition also takes params.state.
+func ideCommand(args []string) error {
+	if len(args) != 1 || args[0] != "--stdio" {
+		return errorf(exitUsage, "Usage: zdp ide --stdio")
+	}
+
+	out := json.NewEncoder(stdout)
+	out.SetEscapeHTML(false)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var req ideRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			out.Encode(ideResponse{Error: &ideError{Code: exitUsage, Message: "invalid request: " + err.Error()}})
+			continue
+		}
+		if req.Method == "shutdown" {
+			out.Encode(ideResponse{ID: req.ID, Result: "ok"})
+			return nil
+		}
+
+		result, err := ideHandle(req)
+		resp := ideResponse{ID: req.ID, Result: result, Error: err}
+		if err := out.Encode(resp); err != nil {
+			return errorf(exitEnvironment, "Failed to write response: %v", err)
+		}
+	}
+	return nil
+}
+
+// ideHandle runs one request
+func ideHandle(req ideRequest) (interface{}, *ideError) {
+	path := req.Params.Path
+	if path == "" {
+		return nil, &ideError{Code: exitUsage, Message: "params.path is required"}
+	}
+
+	switch req.Method {
+	case "metadata":
+		doc, err := extractDocMetadata(path)
+		if err != nil {
+			return nil, &ideError{Code: exitFindings, Message: err.Error()}
+		}
+		return newListedDoc(doc), nil
+
+	case "transitions":
+		state, err := getCurrentState(path)
+		if err != nil {
+			return nil, &ideError{Code: exitFindings, Message: err.Error()}
+		}
+		return map[string]interface{}{"state": state, "transitions": nextStates(state)}, nil
+
+	case "diagnostics":
+		diags, _ := filterSuppressed(path, validateDocument(path))
+		if diags == nil {
+			diags = []diagnostic{}
+		}
+		return diags, nil
+
+	case "transition":
+		if req.Params.State == "" {
+			return nil, &ideError{Code: exitUsage, Message: "params.state is required"}
+		}
+		// Command output would corrupt the protocol stream, so it is
+		// captured and returned as the log
+		var err error
+		log := captureStdout(func() { err = transitionDocument(path, req.Params.State, transitionOptions{Reason: req.Params.Reason}) })
+		if err != nil {
+			return nil, &ideError{Code: ExitCode(err), Message: err.Error()}
+		}
+		stateDir, _ := getStateDir(req.Params.State)
+		return map[string]interface{}{
+			"path": filepath.ToSlash(filepath.Join(stateDir, filepath.Base(path))),
+			"log":  log,
+		}, nil
+	}
+
+	return nil, &ideError{Code: exitUsage, Message: fmt.Sprintf("unknown method \"%s\"", req.Method)}
+}
+
+// captureStdout runs fn with stdout redirected and returns what it
+// printed. A failure raised by fn still propagates, after stdout is
+// restored.
+func captureStdout(fn func()) (output string) {
+	var buf bytes.Buffer
+	saved := stdout
+	stdout = &buf
+	defer func() {
+		stdout = saved
+		output = buf.String()
+	}()
+	fn()
+	return
+}
+
+// watchedDoc is what watch remembers about a document between checks
+type watchedDoc struct {
+	State   string
+	Title   string
+	Path    string
+	Blocked bool // Accepted and waiting on blocked-by
+}
+
+// watchSnapshot records the state of every document by number
+func watchSnapshot() map[string]watchedDoc {
+	docs := scanDocuments()
+	snapshot := make(map[string]watchedDoc)
+	for _, doc := range docs {
+		snapshot[doc.Number] = watchedDoc{State: doc.State, Title: displayTitle(doc.Title), Path: doc.Path}
+	}
+	for _, b := range blockedDocuments(docs) {
+		if len(b.Waiting) > 0 {
+			w := snapshot[b.Number]
+			w.Blocked = true
+			snapshot[b.Number] = w
+		}
+	}
+	return snapshot
+}
+
+// gitHead returns the current commit hash, or "" outside a repository
+func gitHead() string {
+	output, err := repoCommand("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// desktopNotify raises a desktop notification with notify-send (Linux)
+// or osascript (macOS), falling back to the terminal bell
+func desktopNotify(title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = repoCommand("notify-send", "--app-name=zdp", title, body)
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(body), strconv.Quote(title))
+		cmd = repoCommand("osascript", "-e", script)
+	}
+	if cmd == nil {
+		fmt.Fprint(stdout, "\a")
+		return nil
+	}
+	if err := cmd.Run(); err != nil {
+		fmt.Fprint(stdout, "\a")
+		return err
+	}
+	return nil
+}
+
+// watchCommand parses the arguments of "watch [--interval d] [--pull] [--notify]"
+func watchCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp watch [--interval 30s] [--pull] [--notify]"
+	interval := 30 * time.Second
+	pull, notify := false, false
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--interval"); err != nil {
+			return err
+		} else if ok {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return errorf(exitUsage, "Invalid --interval value \"%s\"", value)
+			}
+			interval = d
+			continue
+		}
+		switch args[i] {
+		case "--pull":
+			pull = true
+		case "--notify":
+			notify = true
+		default:
+			return errorf(exitUsage, "%s", usage)
+		}
+	}
+
+	watch(ctx, interval, pull, notify)
+	return nil
+}
+
+// watch reports new documents and state transitions made by teammates
+// whenever HEAD moves (for example after a git pull), until interrupted.
+// With pull set it runs "git pull --ff-only" before each check; with
+// notify set each event also raises a desktop notification.
+func watch(ctx context.Context, interval time.Duration, pull, notify bool) {
+	me := currentIdentity()
+	head := gitHead()
+	known := watchSnapshot()
+	notifyFailed := false
+
+	fmt.Fprintf(stdout, "Watching %d documents (checking every %s, Ctrl-C to stop)\n", len(known), interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(stdout, "Stopped watching")
+			return
+		case <-ticker.C:
+		}
+
+		if pull {
+			if output, err := repoCommandContext(ctx, "git", "pull", "--ff-only", "--quiet").CombinedOutput(); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "⚠ git pull failed: %s\n", strings.TrimSpace(string(output)))
+			}
+		}
+		current := gitHead()
+		if current == head {
+			continue
+		}
+		head = current
+		latest := watchSnapshot()
+
+		var numbers []string
+		for number := range latest {
+			numbers = append(numbers, number)
+		}
+		sort.Strings(numbers)
+
+		for _, number := range numbers {
+			doc := latest[number]
+			before, existed := known[number]
+
+			// A document is unblocked by whoever accepted its last blocker
+			if before.Blocked && !doc.Blocked && normalizeState(doc.State) == "accepted" {
+				title := fmt.Sprintf("%s unblocked", number)
+				body := fmt.Sprintf("%s can become Active: zdp unblock --apply", doc.Title)
+				fmt.Fprintf(stdout, "[%s] %s - %s\n", time.Now().Format("15:04:05"), title, body)
+				if notify {
+					if err := desktopNotify("zdp: "+title, body); err != nil && !notifyFailed {
+						fmt.Fprintf(os.Stderr, "⚠ Desktop notifications unavailable: %v\n", err)
+						notifyFailed = true
+					}
+				}
+			}
+			if existed && normalizeState(before.State) == normalizeState(doc.State) {
+				continue
+			}
+
+			// Only teammates' changes are news
+			author := ""
+			if changes := getGitChanges(doc.Path); len(changes) > 0 {
+				author = changes[0].Author
+			}
+			if author != "" && me.matches(author) {
+				continue
+			}
+
+			var title, body string
+			if !existed {
+				title = fmt.Sprintf("New %s: %s", strings.ToLower(doc.State), number)
+				body = fmt.Sprintf("%s (by %s)", doc.Title, author)
+			} else {
+				title = fmt.Sprintf("%s: %s → %s", number, before.State, doc.State)
+				body = fmt.Sprintf("%s (by %s)", doc.Title, author)
+			}
+			fmt.Fprintf(stdout, "[%s] %s - %s\n", time.Now().Format("15:04:05"), title, body)
+			if notify {
+				if err := desktopNotify("zdp: "+title, body); err != nil && !notifyFailed {
+					fmt.Fprintf(os.Stderr, "⚠ Desktop notifications unavailable: %v\n", err)
+					notifyFailed = true
+				}
+			}
+		}
+		known = latest
+	}
+}
+
+// docAuthor is an author as shown in an exported document's header
+type docAuthor struct {
+	Name        string `json:"name"`
+	Affiliation string `json:"affiliation,omitempty"`
+}
+
+// docHeader is the standard header of an exported document: enough to
+// identify it and its status when read outside the repository
+type docHeader struct {
+	Number       string      `json:"number"`
+	Title        string      `json:"title"`
+	Authors      []docAuthor `json:"authors"`
+	State        string      `json:"state"`
+	Created      string      `json:"created"`
+	Updated      string      `json:"updated"`
+	Supersedes   []string    `json:"supersedes"`
+	SupersededBy []string    `json:"superseded_by"`
+}
+
+// newDocHeader builds a document's export header. Each author in the
+// authors or author field is shown by name, with the affiliation
+// authors.affiliations gives them.
+func newDocHeader(doc *Document) docHeader {
+	h := docHeader{
+		Number:       doc.Number,
+		Title:        displayTitle(doc.Title),
+		State:        doc.State,
+		Created:      doc.Created,
+		Updated:      doc.Updated,
+		Supersedes:   docRefs(doc.Fields["supersedes"]),
+		SupersededBy: docRefs(doc.Fields["superseded-by"]),
+		Authors:      []docAuthor{},
+	}
+	for _, person := range doc.Authors {
+		id := parsePerson(person)
+		author := docAuthor{Name: id.Name}
+		for name, affiliation := range config.Affiliations {
+			if id.matches(name) {
+				author.Affiliation = affiliation
+			}
+		}
+		h.Authors = append(h.Authors, author)
+	}
+	return h
+}
+
+// renderDocHeader renders an export header as the page's metadata list.
+// Supersession links point to the other documents' pages when they are
+// part of the site.
+func renderDocHeader(h docHeader, pages map[string]string) string {
+	var b strings.Builder
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "<dt>%s</dt><dd>%s</dd>", label, value)
+		}
+	}
+	refs := func(numbers []string) string {
+		var links []string
+		for _, n := range numbers {
+			if page, ok := pages[n]; ok {
+				links = append(links, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(page), n))
+			} else {
+				links = append(links, n)
+			}
+		}
+		return strings.Join(links, ", ")
+	}
+
+	var authors []string
+	for _, a := range h.Authors {
+		text := html.EscapeString(a.Name)
+		if a.Affiliation != "" {
+			text += ` <span class="affiliation">(` + html.EscapeString(a.Affiliation) + ")</span>"
+		}
+		authors = append(authors, text)
+	}
+	authorLabel := "Author"
+	if len(authors) > 1 {
+		authorLabel = "Authors"
+	}
+
+	b.WriteString(`<dl class="meta">`)
+	field("Number", html.EscapeString(h.Number))
+	field("Title", html.EscapeString(h.Title))
+	field(authorLabel, strings.Join(authors, "<br>"))
+	field("State", html.EscapeString(h.State))
+	field("Created", html.EscapeString(h.Created))
+	field("Updated", html.EscapeString(h.Updated))
+	field("Supersedes", refs(h.Supersedes))
+	field("Superseded by", refs(h.SupersededBy))
+	b.WriteString("</dl>")
+	return b.String()
+}
+
+// siteImage is a local image prepared for the exported site: the main
+// file, scaled down to the configured maximum, and narrower copies for
+// the srcset of its <img> tag
+type siteImage struct {
+	Width, Height int               // size of the main file; 0 when not decoded
+	Variants      []imageVariant    // narrower copies, narrowest first
+	Files         map[string][]byte // site path to content
+}
+
+// imageVariant is one srcset entry of a siteImage
+type imageVariant struct {
+	Path  string
+	Width int
+}
+
+// variantPath names the copy of a site image scaled to width
+func variantPath(sitePath string, width int) string {
+	ext := path.Ext(sitePath)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(sitePath, ext), width, ext)
+}
+
+// prepareSiteImage optimizes an image for the site according to
+// config.Images. PNG and JPEG files larger than the maximum size are
+// scaled down and re-encoded, keeping the original when that is smaller.
+// Narrower copies are made for each configured srcset width. Other
+// formats, and everything when optimization is off, are copied unchanged.
+func prepareSiteImage(data []byte, sitePath string) siteImage {
+	img := siteImage{Files: map[string][]byte{sitePath: data}}
+	opts := config.Images
+	if !opts.Optimize {
+		return img
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || (format != "png" && format != "jpeg") {
+		return img
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return img
+	}
+
+	encode := func(m image.Image) []byte {
+		var buf bytes.Buffer
+		if format == "jpeg" {
+			jpeg.Encode(&buf, m, &jpeg.Options{Quality: opts.Quality})
+		} else {
+			(&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, m)
+		}
+		return buf.Bytes()
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	scale := math.Min(1, math.Min(float64(opts.MaxWidth)/float64(w), float64(opts.MaxHeight)/float64(h)))
+	main := src
+	if scale < 1 {
+		w, h = max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+		main = scaleImage(src, w, h)
+	}
+	if encoded := encode(main); scale < 1 || len(encoded) < len(data) {
+		img.Files[sitePath] = encoded
+	}
+	img.Width, img.Height = w, h
+
+	for _, width := range opts.Widths {
+		if width >= w {
+			continue
+		}
+		p := variantPath(sitePath, width)
+		img.Files[p] = encode(scaleImage(main, width, max(1, h*width/w)))
+		img.Variants = append(img.Variants, imageVariant{Path: p, Width: width})
+	}
+	return img
+}
+
+// scaleImage shrinks src to w×h by averaging the source pixels each
+// target pixel covers, which keeps text in screenshots legible
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// image returns the prepared form of a local image source of the page,
+// preparing it on first use; nil when the file can't be read
+func (r *htmlRenderer) image(src, sitePath string) *siteImage {
+	file := filepath.Clean(filepath.Join(r.dir, filepath.FromSlash(src)))
+	if img, ok := r.images[file]; ok {
+		return img
+	}
+	var img *siteImage
+	if data, err := os.ReadFile(repoPath(file)); err == nil {
+		prepared := prepareSiteImage(data, sitePath)
+		img = &prepared
+	}
+	r.images[file] = img
+	return img
+}
+
+// siteMarker marks a directory written by exportSite, so it may be replaced
+const siteMarker = ".zdp-site"
+
+// siteStyle is the stylesheet shared by every page of the exported site
+const siteStyle = `body { font-family: system-ui, sans-serif; line-height: 1.55; color: #222; margin: 0; }
+header, main, footer { max-width: 52rem; margin: 0 auto; padding: 0 1rem; }
+header { padding-top: 1rem; border-bottom: 1px solid #ddd; }
+header a { color: inherit; text-decoration: none; font-weight: 600; }
+footer { color: #666; font-size: 0.85rem; border-top: 1px solid #ddd; margin-top: 2rem; padding-bottom: 1rem; }
+a { color: #0b5cad; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+code { font-family: ui-monospace, monospace; font-size: 0.9em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.3rem 0.6rem; text-align: left; }
+blockquote { margin-left: 0; padding-left: 1rem; border-left: 3px solid #ddd; color: #555; }
+dl.meta { display: grid; grid-template-columns: max-content auto; gap: 0.2rem 1rem; color: #555; }
+dl.meta dt { font-weight: 600; }
+dl.meta dd { margin: 0; }
+main img { max-width: 100%; height: auto; }
+.print-footer { display: none; }
+
+@page { margin: 2cm 2cm 2.5cm; @bottom-right { content: "Page " counter(page) " of " counter(pages); font: 9pt system-ui, sans-serif; color: #555; } }
+@media print {
+  body { font-size: 11pt; line-height: 1.4; color: #000; }
+  header, footer { display: none; }
+  header, main, footer { max-width: none; padding: 0; }
+  main h1:not(:first-of-type), main h2 { break-before: page; }
+  h1, h2, h3, h4 { break-after: avoid; }
+  pre, table, blockquote, img, dl.meta { break-inside: avoid; }
+  pre { white-space: pre-wrap; border: 1px solid #ddd; }
+  a { color: #000; }
+  main a[href^="http"]::after { content: " (" attr(href) ")"; font-size: 0.85em; }
+  .print-footer { display: block; position: fixed; bottom: -1.5cm; left: 0; font-size: 9pt; color: #555; }
+}
+`
+
+var imageRe = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+
+// htmlRenderer renders markdown as HTML for the exported site
+type htmlRenderer struct {
+	ids    map[string]int        // heading anchors already used on the page
+	assets map[string]string     // local image paths to their site paths
+	dir    string                // directory of the page's document
+	images map[string]*siteImage // prepared images by file, shared between pages
+}
+
+// newHTMLRenderer returns a renderer for one page of the document in dir.
+// images caches prepared images across pages; nil starts a new cache.
+func newHTMLRenderer(dir string, images map[string]*siteImage) *htmlRenderer {
+	if images == nil {
+		images = make(map[string]*siteImage)
+	}
+	return &htmlRenderer{ids: make(map[string]int), assets: make(map[string]string), dir: dir, images: images}
+}
+
+// headingID returns a unique anchor for a heading on the page, built the
+// way GitHub does: punctuation dropped, spaces turned into hyphens
+func (r *htmlRenderer) headingID(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteByte('-')
+		}
+	}
+	id := b.String()
+	if id == "" {
+		id = "section"
+	}
+	r.ids[id]++
+	if n := r.ids[id]; n > 1 {
+		id = fmt.Sprintf("%s-%d", id, n-1)
+	}
+	return id
+}
+
+// siteLink rewrites links between documents to their pages in the site
+func siteLink(target string) string {
+	if strings.Contains(target, "://") || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "mailto:") {
+		return target
+	}
+	path, fragment := target, ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		path, fragment = target[:i], target[i:]
+	}
+	if !strings.HasSuffix(path, ".md") {
+		return target
+	}
+	if filepath.Base(path) == filepath.Base(config.IndexFile) {
+		return "index.html" + fragment
+	}
+	return sitePageName(path) + fragment
+}
+
+// inline converts code, image, link, bold, and italic markup within a
+// line. Code spans are escaped separately so their contents are left alone.
+func (r *htmlRenderer) inline(text string) string {
+	var spans []string
+	text = inlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, m[1:len(m)-1])
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+	text = html.EscapeString(text)
+
+	text = imageRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := imageRe.FindStringSubmatch(m)
+		src := html.UnescapeString(sub[2])
+		if !strings.Contains(src, "://") && !strings.HasPrefix(src, "data:") {
+			if _, ok := r.assets[src]; !ok {
+				// Stored assets keep their hash names, so they never collide
+				r.assets[src] = storedAssetSitePath(src)
+				if r.assets[src] == "" {
+					r.assets[src] = "assets/" + filepath.Base(src)
+				}
+			}
+			attrs := ""
+			if img := r.image(src, r.assets[src]); img != nil && img.Width > 0 {
+				attrs = fmt.Sprintf(` width="%d" height="%d"`, img.Width, img.Height)
+				if len(img.Variants) > 0 {
+					var set []string
+					for _, v := range img.Variants {
+						set = append(set, fmt.Sprintf("%s %dw", v.Path, v.Width))
+					}
+					set = append(set, fmt.Sprintf("%s %dw", r.assets[src], img.Width))
+					attrs += fmt.Sprintf(` srcset="%s" sizes="(max-width: 52rem) 100vw, 50rem"`, html.EscapeString(strings.Join(set, ", ")))
+				}
+			}
+			return fmt.Sprintf(`<img src="%s" alt="%s"%s>`, html.EscapeString(r.assets[src]), sub[1], attrs)
+		}
+		return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(src), sub[1])
+	})
+	text = linkRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := linkRe.FindStringSubmatch(m)
+		href := siteLink(html.UnescapeString(sub[2]))
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), sub[1])
+	})
+	text = boldRe.ReplaceAllStringFunc(text, func(m string) string {
+		return "<strong>" + m[2:len(m)-2] + "</strong>"
+	})
+	text = italicRe.ReplaceAllStringFunc(text, func(m string) string {
+		return "<em>" + m[1:len(m)-1] + "</em>"
+	})
+
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), "<code>"+html.EscapeString(span)+"</code>", 1)
+	}
+	return text
+}
+
+// table renders a block of markdown table rows; the first row is the header
+func (r *htmlRenderer) table(rows []string) []string {
+	out := []string{"<table>"}
+	header := true
+	for _, row := range rows {
+		cols := splitTableRow(row)
+		if isTableSeparator(cols) {
+			continue
+		}
+		tag := "td"
+		if header {
+			tag = "th"
+			out = append(out, "<thead>")
+		}
+		var cells []string
+		for _, col := range cols {
+			cells = append(cells, "<"+tag+">"+r.inline(strings.ReplaceAll(col, `\|`, "|"))+"</"+tag+">")
+		}
+		out = append(out, "<tr>"+strings.Join(cells, "")+"</tr>")
+		if header {
+			out = append(out, "</thead>", "<tbody>")
+			header = false
+		}
+	}
+	return append(out, "</tbody>", "</table>")
+}
+
+// htmlList is a list left open while rendering nested items
+type htmlList struct {
+	indent int
+	tag    string
+}
+
+// render converts a markdown body into HTML
+func (r *htmlRenderer) render(body string) string {
+	var out, para []string
+	var lists []htmlList
+	lines := strings.Split(body, "\n")
+	afterBlank := false
+
+	flush := func() {
+		if len(para) > 0 {
+			out = append(out, "<p>"+strings.Join(para, "\n")+"</p>")
+			para = nil
+		}
+	}
+	closeLists := func(indent int) {
+		for len(lists) > 0 && lists[len(lists)-1].indent > indent {
+			out[len(out)-1] += "</li></" + lists[len(lists)-1].tag + ">"
+			lists = lists[:len(lists)-1]
+		}
+	}
+	item := func(indent int, tag, text string) {
+		flush()
+		closeLists(indent)
+		if n := len(lists); n > 0 && lists[n-1].indent == indent {
+			if lists[n-1].tag == tag {
+				out[len(out)-1] += "</li>"
+			} else {
+				out[len(out)-1] += "</li></" + lists[n-1].tag + ">"
+				lists = lists[:n-1]
+			}
+		}
+		if n := len(lists); n == 0 || lists[n-1].indent < indent {
+			out = append(out, "<"+tag+">")
+			lists = append(lists, htmlList{indent: indent, tag: tag})
+		}
+		out = append(out, "<li>"+r.inline(text))
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		blank := afterBlank
+		afterBlank = trimmed == ""
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			closeLists(-1)
+			var fence codeFence
+			fence.inCode(line)
+			class := ""
+			if lang := strings.Fields(strings.TrimLeft(trimmed, fence.marker[:1])); len(lang) > 0 {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(lang[0]))
+			}
+			var code []string
+			for i++; i < len(lines); i++ {
+				fence.inCode(lines[i])
+				if fence.marker == "" {
+					break
+				}
+				code = append(code, html.EscapeString(lines[i]))
+			}
+			out = append(out, "<pre><code"+class+">"+strings.Join(code, "\n")+"</code></pre>")
+
+		case strings.HasPrefix(trimmed, "|"):
+			flush()
+			closeLists(-1)
+			var rows []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, lines[i])
+			}
+			i--
+			out = append(out, r.table(rows)...)
+
+		case markdownHeadingRe.MatchString(line):
+			flush()
+			closeLists(-1)
+			m := markdownHeadingRe.FindStringSubmatch(line)
+			level := len(m[1])
+			out = append(out, fmt.Sprintf(`<h%d id="%s">%s</h%d>`, level, r.headingID(m[2]), r.inline(m[2]), level))
+
+		case ruleRe.MatchString(line):
+			flush()
+			closeLists(-1)
+			out = append(out, "<hr>")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			closeLists(-1)
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				text := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(text, " "))
+			}
+			i--
+			out = append(out, "<blockquote>", r.render(strings.Join(quoted, "\n")), "</blockquote>")
+
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			item(len(m[1]), "ul", m[2])
+
+		case orderedRe.MatchString(line):
+			m := orderedRe.FindStringSubmatch(line)
+			item(len(m[1]), "ol", m[3])
+
+		case trimmed == "":
+			flush()
+
+		case len(lists) > 0 && (!blank || line != strings.TrimLeft(line, " \t")):
+			// Continuation of the open list item
+			out[len(out)-1] += " " + r.inline(trimmed)
+
+		default:
+			closeLists(-1)
+			text := r.inline(trimmed)
+			if strings.HasSuffix(line, "  ") {
+				text += "<br>"
+			}
+			para = append(para, text)
+		}
+	}
+	flush()
+	closeLists(-1)
+	return strings.Join(out, "\n")
+}
+
+// sitePageName returns the file name of a document's page in the site
+func sitePageName(docPath string) string {
+	return strings.TrimSuffix(filepath.Base(docPath), ".md") + ".html"
+}
+
+// sitePage wraps rendered content in the page layout shared by the site
+func sitePage(siteTitle, title, content, footer, printFooter string) string {
+	pageTitle := siteTitle
+	if title != "" {
+		pageTitle = title + " · " + siteTitle
+	}
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<header><p><a href="index.html">%s</a></p></header>
+<main>
+%s
+</main>
+<footer><p>%s</p></footer>
+<div class="print-footer">%s</div>
+</body>
+</html>
+`, html.EscapeString(pageTitle), html.EscapeString(siteTitle), content, footer, html.EscapeString(printFooter))
+}
+
+var (
+	auditImgRe     = regexp.MustCompile(`<img\b[^>]*>`)
+	auditAltRe     = regexp.MustCompile(`\balt="([^"]*)"`)
+	auditSrcRe     = regexp.MustCompile(`\bsrc="([^"]*)"`)
+	auditHeadingRe = regexp.MustCompile(`(?s)<h([1-6])\b[^>]*>(.*?)</h[1-6]>`)
+	auditTheadRe   = regexp.MustCompile(`(?s)<table>\s*<thead>(.*?)</thead>`)
+	auditTagRe     = regexp.MustCompile(`<[^>]*>`)
+	cssCommentRe   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssRuleRe      = regexp.MustCompile(`([^{}]+)\{([^{}]*)\}`)
+	cssColorRe     = regexp.MustCompile(`(?i)(?:^|;)\s*(color|background-color|background)\s*:\s*(#[0-9a-f]{6}|#[0-9a-f]{3}|white|black)\b`)
+)
+
+// auditPage checks the rendered body of a page for accessibility
+// problems: images without alt text, skipped heading levels, and tables
+// whose header row is empty
+func auditPage(body string) []string {
+	var issues []string
+	for _, img := range auditImgRe.FindAllString(body, -1) {
+		if alt := auditAltRe.FindStringSubmatch(img); alt == nil || strings.TrimSpace(alt[1]) == "" {
+			src := ""
+			if m := auditSrcRe.FindStringSubmatch(img); m != nil {
+				src = " " + html.UnescapeString(m[1])
+			}
+			issues = append(issues, fmt.Sprintf("image%s has no alt text", src))
+		}
+	}
+
+	previous := 1
+	for _, m := range auditHeadingRe.FindAllStringSubmatch(body, -1) {
+		level, _ := strconv.Atoi(m[1])
+		if level > previous+1 {
+			text := html.UnescapeString(auditTagRe.ReplaceAllString(m[2], ""))
+			issues = append(issues, fmt.Sprintf("heading \"%s\" skips from h%d to h%d", text, previous, level))
+		}
+		previous = level
+	}
+
+	for i, m := range auditTheadRe.FindAllStringSubmatch(body, -1) {
+		if strings.TrimSpace(html.UnescapeString(auditTagRe.ReplaceAllString(m[1], ""))) == "" {
+			issues = append(issues, fmt.Sprintf("table %d has no header text", i+1))
+		}
+	}
+	return issues
+}
+
+// relativeLuminance is the WCAG luminance of a #rgb or #rrggbb color
+func relativeLuminance(color string) float64 {
+	switch strings.ToLower(color) {
+	case "white":
+		color = "#ffffff"
+	case "black":
+		color = "#000000"
+	}
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	var channels [3]float64
+	for i := range channels {
+		v, _ := strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		c := float64(v) / 255
+		if c <= 0.03928 {
+			channels[i] = c / 12.92
+		} else {
+			channels[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*channels[0] + 0.7152*channels[1] + 0.0722*channels[2]
+}
+
+// contrastRatio is the WCAG contrast ratio between two colors
+func contrastRatio(a, b string) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// auditStylesheet reports CSS rules whose text and background colors
+// contrast less than WCAG AA's 4.5:1. Colors a rule doesn't set come
+// from the body rule, else black on white.
+func auditStylesheet(css string) []string {
+	type colors struct{ selector, fg, bg string }
+	var rules []colors
+	base := colors{"body", "#000000", "#ffffff"}
+	for _, m := range cssRuleRe.FindAllStringSubmatch(cssCommentRe.ReplaceAllString(css, ""), -1) {
+		c := colors{selector: strings.Join(strings.Fields(m[1]), " ")}
+		for _, decl := range cssColorRe.FindAllStringSubmatch(m[2], -1) {
+			if strings.EqualFold(decl[1], "color") {
+				c.fg = decl[2]
+			} else {
+				c.bg = decl[2]
+			}
+		}
+		if c.selector == "body" {
+			if c.fg != "" {
+				base.fg = c.fg
+			}
+			if c.bg != "" {
+				base.bg = c.bg
+			}
+		}
+		if c.fg != "" || c.bg != "" {
+			rules = append(rules, c)
+		}
+	}
+
+	var issues []string
+	for _, rule := range rules {
+		fg, bg := rule.fg, rule.bg
+		if fg == "" {
+			fg = base.fg
+		}
+		if bg == "" {
+			bg = base.bg
+		}
+		if ratio := contrastRatio(fg, bg); ratio < 4.5 {
+			issues = append(issues, fmt.Sprintf("\"%s\" has contrast %.1f:1 (%s on %s); WCAG AA needs 4.5:1", rule.selector, ratio, fg, bg))
+		}
+	}
+	return issues
+}
+
+// exportSite renders every document and an index page into outDir as a
+// static HTML site. The site is built beside outDir and swapped in whole,
+// so pages of removed documents disappear and a server never sees a
+// half-written site. It returns the number of document pages written, or
+// ctx's error, leaving outDir untouched, when ctx is cancelled.
+func exportSite(ctx context.Context, outDir string) (int, error) {
+	if entries, err := os.ReadDir(repoPath(outDir)); err == nil && len(entries) > 0 {
+		if _, err := os.Stat(repoPath(filepath.Join(outDir, siteMarker))); err != nil {
+			return 0, fmt.Errorf("refusing to replace %s: it was not written by zdp export", outDir)
+		}
+	}
+
+	buildDir := outDir + ".tmp"
+	if err := os.RemoveAll(repoPath(buildDir)); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(repoPath(filepath.Join(buildDir, "assets")), 0755); err != nil {
+		return 0, err
+	}
+	write := func(name, content string) error {
+		path := filepath.Join(buildDir, name)
+		if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(repoPath(path), []byte(content), 0644)
+	}
+
+	siteTitle := "Design Documents"
+	if content, err := os.ReadFile(repoPath(config.IndexFile)); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(line, "# ") {
+				siteTitle = strings.TrimSpace(strings.TrimPrefix(line, "# "))
+				break
+			}
+		}
+	}
+	footer := "Generated by zdp on " + time.Now().Format("2006-01-02 15:04")
+	if head := gitHead(); head != "" {
+		footer += " from commit <code>" + head[:min(len(head), 12)] + "</code>"
+	}
+
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	images := make(map[string]*siteImage)
+	pages := make(map[string]string)
+	byNumber := make(map[string]*Document)
+	for _, doc := range docs {
+		pages[doc.Number] = sitePageName(doc.Path)
+		byNumber[doc.Number] = doc
+	}
+
+	prog := newProgress("Exporting", len(docs))
+	defer prog.finish()
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(repoPath(buildDir))
+			return 0, err
+		}
+		prog.step(filepath.Base(doc.Path))
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return 0, err
+		}
+		source, missing := resolveWikilinks(strings.TrimLeft(frontmatterRe.ReplaceAllString(string(content), ""), "\n"), filepath.Dir(doc.Path), byNumber)
+		for _, number := range missing {
+			prog.clear()
+			fmt.Fprintf(os.Stderr, "⚠ %s: [[%s]] names a document that does not exist\n", doc.Path, number)
+			warn("%s: [[%s]] names a document that does not exist", doc.Path, number)
+		}
+		r := newHTMLRenderer(filepath.Dir(doc.Path), images)
+		body := r.render(source)
+
+		meta := renderDocHeader(newDocHeader(doc), pages)
+
+		for _, issue := range auditPage(body) {
+			prog.clear()
+			fmt.Fprintf(os.Stderr, "⚠ %s: %s\n", doc.Path, issue)
+			warn("%s: %s", doc.Path, issue)
+		}
+
+		printFooter := fmt.Sprintf("ZDP %s · %s · %s", doc.Number, displayTitle(doc.Title), doc.State)
+		page := sitePage(siteTitle, doc.Number+" "+displayTitle(doc.Title), meta+"\n"+body, footer, printFooter)
+		if err := write(sitePageName(doc.Path), page); err != nil {
+			return 0, err
+		}
+
+		// Copy local images, optimized, next to the pages that use them
+		for src, dst := range r.assets {
+			img := r.image(src, dst)
+			if img == nil {
+				prog.clear()
+				fmt.Fprintf(os.Stderr, "⚠ %s: image %s not found\n", doc.Path, src)
+				continue
+			}
+			for p, data := range img.Files {
+				if err := write(filepath.FromSlash(p), string(data)); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	var rows []string
+	for _, doc := range docs {
+		rows = append(rows, fmt.Sprintf(`<tr><td>%s</td><td><a href="%s">%s</a></td><td>%s</td><td>%s</td></tr>`,
+			html.EscapeString(doc.Number), html.EscapeString(sitePageName(doc.Path)),
+			html.EscapeString(displayTitle(doc.Title)), html.EscapeString(doc.State), html.EscapeString(doc.Updated)))
+	}
+	index := fmt.Sprintf("<h1>%s</h1>\n<table>\n<thead><tr><th>Number</th><th>Title</th><th>State</th><th>Updated</th></tr></thead>\n<tbody>\n%s\n</tbody>\n</table>",
+		html.EscapeString(siteTitle), strings.Join(rows, "\n"))
+	if err := write("index.html", sitePage(siteTitle, "", index, footer, siteTitle)); err != nil {
+		return 0, err
+	}
+	style := siteStyle
+	if config.Stylesheet != "" {
+		theme, err := os.ReadFile(repoPath(config.Stylesheet))
+		if err != nil {
+			return 0, fmt.Errorf("export.stylesheet: %v", err)
+		}
+		style += "\n/* " + config.Stylesheet + " */\n" + string(theme)
+	}
+	for _, issue := range auditStylesheet(style) {
+		fmt.Fprintf(os.Stderr, "⚠ style.css: %s\n", issue)
+		warn("style.css: %s", issue)
+	}
+	if err := write("style.css", style); err != nil {
+		return 0, err
+	}
+	if err := write(siteMarker, ""); err != nil {
+		return 0, err
+	}
+
+	if err := os.RemoveAll(repoPath(outDir)); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(repoPath(buildDir), repoPath(outDir)); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// exportCommand parses the arguments of "export [--format name] [--out dir]"
+func exportCommand(ctx context.Context, args []string) error {
+	format, outDir := "html", ""
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			outDir = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--format"); err != nil {
+			return err
+		} else if ok {
+			format = value
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp export [--format html|<renderer>] [--out dir]")
+	}
+
+	if format == "html" {
+		if outDir == "" {
+			outDir = "site"
+		}
+		count, err := exportSite(ctx, outDir)
+		if err != nil && ctx.Err() != nil {
+			return abortOperation(nil, err, outDir+" left unchanged")
+		}
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to export site: %v", err)
+		}
+		opResult.recordWrite(outDir)
+		fmt.Fprintf(stdout, "Exported %d document(s) to %s/\n", count, outDir)
+		return nil
+	}
+
+	var renderer *exportRenderer
+	var names []string
+	for i, r := range config.Renderers {
+		names = append(names, r.Name)
+		if r.Name == format {
+			renderer = &config.Renderers[i]
+		}
+	}
+	if renderer == nil {
+		return errorf(exitUsage, "Unknown export format \"%s\" (available: %s)", format, strings.Join(append([]string{"html"}, names...), ", "))
+	}
+	if outDir == "" {
+		outDir = filepath.Join("export", format)
+	}
+	count, err := exportWithRenderer(ctx, *renderer, outDir)
+	if err != nil && ctx.Err() != nil {
+		return abortOperation(nil, err, "export incomplete")
+	}
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to export %s: %v", format, err)
+	}
+	fmt.Fprintf(stdout, "Exported %d document(s) to %s/ with %s\n", count, outDir, renderer.Command)
+	return nil
+}
+
+// renderRequest is the JSON a renderer receives on stdin, one document
+// per invocation
+type renderRequest struct {
+	Format   string    `json:"format"`
+	Document listedDoc `json:"document"`
+	Header   docHeader `json:"header"` // the standard header block to print
+	Body     string    `json:"body"`   // markdown without the frontmatter
+}
+
+// renderResponse is the JSON a renderer writes to stdout. Content is
+// base64-encoded, so renderers may return binary formats.
+type renderResponse struct {
+	Mime    string `json:"mime"`
+	Content []byte `json:"content"`
+}
+
+// runRenderer invokes an external renderer for one document
+func runRenderer(ctx context.Context, r exportRenderer, req renderRequest) (renderResponse, error) {
+	var resp renderResponse
+	input, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	argv, zylisp := scriptCommand(r.Command)
+	if zylisp {
+		if input, err = jsonToSexp(input); err != nil {
+			return resp, err
+		}
+	}
+	cmd := repoCommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return resp, fmt.Errorf("renderer %s: %v", r.Name, err)
+	}
+	if zylisp {
+		fields, err := parseSexpMap(string(output))
+		if err != nil {
+			return resp, fmt.Errorf("renderer %s: invalid response: %v", r.Name, err)
+		}
+		resp.Mime, resp.Content = fields["mime"], []byte(fields["content"])
+	} else if err := json.Unmarshal(output, &resp); err != nil {
+		return resp, fmt.Errorf("renderer %s: invalid response: %v", r.Name, err)
+	}
+	if resp.Mime == "" {
+		return resp, fmt.Errorf("renderer %s: response has no mime type", r.Name)
+	}
+	return resp, nil
+}
+
+// exportWithRenderer writes each document through an external renderer
+// into outDir, returning the number of files written. The file extension
+// comes from the renderer's configuration, else from the returned mime type.
+func exportWithRenderer(ctx context.Context, r exportRenderer, outDir string) (int, error) {
+	if err := os.MkdirAll(repoPath(outDir), 0755); err != nil {
+		return 0, err
+	}
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	byNumber := make(map[string]*Document)
+	for _, doc := range docs {
+		byNumber[doc.Number] = doc
+	}
+
+	prog := newProgress("Exporting", len(docs))
+	defer prog.finish()
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		prog.step(filepath.Base(doc.Path))
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return 0, err
+		}
+		body, missing := resolveWikilinks(strings.TrimLeft(frontmatterRe.ReplaceAllString(string(content), ""), "\n"), filepath.Dir(doc.Path), byNumber)
+		for _, number := range missing {
+			prog.clear()
+			fmt.Fprintf(os.Stderr, "⚠ %s: [[%s]] names a document that does not exist\n", doc.Path, number)
+			warn("%s: [[%s]] names a document that does not exist", doc.Path, number)
+		}
+		resp, err := runRenderer(ctx, r, renderRequest{
+			Format:   r.Name,
+			Document: newListedDoc(doc),
+			Header:   newDocHeader(doc),
+			Body:     body,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", doc.Path, err)
+		}
+
+		ext := r.Extension
+		if ext == "" {
+			if exts, _ := mime.ExtensionsByType(resp.Mime); len(exts) > 0 {
+				ext = exts[0]
+			} else {
+				ext = ".out"
+			}
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		path := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(doc.Path), ".md")+ext)
+		if err := os.WriteFile(repoPath(path), resp.Content, 0644); err != nil {
+			return 0, err
+		}
+		opResult.recordWrite(path)
+	}
+	return len(docs), nil
+}
+
+// healthReport summarizes the state of the repository. Score starts at
+// 100 and loses points for each problem, up to a cap per kind (see
+// healthPenalties).
+type healthReport struct {
+	Score         int      `json:"score"`
+	Documents     int      `json:"documents"`
+	IndexProblems []string `json:"index_problems"`
+	Errors        int      `json:"errors"`
+	Warnings      int      `json:"warnings"`
+	StaleDrafts   []string `json:"stale_drafts"`
+	SLABreaches   []string `json:"sla_breaches"`
+}
+
+// healthPenalties are the points each problem costs and the most each
+// kind can cost in total
+var healthPenalties = map[string]struct{ each, max int }{
+	"index":    {10, 30},
+	"errors":   {5, 30},
+	"warnings": {1, 10},
+	"stale":    {2, 15},
+	"sla":      {5, 15},
+}
+
+// repositoryHealth checks the index, validation findings (after
+// suppressions, baseline included), stale drafts, and documents kept
+// Under Review or Deferred past their limits
+func repositoryHealth(ctx context.Context) (healthReport, error) {
+	report := healthReport{IndexProblems: []string{}, StaleDrafts: []string{}, SLABreaches: []string{}}
+
+	if idx, _, err := loadIndex(config.IndexFile); err != nil {
+		report.IndexProblems = append(report.IndexProblems, fmt.Sprintf("%s cannot be parsed: %v", config.IndexFile, err))
+	} else {
+		for _, p := range diagnoseRepository(&idx) {
+			if p.Check == "Index" || p.Check == "Updated dates" {
+				report.IndexProblems = append(report.IndexProblems, p.Message)
+			}
+		}
+	}
+
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	report.Documents = len(docs)
+	var paths []string
+	for _, doc := range docs {
+		paths = append(paths, doc.Path)
+	}
+	findings, _, err := collectFindings(ctx, paths)
+	if err != nil {
+		return report, abortOperation(nil, err, "no health report produced")
+	}
+	for _, f := range findings {
+		if f.Severity == "error" {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+
+	reviewDir := states["under review"]
+	for _, doc := range docs {
+		switch normalizeState(doc.State) {
+		case "draft":
+			if days, ok := daysSince(doc.Updated); ok && days >= config.StaleDays {
+				report.StaleDrafts = append(report.StaleDrafts, fmt.Sprintf("%s not updated for %d days", doc.Number, days))
+			}
+		case "under review":
+			if config.ReviewSLA == 0 {
+				continue
+			}
+			since := doc.Updated
+			changes := getGitChanges(doc.Path)
+			if i := stateEntry(changes, reviewDir); i >= 0 {
+				since = changes[i].Date
+			}
+			if days, ok := daysSince(since); ok && days > config.ReviewSLA {
+				report.SLABreaches = append(report.SLABreaches, fmt.Sprintf("%s Under Review for %d days (limit %d)", doc.Number, days, config.ReviewSLA))
+			}
+		}
+	}
+	if _, known := states["deferred"]; known && config.MaxDeferral > 0 {
+		expired, err := deferredPastLimit()
+		if err != nil {
+			return report, err
+		}
+		for _, c := range expired {
+			report.SLABreaches = append(report.SLABreaches, fmt.Sprintf("%s Deferred for %d days (limit %d)", c.doc.Number, c.days, config.MaxDeferral))
+		}
+	}
+
+	report.Score = 100
+	for kind, n := range map[string]int{
+		"index":    len(report.IndexProblems),
+		"errors":   report.Errors,
+		"warnings": report.Warnings,
+		"stale":    len(report.StaleDrafts),
+		"sla":      len(report.SLABreaches),
+	} {
+		penalty := healthPenalties[kind]
+		report.Score -= min(n*penalty.each, penalty.max)
+	}
+	return report, nil
+}
+
+// healthColor is the badge color for a score
+func healthColor(score int) string {
+	switch {
+	case score >= 90:
+		return "#4c1"
+	case score >= 75:
+		return "#97ca00"
+	case score >= 50:
+		return "#dfb317"
+	}
+	return "#e05d44"
+}
+
+// healthBadge renders the score as a flat SVG badge for a README
+func healthBadge(score int) string {
+	const label = "design health"
+	value := fmt.Sprintf("%d%%", score)
+	// Verdana at 11px averages about 7px per character
+	labelWidth, valueWidth := len(label)*7+10, len(value)*7+10
+	width := labelWidth + valueWidth
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, valueWidth, label, value, healthColor(score), labelWidth/2, labelWidth+valueWidth/2)
+}
+
+// healthCommand parses the arguments of
+// "health [--format text|json] [--badge file.svg] [--min N]"
+func healthCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp health [--format text|json] [--badge file.svg] [--min N]"
+	asJSON := false
+	var badgePath string
+	minimum := 0
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--badge"); err != nil {
+			return err
+		} else if ok {
+			badgePath = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--min"); err != nil {
+			return err
+		} else if ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 100 {
+				return errorf(exitUsage, "Invalid --min value \"%s\" (use 0 to 100)", value)
+			}
+			minimum = n
+			continue
+		}
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return health(ctx, asJSON, badgePath, minimum)
+}
+
+// health prints the repository health report, optionally writing the
+// badge, and fails when the score is below minimum
+func health(ctx context.Context, asJSON bool, badgePath string, minimum int) error {
+	report, err := repositoryHealth(ctx)
+	if err != nil {
+		return err
+	}
+
+	if badgePath != "" {
+		if err := os.WriteFile(repoPath(badgePath), []byte(healthBadge(report.Score)), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to write badge: %v", err)
+		}
+		opResult.recordWrite(badgePath)
+	}
+
+	if asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(stdout, "Health: %d/100 (%d documents)\n\n", report.Score, report.Documents)
+		section := func(title string, items []string) {
+			fmt.Fprintf(stdout, "%s: %d\n", title, len(items))
+			for _, item := range items {
+				fmt.Fprintf(stdout, "  ⚠ %s\n", item)
+			}
+		}
+		section("Index problems", report.IndexProblems)
+		fmt.Fprintf(stdout, "Validation: %d error(s), %d warning(s)\n", report.Errors, report.Warnings)
+		section("Stale drafts", report.StaleDrafts)
+		if config.ReviewSLA == 0 && config.MaxDeferral == 0 {
+			fmt.Fprintln(stdout, "SLA breaches: not checked (set review.sla-days or deferral.max-days)")
+		} else {
+			section("SLA breaches", report.SLABreaches)
+		}
+		if badgePath != "" {
+			fmt.Fprintf(stdout, "\nWrote badge to %s\n", badgePath)
+		}
+	}
+
+	if report.Score < minimum {
+		return errorf(exitFindings, "Health score %d is below %d", report.Score, minimum)
+	}
+	return nil
+}
+
+// siteServer serves an exported site and regenerates it when HEAD moves
+type siteServer struct {
+	dir   string
+	token string // bearer token for /export; empty disables the endpoint
+	pull  bool   // run "git pull --ff-only" before checking HEAD
+
+	mu   sync.RWMutex // held for writing while the site is regenerated
+	head string       // commit the site was last exported from
+}
+
+// refresh regenerates the site if HEAD has moved since the last export,
+// or unconditionally when force is set. It reports whether it exported.
+func (s *siteServer) refresh(ctx context.Context, force bool) (bool, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pull {
+		if output, err := repoCommandContext(ctx, "git", "pull", "--ff-only", "--quiet").CombinedOutput(); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "⚠ git pull failed: %s\n", strings.TrimSpace(string(output)))
+		}
+	}
+	head := gitHead()
+	if !force && head == s.head {
+		return false, 0, nil
+	}
+	count, err := exportSite(ctx, s.dir)
+	if err != nil {
+		return false, 0, err
+	}
+	s.head = head
+	fmt.Fprintf(stdout, "[%s] Exported %d document(s) at %s\n", time.Now().Format("15:04:05"), count, head[:min(len(head), 12)])
+	return true, count, nil
+}
+
+// ServeHTTP serves site files with ETag and Last-Modified validators, so
+// clients revalidate cheaply with If-None-Match or If-Modified-Since
+func (s *siteServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/export":
+		s.handleExport(w, req)
+		return
+	case "/health", "/health.svg":
+		s.handleHealth(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := req.URL.Path
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if path.Base(name) == siteMarker {
+		http.NotFound(w, req)
+		return
+	}
+	file, err := http.Dir(s.dir).Open(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, req, name, info.ModTime(), bytes.NewReader(data))
+}
+
+// handleHealth serves the repository health report as JSON on
+// /health and as a badge on /health.svg, computed from the working tree
+// on each request
+func (s *siteServer) handleHealth(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The write lock keeps a pull in refresh from moving the tree
+	// mid-report, and keeps reports from recording warnings at once
+	s.mu.Lock()
+	report, err := repositoryHealth(req.Context())
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if req.URL.Path == "/health.svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		io.WriteString(w, healthBadge(report.Score))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>"
+// header. The scheme is matched without regard to case, as HTTP
+// requires; any other scheme, or a bare token, is refused.
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// handleExport regenerates the site on an authenticated POST /export.
+// Requests carry "Authorization: Bearer <token>"; "?force=1" exports even
+// when HEAD has not moved.
+func (s *siteServer) handleExport(w http.ResponseWriter, req *http.Request) {
+	if s.token == "" {
+		http.Error(w, "export endpoint disabled: set ZDP_EXPORT_TOKEN", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := bearerToken(req.Header.Get("Authorization"))
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="zdp"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	force := req.URL.Query().Get("force") != ""
+	exported, count, err := s.refresh(req.Context(), force)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("export failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.RLock()
+	head := s.head
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"head":      head,
+		"exported":  exported,
+		"documents": count,
+	})
+}
+
+// serveCommand parses the arguments of
+// "serve [--addr :8080] [--dir site] [--auto-export] [--interval d] [--pull]"
+func serveCommand(ctx context.Context, args []string) error {
+	usage := "Usage: zdp serve [--addr :8080] [--dir site] [--auto-export] [--interval 30s] [--pull]"
+	s := &siteServer{dir: "site", token: os.Getenv("ZDP_EXPORT_TOKEN")}
+	addr := ":8080"
+	interval := 30 * time.Second
+	auto := false
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--addr"); err != nil {
+			return err
+		} else if ok {
+			addr = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--dir"); err != nil {
+			return err
+		} else if ok {
+			s.dir = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--interval"); err != nil {
+			return err
+		} else if ok {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return errorf(exitUsage, "Invalid --interval value \"%s\"", value)
+			}
+			interval = d
+			continue
+		}
+		switch args[i] {
+		case "--auto-export":
+			auto = true
+		case "--pull":
+			s.pull = true
+		default:
+			return errorf(exitUsage, "%s", usage)
+		}
+	}
+
+	return serve(ctx, s, addr, interval, auto)
+}
+
+// serve exports the site, then serves it until interrupted. With auto set
+// HEAD is checked every interval and the site regenerated when it moves;
+// otherwise regeneration happens only through POST /export.
+func serve(ctx context.Context, s *siteServer, addr string, interval time.Duration, auto bool) error {
+	if _, _, err := s.refresh(ctx, true); err != nil && ctx.Err() != nil {
+		return abortOperation(nil, err, "nothing served")
+	} else if err != nil {
+		return errorf(exitEnvironment, "Failed to export site: %v", err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: s}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+
+	fmt.Fprintf(stdout, "Serving %s/ on %s (Ctrl-C to stop)\n", s.dir, addr)
+	if s.token == "" {
+		fmt.Fprintln(stdout, "POST /export is disabled; set ZDP_EXPORT_TOKEN to enable it")
+	}
+	if auto {
+		fmt.Fprintf(stdout, "Regenerating when HEAD changes (checking every %s)\n", interval)
+	}
+
+	var tick <-chan time.Time
+	if auto {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-errs:
+			return errorf(exitEnvironment, "Server failed: %v", err)
+		case <-ctx.Done():
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdown)
+			fmt.Fprintln(stdout, "Stopped serving")
+			return nil
+		case <-tick:
+			if _, _, err := s.refresh(ctx, false); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "⚠ Export failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// replaceScopes are the parts of a document that replace can edit
+var replaceScopes = []string{"body", "headings", "code"}
+
+// termPattern matches term literally; unless partial is set, a term that
+// starts or ends with a word character must not continue a longer word
+func termPattern(term string, partial bool) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(term)
+	if !partial {
+		wordRe := regexp.MustCompile(`^\w|\w$`)
+		if wordRe.MatchString(term[:1]) {
+			pattern = `\b` + pattern
+		}
+		if wordRe.MatchString(term[len(term)-1:]) {
+			pattern += `\b`
+		}
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// replaceSegments replaces term in a line, treating inline code spans as
+// code and the rest as prose. It returns the new line and the count.
+func replaceSegments(line string, term *regexp.Regexp, with string, prose, code bool) (string, int) {
+	var b strings.Builder
+	count, last := 0, 0
+	replace := func(text string, enabled bool) {
+		if enabled {
+			count += len(term.FindAllStringIndex(text, -1))
+			text = term.ReplaceAllLiteralString(text, with)
+		}
+		b.WriteString(text)
+	}
+	for _, loc := range inlineCodeRe.FindAllStringIndex(line, -1) {
+		replace(line[last:loc[0]], prose)
+		replace(line[loc[0]:loc[1]], code)
+		last = loc[1]
+	}
+	replace(line[last:], prose)
+	return b.String(), count
+}
+
+// replaceInBody replaces term in the selected scopes of a markdown body.
+// Fence delimiter lines are never edited, so info strings survive.
+func replaceInBody(body string, term *regexp.Regexp, with string, scopes map[string]bool) (string, int) {
+	lines := strings.Split(body, "\n")
+	var fence codeFence
+	total := 0
+
+	for i, line := range lines {
+		wasCode := fence.marker != ""
+		if fence.inCode(line) {
+			if wasCode && fence.marker != "" && scopes["code"] {
+				var n int
+				lines[i], n = replaceSegments(line, term, with, true, true)
+				total += n
+			}
+			continue
+		}
+		prose := scopes["body"]
+		if markdownHeadingRe.MatchString(line) {
+			prose = scopes["headings"]
+		}
+		var n int
+		lines[i], n = replaceSegments(line, term, with, prose, scopes["code"])
+		total += n
+	}
+	return strings.Join(lines, "\n"), total
+}
+
+// replaceCommand parses the arguments of
+// "replace --term old --with new [--scope body,headings,code] [--partial] [--apply]"
+func replaceCommand(args []string) error {
+	usage := "Usage: zdp replace --term <old> --with <new> [--scope body,headings,code] [--partial] [--apply]"
+	var term, with string
+	hasWith, partial, apply := false, false, false
+	scopes := map[string]bool{"body": true, "headings": true}
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--term"); err != nil {
+			return err
+		} else if ok {
+			term = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--with"); err != nil {
+			return err
+		} else if ok {
+			with, hasWith = value, true
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--scope"); err != nil {
+			return err
+		} else if ok {
+			scopes = make(map[string]bool)
+			for _, scope := range strings.Split(value, ",") {
+				scope = strings.TrimSpace(scope)
+				if !containsString(replaceScopes, scope) {
+					return errorf(exitUsage, "Unknown scope \"%s\" (use %s)", scope, strings.Join(replaceScopes, ", "))
+				}
+				scopes[scope] = true
+			}
+			continue
+		}
+		switch args[i] {
+		case "--partial":
+			partial = true
+		case "--apply":
+			apply = true
+		default:
+			return errorf(exitUsage, "%s", usage)
+		}
+	}
+	if term == "" || !hasWith {
+		return errorf(exitUsage, "%s", usage)
+	}
+
+	return replaceTerm(term, with, scopes, partial, apply)
+}
+
+// replaceTerm previews, and with apply writes, a replacement across all
+// documents. Frontmatter is never edited except to bump "updated" on
+// documents that change.
+func replaceTerm(term, with string, scopes map[string]bool, partial, apply bool) error {
+	pattern := termPattern(term, partial)
+	today := time.Now().Format("2006-01-02")
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+
+	files, total := 0, 0
+	for _, doc := range docs {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read file: %v", err)
+		}
+		header := frontmatterRe.FindString(string(content))
+		body := string(content)[len(header):]
+		replaced, count := replaceInBody(body, pattern, with, scopes)
+		if count == 0 {
+			continue
+		}
+		files++
+		total += count
+
+		// Preview the changed lines with their line numbers
+		offset := strings.Count(header, "\n")
+		before, after := strings.Split(body, "\n"), strings.Split(replaced, "\n")
+		fmt.Fprintf(stdout, "--- %s (%d)\n", doc.Path, count)
+		for i := range before {
+			if before[i] != after[i] {
+				fmt.Fprintf(stdout, "  %4d - %s\n", offset+i+1, before[i])
+				fmt.Fprintf(stdout, "  %4d + %s\n", offset+i+1, after[i])
+			}
+		}
+		fmt.Fprintln(stdout)
+
+		if !apply {
+			continue
+		}
+		updated := header + replaced
+		if header != "" {
+			if updated, err = setFrontmatterField(updated, "updated", today); err != nil {
+				return errorf(exitFindings, "%s: %v", doc.Path, err)
+			}
+		}
+		if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to update file: %v", err)
+		}
+		opResult.recordFieldChanges(doc.Path, string(content), updated)
+	}
+
+	switch {
+	case total == 0:
+		fmt.Fprintf(stdout, "No occurrences of \"%s\" found\n", term)
+	case apply:
+		fmt.Fprintf(stdout, "Replaced %d occurrence(s) in %d document(s)\n", total, files)
+	default:
+		fmt.Fprintf(stdout, "%d occurrence(s) in %d document(s); rerun with --apply to write them\n", total, files)
+	}
+	return nil
+}
+
+// termLines returns the line numbers of a body where term occurs in the
+// selected scopes
+func termLines(body string, term *regexp.Regexp, scopes map[string]bool) []int {
+	// Deleting every match changes exactly the lines that contain one
+	stripped, _ := replaceInBody(body, term, "", scopes)
+	before, after := strings.Split(body, "\n"), strings.Split(stripped, "\n")
+	var lines []int
+	for i := range before {
+		if before[i] != after[i] {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// termsCommand parses the arguments of "terms [--include-code]"
+func termsCommand(args []string) error {
+	scopes := map[string]bool{"body": true, "headings": true}
+	for _, arg := range args {
+		if arg != "--include-code" {
+			return errorf(exitUsage, "Usage: zdp terms [--include-code]")
+		}
+		scopes["code"] = true
+	}
+
+	return termDrift(scopes)
+}
+
+// termDrift reports documents still using terms renamed under
+// terminology.renames in .zdp.yaml, with the lines to clean up
+func termDrift(scopes map[string]bool) error {
+	if len(config.Renames) == 0 {
+		fmt.Fprintln(stdout, "No renamed terms configured (terminology.renames in .zdp.yaml)")
+		return nil
+	}
+	var terms []string
+	for term := range config.Renames {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+
+	total := 0
+	for _, term := range terms {
+		pattern := termPattern(term, false)
+		var found []string
+		count := 0
+		for _, doc := range docs {
+			content, err := os.ReadFile(repoPath(doc.Path))
+			if err != nil {
+				return errorf(exitEnvironment, "Failed to read file: %v", err)
+			}
+			header := frontmatterRe.FindString(string(content))
+			offset := strings.Count(header, "\n")
+
+			var lines []string
+			if pattern.MatchString(doc.Title) {
+				lines = append(lines, "title")
+			}
+			for _, n := range termLines(string(content)[len(header):], pattern, scopes) {
+				lines = append(lines, strconv.Itoa(offset+n))
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			count += len(lines)
+			found = append(found, fmt.Sprintf("  %s: %s", doc.Path, strings.Join(lines, ", ")))
+		}
+		if len(found) == 0 {
+			continue
+		}
+		total += count
+		fmt.Fprintf(stdout, "\"%s\" → \"%s\" (%d line(s) in %d document(s))\n", term, config.Renames[term], count, len(found))
+		for _, line := range found {
+			fmt.Fprintln(stdout, line)
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	if total == 0 {
+		fmt.Fprintf(stdout, "No renamed terms in use (%d checked)\n", len(terms))
+		return nil
+	}
+	return errorf(exitFindings, "%d line(s) still use renamed terms; see \"zdp replace\" to update them", total)
+}
+
+// sourceMarkerRe matches a marker naming the canonical source of the code
+// block after it: <!-- zdp:source ../zylisp/grammar.ebnf#expr -->
+var sourceMarkerRe = regexp.MustCompile(`^\s*<!--\s*zdp:source\s+(\S+)\s*-->\s*$`)
+
+// sourceQuote is a code block in a document copied from a file kept
+// elsewhere, found after a zdp:source marker
+type sourceQuote struct {
+	Line       int    // 1-based line of the marker
+	Source     string // the pointer, path#fragment
+	Start, End int    // the block's content, as 0-based lines [Start, End)
+	Problem    string // why the block can't be checked, if it can't
+}
+
+// findSourceQuotes finds the zdp:source markers in a document's lines and
+// the fenced code block each one points at
+func findSourceQuotes(lines []string) []sourceQuote {
+	var quotes []sourceQuote
+	for i := 0; i < len(lines); i++ {
+		m := sourceMarkerRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		q := sourceQuote{Line: i + 1, Source: m[1]}
+		j := i + 1
+		for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+			j++
+		}
+		trimmed := ""
+		if j < len(lines) {
+			trimmed = strings.TrimSpace(lines[j])
+		}
+		if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+			q.Problem = "no fenced code block follows the marker"
+			quotes = append(quotes, q)
+			continue
+		}
+		fence := trimmed[:3]
+		q.Start = j + 1
+		q.End = -1
+		for k := q.Start; k < len(lines); k++ {
+			if strings.HasPrefix(strings.TrimSpace(lines[k]), fence) {
+				q.End = k
+				break
+			}
+		}
+		if q.End < 0 {
+			q.Problem = "the code block after the marker is not closed"
+			quotes = append(quotes, q)
+			continue
+		}
+		quotes = append(quotes, q)
+		i = q.End
+	}
+	return quotes
+}
+
+// grammarRuleRe matches the start of a grammar rule definition, such as
+// "expr ::= ..." or "expr = ..."
+var grammarRuleRe = regexp.MustCompile(`^([A-Za-z_][\w-]*)\s*(::=|:=|=|:)`)
+
+// lineRangeRe matches a line fragment such as L10 or L10-L20
+var lineRangeRe = regexp.MustCompile(`^L(\d+)(?:-L?(\d+))?$`)
+
+// canonicalText reads the text a zdp:source pointer names, relative to
+// the repository root: a whole file, a range of lines (#L10-L20), or a
+// grammar rule (#expr), which runs to the next blank line or rule
+func canonicalText(source string) ([]string, error) {
+	path, fragment, _ := strings.Cut(source, "#")
+	content, err := os.ReadFile(repoPath(filepath.FromSlash(path)))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+
+	if fragment == "" {
+		return lines, nil
+	}
+	if m := lineRangeRe.FindStringSubmatch(fragment); m != nil {
+		from, _ := strconv.Atoi(m[1])
+		to := from
+		if m[2] != "" {
+			to, _ = strconv.Atoi(m[2])
+		}
+		if from < 1 || to < from || to > len(lines) {
+			return nil, fmt.Errorf("%s has %d line(s), so #%s is out of range", path, len(lines), fragment)
+		}
+		return lines[from-1 : to], nil
+	}
+	for i, line := range lines {
+		if m := grammarRuleRe.FindStringSubmatch(line); m == nil || m[1] != fragment {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "" && !grammarRuleRe.MatchString(lines[end]) {
+			end++
+		}
+		return lines[i:end], nil
+	}
+	return nil, fmt.Errorf("%s has no rule named %q", path, fragment)
+}
+
+// normalizeQuote drops trailing whitespace and surrounding blank lines,
+// which don't count as drift
+func normalizeQuote(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, strings.TrimRight(line, " \t"))
+	}
+	for len(out) > 0 && out[0] == "" {
+		out = out[1:]
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// verifyQuotesCommand parses the arguments of
+// "verify-quotes [<doc|number>...] [--fix]"
+func verifyQuotesCommand(args []string) error {
+	fix := false
+	var docs []*Document
+	for _, arg := range args {
+		if arg == "--fix" {
+			fix = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return errorf(exitUsage, "Usage: zdp verify-quotes [<doc|number>...] [--fix]")
+		}
+		doc, err := findDocument(arg)
+		if err != nil {
+			return errorf(exitUsage, "%v", err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		docs = scanDocuments()
+	}
+	return verifyQuotes(docs, fix)
+}
+
+// verifyQuotes compares every code block marked with zdp:source against
+// the file it was copied from, printing the drift as a diff. With fix,
+// drifted blocks are replaced with the canonical text.
+func verifyQuotes(docs []*Document, fix bool) error {
+	checked, drifted, broken, fixed := 0, 0, 0, 0
+	for _, doc := range docs {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		lines := strings.Split(string(content), "\n")
+		quotes := findSourceQuotes(lines)
+		changed := false
+
+		// Replacing a block shifts the lines after it by shift
+		shift := 0
+		for _, q := range quotes {
+			q.Start, q.End = q.Start+shift, q.End+shift
+			checked++
+			if q.Problem != "" {
+				fmt.Fprintf(stdout, "%s:%d: %s: %s\n", doc.Path, q.Line, q.Source, q.Problem)
+				broken++
+				continue
+			}
+			canonical, err := canonicalText(q.Source)
+			if err != nil {
+				fmt.Fprintf(stdout, "%s:%d: %s: %v\n", doc.Path, q.Line, q.Source, err)
+				broken++
+				continue
+			}
+			want, have := normalizeQuote(canonical), normalizeQuote(lines[q.Start:q.End])
+			if strings.Join(want, "\n") == strings.Join(have, "\n") {
+				continue
+			}
+			if fix {
+				lines = append(lines[:q.Start], append(append([]string{}, want...), lines[q.End:]...)...)
+				shift += len(want) - (q.End - q.Start)
+				changed = true
+				fixed++
+				fmt.Fprintf(stdout, "%s:%d: updated from %s\n", doc.Path, q.Line, q.Source)
+				continue
+			}
+			drifted++
+			fmt.Fprintf(stdout, "%s:%d: drifted from %s (- document, + source)\n", doc.Path, q.Line, q.Source)
+			for _, op := range diffLines(have, want) {
+				if op.Kind != ' ' {
+					fmt.Fprintf(stdout, "    %c %s\n", op.Kind, op.Text)
+				}
+			}
+		}
+
+		if changed {
+			updated := strings.Join(lines, "\n")
+			if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+				return errorf(exitEnvironment, "Failed to update %s: %v", doc.Path, err)
+			}
+			opResult.recordWrite(doc.Path)
+		}
+	}
+
+	switch {
+	case checked == 0:
+		fmt.Fprintln(stdout, "No quoted sources (<!-- zdp:source path#rule --> before a code block)")
+	case drifted+broken == 0 && fixed == 0:
+		fmt.Fprintf(stdout, "%d quoted source(s) match\n", checked)
+	case drifted+broken == 0:
+		fmt.Fprintf(stdout, "\nUpdated %d of %d quoted source(s)\n", fixed, checked)
+	case drifted == 0:
+		return errorf(exitFindings, "%d of %d quoted source(s) can't be checked", broken, checked)
+	default:
+		return errorf(exitFindings, "%d of %d quoted source(s) drifted, %d can't be checked", drifted, checked, broken)
+	}
+	return nil
+}
+
+// testIDRe matches a conformance test given by ID rather than by path
+var testIDRe = regexp.MustCompile(`^[A-Za-z_][\w.:-]*$`)
+
+// testIndex finds conformance tests in the main repository. IDs are
+// looked up in the files under conformance.dir, read once on first use.
+type testIndex struct {
+	files map[string]string // path under the repository → content
+}
+
+// findTest reports whether a tests entry names an existing test: a path
+// or glob relative to conformance.repo, a path#ID whose file mentions the
+// ID, or a bare ID mentioned in a file under conformance.dir
+func (t *testIndex) findTest(entry string) error {
+	path, id, hasID := strings.Cut(entry, "#")
+	if !hasID && testIDRe.MatchString(entry) && !strings.Contains(entry, ".") {
+		path, id = "", entry
+	}
+
+	if path != "" {
+		matches, err := filepath.Glob(filepath.Join(config.TestRepo, filepath.FromSlash(path)))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s does not exist in %s", path, config.TestRepo)
+		}
+		if id == "" {
+			return nil
+		}
+		pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(id) + `\b`)
+		for _, match := range matches {
+			if content, err := os.ReadFile(repoPath(match)); err == nil && pattern.Match(content) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s does not mention %s", path, id)
+	}
+
+	if t.files == nil {
+		t.files = make(map[string]string)
+		root := filepath.Join(config.TestRepo, filepath.FromSlash(config.TestDir))
+		err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != root {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() {
+				if content, err := os.ReadFile(repoPath(p)); err == nil {
+					t.files[p] = string(content)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read conformance tests in %s: %v", root, err)
+		}
+	}
+	pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(id) + `\b`)
+	for _, content := range t.files {
+		if pattern.MatchString(content) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no test named %s in %s", id, filepath.ToSlash(filepath.Join(config.TestRepo, config.TestDir)))
+}
+
+// conformanceReport is the JSON form of "conformance"
+type conformanceReport struct {
+	Broken   []brokenTestLink `json:"broken"`   // tests entries naming no test
+	Untested []listedDoc      `json:"untested"` // Final documents without tests
+	Linked   int              `json:"linked"`   // tests entries checked
+}
+
+// brokenTestLink is a tests entry that names no test
+type brokenTestLink struct {
+	Number  string `json:"number"`
+	Path    string `json:"path"`
+	Test    string `json:"test"`
+	Problem string `json:"problem"`
+}
+
+// conformanceCommand parses the arguments of "conformance [--format text|json]"
+func conformanceCommand(args []string) error {
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		isFlag, json, err := formatFlag(args, &i)
+		if err != nil {
+			return err
+		}
+		if !isFlag {
+			return errorf(exitUsage, "Usage: zdp conformance [--format text|json]")
+		}
+		asJSON = json
+	}
+	if info, err := os.Stat(repoPath(config.TestRepo)); err != nil || !info.IsDir() {
+		return errorf(exitEnvironment, "Main repository %s not found; set conformance.repo in .zdp.yaml", config.TestRepo)
+	}
+
+	docs := scanDocuments()
+	sort.SliceStable(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	report := conformanceReport{Broken: []brokenTestLink{}, Untested: []listedDoc{}}
+	var index testIndex
+	for _, doc := range docs {
+		tests := metaList(doc.Fields["tests"])
+		for _, test := range tests {
+			report.Linked++
+			if err := index.findTest(test); err != nil {
+				report.Broken = append(report.Broken, brokenTestLink{doc.Number, filepath.ToSlash(doc.Path), test, err.Error()})
+			}
+		}
+		if len(tests) == 0 && normalizeState(doc.State) == "final" {
+			report.Untested = append(report.Untested, newListedDoc(doc))
+			warn("%s is Final without conformance tests", doc.Path)
+		}
+	}
+
+	if asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, b := range report.Broken {
+			fmt.Fprintf(stdout, "✗ %s  %s: %s\n", b.Number, b.Test, b.Problem)
+		}
+		if len(report.Untested) > 0 {
+			if len(report.Broken) > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintln(stdout, "Final documents without conformance tests:")
+			for _, doc := range report.Untested {
+				fmt.Fprintf(stdout, "  %s  %s\n", doc.Number, doc.Title)
+			}
+		}
+		if len(report.Broken) == 0 && len(report.Untested) == 0 {
+			fmt.Fprintf(stdout, "All %d linked test(s) exist, and every Final document has tests\n", report.Linked)
+		}
+	}
+	if len(report.Broken) > 0 {
+		return errorf(exitFindings, "%d of %d linked test(s) not found", len(report.Broken), report.Linked)
+	}
+	return nil
+}
+
+// estimateSizes are the T-shirt sizes accepted by the estimate field, in weeks
+var estimateSizes = map[string]float64{"S": 1, "M": 4, "L": 12}
+
+var estimateWeeksRe = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*(w|wk|wks|week|weeks)$`)
+
+// parseEstimate converts an estimate field (S, M, L, or a number of weeks
+// such as "3w" or "2 weeks") to weeks. ok is false when there is none.
+func parseEstimate(value string) (weeks float64, ok bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "none") {
+		return 0, false, nil
+	}
+	if size, known := estimateSizes[strings.ToUpper(value)]; known {
+		return size, true, nil
+	}
+	if m := estimateWeeksRe.FindStringSubmatch(value); m != nil {
+		weeks, _ = strconv.ParseFloat(m[1], 64)
+		return weeks, true, nil
+	}
+	return 0, false, fmt.Errorf("invalid estimate \"%s\" (use S, M, L, or a number of weeks such as 3w)", value)
+}
+
+// closedStates are left out of effort rollups unless --all is given
+var closedStates = []string{"final", "deferred", "rejected", "withdrawn", "superseded"}
+
+// effortCommand parses the arguments of "effort [--by component|milestone] [--all]"
+func effortCommand(args []string) error {
+	by, all := "component", false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--by"); err != nil {
+			return err
+		} else if ok {
+			if value != "component" && value != "milestone" {
+				return errorf(exitUsage, "Invalid --by value \"%s\" (use component or milestone)", value)
+			}
+			by = value
+			continue
+		}
+		if args[i] != "--all" {
+			return errorf(exitUsage, "Usage: zdp effort [--by component|milestone] [--all]")
+		}
+		all = true
+	}
+
+	effortRollup(by, all)
+	return nil
+}
+
+// effortTotal accumulates the estimates of one group
+type effortTotal struct {
+	Docs        int
+	Estimated   int
+	Weeks       float64
+	Unestimated []string
+}
+
+// effortRollup totals estimates of open documents per component or
+// milestone. A document with several components counts toward each.
+func effortRollup(by string, all bool) {
+	totals := make(map[string]*effortTotal)
+	var overall effortTotal
+
+	docs := scanDocuments()
+	sort.Slice(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	for _, doc := range docs {
+		if !all && containsString(closedStates, normalizeState(doc.State)) {
+			continue
+		}
+		weeks, ok, err := parseEstimate(doc.Fields["estimate"])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠ %s: %v\n", doc.Path, err)
+			warn("%s: %v", doc.Path, err)
+		}
+
+		groups := []string{docMilestone(doc)}
+		if by == "component" {
+			groups = metaList(doc.Fields["component"])
+		}
+		if len(groups) == 0 || groups[0] == "" {
+			groups = []string{"(none)"}
+		}
+
+		for _, t := range append([]*effortTotal{&overall}, groupTotals(totals, groups)...) {
+			t.Docs++
+			if ok {
+				t.Estimated++
+				t.Weeks += weeks
+			} else {
+				t.Unestimated = append(t.Unestimated, doc.Number)
+			}
+		}
+	}
+
+	if overall.Docs == 0 {
+		fmt.Fprintln(stdout, "No open documents")
+		return
+	}
+
+	var names []string
+	width := len(by)
+	for name := range totals {
+		names = append(names, name)
+		width = max(width, len(name))
+	}
+	sort.Strings(names)
+
+	scope := "open documents"
+	if all {
+		scope = "all documents"
+	}
+	fmt.Fprintf(stdout, "Effort by %s (%s; S=%g, M=%g, L=%g weeks):\n\n", by, scope, estimateSizes["S"], estimateSizes["M"], estimateSizes["L"])
+	row := func(name string, t *effortTotal) {
+		fmt.Fprintf(stdout, "  %-*s  %4d  %9d  %6.1f\n", width, name, t.Docs, t.Estimated, t.Weeks)
+	}
+	fmt.Fprintf(stdout, "  %-*s  %4s  %9s  %6s\n", width, strings.ToUpper(by[:1])+by[1:], "Docs", "Estimated", "Weeks")
+	for _, name := range names {
+		row(name, totals[name])
+	}
+	fmt.Fprintf(stdout, "  %s\n", strings.Repeat("─", width+25))
+	row("Total", &overall)
+
+	if len(overall.Unestimated) > 0 {
+		fmt.Fprintf(stdout, "\nWithout an estimate: %s\n", strings.Join(overall.Unestimated, ", "))
+	}
+}
+
+// groupTotals returns the totals for the named groups, creating them as needed
+func groupTotals(totals map[string]*effortTotal, groups []string) []*effortTotal {
+	var result []*effortTotal
+	for _, group := range groups {
+		if totals[group] == nil {
+			totals[group] = &effortTotal{}
+		}
+		result = append(result, totals[group])
+	}
+	return result
+}
+
+// riskRegisterPath is the generated risk register, refreshed by update-index
+const riskRegisterPath = "RISKS.md"
+
+// riskSeverities orders severities from most to least serious
+var riskSeverities = []string{"Critical", "High", "Medium", "Low", "Unrated"}
+
+var (
+	riskHeadingRe  = regexp.MustCompile(`(?i)^risks?\b`)
+	riskLeadRe     = regexp.MustCompile(`(?i)^[\[(*_]*(critical|high|medium|low)[\])*_]*\s*(?:[:\-–—]|\s\()?\s*`)
+	riskSeverityRe = regexp.MustCompile(`(?i)\(?\bseverity:\s*(critical|high|medium|low)\)?`)
+	riskOwnerRe    = regexp.MustCompile(`(?i)\(?\bowner:\s*([^);,]+?)\s*(\)|[;,]|$)`)
+)
+
+// riskEntry is one risk in the register
+type riskEntry struct {
+	Severity string
+	Text     string
+	Owner    string
+	Number   string
+	Path     string
+}
+
+// severityRank returns the position of a severity in riskSeverities
+func severityRank(severity string) int {
+	for i, s := range riskSeverities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return len(riskSeverities) - 1
+}
+
+// parseRiskText pulls a severity ("High: ...", "[high] ...", or
+// "severity: high") and an owner ("owner: name") out of a risk's text
+func parseRiskText(text string) (severity, owner, rest string) {
+	severity = "Unrated"
+	if m := riskSeverityRe.FindStringSubmatch(text); m != nil {
+		severity = m[1]
+		text = strings.Replace(text, m[0], "", 1)
+	} else if m := riskLeadRe.FindStringSubmatch(text); m != nil {
+		severity = m[1]
+		text = text[len(m[0]):]
+	}
+	if m := riskOwnerRe.FindStringSubmatch(text); m != nil {
+		owner = strings.TrimSpace(m[1])
+		text = strings.Replace(text, strings.TrimRight(m[0], ";,"), "", 1)
+	}
+	severity = riskSeverities[severityRank(severity)]
+	rest = strings.Join(strings.Fields(strings.Trim(text, " ;,")), " ")
+	rest = regexp.MustCompile(`\s+([;,.])`).ReplaceAllString(rest, "$1")
+	return severity, owner, rest
+}
+
+// riskSectionItems returns the risks listed under "Risks" headings of a
+// body: each top-level bullet is one risk, with its nested lines folded
+// in; a section without bullets is one risk
+func riskSectionItems(body string) []string {
+	var items []string
+	lines := strings.Split(body, "\n")
+	var fence codeFence
+
+	for i := 0; i < len(lines); i++ {
+		if fence.inCode(lines[i]) {
+			continue
+		}
+		m := markdownHeadingRe.FindStringSubmatch(lines[i])
+		if m == nil || len(m[1]) < 2 || !riskHeadingRe.MatchString(sectionNumberRe.ReplaceAllString(m[2], "")) {
+			continue
+		}
+
+		var found []string
+		var paragraph []string
+		var inner codeFence
+		j := i + 1
+		for ; j < len(lines); j++ {
+			line := lines[j]
+			if inner.inCode(line) {
+				continue
+			}
+			if sub := markdownHeadingRe.FindStringSubmatch(line); sub != nil && len(sub[1]) <= len(m[1]) {
+				break
+			}
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case trimmed == "":
+			case bulletRe.MatchString(line) && bulletRe.FindStringSubmatch(line)[1] == "":
+				found = append(found, bulletRe.FindStringSubmatch(line)[2])
+			case orderedRe.MatchString(line) && orderedRe.FindStringSubmatch(line)[1] == "":
+				found = append(found, orderedRe.FindStringSubmatch(line)[3])
+			case len(found) > 0 && bulletRe.MatchString(line):
+				// Nested bullets (mitigations, notes) belong to the risk
+				found[len(found)-1] += "; " + bulletRe.FindStringSubmatch(line)[2]
+			case len(found) > 0:
+				found[len(found)-1] += " " + trimmed
+			default:
+				paragraph = append(paragraph, trimmed)
+			}
+		}
+		if len(found) == 0 && len(paragraph) > 0 {
+			found = []string{strings.Join(paragraph, " ")}
+		}
+		items = append(items, found...)
+		i = j - 1
+	}
+	return items
+}
+
+// frontmatterRisks returns the entries of a "risk:" (or "risks:") field:
+// a string, a list of strings, or a list of mappings with description,
+// severity, and owner keys
+func frontmatterRisks(doc *Document) []riskEntry {
+	var raw interface{}
+	for _, key := range []string{"risk", "risks"} {
+		if value, ok := doc.Meta[key]; ok {
+			raw = value
+			break
+		}
+	}
+	items, isList := raw.([]interface{})
+	if !isList && raw != nil {
+		items = []interface{}{raw}
+	}
+
+	var risks []riskEntry
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			if v == "" || strings.EqualFold(v, "none") {
+				continue
+			}
+			severity, owner, text := parseRiskText(v)
+			risks = append(risks, riskEntry{Severity: severity, Owner: owner, Text: text})
+		case map[string]interface{}:
+			text := ""
+			for _, key := range []string{"description", "risk", "text"} {
+				if s, ok := v[key].(string); ok && s != "" {
+					text = s
+					break
+				}
+			}
+			severity, _ := v["severity"].(string)
+			owner, _ := v["owner"].(string)
+			risks = append(risks, riskEntry{Severity: riskSeverities[severityRank(severity)], Owner: owner, Text: text})
+		}
+	}
+	return risks
+}
+
+// collectRisks gathers the risks of every Active document. Risks without
+// an owner fall to the document's champion, then its author.
+func collectRisks() ([]riskEntry, error) {
+	var risks []riskEntry
+
+	for _, doc := range scanDocuments() {
+		if normalizeState(doc.State) != "active" {
+			continue
+		}
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return nil, errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+
+		found := frontmatterRisks(doc)
+		for _, item := range riskSectionItems(frontmatterRe.ReplaceAllString(string(content), "")) {
+			severity, owner, text := parseRiskText(item)
+			found = append(found, riskEntry{Severity: severity, Owner: owner, Text: text})
+		}
+
+		fallback := doc.Author
+		if champion := doc.Fields["champion"]; champion != "" && !strings.EqualFold(champion, "none") {
+			fallback = champion
+		}
+		for _, risk := range found {
+			if risk.Text == "" {
+				continue
+			}
+			if risk.Owner == "" {
+				risk.Owner = fallback
+			}
+			risk.Number, risk.Path = doc.Number, filepath.ToSlash(doc.Path)
+			risks = append(risks, risk)
+		}
+	}
+
+	sort.SliceStable(risks, func(i, j int) bool {
+		if a, b := severityRank(risks[i].Severity), severityRank(risks[j].Severity); a != b {
+			return a < b
+		}
+		return docNumberLess(risks[i].Number, risks[j].Number)
+	})
+	return risks, nil
+}
+
+// renderRiskRegister renders the risk register page
+func renderRiskRegister(risks []riskEntry) string {
+	blocks := []string{
+		"# Risk Register",
+		"Risks recorded in Active design documents, under a \"Risks\" heading or in the `risk:` field, most severe first. Generated by `zdp risks` and refreshed by `zdp update-index`; edit the documents, not this page.",
+	}
+	if len(risks) == 0 {
+		return strings.Join(append(blocks, "_No risks recorded._"), "\n\n") + "\n"
+	}
+
+	rows := []string{"| Severity | Risk | Owner | Document |", "|----------|------|-------|----------|"}
+	for _, risk := range risks {
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s | [%s](%s) |",
+			risk.Severity, escapeTableCell(risk.Text), escapeTableCell(risk.Owner), risk.Number, risk.Path))
+	}
+	return strings.Join(append(blocks, strings.Join(rows, "\n")), "\n\n") + "\n"
+}
+
+// writeRiskRegister regenerates the risk register, reporting whether the
+// file changed
+func writeRiskRegister(path string) (bool, int, error) {
+	risks, err := collectRisks()
+	if err != nil {
+		return false, 0, err
+	}
+	content := renderRiskRegister(risks)
+	if existing, err := os.ReadFile(repoPath(path)); err == nil && string(existing) == content {
+		return false, len(risks), nil
+	}
+	if err := os.WriteFile(repoPath(path), []byte(content), 0644); err != nil {
+		return false, 0, errorf(exitEnvironment, "Failed to write %s: %v", path, err)
+	}
+	opResult.recordWrite(path)
+	return true, len(risks), nil
+}
+
+// risksCommand parses the arguments of "risks [--out RISKS.md]"
+func risksCommand(args []string) error {
+	path := riskRegisterPath
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			path = value
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp risks [--out RISKS.md]")
+	}
+
+	changed, count, err := writeRiskRegister(path)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Fprintf(stdout, "%s is already up to date (%d risk(s))\n", path, count)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Wrote %d risk(s) to %s\n", count, path)
+	return nil
+}
+
+// deprecationRegistryPath is the generated deprecation registry, refreshed
+// by update-index
+const deprecationRegistryPath = "DEPRECATIONS.md"
+
+// stabilityLevels are the values of the stability field
+var stabilityLevels = []string{"experimental", "stable", "deprecated"}
+
+// deprecation is one feature in the deprecation registry
+type deprecation struct {
+	Feature     string
+	Since       string // release the deprecating proposal targets
+	Removal     string
+	Replacement string
+	Number      string // the proposal that deprecates the feature
+	Path        string
+	State       string
+	FeatureDoc  string // the document specifying the feature, if any
+}
+
+// releaseLess orders release names such as v0.6.0 and v0.10 by their
+// numeric parts, leaving empty names last
+func releaseLess(a, b string) bool {
+	if a == "" || b == "" {
+		return a != "" && b == ""
+	}
+	pa, pb := digitsRe.FindAllString(a, -1), digitsRe.FindAllString(b, -1)
+	if len(pa) == 0 && len(pb) == 0 {
+		return a < b
+	}
+	// Missing parts count as zero, so v0.6 and v0.6.0 are the same release
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na < nb
+		}
+	}
+	return false
+}
+
+// frontmatterDeprecations returns the entries of a document's
+// "deprecates:" field: a feature name, a list of them, or a list of
+// mappings with feature, removal, and replacement keys. A feature given
+// as a document number stands for the feature that document specifies.
+func frontmatterDeprecations(doc *Document) []deprecation {
+	raw := doc.Meta["deprecates"]
+	items, isList := raw.([]interface{})
+	if !isList && raw != nil {
+		items = []interface{}{raw}
+	}
+
+	var found []deprecation
+	for _, item := range items {
+		var d deprecation
+		switch v := item.(type) {
+		case string:
+			d.Feature = strings.TrimSpace(v)
+		case int:
+			d.Feature = strconv.Itoa(v)
+		case map[string]interface{}:
+			for key, field := range map[string]*string{"feature": &d.Feature, "removal": &d.Removal, "replacement": &d.Replacement} {
+				switch value := v[key].(type) {
+				case string:
+					*field = strings.TrimSpace(value)
+				case int:
+					*field = strconv.Itoa(value)
+				}
+			}
+		}
+		if d.Feature == "" || strings.EqualFold(d.Feature, "none") {
+			continue
+		}
+		if digitsRe.FindString(d.Feature) == d.Feature {
+			d.FeatureDoc = docRefs(d.Feature)[0]
+		}
+		found = append(found, d)
+	}
+	return found
+}
+
+// collectDeprecations gathers the features deprecated by Accepted, Active,
+// and Final proposals, plus documents marked "stability: deprecated" that
+// no proposal names, ordered by planned removal
+func collectDeprecations() []deprecation {
+	docs := scanDocuments()
+	byNumber := map[string]*Document{}
+	for _, doc := range docs {
+		byNumber[doc.Number] = doc
+	}
+
+	var registry []deprecation
+	named := map[string]bool{}
+	for _, doc := range docs {
+		switch normalizeState(doc.State) {
+		case "accepted", "active", "final":
+		default:
+			continue
+		}
+		for _, d := range frontmatterDeprecations(doc) {
+			if d.FeatureDoc != "" {
+				named[d.FeatureDoc] = true
+				if target := byNumber[d.FeatureDoc]; target != nil {
+					d.Feature = displayTitle(target.Title)
+				}
+			}
+			d.Since = docMilestone(doc)
+			d.Number, d.Path, d.State = doc.Number, filepath.ToSlash(doc.Path), doc.State
+			registry = append(registry, d)
+		}
+	}
+
+	// Documents deprecated on their own, or by a proposal that has no
+	// deprecates entry for them, point at whatever superseded them
+	for _, doc := range docs {
+		if !strings.EqualFold(doc.Fields["stability"], "deprecated") || named[doc.Number] {
+			continue
+		}
+		d := deprecation{Feature: displayTitle(doc.Title), FeatureDoc: doc.Number}
+		if next := docRefs(doc.Fields["superseded-by"]); len(next) > 0 && byNumber[next[0]] != nil {
+			by := byNumber[next[0]]
+			d.Number, d.Path, d.State, d.Since = by.Number, filepath.ToSlash(by.Path), by.State, docMilestone(by)
+		}
+		registry = append(registry, d)
+	}
+
+	sort.SliceStable(registry, func(i, j int) bool {
+		if registry[i].Removal != registry[j].Removal {
+			return releaseLess(registry[i].Removal, registry[j].Removal)
+		}
+		return strings.ToLower(registry[i].Feature) < strings.ToLower(registry[j].Feature)
+	})
+	return registry
+}
+
+// renderDeprecationRegistry renders the deprecation registry page
+func renderDeprecationRegistry(registry []deprecation) string {
+	blocks := []string{
+		"# Deprecation Registry",
+		"Language features slated for removal, from the `deprecates:` field of Accepted, Active, and Final proposals and from documents marked `stability: deprecated`, soonest removal first. Generated by `zdp deprecations` and refreshed by `zdp update-index`; edit the documents, not this page.",
+	}
+	if len(registry) == 0 {
+		return strings.Join(append(blocks, "_No deprecations recorded._"), "\n\n") + "\n"
+	}
+
+	cell := func(value string) string {
+		if value == "" {
+			return "—"
+		}
+		return escapeTableCell(value)
+	}
+	rows := []string{"| Feature | Deprecated in | Removal | Replacement | Proposal |", "|---------|---------------|---------|-------------|----------|"}
+	for _, d := range registry {
+		proposal := "—"
+		if d.Number != "" {
+			proposal = fmt.Sprintf("[%s](%s) (%s)", d.Number, d.Path, d.State)
+		}
+		feature := cell(d.Feature)
+		if d.FeatureDoc != "" {
+			feature += " (" + d.FeatureDoc + ")"
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s | %s | %s |",
+			feature, cell(d.Since), cell(d.Removal), cell(d.Replacement), proposal))
+	}
+	return strings.Join(append(blocks, strings.Join(rows, "\n")), "\n\n") + "\n"
+}
+
+// writeDeprecationRegistry regenerates the deprecation registry,
+// reporting whether the file changed
+func writeDeprecationRegistry(path string) (bool, int, error) {
+	registry := collectDeprecations()
+	content := renderDeprecationRegistry(registry)
+	if existing, err := os.ReadFile(repoPath(path)); err == nil && string(existing) == content {
+		return false, len(registry), nil
+	}
+	if err := os.WriteFile(repoPath(path), []byte(content), 0644); err != nil {
+		return false, 0, errorf(exitEnvironment, "Failed to write %s: %v", path, err)
+	}
+	opResult.recordWrite(path)
+	return true, len(registry), nil
+}
+
+// deprecationsCommand parses the arguments of "deprecations [--out DEPRECATIONS.md]"
+func deprecationsCommand(args []string) error {
+	path := deprecationRegistryPath
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--out"); err != nil {
+			return err
+		} else if ok {
+			path = value
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp deprecations [--out DEPRECATIONS.md]")
+	}
+
+	changed, count, err := writeDeprecationRegistry(path)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Fprintf(stdout, "%s is already up to date (%d deprecation(s))\n", path, count)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Wrote %d deprecation(s) to %s\n", count, path)
+	return nil
+}
+
+// lifecycleEvent is one document lifecycle change, from the journal or
+// derived from git history. ID is "journal:<time>:<number>" for journal
+// entries and "<commit>:<number>" otherwise, stable across runs, so
+// consumers can process each event exactly once.
+type lifecycleEvent struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"` // "created", "transitioned", or "removed"
+	Number string `json:"number"`
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Forced bool   `json:"forced,omitempty"`
+	Commit string `json:"commit,omitempty"` // empty for a journal entry not yet committed
+	Author string `json:"author"`
+	Date   string `json:"date"`
+}
+
+// lifecycleEvents reads the lifecycle events in a git log range, oldest
+// first. Additions and removals in state directories are creations and
+// removals; renames across state directories are transitions.
+func lifecycleEvents(ctx context.Context, logArgs ...string) ([]lifecycleEvent, error) {
+	args := append([]string{"-c", "core.quotePath=false", "log", "--reverse", "-M", "--name-status", "--format=%x00%H%x09%aI%x09%an"}, logArgs...)
+	args = append(append(args, "--"), sortedStateDirs()...)
+	output, err := repoCommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %v", err)
+	}
+
+	var events []lifecycleEvent
+	for _, commit := range strings.Split(string(output), "\x00") {
+		lines := strings.Split(strings.TrimSpace(commit), "\n")
+		header := strings.SplitN(lines[0], "\t", 3)
+		if len(header) != 3 {
+			continue
+		}
+		hash, date, author := header[0], header[1], header[2]
+
+		for _, line := range lines[1:] {
+			fields := strings.Split(line, "\t")
+			if len(fields) < 2 || !strings.HasSuffix(fields[len(fields)-1], ".md") {
+				continue
+			}
+			path := fields[len(fields)-1]
+			event := lifecycleEvent{
+				Number: extractNumberFromFilename(filepath.Base(path)),
+				Path:   path,
+				Commit: hash,
+				Author: author,
+				Date:   date,
+			}
+			if event.Number == "" {
+				continue
+			}
+			state := getTitleCaseState(dirToState[filepath.Base(filepath.Dir(path))])
+
+			switch status := fields[0]; {
+			case status == "A":
+				event.Type, event.To = "created", state
+			case status == "D":
+				event.Type, event.From = "removed", state
+			case strings.HasPrefix(status, "R") && len(fields) == 3:
+				from := getTitleCaseState(dirToState[filepath.Base(filepath.Dir(fields[1]))])
+				if from == state {
+					continue
+				}
+				event.Type, event.From, event.To = "transitioned", from, state
+			default:
+				continue
+			}
+			event.ID = hash + ":" + event.Number
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// journalEvents turns the journal's transition entries into lifecycle
+// events, oldest first
+func journalEvents(entries []journalEntry) []lifecycleEvent {
+	var events []lifecycleEvent
+	for _, e := range entries {
+		if e.Action != "transition" || len(e.Paths) == 0 {
+			continue
+		}
+		path := filepath.ToSlash(e.Paths[len(e.Paths)-1])
+		number := extractNumberFromFilename(filepath.Base(path))
+		if number == "" {
+			continue
+		}
+		events = append(events, lifecycleEvent{
+			ID:     "journal:" + e.Time + ":" + number,
+			Type:   "transitioned",
+			Number: number,
+			Path:   path,
+			From:   e.Details["from"],
+			To:     e.Details["to"],
+			Reason: e.Details["reason"],
+			Forced: e.Details["forced"] == "true",
+			Author: e.Actor,
+			Date:   e.Time,
+		})
+	}
+	return events
+}
+
+// withJournal merges the journal's events into the events read from git.
+// A git transition the journal also recorded is replaced by the journal
+// entry, which keeps the reason and whether it was forced; journal
+// entries with no commit yet are added when they are not before after.
+// emitted and matched carry the journal IDs already printed and already
+// paired with a commit from one call to the next.
+func withJournal(gitEvents, journal []lifecycleEvent, after time.Time, emitted, matched map[string]bool) []lifecycleEvent {
+	var events []lifecycleEvent
+	for _, event := range gitEvents {
+		found := -1
+		for i, j := range journal {
+			if !matched[j.ID] && j.Type == event.Type && j.Number == event.Number && j.From == event.From && j.To == event.To {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			events = append(events, event)
+			continue
+		}
+		id := journal[found].ID
+		matched[id] = true
+		if emitted[id] {
+			continue
+		}
+		emitted[id] = true
+		j := journal[found]
+		j.Commit = event.Commit
+		events = append(events, j)
+	}
+	for _, j := range journal {
+		if emitted[j.ID] {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, j.Date); err == nil && t.Before(after) {
+			continue
+		}
+		emitted[j.ID] = true
+		events = append(events, j)
+	}
+
+	sort.SliceStable(events, func(i, k int) bool {
+		ti, _ := time.Parse(time.RFC3339, events[i].Date)
+		tk, _ := time.Parse(time.RFC3339, events[k].Date)
+		return ti.Before(tk)
+	})
+	return events
+}
+
+// eventsCommand parses the arguments of
+// "events [--since ref|date] [--follow] [--interval d]"
+func eventsCommand(ctx context.Context, args []string) error {
+	var since string
+	follow := false
+	interval := 30 * time.Second
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--since"); err != nil {
+			return err
+		} else if ok {
+			since = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--interval"); err != nil {
+			return err
+		} else if ok {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return errorf(exitUsage, "Invalid --interval value \"%s\"", value)
+			}
+			interval = d
+			continue
+		}
+		if args[i] != "--follow" {
+			return errorf(exitUsage, "Usage: zdp events [--since <ref|YYYY-MM-DD>] [--follow] [--interval 30s]")
+		}
+		follow = true
+	}
+
+	var logArgs []string
+	var after time.Time
+	seen := map[string]bool{}
+	switch {
+	case since == "":
+	case indexDateRe.MatchString(since):
+		logArgs = []string{"--since=" + since}
+		after, _ = time.ParseInLocation("2006-01-02", since, time.Local)
+	default:
+		if err := repoCommand("git", "rev-parse", "--verify", "--quiet", since+"^{commit}").Run(); err != nil {
+			return errorf(exitUsage, "--since: \"%s\" is neither a date (YYYY-MM-DD) nor a git ref", since)
+		}
+		// Exclude commits reachable from the ref, and the journal
+		// entries already committed there
+		logArgs = []string{"^" + since}
+		if content, err := repoCommand("git", "show", since+":"+filepath.ToSlash(journalPath)).Output(); err == nil {
+			entries, err := parseJournal(content)
+			if err != nil {
+				warn("Failed to read %s at %s: %v", journalPath, since, err)
+			}
+			for _, event := range journalEvents(entries) {
+				seen[event.ID] = true
+			}
+		}
+	}
+
+	return streamEvents(ctx, logArgs, after, seen, follow, interval)
+}
+
+// streamEvents prints the events in a log range, and the journal entries
+// not in seen and not before after, as JSON lines. With follow set it
+// keeps running, printing the events of new commits and journal entries
+// as they appear.
+func streamEvents(ctx context.Context, logArgs []string, after time.Time, seen map[string]bool, follow bool, interval time.Duration) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetEscapeHTML(false)
+	emitted, matched := map[string]bool{}, map[string]bool{}
+	for id := range seen {
+		emitted[id], matched[id] = true, true
+	}
+	emit := func(args []string) error {
+		var events []lifecycleEvent
+		if args != nil {
+			var err error
+			if events, err = lifecycleEvents(ctx, args...); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errorf(exitEnvironment, "%v", err)
+			}
+		}
+		entries, err := loadJournal()
+		if err != nil {
+			warn("Reading lifecycle events from git alone: %v", err)
+		}
+		for _, event := range withJournal(events, journalEvents(entries), after, emitted, matched) {
+			enc.Encode(event)
+		}
+		return nil
+	}
+
+	head := gitHead()
+	if head == "" {
+		return errorf(exitEnvironment, "Not in a git repository with commits")
+	}
+	if err := emit(append(logArgs, head)); err != nil || !follow {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current := gitHead()
+		if current == "" || current == head {
+			// No new commits, but the journal may have grown
+			if err := emit(nil); err != nil {
+				return err
+			}
+			continue
+		}
+		// Only commits reachable from the new HEAD but not the old one
+		if err := emit([]string{head + ".." + current}); err != nil {
+			return err
+		}
+		head = current
+	}
+}
+
+// journalPath is the append-only log of changes made through zdp
+var journalPath = filepath.Join(".zdp", "journal.jsonl")
+
+// journalEntry is one line of the journal: a change zdp made, who made
+// it, and the files it touched
+type journalEntry struct {
+	Time       string            `json:"time"`
+	Action     string            `json:"action"` // add, renumber, transition, or index-sync
+	Actor      string            `json:"actor"`
+	RecordedBy string            `json:"recorded_by,omitempty"` // who actually ran zdp, when acting with --as
+	Paths      []string          `json:"paths"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// appendJournal appends an entry to the journal. The file is only ever
+// added to; a failure to write it is a warning, not a reason to undo
+// the change it records.
+func appendJournal(action string, paths []string, details map[string]string) {
+	entry := journalEntry{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Action:     action,
+		Actor:      currentIdentity().String(),
+		RecordedBy: recordedBy(),
+		Paths:      paths,
+		Details:    details,
+	}
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(entry)
+	if err == nil {
+		err = os.MkdirAll(repoPath(filepath.Dir(journalPath)), 0755)
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(repoPath(journalPath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			_, err = f.Write(line.Bytes())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		warn("Failed to record %s in %s: %v", action, journalPath, err)
+		return
+	}
+	opResult.recordWrite(journalPath)
+}
+
+// loadJournal reads the journal, oldest entry first
+func loadJournal() ([]journalEntry, error) {
+	content, err := os.ReadFile(repoPath(journalPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseJournal(content)
+}
+
+// parseJournal parses the lines of a journal
+func parseJournal(content []byte) ([]journalEntry, error) {
+	var entries []journalEntry
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", journalPath, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// journalActions are the kinds of change the journal records
+var journalActions = []string{"add", "renumber", "transition", "index-sync"}
+
+// journalCommand parses the arguments of "journal" and prints the
+// matching entries, oldest first
+func journalCommand(args []string) error {
+	usage := "Usage: zdp journal [<doc|number>] [--action add|renumber|transition|index-sync] [--actor who] [--since YYYY-MM-DD] [--until YYYY-MM-DD] [--format text|json]"
+	var number, action, actor, since, until string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--action"); err != nil {
+			return err
+		} else if ok {
+			if action = strings.ToLower(value); !containsString(journalActions, action) {
+				return errorf(exitUsage, "Unknown --action \"%s\"; use one of: %s", value, strings.Join(journalActions, ", "))
+			}
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--actor"); err != nil {
+			return err
+		} else if ok {
+			actor = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--since"); err != nil {
+			return err
+		} else if ok {
+			since = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--until"); err != nil {
+			return err
+		} else if ok {
+			until = value
+			continue
+		}
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || number != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		number = args[i]
+	}
+	for _, date := range []string{since, until} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return errorf(exitUsage, "Invalid date \"%s\"; use YYYY-MM-DD", date)
+		}
+	}
+	if number != "" {
+		// The document may have been renumbered or removed since, so a
+		// bare number is taken as it is
+		if doc, err := findDocument(number); err == nil {
+			number = doc.Number
+		} else if n, err := strconv.Atoi(number); err == nil {
+			number = fmt.Sprintf("%04d", n)
+		} else {
+			return errorf(exitUsage, "%v", err)
+		}
+	}
+
+	entries, err := loadJournal()
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read the journal: %v", err)
+	}
+	matched := []journalEntry{}
+	for _, e := range entries {
+		day := e.Time
+		if len(day) > 10 {
+			day = day[:10]
+		}
+		switch {
+		case action != "" && e.Action != action,
+			actor != "" && !parsePerson(e.Actor).matches(actor),
+			since != "" && day < since,
+			until != "" && day > until,
+			number != "" && !journalTouches(e, number):
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	if asJSON {
+		return printJSON(matched)
+	}
+	if len(matched) == 0 {
+		fmt.Fprintln(stdout, "No journal entries match")
+		return nil
+	}
+	for _, e := range matched {
+		what := strings.Join(e.Paths, " → ")
+		if e.Action == "index-sync" {
+			what = fmt.Sprintf("%s (%s change(s))", strings.Join(e.Paths, ", "), e.Details["changes"])
+		}
+		fmt.Fprintf(stdout, "%s  %-10s  %s  %s\n", e.Time, e.Action, e.Actor, what)
+		if e.Action == "transition" {
+			note := e.Details["from"] + " → " + e.Details["to"]
+			if reason := e.Details["reason"]; reason != "" {
+				note += ": " + reason
+			}
+			fmt.Fprintf(stdout, "    %s\n", note)
+		}
+		if e.RecordedBy != "" {
+			fmt.Fprintf(stdout, "    recorded by %s\n", e.RecordedBy)
+		}
+	}
+	return nil
+}
+
+// journalTouches reports whether a journal entry concerns the document
+// with the given number, under any of the paths it had
+func journalTouches(e journalEntry, number string) bool {
+	if e.Details["number"] == number {
+		return true
+	}
+	for _, path := range e.Paths {
+		if extractNumberFromFilename(filepath.Base(path)) == number {
+			return true
+		}
+	}
+	return false
+}
+
+// benchBudgets are the documented time budgets per operation and corpus
+// size; bench fails when an operation exceeds its budget
+var benchBudgets = map[string]map[int]time.Duration{
+	"update-index": {1000: 500 * time.Millisecond, 10000: 5 * time.Second},
+	"validate":     {1000: 500 * time.Millisecond, 10000: 5 * time.Second},
+	"search":       {1000: 250 * time.Millisecond, 10000: 2500 * time.Millisecond},
+}
+
+// benchIndex is the empty index a synthetic corpus starts with
+const benchIndex = `# Benchmark Index
+
+## All Documents by Number
+
+| Number | Title | State | Updated |
+|--------|-------|-------|---------|
+`
+
+// benchOperations are timed in this order
+var benchOperations = []string{"update-index", "validate", "search"}
+
+// writeBenchCorpus fills dir with a git repository of n synthetic
+// documents spread over the state directories, and an empty index
+func writeBenchCorpus(dir string, n int) error {
+	var stateNames []string
+	for name := range states {
+		stateNames = append(stateNames, name)
+	}
+	sort.Strings(stateNames)
+
+	body := strings.Repeat(`
+## Section
+
+Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod
+tempor incididunt ut labore et dolore magna aliqua. See `+"`(defun f (x) x)`"+`.
+
+- First point about the design
+- Second point, with **emphasis**
+
+`+"```lisp\n(defun add (a b)\n  (+ a b))\n```\n", 10)
+
+	for i := 1; i <= n; i++ {
+		state := stateNames[i%len(stateNames)]
+		number := fmt.Sprintf("%04d", i)
+		path := filepath.Join(dir, states[state], number+"-synthetic-document.md")
+		if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+			return err
+		}
+		content := fmt.Sprintf("---\nnumber: %s\ntitle: \"Synthetic Document %s\"\nauthor: Bench\ncreated: 2025-01-01\nupdated: 2025-01-%02d\nstate: %s\nsupersedes: None\nsuperseded-by: None\n---\n\n# Synthetic Document %s\n%s",
+			number, number, i%28+1, getTitleCaseState(state), number, body)
+		if err := os.WriteFile(repoPath(path), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(repoPath(filepath.Join(dir, config.IndexFile)), []byte(benchIndex), 0644); err != nil {
+		return err
+	}
+
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=zdp bench", "-c", "user.email=bench@example.com", "commit", "--quiet", "-m", "Synthetic corpus"},
+	} {
+		cmd := repoCommand("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// runBenchOperation runs one of benchOperations on the repository at
+// repoRoot. The benchmarks in bench_test.go time the same code.
+func runBenchOperation(ctx context.Context, op string) error {
+	switch op {
+	case "update-index":
+		return updateIndexCommand(ctx)
+	case "validate":
+		idx, _, _ := loadIndex(config.IndexFile)
+		for _, doc := range scanDocuments() {
+			validateDocumentWith(doc.Path, &idx)
+		}
+	case "search":
+		var docs []federatedDoc
+		for _, doc := range scanDocuments() {
+			docs = append(docs, federatedDoc{Document: doc})
+		}
+		federatedSearch(docs, "magna aliqua")
+	}
+	return nil
+}
+
+// benchCommand parses the arguments of "bench [--docs 1000,10000] [--keep]"
+func benchCommand(ctx context.Context, args []string) error {
+	sizes := []int{1000, 10000}
+	keep := false
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--docs"); err != nil {
+			return err
+		} else if ok {
+			sizes = nil
+			for _, part := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(part))
+				if err != nil || n <= 0 {
+					return errorf(exitUsage, "Invalid --docs value \"%s\"", part)
+				}
+				sizes = append(sizes, n)
+			}
+			continue
+		}
+		if args[i] != "--keep" {
+			return errorf(exitUsage, "Usage: zdp bench [--docs 1000,10000] [--keep]")
+		}
+		keep = true
+	}
+
+	return bench(ctx, sizes, keep)
+}
+
+// bench times the core operations over synthetic corpora and compares
+// them with benchBudgets. It runs with the default configuration so
+// results are comparable between repositories.
+func bench(ctx context.Context, sizes []int, keep bool) error {
+	saved, savedRoot := config, repoRoot
+	setConfig(defaultConfig())
+	defer func() {
+		setConfig(saved)
+		repoRoot = savedRoot
+	}()
+
+	over := 0
+	fmt.Fprintf(stdout, "%-14s %6s %10s %10s\n", "Operation", "Docs", "Time", "Budget")
+	for _, n := range sizes {
+		dir, err := os.MkdirTemp("", "zdp-bench-")
+		if err != nil {
+			return errorf(exitEnvironment, "%v", err)
+		}
+		if !keep {
+			defer os.RemoveAll(dir)
+		}
+		if err := writeBenchCorpus(dir, n); err != nil {
+			return errorf(exitEnvironment, "Failed to create corpus: %v", err)
+		}
+		repoRoot = dir
+
+		for _, op := range benchOperations {
+			start := time.Now()
+			var err error
+			captureStdout(func() { err = runBenchOperation(ctx, op) })
+			if err != nil {
+				return err
+			}
+			elapsed := time.Since(start)
+
+			budget, status := "-", ""
+			if limit, ok := benchBudgets[op][n]; ok {
+				budget = limit.String()
+				status = "✓"
+				if elapsed > limit {
+					status = "✗"
+					over++
+				}
+			}
+			fmt.Fprintf(stdout, "%-14s %6d %10s %10s %s\n", op, n, elapsed.Round(time.Millisecond), budget, status)
+		}
+		if keep {
+			fmt.Fprintf(stdout, "Kept corpus of %d documents in %s\n", n, dir)
+		}
+	}
+
+	if over > 0 {
+		return errorf(exitFindings, "%d operation(s) over budget", over)
+	}
+	return nil
+}
+
+// globalOptions holds the flags accepted by every command
+type globalOptions struct {
+	timeout time.Duration
+	output  string
+	strict  bool
+	as      string
+	dryRun  bool
+	stage   bool
+	yes     bool
+	storage string
+}
+
+// flagValue returns the value of a --name or --name=value flag at args[*i]
+func flagValue(args []string, i *int, name string) (string, bool, error) {
+	arg := args[*i]
+	if strings.HasPrefix(arg, name+"=") {
+		return strings.TrimPrefix(arg, name+"="), true, nil
+	}
+	if arg != name {
+		return "", false, nil
+	}
+	if *i+1 >= len(args) {
+		return "", false, errorf(exitUsage, "%s requires a value", name)
+	}
+	*i++
+	return args[*i], true, nil
+}
+
+// parseGlobalFlags strips global flags from the arguments
+func parseGlobalFlags(args []string) ([]string, globalOptions, error) {
+	var opts globalOptions
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--timeout"); err != nil {
+			return nil, globalOptions{}, err
+		} else if ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, globalOptions{}, errorf(exitUsage, "Invalid --timeout value \"%s\": %v", value, err)
+			}
+			opts.timeout = d
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--output"); err != nil {
+			return nil, globalOptions{}, err
+		} else if ok {
+			opts.output = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--as"); err != nil {
+			return nil, globalOptions{}, err
+		} else if ok {
+			if strings.TrimSpace(value) == "" {
+				return nil, globalOptions{}, errorf(exitUsage, "Invalid --as value: give a name, an email, or \"Name <email>\"")
+			}
+			opts.as = value
+			continue
+		}
+		if value, ok, err := flagValue(args, &i, "--storage"); err != nil {
+			return nil, globalOptions{}, err
+		} else if ok {
+			opts.storage = value
+			continue
+		}
+		if args[i] == "--strict" {
+			opts.strict = true
+			continue
+		}
+		if args[i] == "--dry-run" {
+			opts.dryRun = true
+			continue
+		}
+		if args[i] == "--stage" {
+			opts.stage = true
+			continue
+		}
+		if args[i] == "--yes" {
+			opts.yes = true
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+
+	return rest, opts, nil
+}
+
+// dryRunCommands are the commands --dry-run supports. Their only effects
+// are on files in the repository and on the git index, which a dry run
+// redirects to a scratch copy.
+var dryRunCommands = []string{"add", "add-headers", "move", "set", "short-ids", "supersede", "transition", "unblock", "update-index"}
+
+// dryRunning is set while a command runs under --dry-run, so effects
+// outside the repository, such as hooks, are only described
+var dryRunning bool
+
+// dryRun runs a command against a scratch copy of the working tree and
+// then prints the moves, frontmatter edits, and other file changes it
+// made there
+func dryRun(run func() error) error {
+	s, err := newScratchTree("--dry-run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(repoPath(s.dir))
+	return s.run(func() error {
+		err := run()
+		printPlannedChanges(s.cwd)
+		return err
+	})
+}
+
+// scratchTree is a copy of the working tree, made in a temporary
+// directory, in which a command can run without touching the real one.
+// git commands see the real repository's history but a copy of its
+// index, so git mv and git add leave the real index alone.
+type scratchTree struct {
+	dir  string // temporary directory holding the copy and its git index
+	tree string // root of the copy
+	root string // root of the real working tree
+	cwd  string // working directory, inside root
+	rel  string // cwd relative to root
+	flag string // the global flag the copy is for
+	env  map[string]string
+
+	copied []string // files copied, relative to root
+}
+
+// newScratchTree copies the tracked and untracked files of the working
+// tree, but not ignored ones, to a scratch directory
+func newScratchTree(flag string) (*scratchTree, error) {
+	var paths []string
+	for _, args := range [][]string{{"--show-toplevel"}, {"--absolute-git-dir"}, {"--git-path", "index"}} {
+		output, err := repoCommand("git", append([]string{"rev-parse"}, args...)...).Output()
+		if err != nil {
+			return nil, errorf(exitEnvironment, "%s needs a git repository", flag)
+		}
+		paths = append(paths, strings.TrimSpace(string(output)))
+	}
+	root, gitDir := paths[0], paths[1]
+	indexFile, err := filepath.Abs(repoPath(paths[2]))
+	if err != nil {
+		return nil, errorf(exitEnvironment, "%v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, errorf(exitEnvironment, "%v", err)
+	}
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil {
+		return nil, errorf(exitEnvironment, "%v", err)
+	}
+
+	scratch, err := os.MkdirTemp("", "zdp-scratch-")
+	if err != nil {
+		return nil, errorf(exitEnvironment, "%v", err)
+	}
+	s := &scratchTree{dir: scratch, tree: filepath.Join(scratch, "tree"), root: root, cwd: cwd, rel: rel, flag: flag}
+	s.env = map[string]string{"GIT_DIR": gitDir, "GIT_WORK_TREE": s.tree, "GIT_INDEX_FILE": filepath.Join(scratch, "index")}
+
+	copyFile := func(src, dst string) error {
+		content, err := os.ReadFile(repoPath(src))
+		if os.IsNotExist(err) {
+			return nil // deleted in the working tree
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(repoPath(filepath.Dir(dst)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(repoPath(dst), content, 0644)
+	}
+	s.copied, err = s.files(root)
+	if err != nil {
+		os.RemoveAll(repoPath(scratch))
+		return nil, err
+	}
+	for _, name := range s.copied {
+		if err := copyFile(filepath.Join(root, name), filepath.Join(s.tree, name)); err != nil {
+			os.RemoveAll(repoPath(scratch))
+			return nil, errorf(exitEnvironment, "Failed to copy %s for %s: %v", name, flag, err)
+		}
+	}
+	if err := copyFile(indexFile, filepath.Join(scratch, "index")); err != nil {
+		os.RemoveAll(repoPath(scratch))
+		return nil, errorf(exitEnvironment, "Failed to copy the git index for %s: %v", flag, err)
+	}
+	if err := os.MkdirAll(repoPath(filepath.Join(s.tree, rel)), 0755); err != nil {
+		os.RemoveAll(repoPath(scratch))
+		return nil, errorf(exitEnvironment, "%v", err)
+	}
+	return s, nil
+}
+
+// files lists the tracked and untracked files of a working tree, but not
+// ignored ones, relative to its root
+func (s *scratchTree) files(root string) ([]string, error) {
+	output, err := repoCommand("git", "-C", root, "ls-files", "-z", "--cached", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, errorf(exitEnvironment, "git ls-files failed: %v", err)
+	}
+	var names []string
+	for _, name := range strings.Split(strings.TrimRight(string(output), "\x00"), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// run runs fn in the copy, at the same place in it as the working
+// directory in the real tree, with effects outside the repository only
+// described
+func (s *scratchTree) run(fn func() error) error {
+	for name, value := range s.env {
+		saved, had := os.LookupEnv(name)
+		os.Setenv(name, value)
+		defer func(name, saved string, had bool) {
+			if had {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name, saved, had)
+	}
+	if err := os.Chdir(filepath.Join(s.tree, s.rel)); err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	defer os.Chdir(s.cwd)
+
+	dryRunning = true
+	defer func() { dryRunning = false }()
+	return fn()
+}
+
+// stageCommands are the commands --stage supports. Like dryRunCommands,
+// their only effects are on files in the repository and on the git index.
+var stageCommands = []string{"add", "add-headers", "adopt", "assets", "decide", "expire", "move", "replace", "set", "short-ids", "supersede", "transition", "unblock", "update-index"}
+
+// staging is set while a command runs under --stage, so its hooks are
+// held until the changes are committed
+var staging bool
+
+// heldHook is a hook a staged command would have run
+type heldHook struct {
+	event, command string
+	input          []byte
+}
+
+// heldHooks are the hooks held during a staged command
+var heldHooks []heldHook
+
+// stagedChange is a file a staged command created, edited, or deleted
+type stagedChange struct {
+	Path     string // relative to the root of the working tree
+	Old, New []byte // nil when the file did not exist, or no longer does
+}
+
+// stage runs a command against a scratch copy of the working tree, shows
+// every file it created, moved, edited, or deleted there as one diff, and
+// once confirmed applies them all as a single git commit. If the command
+// fails, the changes are declined, or the commit is refused, the working
+// tree is left as it was.
+func stage(run func() error, args []string, yes bool) error {
+	s, err := newScratchTree("--stage")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(repoPath(s.dir))
+
+	var after []string
+	staging, commitNotes = true, nil
+	err = s.run(func() error {
+		if err := run(); err != nil {
+			return err
+		}
+		var err error
+		after, err = s.files(s.tree)
+		return err
+	})
+	staging = false
+	hooks := heldHooks
+	heldHooks = nil
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Staged changes discarded; nothing was changed")
+		return err
+	}
+
+	changes, err := s.changes(after)
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to compare the staged changes: %v", err)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(stdout, "\nStaged: nothing to change")
+		return nil
+	}
+	printStagedChanges(changes)
+	if !yes && !confirm(fmt.Sprintf("Apply %d file change(s) as one commit?", len(changes))) {
+		fmt.Fprintln(stdout, "Aborted; nothing was changed")
+		return nil
+	}
+
+	message := withCommitNotes("zdp " + quoteArgs(args))
+	if err := s.apply(changes, message); err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		runHook(h.event, h.command, h.input)
+	}
+	return nil
+}
+
+// changes compares the files left in the copy, after, with the files
+// copied from the real tree
+func (s *scratchTree) changes(after []string) ([]stagedChange, error) {
+	read := func(path string) ([]byte, error) {
+		content, err := os.ReadFile(repoPath(path))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if content == nil && err == nil {
+			content = []byte{}
+		}
+		return content, err
+	}
+
+	seen := make(map[string]bool)
+	var changes []stagedChange
+	for _, name := range append(append([]string{}, s.copied...), after...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		old, err := read(filepath.Join(s.root, name))
+		if err != nil {
+			return nil, err
+		}
+		var new []byte
+		if containsString(after, name) {
+			if new, err = read(filepath.Join(s.tree, name)); err != nil {
+				return nil, err
+			}
+		}
+		if (old == nil) != (new == nil) || !bytes.Equal(old, new) {
+			changes = append(changes, stagedChange{Path: name, Old: old, New: new})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// printStagedChanges shows staged changes as one diff. A file deleted in
+// one directory and created with the same name in another is shown as a
+// move, with any edits made along the way.
+func printStagedChanges(changes []stagedChange) {
+	printDiff := func(old, new []byte) {
+		for _, op := range diffLines(strings.Split(string(old), "\n"), strings.Split(string(new), "\n")) {
+			if op.Kind != ' ' {
+				fmt.Fprintf(stdout, "    %c %s\n", op.Kind, op.Text)
+			}
+		}
+	}
+
+	fmt.Fprintln(stdout, "\nStaged changes:")
+	moved := make(map[string]bool)
+	for _, c := range changes {
+		if c.Old != nil || moved[c.Path] {
+			continue
+		}
+		for _, from := range changes {
+			if from.New == nil && !moved[from.Path] && filepath.Base(from.Path) == filepath.Base(c.Path) {
+				moved[from.Path], moved[c.Path] = true, true
+				fmt.Fprintf(stdout, "  move %s → %s\n", from.Path, c.Path)
+				printDiff(from.Old, c.New)
+				break
+			}
+		}
+	}
+	for _, c := range changes {
+		switch {
+		case moved[c.Path]:
+		case c.Old == nil:
+			fmt.Fprintf(stdout, "  add %s (%d line(s))\n", c.Path, strings.Count(string(c.New), "\n"))
+		case c.New == nil:
+			fmt.Fprintf(stdout, "  delete %s\n", c.Path)
+		default:
+			fmt.Fprintf(stdout, "  change %s:\n", c.Path)
+			printDiff(c.Old, c.New)
+		}
+	}
+	fmt.Fprintln(stdout)
+}
+
+// confirm asks a yes/no question on stdin. Anything but y or yes,
+// including the end of input, is no.
+func confirm(question string) bool {
+	fmt.Fprintf(stdout, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Fprintln(stdout)
+	return false
+}
+
+// apply writes staged changes to the real tree and commits exactly those
+// files. If a write or the commit fails, every file is restored.
+func (s *scratchTree) apply(changes []stagedChange, message string) error {
+	write := func(name string, content []byte) error {
+		dst := filepath.Join(s.root, name)
+		if content == nil {
+			if err := os.Remove(repoPath(dst)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(repoPath(dst)); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.MkdirAll(repoPath(filepath.Dir(dst)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(repoPath(dst), content, mode)
+	}
+	var applied []stagedChange
+	rollback := func() {
+		for i := len(applied) - 1; i >= 0; i-- {
+			if err := write(applied[i].Path, applied[i].Old); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ Failed to restore %s: %v\n", applied[i].Path, err)
+			}
+		}
+	}
+
+	var paths []string
+	for _, c := range changes {
+		if err := write(c.Path, c.New); err != nil {
+			rollback()
+			return errorf(exitEnvironment, "Failed to write %s; nothing was changed: %v", c.Path, err)
+		}
+		applied = append(applied, c)
+		paths = append(paths, c.Path)
+	}
+
+	git := func(args ...string) error {
+		output, err := repoCommand("git", append([]string{"-C", s.root}, args...)...).CombinedOutput()
+		if msg := strings.TrimSpace(string(output)); err != nil && msg != "" {
+			return fmt.Errorf("git %s failed: %s", args[0], msg)
+		} else if err != nil {
+			return fmt.Errorf("git %s failed: %v", args[0], err)
+		}
+		return nil
+	}
+	err := git(append([]string{"add", "-A", "--"}, paths...)...)
+	if err == nil {
+		err = git(append([]string{"commit", "-q", "-m", message, "--"}, paths...)...)
+	}
+	if err != nil {
+		git(append([]string{"reset", "-q", "--"}, paths...)...)
+		rollback()
+		return errorf(exitConflict, "%v; nothing was changed", err)
+	}
+
+	output, _ := repoCommand("git", "-C", s.root, "rev-parse", "--short", "HEAD").Output()
+	commit := strings.TrimSpace(string(output))
+	opResult.Commits = append(opResult.Commits, commit)
+	subject, _, _ := strings.Cut(message, "\n")
+	fmt.Fprintf(stdout, "Committed %d file change(s) as %s: %s\n", len(changes), commit, subject)
+	return nil
+}
+
+// printPlannedChanges summarizes what a dry run changed in the scratch
+// copy (the working directory) compared with the real tree at orig
+func printPlannedChanges(orig string) {
+	var lines []string
+	for _, m := range opResult.Moved {
+		lines = append(lines, fmt.Sprintf("  move %s → %s", m.From, m.To))
+	}
+	edited := make(map[string]bool)
+	for _, c := range opResult.FieldsChanged {
+		edited[c.File] = true
+		if c.Old == "" {
+			lines = append(lines, fmt.Sprintf("  set %s in %s: %s", c.Field, c.File, c.New))
+		} else {
+			lines = append(lines, fmt.Sprintf("  set %s in %s: %s → %s", c.Field, c.File, c.Old, c.New))
+		}
+	}
+
+	// Files other than documents, such as the index, are shown as a diff
+	for _, path := range opResult.FilesWritten {
+		if edited[path] {
+			continue
+		}
+		after, err := os.ReadFile(repoPath(path))
+		if err != nil {
+			continue
+		}
+		before, _ := os.ReadFile(repoPath(filepath.Join(orig, path)))
+		if string(before) == string(after) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  change %s:", path))
+		for _, op := range diffLines(strings.Split(string(before), "\n"), strings.Split(string(after), "\n")) {
+			if op.Kind != ' ' {
+				lines = append(lines, fmt.Sprintf("    %c %s", op.Kind, op.Text))
+			}
+		}
+	}
+	for _, path := range opResult.GitStaged {
+		lines = append(lines, "  stage "+path)
+	}
+
+	if len(lines) == 0 {
+		fmt.Fprintln(stdout, "\nDry run: nothing would change")
+		return
+	}
+	fmt.Fprintf(stdout, "\nDry run: nothing was changed. The command would:\n%s\n", strings.Join(lines, "\n"))
+}
+
+// replCommands are the commands a repl session runs: the commands that
+// only read documents, and those --stage supports, whose changes the
+// session holds until it commits them
+var replCommands = append([]string{"churn", "compare", "decisions", "effort", "explain", "get", "health", "heatmap", "journal", "list", "next", "read", "review", "risks", "search", "show", "simulate", "states", "terms", "validate", "versions", "whoami"}, stageCommands...)
+
+// replHelp describes the commands of the session itself
+const replHelp = `Session commands:
+  status             show the changes staged so far
+  commit [message]   apply the staged changes as one git commit
+  discard            drop the staged changes
+  help [command]     this list, or a zdp command's help
+  quit               end the session (also exit, or end of input)
+
+zdp commands: %s`
+
+// replCommand runs an interactive session: each line of stdin is a zdp
+// command, run in this process against a scratch copy of the working
+// tree with the documents' metadata kept in memory between commands.
+// Changes accumulate in the copy, where later commands see them, until
+// "commit" applies them all as one git commit; hooks are held until then.
+func replCommand(args []string) error {
+	if len(args) > 0 {
+		return errorf(exitUsage, "Usage: zdp repl")
+	}
+	interactive := false
+	if info, err := os.Stdin.Stat(); err == nil {
+		interactive = info.Mode()&os.ModeCharDevice != 0
+	}
+
+	docCache = &metadataCache{entries: map[string]cachedDoc{}}
+	defer func() { docCache = nil }()
+	s, err := newScratchTree("repl")
+	if err != nil {
+		return err
+	}
+	defer func() { os.RemoveAll(repoPath(s.dir)) }()
+
+	staging = true
+	defer func() { staging = false }()
+	var pending []string // command lines that may have changed files
+	var failed []error
+
+	changes := func() ([]stagedChange, error) {
+		var after []string
+		err := s.run(func() error {
+			var err error
+			after, err = s.files(s.tree)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		list, err := s.changes(after)
+		if err != nil {
+			return nil, errorf(exitEnvironment, "Failed to compare the staged changes: %v", err)
+		}
+		return list, nil
+	}
+	reset := func() error {
+		os.RemoveAll(repoPath(s.dir))
+		next, err := newScratchTree("repl")
+		if err != nil {
+			return err
+		}
+		s = next
+		pending, heldHooks, commitNotes = nil, nil, nil
+		return nil
+	}
+	commit := func(message string) error {
+		list, err := changes()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Fprintln(stdout, "Nothing to commit")
+			return nil
+		}
+		if message == "" {
+			message = "zdp repl\n\n" + strings.Join(pending, "\n")
+		}
+		printStagedChanges(list)
+		if err := s.apply(list, withCommitNotes(message)); err != nil {
+			return err
+		}
+		hooks := heldHooks
+		if err := reset(); err != nil {
+			return err
+		}
+		for _, h := range hooks {
+			runHook(h.event, h.command, h.input)
+		}
+		return nil
+	}
+
+	// session runs one line
+	session := func(words []string) (quit bool, err error) {
+		switch words[0] {
+		case "quit", "exit":
+			list, err := changes()
+			if err != nil {
+				return false, err
+			}
+			if len(list) > 0 && interactive {
+				if !confirm(fmt.Sprintf("Discard %d staged file change(s)?", len(list))) {
+					return false, nil
+				}
+			}
+			return true, nil
+		case "status":
+			list, err := changes()
+			if err != nil {
+				return false, err
+			}
+			if len(list) == 0 {
+				fmt.Fprintln(stdout, "Nothing staged")
+			} else {
+				printStagedChanges(list)
+			}
+			return false, nil
+		case "commit":
+			return false, commit(strings.Join(words[1:], " "))
+		case "discard":
+			list, err := changes()
+			if err != nil {
+				return false, err
+			}
+			if err := reset(); err != nil {
+				return false, err
+			}
+			fmt.Fprintf(stdout, "Discarded %d staged file change(s)\n", len(list))
+			return false, nil
+		case "help", "?":
+			if len(words) == 1 {
+				fmt.Fprintf(stdout, replHelp+"\n", strings.Join(replCommands, ", "))
+				return false, nil
+			}
+		}
+
+		name := words[0]
+		if looksLikeDocument(name) && findCommand(name) == nil {
+			name = "transition"
+		}
+		if name != "help" && !containsString(replCommands, name) {
+			return false, errorf(exitUsage, "\"%s\" can't run in a repl session; run \"help\" for the commands it can", words[0])
+		}
+		if containsString(stageCommands, name) {
+			pending = append(pending, "zdp "+quoteArgs(words))
+		}
+		ctx, cancel := operationContext(0)
+		defer cancel()
+		return false, s.run(func() error { return runCommand(ctx, words) })
+	}
+
+	if interactive {
+		fmt.Fprintln(stdout, "zdp repl: run zdp commands without \"zdp\"; \"help\" lists them. Changes are staged until \"commit\".")
+	}
+	lines := newPromptReader(os.Stdin)
+	for {
+		if interactive {
+			fmt.Fprint(stdout, "zdp> ")
+		}
+		line, ok := lines.read()
+		if !ok {
+			if interactive {
+				fmt.Fprintln(stdout)
+			}
+			break
+		}
+		words, err := splitCommandLine(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = append(failed, errorf(exitUsage, "%v", err))
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		quit, err := session(words)
+		reportSkippedDocs()
+		skippedDocs, skippedErrs = nil, map[string]error{}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			failed = append(failed, err)
+		}
+		if quit {
+			break
+		}
+	}
+
+	list, err := changes()
+	if err != nil {
+		return err
+	}
+	if n := len(list); n > 0 {
+		fmt.Fprintf(os.Stderr, "Discarded %d staged file change(s) that were not committed\n", n)
+		if !interactive {
+			return errorf(exitConflict, "the session ended without committing its changes")
+		}
+	}
+	if len(failed) > 0 && !interactive {
+		return errorf(ExitCode(failed[0]), "%d command(s) in the session failed", len(failed))
+	}
+	return nil
+}
+
+// promptReader reads the lines of a repl session. Commands run in the
+// session get their own interrupt handling from operationContext, but
+// nothing reads a context at the prompt, so an interrupt arriving while
+// read waits ends the input instead, like end of file.
+type promptReader struct {
+	want  chan struct{}
+	lines chan promptLine
+}
+
+// promptLine is one result of scanning the input
+type promptLine struct {
+	text string
+	ok   bool
+}
+
+// newPromptReader starts reading r a line at a time. Each line is only
+// scanned once read asks for it, so commands such as quit's confirmation
+// can read the input in between.
+func newPromptReader(r io.Reader) *promptReader {
+	p := &promptReader{want: make(chan struct{}), lines: make(chan promptLine)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	go func() {
+		for range p.want {
+			ok := scanner.Scan()
+			p.lines <- promptLine{scanner.Text(), ok}
+			if !ok {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// read returns the next line, or false at the end of the input or when
+// the process is interrupted while waiting for it
+func (p *promptReader) read() (string, bool) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	p.want <- struct{}{}
+	select {
+	case line := <-p.lines:
+		return line.text, line.ok
+	case <-interrupts:
+		return "", false
+	}
+}
+
+// quoteArgs joins command-line words, quoting those a shell would split
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitCommandLine splits a line into words the way a shell would for
+// simple commands: single quotes keep text as is, double quotes allow
+// backslash escapes, and a # starting a word begins a comment
+func splitCommandLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated \" quote")
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Main runs the zdp command line with the arguments after the program
+// name, exiting the process with a status from "Exit Status" in README.md
+// when the command fails
+func Main(args []string) {
+	defer handleExit()
+	if err := runMain(args); err != nil {
+		exitWith(err)
+	}
+}
+
+// runMain runs the command line, returning the error Main exits with
+func runMain(args []string) error {
+	args, opts, err := parseGlobalFlags(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(".zdp.yaml")
+	if err != nil {
+		return errorf(exitEnvironment, "Invalid .zdp.yaml: %v", err)
+	}
+	setConfig(cfg)
+	if opts.as != "" {
+		id, _ := parsePerson(opts.as).withAliases()
+		actingAs = &id
+	}
+
+	ctx, cancel := operationContext(opts.timeout)
+	defer cancel()
+
+	run := func() error { return runCommand(ctx, args) }
+	if opts.dryRun {
+		if len(args) == 0 || !(containsString(dryRunCommands, args[0]) || looksLikeDocument(args[0])) {
+			return errorf(exitUsage, "--dry-run works with: %s", strings.Join(dryRunCommands, ", "))
+		}
+		inner := run
+		run = func() error { return dryRun(inner) }
+	}
+	if opts.stage {
+		if opts.dryRun || len(args) == 0 || !(containsString(stageCommands, args[0]) || looksLikeDocument(args[0])) {
+			return errorf(exitUsage, "--stage works with: %s", strings.Join(stageCommands, ", "))
+		}
+		inner := run
+		run = func() error { return stage(inner, args, opts.yes) }
+	}
+	if opts.storage != "" {
+		if opts.dryRun || opts.stage || len(args) == 0 || !containsString(storageCommands, args[0]) {
+			return errorf(exitUsage, "--storage works with: %s", strings.Join(storageCommands, ", "))
+		}
+		run = func() error {
+			return withStorage(ctx, opts.storage, args, func(args []string) error { return runCommand(ctx, args) })
+		}
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	reportSkippedDocs()
+
+	if opts.output != "" {
+		if err := writeOperationResult(opts.output, args); err != nil {
+			return errorf(exitEnvironment, "Failed to write operation result: %v", err)
+		}
+	}
+
+	if opts.strict && len(warnings) > 0 {
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		return errorf(exitFindings, "%d warning(s) treated as failures (--strict)", len(warnings))
+	}
+	return nil
+}
+
+// exitWith prints a failed command's error and exits with its code
+func exitWith(err error) {
+	reportSkippedDocs()
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	os.Exit(ExitCode(err))
+}
+
+// handleExit reports a panic, which is always a bug, with its stack trace
+// and exitInternal, so scripts can tell it from a usage error
+func handleExit() {
+	if r := recover(); r != nil {
+		fmt.Fprintf(os.Stderr, "Internal error: %v\n\n%s\nPlease report this as a bug.\n", r, debug.Stack())
+		os.Exit(exitInternal)
+	}
+}
+
+// digitsRe matches a run of digits
+var digitsRe = regexp.MustCompile(`\d+`)
+
+// docRefs parses a field naming other documents, such as supersedes:
+// "0042", "42", "[0042, 0043]", or "None", into four-digit numbers
+func docRefs(value string) []string {
+	var refs []string
+	for _, n := range digitsRe.FindAllString(value, -1) {
+		num, _ := strconv.Atoi(n)
+		if ref := fmt.Sprintf("%04d", num); !containsString(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// supersededByNote is the index annotation of a document's superseded-by
+func supersededByNote(doc *Document) string {
+	return strings.Join(docRefs(doc.Fields["superseded-by"]), ", ")
+}
+
+// noneIfEmpty shows an empty field value as None
+func noneIfEmpty(value string) string {
+	if value == "" {
+		return "None"
+	}
+	return value
+}
+
+// supersessions is the supersession graph of the documents. An edge from
+// B to A exists when A lists B in supersedes or B lists A in superseded-by,
+// so a chain is found even when only one side records it.
+type supersessions struct {
+	docs         map[string]*Document
+	successors   map[string][]string // documents superseding each number
+	predecessors map[string][]string // documents each number supersedes
+}
+
+// newSupersessions builds the supersession graph of docs
+func newSupersessions(docs []*Document) *supersessions {
+	g := &supersessions{docs: map[string]*Document{}, successors: map[string][]string{}, predecessors: map[string][]string{}}
+	link := func(old, replacement string) {
+		if !containsString(g.successors[old], replacement) {
+			g.successors[old] = append(g.successors[old], replacement)
+			g.predecessors[replacement] = append(g.predecessors[replacement], old)
+		}
+	}
+	for _, doc := range docs {
+		g.docs[doc.Number] = doc
+		for _, old := range docRefs(doc.Fields["supersedes"]) {
+			link(old, doc.Number)
+		}
+		for _, replacement := range docRefs(doc.Fields["superseded-by"]) {
+			link(doc.Number, replacement)
+		}
+	}
+	for _, m := range []map[string][]string{g.successors, g.predecessors} {
+		for _, list := range m {
+			sort.Slice(list, func(i, j int) bool { return docNumberLess(list[i], list[j]) })
+		}
+	}
+	return g
+}
+
+// lineage returns every document connected to number through
+// supersession, oldest first
+func (g *supersessions) lineage(number string) []string {
+	seen := map[string]bool{}
+	var walk func(n string)
+	walk = func(n string) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, next := range append(append([]string{}, g.predecessors[n]...), g.successors[n]...) {
+			walk(next)
+		}
+	}
+	walk(number)
+
+	var numbers []string
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return docNumberLess(numbers[i], numbers[j]) })
+	return numbers
+}
+
+// supersessionDiagnostics checks supersession fields across documents:
+// references to missing documents, one-sided links, links the other side
+// contradicts, forks, cycles, and superseded documents in the wrong state.
+// Findings are keyed by document path.
+func supersessionDiagnostics(docs []*Document) map[string][]diagnostic {
+	g := newSupersessions(docs)
+	found := map[string][]diagnostic{}
+	add := func(doc *Document, field, severity, code, format string, args ...interface{}) {
+		key := filepath.Clean(doc.Path)
+		found[key] = append(found[key], diagnostic{frontmatterFieldLine(doc.Path, field), severity, code, fmt.Sprintf(format, args...)})
+	}
+
+	for _, doc := range docs {
+		check := func(field, reverse string) {
+			for _, ref := range docRefs(doc.Fields[field]) {
+				other, ok := g.docs[ref]
+				switch {
+				case ref == doc.Number:
+					add(doc, field, "error", "ZDP019", "%s names the document itself", field)
+				case !ok:
+					add(doc, field, "error", "ZDP020", "%s %s, which does not exist", field, ref)
+				case len(docRefs(other.Fields[reverse])) == 0:
+					add(doc, field, "warning", "ZDP021", "%s %s, but %s has no %s; run zdp chain --fix", field, ref, ref, reverse)
+				case !containsString(docRefs(other.Fields[reverse]), doc.Number):
+					add(doc, field, "error", "ZDP022", "%s %s, but %s has %s %s", field, ref, ref, reverse, other.Fields[reverse])
+				}
+			}
+		}
+		check("supersedes", "superseded-by")
+		check("superseded-by", "supersedes")
+
+		if next := g.successors[doc.Number]; len(next) > 1 {
+			add(doc, "superseded-by", "warning", "ZDP023", "superseded by several documents (%s); the chain forks", strings.Join(next, ", "))
+		}
+		if len(docRefs(doc.Fields["superseded-by"])) > 0 && normalizeState(doc.State) != "superseded" {
+			add(doc, "state", "warning", "ZDP024", "has superseded-by but its state is %s", doc.State)
+		}
+
+		// A cycle leads back to the document through its successors
+		path := []string{doc.Number}
+		var cycle []string
+		var walk func(n string)
+		walk = func(n string) {
+			for _, next := range g.successors[n] {
+				if cycle != nil {
+					return
+				}
+				if next == doc.Number {
+					cycle = append(append([]string{}, path...), next)
+					return
+				}
+				if !containsString(path, next) {
+					path = append(path, next)
+					walk(next)
+					path = path[:len(path)-1]
+				}
+			}
+		}
+		walk(doc.Number)
+		if cycle != nil {
+			add(doc, "superseded-by", "error", "ZDP025", "supersession cycle: %s", strings.Join(cycle, " → "))
+		}
+	}
+	return found
+}
+
+// frontmatterFieldLine returns the line of a frontmatter field in a
+// file, or 1 when it has none
+func frontmatterFieldLine(path, key string) int {
+	header, _ := readFrontmatter(path)
+	for i, line := range strings.Split(header, "\n") {
+		if strings.HasPrefix(line, key+":") {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// dependencyDiagnostics checks the depends-on and blocked-by fields of
+// every document: each number must name another document that exists
+// and has not been rejected or withdrawn
+func dependencyDiagnostics(docs []*Document) map[string][]diagnostic {
+	byNumber := make(map[string]*Document)
+	for _, doc := range docs {
+		byNumber[doc.Number] = doc
+	}
+	fields := []struct{ name, missing, dead string }{
+		{"depends-on", "ZDP027", "ZDP028"},
+		{"blocked-by", "ZDP034", "ZDP035"},
+	}
+	found := map[string][]diagnostic{}
+	for _, doc := range docs {
+		for _, field := range fields {
+			for _, ref := range docRefs(doc.Fields[field.name]) {
+				other, ok := byNumber[ref]
+				var d diagnostic
+				switch {
+				case ref == doc.Number:
+					d = diagnostic{Severity: "error", Code: field.missing, Message: field.name + " names the document itself"}
+				case !ok:
+					d = diagnostic{Severity: "error", Code: field.missing, Message: fmt.Sprintf("%s %s, which does not exist", field.name, ref)}
+				case normalizeState(other.State) == "rejected" || normalizeState(other.State) == "withdrawn":
+					d = diagnostic{Severity: "error", Code: field.dead, Message: fmt.Sprintf("%s %s, which is %s", field.name, ref, other.State)}
+				default:
+					continue
+				}
+				d.Line = frontmatterFieldLine(doc.Path, field.name)
+				key := filepath.Clean(doc.Path)
+				found[key] = append(found[key], d)
+			}
+		}
+	}
+	return found
+}
+
+// wikilinkRe matches a short reference to another document in a body:
+// [[0042]], [[42]], [[tail-calls]], or [[0042|link text]]
+var wikilinkRe = regexp.MustCompile(`\[\[(\d+|[a-z][a-z0-9]*(?:-[a-z0-9]+)*)(?:\|([^\]\n]+))?\]\]`)
+
+// replaceWikilinks calls replace for each wikilink in a body outside code
+// blocks and code spans, with the referenced number or short ID, the
+// link text if given, and the 1-based line, substituting what it returns
+func replaceWikilinks(body string, replace func(ref, label string, line int) string) string {
+	lines := strings.Split(body, "\n")
+	var fence codeFence
+	for i, line := range lines {
+		if fence.inCode(line) || !strings.Contains(line, "[[") {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		spans := append(inlineCodeRe.FindAllStringIndex(line, -1), []int{len(line), len(line)})
+		for _, span := range spans {
+			b.WriteString(wikilinkRe.ReplaceAllStringFunc(line[last:span[0]], func(m string) string {
+				sub := wikilinkRe.FindStringSubmatch(m)
+				ref := sub[1]
+				if !shortIDRe.MatchString(ref) {
+					ref = docRefs(ref)[0]
+				}
+				return replace(ref, strings.TrimSpace(sub[2]), i+1)
+			}))
+			b.WriteString(line[span[0]:span[1]])
+			last = span[1]
+		}
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resolveWikilinks rewrites the wikilinks of a body in dir as markdown
+// links to the documents' current paths, titled with their number and
+// title unless the link gives its own text. References to documents that
+// don't exist are left as written and returned.
+func resolveWikilinks(body, dir string, byNumber map[string]*Document) (string, []string) {
+	var missing []string
+	resolved := replaceWikilinks(body, func(ref, label string, line int) string {
+		doc := byNumber[ref]
+		if doc == nil && shortIDRe.MatchString(ref) {
+			for _, candidate := range byNumber {
+				if docShortID(candidate) == ref {
+					doc = candidate
+				}
+			}
+		}
+		if doc == nil {
+			missing = append(missing, ref)
+			if label != "" {
+				return fmt.Sprintf("[[%s|%s]]", ref, label)
+			}
+			return fmt.Sprintf("[[%s]]", ref)
+		}
+		if label == "" {
+			label = doc.Number + " " + displayTitle(doc.Title)
+		}
+		target := filepath.ToSlash(doc.Path)
+		if rel, err := filepath.Rel(dir, doc.Path); err == nil {
+			target = filepath.ToSlash(rel)
+		}
+		return fmt.Sprintf("[%s](%s)", label, target)
+	})
+	return resolved, missing
+}
+
+// wikilinkDiagnostics checks the wikilinks in the bodies of the checked
+// documents against every document in docs
+func wikilinkDiagnostics(checked, docs []*Document) map[string][]diagnostic {
+	exists := takenShortIDs(docs)
+	for _, doc := range docs {
+		exists[doc.Number] = true
+	}
+	found := map[string][]diagnostic{}
+	for _, doc := range checked {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			continue
+		}
+		// Line numbers count from the top of the file, frontmatter included
+		text := string(content)
+		offset := 0
+		if m := frontmatterRe.FindStringIndex(text); m != nil {
+			offset = strings.Count(text[:m[1]], "\n")
+			text = text[m[1]:]
+		}
+		key := filepath.Clean(doc.Path)
+		replaceWikilinks(text, func(ref, label string, line int) string {
+			if !exists[ref] {
+				found[key] = append(found[key], diagnostic{line + offset, "error", "ZDP032", fmt.Sprintf("[[%s]] names a document that does not exist", ref)})
+			}
+			return ""
+		})
+	}
+	return found
+}
+
+// shortIDDiagnostics reports malformed short IDs and short IDs that
+// several documents use
+func shortIDDiagnostics(docs []*Document) map[string][]diagnostic {
+	users := make(map[string][]string)
+	for _, doc := range docs {
+		if id := docShortID(doc); id != "" {
+			users[id] = append(users[id], doc.Number)
+		}
+	}
+	found := map[string][]diagnostic{}
+	for _, doc := range docs {
+		raw := strings.TrimSpace(doc.Fields["short-id"])
+		if raw == "" {
+			continue
+		}
+		id := docShortID(doc)
+		key := filepath.Clean(doc.Path)
+		line := frontmatterFieldLine(doc.Path, "short-id")
+		if !shortIDRe.MatchString(id) {
+			found[key] = append(found[key], diagnostic{line, "error", "ZDP033", fmt.Sprintf("malformed short-id %s (use lowercase words joined by hyphens, starting with a letter)", raw)})
+			continue
+		}
+		var others []string
+		for _, number := range users[id] {
+			if number != doc.Number {
+				others = append(others, number)
+			}
+		}
+		if len(others) > 0 {
+			found[key] = append(found[key], diagnostic{line, "error", "ZDP033", fmt.Sprintf("short-id %s is also used by %s", id, strings.Join(others, ", "))})
+		}
+	}
+	return found
+}
+
+// dependents returns the numbers of the documents listing number in
+// their depends-on field
+func dependents(docs []*Document, number string) []string {
+	var list []string
+	for _, doc := range docs {
+		if containsString(docRefs(doc.Fields["depends-on"]), number) && !containsString(list, doc.Number) {
+			list = append(list, doc.Number)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return docNumberLess(list[i], list[j]) })
+	return list
+}
+
+// unblockingStates are the states in which a proposal no longer holds up
+// the documents that list it in blocked-by
+var unblockingStates = []string{"accepted", "active", "final", "superseded"}
+
+// pendingBlockers returns the documents in doc's blocked-by that haven't
+// reached Accepted, as "0012 (Draft)"
+func pendingBlockers(doc *Document, byNumber map[string]*Document) []string {
+	var pending []string
+	for _, ref := range docRefs(doc.Fields["blocked-by"]) {
+		blocker, ok := byNumber[ref]
+		switch {
+		case !ok:
+			pending = append(pending, ref+" (missing)")
+		case !containsString(unblockingStates, normalizeState(blocker.State)):
+			pending = append(pending, fmt.Sprintf("%s (%s)", ref, blocker.State))
+		}
+	}
+	return pending
+}
+
+// blockedDoc is an Accepted document with a blocked-by field, as
+// unblock reports it
+type blockedDoc struct {
+	listedDoc
+	BlockedBy []string `json:"blocked_by"`
+	Waiting   []string `json:"waiting"` // blockers that haven't reached Accepted
+}
+
+// blockedDocuments returns the Accepted documents with a blocked-by
+// field, in number order. Those with nothing left to wait for are ready
+// to become Active.
+func blockedDocuments(docs []*Document) []blockedDoc {
+	byNumber := make(map[string]*Document)
+	for _, doc := range docs {
+		byNumber[doc.Number] = doc
+	}
+	blocked := []blockedDoc{}
+	for _, doc := range docs {
+		refs := docRefs(doc.Fields["blocked-by"])
+		if len(refs) == 0 || normalizeState(doc.State) != "accepted" {
+			continue
+		}
+		waiting := pendingBlockers(doc, byNumber)
+		if waiting == nil {
+			waiting = []string{}
+		}
+		blocked = append(blocked, blockedDoc{listedDoc: newListedDoc(doc), BlockedBy: refs, Waiting: waiting})
+	}
+	sort.Slice(blocked, func(i, j int) bool { return docNumberLess(blocked[i].Number, blocked[j].Number) })
+	return blocked
+}
+
+// unblockDocument makes a document whose blockers have all been accepted
+// Active, giving them as the reason
+func unblockDocument(b blockedDoc) error {
+	return transitionDocument(b.Path, "Active", transitionOptions{Reason: "Unblocked: " + strings.Join(b.BlockedBy, ", ") + " reached Accepted"})
+}
+
+// unblockAfterTransition runs once number has moved to state: Accepted
+// documents it was the last blocker of, or that number itself when it
+// was just accepted with nothing left to wait for, are made Active when
+// transitions.auto-unblock is set, and otherwise pointed out
+func unblockAfterTransition(number, state string) error {
+	if !containsString(unblockingStates, normalizeState(state)) {
+		return nil
+	}
+	for _, b := range blockedDocuments(scanDocuments()) {
+		if len(b.Waiting) > 0 || !(b.Number == number || containsString(b.BlockedBy, number)) {
+			continue
+		}
+		if !config.AutoUnblock {
+			fmt.Fprintf(stdout, "%s is no longer blocked; make it Active with: zdp unblock --apply\n", b.Number)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s is no longer blocked; making it Active (transitions.auto-unblock)\n", b.Number)
+		if err := unblockDocument(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unblockCommand parses the arguments of "unblock [--apply] [--format
+// text|json]" and lists the Accepted documents waiting on blocked-by,
+// making those with nothing left to wait for Active when --apply is given
+func unblockCommand(args []string) error {
+	apply, asJSON := false, false
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if args[i] != "--apply" {
+			return errorf(exitUsage, "Usage: zdp unblock [--apply] [--format text|json]")
+		}
+		apply = true
+	}
+
+	blocked := blockedDocuments(scanDocuments())
+	var ready, waiting []blockedDoc
+	for _, b := range blocked {
+		if len(b.Waiting) == 0 {
+			ready = append(ready, b)
+		} else {
+			waiting = append(waiting, b)
+		}
+	}
+	if apply {
+		for _, b := range ready {
+			var err error
+			if asJSON {
+				captureStdout(func() { err = unblockDocument(b) })
+			} else {
+				err = unblockDocument(b)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if asJSON {
+		return printJSON(blocked)
+	}
+	if len(blocked) == 0 {
+		fmt.Fprintln(stdout, "No Accepted document has a blocked-by field")
+		return nil
+	}
+	if len(ready) > 0 && !apply {
+		fmt.Fprintln(stdout, "Ready to become Active (run with --apply):")
+		for _, b := range ready {
+			fmt.Fprintf(stdout, "  %s  %s (blocked by %s, all Accepted)\n", b.Number, displayTitle(b.Title), strings.Join(b.BlockedBy, ", "))
+		}
+	}
+	if len(waiting) > 0 {
+		fmt.Fprintln(stdout, "Waiting:")
+		for _, b := range waiting {
+			fmt.Fprintf(stdout, "  %s  %s: %s\n", b.Number, displayTitle(b.Title), strings.Join(b.Waiting, ", "))
+		}
+	}
+	return nil
+}
+
+// stateColors are the node colors of each built-in state in graph;
+// other states are drawn in gray
+var stateColors = map[string]string{
+	"draft":        "#e0e0e0",
+	"under review": "#fff3b0",
+	"revised":      "#ffe0a3",
+	"accepted":     "#c6f0c2",
+	"active":       "#9fd8f5",
+	"final":        "#6cc070",
+	"deferred":     "#d8c8f0",
+	"rejected":     "#f4a6a6",
+	"withdrawn":    "#c8c8c8",
+	"superseded":   "#b8b8d8",
+}
+
+// graphNode is a document in "graph --format json"
+type graphNode struct {
+	Number string `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Path   string `json:"path"`
+}
+
+// graphEdge links two documents: From supersedes or depends on To
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"` // supersedes or depends-on
+}
+
+// graphCommand parses the arguments of "graph [--format dot|json]"
+func graphCommand(args []string) error {
+	format := "dot"
+	for i := 0; i < len(args); i++ {
+		if value, ok, err := flagValue(args, &i, "--format"); err != nil {
+			return err
+		} else if ok {
+			if value != "dot" && value != "json" {
+				return errorf(exitUsage, "Invalid --format value \"%s\" (use dot or json)", value)
+			}
+			format = value
+			continue
+		}
+		return errorf(exitUsage, "Usage: zdp graph [--format dot|json]")
+	}
+
+	docs := scanDocuments()
+	sort.SliceStable(docs, func(i, j int) bool { return docNumberLess(docs[i].Number, docs[j].Number) })
+	nodes, edges := documentGraph(docs)
+	if format == "json" {
+		return printJSON(struct {
+			Nodes []graphNode `json:"nodes"`
+			Edges []graphEdge `json:"edges"`
+		}{nodes, edges})
+	}
+	fmt.Fprint(stdout, renderDOT(nodes, edges))
+	return nil
+}
+
+// documentGraph returns the documents as nodes, and their supersession
+// and dependency links as edges. Supersession counts either side's
+// field, as in chain; edges to missing documents are left out.
+func documentGraph(docs []*Document) ([]graphNode, []graphEdge) {
+	nodes := []graphNode{}
+	known := make(map[string]bool)
+	for _, doc := range docs {
+		if known[doc.Number] {
+			continue
+		}
+		known[doc.Number] = true
+		nodes = append(nodes, graphNode{doc.Number, displayTitle(doc.Title), doc.State, filepath.ToSlash(doc.Path)})
+	}
+
+	edges := []graphEdge{}
+	g := newSupersessions(docs)
+	for _, node := range nodes {
+		for _, old := range g.predecessors[node.Number] {
+			if known[old] && old != node.Number {
+				edges = append(edges, graphEdge{node.Number, old, "supersedes"})
+			}
+		}
+	}
+	seen := make(map[graphEdge]bool)
+	for _, doc := range docs {
+		for _, ref := range docRefs(doc.Fields["depends-on"]) {
+			edge := graphEdge{doc.Number, ref, "depends-on"}
+			if known[ref] && ref != doc.Number && !seen[edge] {
+				seen[edge] = true
+				edges = append(edges, edge)
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// renderDOT renders the document graph for Graphviz: nodes are filled
+// with their state's color, supersession edges are solid and dependency
+// edges dashed
+func renderDOT(nodes []graphNode, edges []graphEdge) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace
+	quote := func(s string) string { return `"` + escape(s) + `"` }
+
+	var b strings.Builder
+	b.WriteString("digraph designs {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n\n")
+
+	// One legend entry per state in use, in lifecycle order
+	var used []string
+	for _, node := range nodes {
+		if !containsString(used, node.State) {
+			used = append(used, node.State)
+		}
+	}
+	sort.SliceStable(used, func(i, j int) bool { return stateRank(used[i]) < stateRank(used[j]) })
+	color := func(state string) string {
+		if c, ok := stateColors[normalizeState(state)]; ok {
+			return c
+		}
+		return "#f0f0f0"
+	}
+
+	for _, node := range nodes {
+		label := `"` + escape(node.Number) + `\n` + escape(node.Title) + `"`
+		fmt.Fprintf(&b, "  %s [label=%s, fillcolor=%s, tooltip=%s];\n", quote(node.Number), label, quote(color(node.State)), quote(node.State))
+	}
+	if len(edges) > 0 {
+		b.WriteString("\n")
+	}
+	for _, edge := range edges {
+		style := "solid"
+		if edge.Kind == "depends-on" {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, style=%s];\n", quote(edge.From), quote(edge.To), quote(edge.Kind), style)
+	}
+
+	b.WriteString("\n  subgraph cluster_legend {\n    label=\"States\";\n")
+	for i, state := range used {
+		fmt.Fprintf(&b, "    legend%d [label=%s, fillcolor=%s];\n", i, quote(state), quote(color(state)))
+	}
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
+
+// chainCommand parses the arguments of "chain <doc>" and "chain --fix"
+func chainCommand(args []string) error {
+	if len(args) == 1 && args[0] == "--fix" {
+		return fixSupersessionLinks()
+	}
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		return errorf(exitUsage, "Usage: zdp chain <doc|number> | zdp chain --fix")
+	}
+	doc, err := findDocument(args[0])
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	printChain(newSupersessions(scanDocuments()), doc.Number)
+	return nil
+}
+
+// paths returns every route through the lineage of number, from a
+// document that supersedes nothing to one that nothing supersedes. A
+// route that loops back ends with the repeated number, and cycle is set.
+func (g *supersessions) paths(number string) (routes [][]string, cycle bool) {
+	members := g.lineage(number)
+	visited := map[string]bool{}
+	var walk func(route []string)
+	walk = func(route []string) {
+		n := route[len(route)-1]
+		visited[n] = true
+		if len(g.successors[n]) == 0 {
+			routes = append(routes, route)
+			return
+		}
+		for _, next := range g.successors[n] {
+			extended := append(append([]string{}, route...), next)
+			if containsString(route, next) {
+				routes = append(routes, extended)
+				cycle = true
+				continue
+			}
+			walk(extended)
+		}
+	}
+	for _, n := range members {
+		if len(g.predecessors[n]) == 0 {
+			walk([]string{n})
+		}
+	}
+	// Members of a cycle have no root; start from the lowest number
+	for _, n := range members {
+		if !visited[n] {
+			walk([]string{n})
+		}
+	}
+	return routes, cycle
+}
+
+// lineageReport is the JSON form of "lineage"
+type lineageReport struct {
+	Number   string              `json:"number"`
+	Paths    [][]string          `json:"paths"`
+	Missing  []string            `json:"missing"`
+	Cycle    bool                `json:"cycle"`
+	Problems []validationFinding `json:"problems"`
+}
+
+// lineageCommand parses the arguments of
+// "lineage <doc|number> [--format text|json]"
+func lineageCommand(args []string) error {
+	usage := "Usage: zdp lineage <doc|number> [--format text|json]"
+	var ref string
+	asJSON := false
+	for i := 0; i < len(args); i++ {
+		if isFlag, json, err := formatFlag(args, &i); err != nil {
+			return err
+		} else if isFlag {
+			asJSON = json
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") || ref != "" {
+			return errorf(exitUsage, "%s", usage)
+		}
+		ref = args[i]
+	}
+	if ref == "" {
+		return errorf(exitUsage, "%s", usage)
+	}
+	doc, err := findDocument(ref)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+
+	docs := scanDocuments()
+	g := newSupersessions(docs)
+	routes, cycle := g.paths(doc.Number)
+	report := lineageReport{Number: doc.Number, Paths: routes, Missing: []string{}, Cycle: cycle, Problems: []validationFinding{}}
+	members := g.lineage(doc.Number)
+	for _, n := range members {
+		if _, ok := g.docs[n]; !ok {
+			report.Missing = append(report.Missing, n)
+		}
+	}
+	diags := supersessionDiagnostics(docs)
+	errors := 0
+	for _, n := range members {
+		if member, ok := g.docs[n]; ok {
+			for _, d := range diags[filepath.Clean(member.Path)] {
+				report.Problems = append(report.Problems, validationFinding{member.Path, d})
+				if d.Severity == "error" {
+					errors++
+				}
+			}
+		}
+	}
+
+	switch {
+	case asJSON:
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	case len(members) == 1:
+		fmt.Fprintf(stdout, "%s is not part of a supersession chain\n", doc.Number)
+	default:
+		for _, route := range routes {
+			shown := make([]string, len(route))
+			for i, n := range route {
+				shown[i] = n
+				if _, ok := g.docs[n]; !ok {
+					shown[i] += " (missing)"
+				} else if i == len(route)-1 && containsString(route[:i], n) {
+					shown[i] += " (cycle)"
+				}
+			}
+			fmt.Fprintln(stdout, strings.Join(shown, " → "))
+		}
+		fmt.Fprintln(stdout)
+		for _, n := range members {
+			if member, ok := g.docs[n]; ok {
+				mark := ""
+				if n == doc.Number {
+					mark = "  ◀ this document"
+				}
+				fmt.Fprintf(stdout, "  %s  %-12s %s%s\n", n, member.State, displayTitle(member.Title), mark)
+			}
+		}
+		if len(report.Problems) > 0 {
+			fmt.Fprintln(stdout)
+			for _, p := range report.Problems {
+				marker := "⚠"
+				if p.Severity == "error" {
+					marker = "✗"
+				}
+				fmt.Fprintf(stdout, "%s %s: %s\n", marker, p.Path, p.Message)
+			}
+		}
+	}
+	if errors > 0 {
+		return errorf(exitFindings, "The lineage of %s is broken: %d error(s)", doc.Number, errors)
+	}
+	return nil
+}
+
+// printChain prints the supersession lineage of a document as a tree
+// from its oldest ancestors, marking the document itself
+func printChain(g *supersessions, number string) {
+	members := g.lineage(number)
+	if len(members) == 1 {
+		fmt.Fprintf(stdout, "%s is not part of a supersession chain\n", number)
+		return
+	}
+
+	printed := map[string]bool{}
+	var show func(n string, depth int)
+	show = func(n string, depth int) {
+		line := strings.Repeat("    ", depth)
+		if depth > 0 {
+			line = strings.Repeat("    ", depth-1) + "└─▶ "
+		}
+		if doc, ok := g.docs[n]; ok {
+			line += fmt.Sprintf("%s %s (%s)", n, displayTitle(doc.Title), doc.State)
+		} else {
+			line += n + " (missing)"
+		}
+		if n == number {
+			line += "  ◀ this document"
+		}
+		if printed[n] {
+			fmt.Fprintln(stdout, line+"  (see above)")
+			return
+		}
+		printed[n] = true
+		fmt.Fprintln(stdout, line)
+		for _, next := range g.successors[n] {
+			show(next, depth+1)
+		}
+	}
+	for _, n := range members {
+		if len(g.predecessors[n]) == 0 {
+			show(n, 0)
+		}
+	}
+	// Members of a cycle have no root; start from the lowest number
+	for _, n := range members {
+		if !printed[n] {
+			show(n, 0)
+		}
+	}
+
+	if diags := supersessionDiagnostics(scanDocuments()); len(diags) > 0 {
+		for _, n := range members {
+			if doc, ok := g.docs[n]; ok {
+				for _, d := range diags[filepath.Clean(doc.Path)] {
+					fmt.Fprintf(stdout, "⚠ %s: %s\n", n, d.Message)
+				}
+			}
+		}
+	}
+}
+
+// fixSupersessionLinks fills in the missing side of one-sided
+// supersession links. Fields that already name other documents are
+// left for a person to resolve.
+func fixSupersessionLinks() error {
+	docs := scanDocuments()
+	g := newSupersessions(docs)
+	fixed := 0
+	for _, doc := range docs {
+		for _, field := range []string{"supersedes", "superseded-by"} {
+			want := g.predecessors[doc.Number]
+			if field == "superseded-by" {
+				want = g.successors[doc.Number]
+			}
+			if len(want) == 0 || len(docRefs(doc.Fields[field])) > 0 {
+				continue
+			}
+			value := strings.Join(want, ", ")
+			content, err := os.ReadFile(repoPath(doc.Path))
+			if err != nil {
+				return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+			}
+			updated, err := setFrontmatterField(string(content), field, value)
+			if err != nil {
+				return errorf(exitFindings, "%s: %v", doc.Path, err)
+			}
+			if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+				return errorf(exitEnvironment, "Failed to write %s: %v", doc.Path, err)
+			}
+			opResult.recordFieldChanges(doc.Path, string(content), updated)
+			doc.Fields[field] = value
+			fmt.Fprintf(stdout, "✓ %s: %s: %s\n", doc.Path, field, value)
+			fixed++
+		}
+	}
+	if fixed == 0 {
+		fmt.Fprintln(stdout, "Supersession links are already two-sided")
+		return nil
+	}
+	fmt.Fprintf(stdout, "\nFilled in %d field(s); run zdp update-index to refresh the index\n", fixed)
+	return nil
+}
+
+// supersedeDocument records that replacement supersedes old in one step:
+// old's superseded-by and replacement's supersedes are set, old is
+// transitioned to Superseded, and both index entries are refreshed.
+// Everything is checked first, and the documents and index are restored
+// if a step fails. The transition is journaled, and its hooks run, only
+// once every step has succeeded.
+func supersedeDocument(oldArg, newArg string) error {
+	old, err := findDocument(oldArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	replacement, err := findDocument(newArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	if old.Number == replacement.Number {
+		return errorf(exitUsage, "A document can't supersede itself")
+	}
+	if _, err := getStateDir("superseded"); err != nil {
+		return errorf(exitUsage, "The workflow has no Superseded state")
+	}
+	if normalizeState(old.State) == "superseded" {
+		return errorf(exitConflict, "%s is already Superseded (superseded-by: %s)", old.Number, noneIfEmpty(old.Fields["superseded-by"]))
+	}
+	switch normalizeState(replacement.State) {
+	case "superseded", "rejected", "withdrawn":
+		return errorf(exitConflict, "%s is %s, so it can't supersede another document", replacement.Number, replacement.State)
+	}
+	if others := docRefs(old.Fields["superseded-by"]); len(others) > 0 && !containsString(others, replacement.Number) {
+		return errorf(exitConflict, "%s already names %s in superseded-by; resolve that first", old.Number, strings.Join(others, ", "))
+	}
+	if containsString(docRefs(old.Fields["supersedes"]), replacement.Number) || containsString(docRefs(replacement.Fields["superseded-by"]), old.Number) {
+		return errorf(exitConflict, "%s supersedes %s, so %s can't supersede it", old.Number, replacement.Number, replacement.Number)
+	}
+
+	// Prepare both edits before writing either
+	var contents []string
+	for _, path := range []string{old.Path, replacement.Path, config.IndexFile} {
+		content, err := os.ReadFile(repoPath(path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", path, err)
+		}
+		contents = append(contents, string(content))
+	}
+	oldContent, newContent, indexContent := contents[0], contents[1], contents[2]
+	supersedes := docRefs(replacement.Fields["supersedes"])
+	if !containsString(supersedes, old.Number) {
+		supersedes = append(supersedes, old.Number)
+	}
+	oldUpdated, err := setFrontmatterField(oldContent, "superseded-by", replacement.Number)
+	if err != nil {
+		return errorf(exitFindings, "%s: %v", old.Path, err)
+	}
+	newUpdated, err := setFrontmatterField(newContent, "supersedes", strings.Join(supersedes, ", "))
+	if err != nil {
+		return errorf(exitFindings, "%s: %v", replacement.Path, err)
+	}
+
+	rollback := func(err error) error {
+		var failed []string
+		stateDir, _ := getStateDir("superseded")
+		if moved := filepath.Join(stateDir, filepath.Base(old.Path)); moved != old.Path {
+			if _, statErr := os.Stat(repoPath(moved)); statErr == nil {
+				if moveErr := moveDocument(moved, old.Path); moveErr != nil {
+					failed = append(failed, fmt.Sprintf("moving %s back to %s: %v", moved, old.Path, moveErr))
+				}
+			}
+		}
+		restore := []struct{ path, content string }{{old.Path, oldContent}, {replacement.Path, newContent}, {config.IndexFile, indexContent}}
+		for _, r := range restore {
+			if writeErr := os.WriteFile(repoPath(r.path), []byte(r.content), 0644); writeErr != nil {
+				failed = append(failed, fmt.Sprintf("restoring %s: %v", r.path, writeErr))
+			}
+		}
+		if len(failed) > 0 {
+			return errorf(exitEnvironment, "%v; undoing the change also failed, so check these by hand: %s", err, strings.Join(failed, "; "))
+		}
+		return errorf(ExitCode(err), "%v; nothing was changed", err)
+	}
+
+	if err := os.WriteFile(repoPath(replacement.Path), []byte(newUpdated), 0644); err != nil {
+		return rollback(errorf(exitEnvironment, "Failed to update %s: %v", replacement.Path, err))
+	}
+	opResult.recordFieldChanges(replacement.Path, newContent, newUpdated)
+	if err := os.WriteFile(repoPath(old.Path), []byte(oldUpdated), 0644); err != nil {
+		return rollback(errorf(exitEnvironment, "Failed to update %s: %v", old.Path, err))
+	}
+	opResult.recordFieldChanges(old.Path, oldContent, oldUpdated)
+	fmt.Fprintf(stdout, "Set supersedes of %s to %s\n", replacement.Number, strings.Join(supersedes, ", "))
+	fmt.Fprintf(stdout, "Set superseded-by of %s to %s\n", old.Number, replacement.Number)
+
+	var record []func() error
+	if err := transitionDocument(old.Path, "Superseded", transitionOptions{Force: true, Reason: "Superseded by " + replacement.Number, Record: &record}); err != nil {
+		return rollback(err)
+	}
+	stateDir, _ := getStateDir("superseded")
+	for _, path := range []string{filepath.Join(stateDir, filepath.Base(old.Path)), replacement.Path} {
+		if _, err := refreshIndexEntry(path); err != nil {
+			return rollback(errorf(exitEnvironment, "Failed to update index: %v", err))
+		}
+	}
+	fmt.Fprintf(stdout, "%s now supersedes %s\n", replacement.Number, old.Number)
+	for _, fn := range record {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doctorProblem is one inconsistency found by doctor, with the repair
+// --fix makes for it, if one is safe
+type doctorProblem struct {
+	Check   string
+	Message string
+	Fix     func() error // nil when a person has to resolve it
+}
+
+// doctorChecks lists the doctor checks in report order
+var doctorChecks = []string{"Orphan files", "Index", "Duplicate numbers", "Supersession links", "Updated dates"}
+
+// diagnoseRepository finds the inconsistencies doctor reports. Fixes to
+// the index are made to idx, which the caller saves.
+func diagnoseRepository(idx *Index) []doctorProblem {
+	var problems []doctorProblem
+	add := func(check string, fix func() error, format string, args ...interface{}) {
+		problems = append(problems, doctorProblem{check, fmt.Sprintf(format, args...), fix})
+	}
+
+	docs := scanDocuments()
+	byNumber := make(map[string][]*Document)
+	for _, doc := range docs {
+		byNumber[doc.Number] = append(byNumber[doc.Number], doc)
+	}
+
+	// Documents git doesn't know about, and sidecars of missing documents
+	tracked := make(map[string]bool)
+	for _, path := range getGitTrackedDocs() {
+		tracked[filepath.Clean(path)] = true
+	}
+	for _, doc := range docs {
+		if !tracked[filepath.Clean(doc.Path)] {
+			add("Orphan files", nil, "%s is not tracked by git; run zdp add or remove it", doc.Path)
+		}
+	}
+	for _, kind := range []string{"comments", "acks"} {
+		sidecars, _ := filepath.Glob(filepath.Join(".zdp", kind, "*.jsonl"))
+		for _, path := range sidecars {
+			if number := strings.TrimSuffix(filepath.Base(path), ".jsonl"); len(byNumber[number]) == 0 {
+				add("Orphan files", nil, "%s belongs to %s, which has no document", path, number)
+			}
+		}
+	}
+
+	// Index rows and links without files, and documents the index lacks
+	for _, entry := range idx.Entries {
+		if len(byNumber[entry.Number]) == 0 {
+			number := entry.Number
+			add("Index", func() error {
+				idx.RemoveEntry(number)
+				return nil
+			}, "row %s has no document", number)
+		}
+	}
+	for _, section := range idx.Sections {
+		for _, link := range section.Links {
+			if _, err := os.Stat(repoPath(link.Path)); os.IsNotExist(err) {
+				state, path := section.State, link.Path
+				add("Index", func() error {
+					idx.RemoveLink(state, path)
+					return nil
+				}, "%s section links to %s, which does not exist", state, path)
+			}
+		}
+	}
+	for _, doc := range docs {
+		if _, err := getStateDir(doc.State); err != nil {
+			continue
+		}
+		doc := doc
+		if idx.Entry(doc.Number) == nil {
+			add("Index", func() error {
+				idx.InsertEntries([]IndexEntry{{Number: doc.Number, Title: doc.Title, State: doc.State, Updated: doc.Updated, SupersededBy: supersededByNote(doc)}})
+				return nil
+			}, "%s is not in the table", doc.Path)
+		}
+		if !idx.HasLink(doc.Path) {
+			add("Index", func() error {
+				idx.AddLink(dirToState[filepath.Base(filepath.Dir(doc.Path))], IndexLink{Number: doc.Number, Title: doc.Title, Path: doc.Path})
+				return nil
+			}, "%s is not linked from its state section", doc.Path)
+		}
+	}
+
+	var numbers []string
+	for number, found := range byNumber {
+		if len(found) > 1 {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Strings(numbers)
+	for _, number := range numbers {
+		var paths []string
+		for _, doc := range byNumber[number] {
+			paths = append(paths, doc.Path)
+		}
+		add("Duplicate numbers", nil, "%s is used by %s; renumber all but one", number, strings.Join(paths, ", "))
+	}
+
+	// One-sided links are filled in all at once, so repeated calls find
+	// nothing left to do; the rest need a person
+	fixLinks := func() error {
+		var err error
+		captureStdout(func() { err = fixSupersessionLinks() })
+		return err
+	}
+	chains := supersessionDiagnostics(docs)
+	for _, doc := range docs {
+		for _, d := range chains[filepath.Clean(doc.Path)] {
+			var fix func() error
+			if strings.HasSuffix(d.Message, "run zdp chain --fix") {
+				fix = fixLinks
+			}
+			add("Supersession links", fix, "%s: %s", doc.Path, strings.TrimSuffix(d.Message, "; run zdp chain --fix"))
+		}
+	}
+
+	// An updated date older than the last edit to the document. The commit
+	// adding a document doesn't count, so imported documents keep the
+	// dates they came with.
+	for _, doc := range docs {
+		changes := getGitChanges(doc.Path)
+		if len(changes) < 2 || doc.Updated == "" || changes[0].Date <= doc.Updated {
+			continue
+		}
+		doc, date := doc, changes[0].Date
+		add("Updated dates", func() error {
+			content, err := os.ReadFile(repoPath(doc.Path))
+			if err != nil {
+				return err
+			}
+			updated, err := setFrontmatterField(string(content), "updated", date)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+				return err
+			}
+			opResult.recordFieldChanges(doc.Path, string(content), updated)
+			if entry := idx.Entry(doc.Number); entry != nil {
+				entry.Updated = date
+			}
+			return nil
+		}, "%s is updated %s, but was last changed on %s", doc.Path, doc.Updated, date)
+	}
+
+	return problems
+}
+
+// doctor reports repository inconsistencies by check and, with fix set,
+// repairs the ones that can be repaired safely. It exits with status 1
+// while problems remain.
+func doctor(fix bool) error {
+	idx, _, err := loadIndex(config.IndexFile)
+	if err != nil {
+		return errorf(exitFindings, "Failed to parse index: %v", err)
+	}
+
+	problems := diagnoseRepository(&idx)
+	fixed, remaining := 0, 0
+	indexChanged := false
+	var indexFixes []string
+	for _, check := range doctorChecks {
+		var lines []string
+		for i := range problems {
+			p := &problems[i]
+			if p.Check != check {
+				continue
+			}
+			switch {
+			case p.Fix != nil && fix:
+				if err := p.Fix(); err != nil {
+					return errorf(exitEnvironment, "Failed to fix %s: %v", p.Message, err)
+				}
+				if check == "Index" || check == "Updated dates" {
+					indexChanged = true
+					indexFixes = append(indexFixes, p.Message)
+				}
+				lines = append(lines, "  ✓ fixed: "+p.Message)
+				fixed++
+			case p.Fix != nil:
+				lines = append(lines, "  ⚠ "+p.Message+" (--fix repairs this)")
+				remaining++
+			default:
+				lines = append(lines, "  ⚠ "+p.Message)
+				remaining++
+			}
+		}
+		if len(lines) == 0 {
+			fmt.Fprintf(stdout, "✓ %s\n", check)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\n%s\n", check, strings.Join(lines, "\n"))
+	}
+
+	if indexChanged {
+		if err := saveIndex(config.IndexFile, idx); err != nil {
+			return errorf(exitEnvironment, "Failed to write index: %v", err)
+		}
+		for _, change := range indexFixes {
+			opResult.recordIndexChange("Fixed: %s", change)
+		}
+	}
+	if fixed > 0 {
+		fmt.Fprintf(stdout, "\nFixed %d problem(s)\n", fixed)
+	}
+	if remaining > 0 {
+		return errorf(exitFindings, "%d problem(s) remain", remaining)
+	}
+	return nil
+}
+
+// guardHookMarker identifies commit-msg hooks written by "zdp guard install"
+const guardHookMarker = "# Installed by zdp guard install"
+
+// guardHookScript is the commit-msg hook. It runs the zdp built at the top
+// of the repository, falling back to zdp on PATH, so it keeps working when
+// the binary that installed it is rebuilt or was a temporary "go run" build.
+const guardHookScript = `#!/bin/sh
+` + guardHookMarker + `
+zdp="$(git rev-parse --show-toplevel)/zdp"
+[ -x "$zdp" ] || zdp=zdp
+if ! command -v "$zdp" >/dev/null 2>&1; then
+	echo "zdp guard: no zdp binary at the top of the repository or on PATH" >&2
+	exit 1
+fi
+exec "$zdp" guard check "$1"
+`
+
+// frozenChange is a staged change to a document in a frozen state
+type frozenChange struct {
+	Path   string
+	State  string
+	Change string // "edited" or "deleted"
+}
+
+// stagedFrozenChanges lists staged changes to the bodies of documents
+// whose committed version is in a frozen state (config.Guard). Renames
+// and frontmatter-only edits, such as transitions and superseded-by
+// links, are allowed, as are new rows in the Revision History.
+func stagedFrozenChanges() ([]frozenChange, error) {
+	if gitHead() == "" {
+		return nil, nil
+	}
+	output, err := repoCommand("git", "-c", "core.quotePath=false", "diff", "--cached", "--name-status", "-M").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %v", err)
+	}
+
+	body := func(content []byte) string {
+		return withoutRevisionHistory(strings.TrimLeft(frontmatterRe.ReplaceAllString(string(content), ""), "\n"))
+	}
+	var changes []frozenChange
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		status, oldPath, newPath := fields[0], fields[1], fields[len(fields)-1]
+		if !strings.HasSuffix(oldPath, ".md") || !(status == "M" || status == "D" || strings.HasPrefix(status, "R")) {
+			continue
+		}
+		state := dirToState[filepath.Base(filepath.Dir(oldPath))]
+		if state == "" || !containsString(config.Guard, normalizeState(state)) {
+			continue
+		}
+		change := frozenChange{Path: oldPath, State: state, Change: "deleted"}
+		if status != "D" {
+			before, err := repoCommand("git", "show", "HEAD:"+oldPath).Output()
+			if err != nil {
+				return nil, fmt.Errorf("git show HEAD:%s failed: %v", oldPath, err)
+			}
+			after, err := repoCommand("git", "show", ":"+newPath).Output()
+			if err != nil {
+				return nil, fmt.Errorf("git show :%s failed: %v", newPath, err)
+			}
+			if body(before) == body(after) {
+				continue
+			}
+			change.Path, change.Change = newPath, "edited"
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// hasOverrideToken reports whether a commit message contains the guard
+// override token, ignoring git's comment lines
+func hasOverrideToken(message string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") && strings.Contains(line, config.OverrideToken) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardCommand parses the arguments of
+// "guard install [--force] | uninstall | check [<message-file>]"
+func guardCommand(args []string) error {
+	const usage = "Usage: zdp guard install [--force] | zdp guard uninstall | zdp guard check [<message-file>]"
+	if len(args) == 0 {
+		return errorf(exitUsage, usage)
+	}
+	switch args[0] {
+	case "install":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--force") {
+			return errorf(exitUsage, usage)
+		}
+		return installGuardHook(len(args) == 2)
+	case "uninstall":
+		if len(args) != 1 {
+			return errorf(exitUsage, usage)
+		}
+		return uninstallGuardHook()
+	case "check":
+		if len(args) > 2 {
+			return errorf(exitUsage, usage)
+		}
+		message := ""
+		if len(args) == 2 {
+			content, err := os.ReadFile(repoPath(args[1]))
+			if err != nil {
+				return errorf(exitEnvironment, "Failed to read commit message: %v", err)
+			}
+			message = string(content)
+		}
+		return checkFrozenDocuments(message)
+	default:
+		return errorf(exitUsage, usage)
+	}
+}
+
+// checkFrozenDocuments fails when staged changes edit a frozen document
+// and the commit message lacks the override token
+func checkFrozenDocuments(message string) error {
+	changes, err := stagedFrozenChanges()
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if hasOverrideToken(message) {
+		fmt.Fprintf(os.Stderr, "Note: %s in the commit message allows changes to %d frozen document(s)\n", config.OverrideToken, len(changes))
+		return nil
+	}
+
+	for _, c := range changes {
+		fmt.Fprintf(os.Stderr, "✗ %s: %s while %s\n", c.Path, c.Change, c.State)
+	}
+	fmt.Fprintf(os.Stderr, "\nDocuments in %s are part of the historical record.\n", strings.Join(guardStateNames(), ", "))
+	fmt.Fprintf(os.Stderr, "Frontmatter updates and moves are allowed; to change the content anyway,\n")
+	fmt.Fprintf(os.Stderr, "include %s in the commit message.\n", config.OverrideToken)
+	return errorf(exitConflict, "%d frozen document(s) changed", len(changes))
+}
+
+// guardStateNames returns the frozen states that exist in the workflow,
+// as written in headers
+func guardStateNames() []string {
+	var names []string
+	for _, dir := range sortedStateDirs() {
+		if state := dirToState[dir]; containsString(config.Guard, normalizeState(state)) {
+			names = append(names, state)
+		}
+	}
+	return names
+}
+
+// guardHookPath returns the commit-msg hook path, honouring core.hooksPath
+func guardHookPath() (string, error) {
+	output, err := repoCommand("git", "rev-parse", "--git-path", "hooks/commit-msg").Output()
+	if err != nil {
+		return "", errorf(exitEnvironment, "Not in a git repository")
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// installGuardHook writes a commit-msg hook running "zdp guard check".
+// An existing hook not written by zdp is only replaced with force.
+func installGuardHook(force bool) error {
+	path, err := guardHookPath()
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(repoPath(path)); err == nil && !strings.Contains(string(existing), guardHookMarker) && !force {
+		return errorf(exitConflict, "%s already exists and was not installed by zdp; use --force to replace it", path)
+	}
+
+	if err := os.MkdirAll(repoPath(filepath.Dir(path)), 0755); err != nil {
+		return errorf(exitEnvironment, "Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(repoPath(path), []byte(guardHookScript), 0755); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", path, err)
+	}
+	opResult.recordWrite(path)
+	fmt.Fprintf(stdout, "Installed %s\n", path)
+	fmt.Fprintf(stdout, "Commits editing %s documents now need %s in the message\n", strings.Join(guardStateNames(), ", "), config.OverrideToken)
+	return nil
+}
+
+// uninstallGuardHook removes the commit-msg hook if zdp installed it
+func uninstallGuardHook() error {
+	path, err := guardHookPath()
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(repoPath(path))
+	if os.IsNotExist(err) {
+		fmt.Fprintln(stdout, "No commit-msg hook installed")
+		return nil
+	}
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read %s: %v", path, err)
+	}
+	if !strings.Contains(string(existing), guardHookMarker) {
+		return errorf(exitConflict, "%s was not installed by zdp; leaving it in place", path)
+	}
+	if err := os.Remove(repoPath(path)); err != nil {
+		return errorf(exitEnvironment, "Failed to remove %s: %v", path, err)
+	}
+	fmt.Fprintf(stdout, "Removed %s\n", path)
+	return nil
+}
+
+// assetStoreDir holds content-addressed assets, each stored once as
+// assets/<xx>/<hash>.<ext> however many documents use it
+const assetStoreDir = "assets"
+
+// assetHashLen is how many hex digits of the SHA-256 hash name an asset
+const assetHashLen = 16
+
+// assetStorePath returns the store path for content with the given
+// file extension
+func assetStorePath(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:assetHashLen]
+	return filepath.Join(assetStoreDir, hash[:2], hash+strings.ToLower(ext))
+}
+
+// isLocalAsset reports whether an image source is a file in the repository
+func isLocalAsset(src string) bool {
+	return !strings.Contains(src, "://") && !strings.HasPrefix(src, "data:") && !strings.HasPrefix(src, "/")
+}
+
+// storedAssetSitePath returns the store-relative path ("assets/xx/...")
+// of an image source pointing into the store from a state directory, or ""
+func storedAssetSitePath(src string) string {
+	clean := strings.TrimPrefix(path.Clean(src), "../")
+	if strings.HasPrefix(clean, assetStoreDir+"/") {
+		return clean
+	}
+	return ""
+}
+
+// documentImageRefs returns the local image sources in a document body,
+// outside code blocks, in order of appearance
+func documentImageRefs(content string) []string {
+	var refs []string
+	var fence codeFence
+	for _, line := range strings.Split(content, "\n") {
+		if fence.inCode(line) {
+			continue
+		}
+		for _, m := range imageRe.FindAllStringSubmatch(line, -1) {
+			if isLocalAsset(m[2]) {
+				refs = append(refs, m[2])
+			}
+		}
+	}
+	return refs
+}
+
+// rewriteImageRefs replaces image sources in a document body outside code
+// blocks, using the sources mapped in to
+func rewriteImageRefs(content string, to map[string]string) string {
+	lines := strings.Split(content, "\n")
+	var fence codeFence
+	for i, line := range lines {
+		if fence.inCode(line) {
+			continue
+		}
+		lines[i] = imageRe.ReplaceAllStringFunc(line, func(m string) string {
+			sub := imageRe.FindStringSubmatch(m)
+			if dst, ok := to[sub[2]]; ok {
+				return "![" + sub[1] + "](" + dst + ")"
+			}
+			return m
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// referencedAssets returns the repository paths of the local images every
+// document references, mapped to the documents using them
+func referencedAssets() (map[string][]string, error) {
+	refs := make(map[string][]string)
+	for _, doc := range scanDocuments() {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return nil, errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		for _, src := range documentImageRefs(string(content)) {
+			file := filepath.Clean(filepath.Join(filepath.Dir(doc.Path), filepath.FromSlash(src)))
+			if !containsString(refs[file], doc.Number) {
+				refs[file] = append(refs[file], doc.Number)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// storeAsset copies data into the store unless it is already there,
+// staging new files in git. It reports whether a file was written.
+func storeAsset(data []byte, ext string) (string, bool, error) {
+	dst := assetStorePath(data, ext)
+	if _, err := os.Stat(repoPath(dst)); err == nil {
+		return dst, false, nil
+	}
+	if err := os.MkdirAll(repoPath(filepath.Dir(dst)), 0755); err != nil {
+		return dst, false, err
+	}
+	if err := os.WriteFile(repoPath(dst), data, 0644); err != nil {
+		return dst, false, err
+	}
+	opResult.recordWrite(dst)
+	if output, err := repoCommand("git", "add", dst).CombinedOutput(); err != nil {
+		return dst, true, fmt.Errorf("git add failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	opResult.recordStaged(dst)
+	return dst, true, nil
+}
+
+// assetsCommand parses the arguments of
+// "assets add <doc> <file> [--alt text] | dedup [--apply] | gc [--apply]"
+func assetsCommand(args []string) error {
+	const usage = "Usage: zdp assets add <doc> <file> [--alt text] | zdp assets dedup [--apply] | zdp assets gc [--apply]"
+	if len(args) == 0 {
+		return errorf(exitUsage, usage)
+	}
+	apply := len(args) == 2 && args[1] == "--apply"
+
+	switch {
+	case args[0] == "add":
+		var positional []string
+		alt := ""
+		for i := 1; i < len(args); i++ {
+			if value, ok, err := flagValue(args, &i, "--alt"); err != nil {
+				return err
+			} else if ok {
+				alt = value
+				continue
+			}
+			positional = append(positional, args[i])
+		}
+		if len(positional) != 2 {
+			return errorf(exitUsage, usage)
+		}
+		return addAsset(positional[0], positional[1], alt)
+	case args[0] == "dedup" && (len(args) == 1 || apply):
+		return dedupAssets(apply)
+	case args[0] == "gc" && (len(args) == 1 || apply):
+		return collectAssets(apply)
+	default:
+		return errorf(exitUsage, usage)
+	}
+}
+
+// addAsset stores a file and appends an image referencing it to a document
+func addAsset(docArg, file, alt string) error {
+	doc, err := findDocument(docArg)
+	if err != nil {
+		return errorf(exitUsage, "%v", err)
+	}
+	data, err := os.ReadFile(repoPath(file))
+	if err != nil {
+		return errorf(exitUsage, "Failed to read %s: %v", file, err)
+	}
+	dst, written, err := storeAsset(data, filepath.Ext(file))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to store %s: %v", file, err)
+	}
+
+	content, err := os.ReadFile(repoPath(doc.Path))
+	if err != nil {
+		return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+	}
+	rel, err := filepath.Rel(filepath.Dir(doc.Path), dst)
+	if err != nil {
+		return errorf(exitEnvironment, "%v", err)
+	}
+	if alt == "" {
+		alt = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	updated := strings.TrimRight(string(content), "\n") + "\n\n![" + alt + "](" + filepath.ToSlash(rel) + ")\n"
+	if err := os.WriteFile(repoPath(doc.Path), []byte(updated), 0644); err != nil {
+		return errorf(exitEnvironment, "Failed to write %s: %v", doc.Path, err)
+	}
+	opResult.recordWrite(doc.Path)
+
+	if written {
+		fmt.Fprintf(stdout, "Stored %s as %s\n", file, filepath.ToSlash(dst))
+	} else {
+		fmt.Fprintf(stdout, "%s is already stored as %s\n", file, filepath.ToSlash(dst))
+	}
+	fmt.Fprintf(stdout, "Added a reference to %s in %s\n", filepath.ToSlash(dst), doc.Path)
+	return nil
+}
+
+// dedupAssets moves the local images documents reference into the store,
+// so identical files collapse into one, and rewrites the references.
+// Without apply it only reports what would change. The original files
+// are left in place; remove them once nothing else links to them.
+func dedupAssets(apply bool) error {
+	type plannedDoc struct {
+		doc *Document
+		to  map[string]string
+	}
+	var plans []plannedDoc
+	stored := make(map[string][]string) // store path -> original files
+	refs := 0
+
+	for _, doc := range scanDocuments() {
+		content, err := os.ReadFile(repoPath(doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", doc.Path, err)
+		}
+		to := make(map[string]string)
+		for _, src := range documentImageRefs(string(content)) {
+			if _, done := to[src]; done || storedAssetSitePath(src) != "" {
+				continue
+			}
+			file := filepath.Join(filepath.Dir(doc.Path), filepath.FromSlash(src))
+			data, err := os.ReadFile(repoPath(file))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ %s: image %s not found\n", doc.Path, src)
+				warn("%s: image %s not found", doc.Path, src)
+				continue
+			}
+			dst := assetStorePath(data, filepath.Ext(file))
+			rel, _ := filepath.Rel(filepath.Dir(doc.Path), dst)
+			to[src] = filepath.ToSlash(rel)
+			if clean := filepath.Clean(file); !containsString(stored[dst], clean) {
+				stored[dst] = append(stored[dst], clean)
+			}
+			refs++
+		}
+		if len(to) > 0 {
+			plans = append(plans, plannedDoc{doc, to})
+		}
+	}
+
+	if refs == 0 {
+		fmt.Fprintln(stdout, "Every local image is already in the asset store")
+		return nil
+	}
+
+	var dsts []string
+	for dst := range stored {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+	for _, dst := range dsts {
+		fmt.Fprintf(stdout, "%s ← %s\n", filepath.ToSlash(dst), strings.Join(stored[dst], ", "))
+	}
+	if !apply {
+		fmt.Fprintf(stdout, "\n%d reference(s) in %d document(s) to %d stored asset(s); rerun with --apply to write them\n", refs, len(plans), len(dsts))
+		return nil
+	}
+
+	for _, dst := range dsts {
+		original := stored[dst][0]
+		data, err := os.ReadFile(repoPath(original))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", original, err)
+		}
+		if _, _, err := storeAsset(data, filepath.Ext(original)); err != nil {
+			return errorf(exitEnvironment, "Failed to store %s: %v", original, err)
+		}
+	}
+	for _, plan := range plans {
+		content, err := os.ReadFile(repoPath(plan.doc.Path))
+		if err != nil {
+			return errorf(exitEnvironment, "Failed to read %s: %v", plan.doc.Path, err)
+		}
+		if err := os.WriteFile(repoPath(plan.doc.Path), []byte(rewriteImageRefs(string(content), plan.to)), 0644); err != nil {
+			return errorf(exitEnvironment, "Failed to write %s: %v", plan.doc.Path, err)
+		}
+		opResult.recordWrite(plan.doc.Path)
+	}
+	fmt.Fprintf(stdout, "\nRewrote %d reference(s) in %d document(s) to %d stored asset(s)\n", refs, len(plans), len(dsts))
+	return nil
+}
+
+// collectAssets removes stored assets no document references. Without
+// apply it only lists them.
+func collectAssets(apply bool) error {
+	used, err := referencedAssets()
+	if err != nil {
+		return err
+	}
+	var unused []string
+	filepath.WalkDir(assetStoreDir, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && len(used[filepath.Clean(p)]) == 0 {
+			unused = append(unused, p)
+		}
+		return nil
+	})
+	if len(unused) == 0 {
+		fmt.Fprintln(stdout, "Every stored asset is referenced")
+		return nil
+	}
+
+	for _, p := range unused {
+		fmt.Fprintln(stdout, filepath.ToSlash(p))
+		if !apply {
+			continue
+		}
+		if err := repoCommand("git", "rm", "--quiet", "--cached", "--ignore-unmatch", p).Run(); err != nil {
+			return errorf(exitEnvironment, "git rm failed for %s: %v", p, err)
+		}
+		if err := os.Remove(repoPath(p)); err != nil {
+			return errorf(exitEnvironment, "Failed to remove %s: %v", p, err)
+		}
+		opResult.recordWrite(p)
+		os.Remove(repoPath(filepath.Dir(p))) // only succeeds once the directory is empty
+	}
+	if apply {
+		fmt.Fprintf(stdout, "\nRemoved %d unreferenced asset(s)\n", len(unused))
+		return nil
+	}
+	fmt.Fprintf(stdout, "\n%d unreferenced asset(s); rerun with --apply to remove them\n", len(unused))
+	return nil
+}
+
+// command is one zdp subcommand. Run receives the arguments after the
+// command name, with global flags already removed.
+type command struct {
+	Name    string
+	Usage   string // arguments and flags, as in "<doc> <new-state>"
+	Summary string // one line for the command list
+	Help    string // details for "zdp help <command>"; optional
+	Run     func(ctx context.Context, args []string) error
+}
+
+// commands lists every subcommand, in the order "zdp help" shows them
+var commands []command
+
+func init() {
+	commands = []command{
+		{Name: "list", Usage: "[--where expr]... [--state name] [--author person] [--tag tag]... [--since date] [--updated-before date] [--sort order] [--reverse] [--format text|json]", Summary: "List documents, optionally filtered by metadata",
+			Help: "Without flags, documents are grouped by state. --where filters with the query\nlanguage described in README.md. --state, --author, and --tag keep documents\nin a state, by an author, or listing a tag (repeat --tag to require several).\n--since and --updated-before keep documents updated on or after, or before, a\nYYYY-MM-DD date. All filters must match. --sort number|title|updated|state\nprints a flat list in that order (updated is most recent first), and\n--reverse flips it. --format json prints the matches as JSON.",
+			Run: func(ctx context.Context, args []string) error {
+				if len(args) == 0 {
+					listDocuments()
+					return nil
+				}
+				return listCommand(args)
+			}},
+		{Name: "states", Usage: "[--format text|json]", Summary: "List supported states",
+			Run: func(ctx context.Context, args []string) error {
+				asJSON := false
+				for i := 0; i < len(args); i++ {
+					isFlag, json, err := formatFlag(args, &i)
+					if err != nil {
+						return err
+					}
+					if !isFlag {
+						return errorf(exitUsage, "Usage: zdp states [--format text|json]")
+					}
+					asJSON = json
+				}
+				if asJSON {
+					return listStatesJSON()
+				}
+				listStates()
+				return nil
+			}},
+		{Name: "new", Usage: "<slug|title> [--slug <slug>] [--number N] [--type rfc|adr|process]", Summary: "Create a new draft from a template",
+			Run: func(ctx context.Context, args []string) error { return newCommand(args) }},
+		{Name: "add", Usage: "<doc.md|number>", Summary: "Add a new document with full processing",
+			Help: "Moves the document into the draft directory, numbers it, adds headers,\nsyncs its state with its directory, stages it in git, and indexes it.",
+			Run: func(ctx context.Context, args []string) error {
+				docPath, err := singleDocArg("add", args)
+				if err != nil {
+					return err
+				}
+				return addDocument(docPath)
+			}},
+		{Name: "transition", Usage: "<doc.md|number> <new-state> [--reason text] [--force] [--format text|json]", Summary: "Transition a document to a new state",
+			Help: "Updates the state header, records the move and --reason in state-history,\nmoves the file to the state's directory with git mv, and updates the index.\nRun \"zdp states\" for the state names. Moves outside transitions.allowed\n(by default, the table under \"State Transitions\" in README.md) are refused\nunless --force is given.",
+			Run: func(ctx context.Context, args []string) error {
+				var positional []string
+				var opts transitionOptions
+				asJSON := false
+				for i := 0; i < len(args); i++ {
+					if isFlag, json, err := formatFlag(args, &i); err != nil {
+						return err
+					} else if isFlag {
+						asJSON = json
+						continue
+					}
+					if reason, ok, err := flagValue(args, &i, "--reason"); err != nil {
+						return err
+					} else if ok {
+						if opts.Reason = strings.TrimSpace(reason); opts.Reason == "" {
+							return errorf(exitUsage, "--reason needs some text")
+						}
+						continue
+					}
+					if args[i] == "--force" {
+						opts.Force = true
+						continue
+					}
+					positional = append(positional, args[i])
+				}
+				if err := exactArgs("transition", positional, 2); err != nil {
+					return err
+				}
+				docPath, err := docPathArg(positional[0])
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return transitionJSON(docPath, positional[1], opts)
+				}
+				return transitionDocument(docPath, positional[1], opts)
+			}},
+		{Name: "explain", Usage: "<doc|number> <new-state> [--format text|json]", Summary: "Explain which rules apply to a transition and which are unmet",
+			Help: "Changes nothing. Lists what transition enforces, what validate would report\nonce the document is in the new state, outstanding votes, frozen states, and\nhooks. Exits with status 1 if the transition would be refused.",
+			Run:  func(ctx context.Context, args []string) error { return explainCommand(args) }},
+		{Name: "move", Usage: "<doc.md|number>", Summary: "Move a document to the directory matching its header state",
+			Run: func(ctx context.Context, args []string) error {
+				docPath, err := singleDocArg("move", args)
+				if err != nil {
+					return err
+				}
+				return moveToMatchHeader(docPath)
+			}},
+		{Name: "index", Usage: "<doc.md|number>", Summary: "Add a document to the index",
+			Run: func(ctx context.Context, args []string) error {
+				docPath, err := singleDocArg("index", args)
+				if err != nil {
+					return err
+				}
+				if err := addToIndex(docPath); err != nil {
+					return errorf(exitEnvironment, "%v", err)
+				}
+				return nil
+			}},
+		{Name: "add-headers", Usage: "<doc.md|number>", Summary: "Add or update YAML frontmatter headers",
+			Run: func(ctx context.Context, args []string) error {
+				docPath, err := singleDocArg("add-headers", args)
+				if err != nil {
+					return err
+				}
+				return addHeadersToDocument(docPath)
+			}},
+		{Name: "update-index", Summary: "Sync the index with git-tracked documents",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("update-index", args, 0); err != nil {
+					return err
+				}
+				return updateIndexCommand(ctx)
+			}},
+		{Name: "adopt", Usage: "<doc> <new-author> [--force]", Summary: "Hand an abandoned draft to a new author",
+			Help: "Replaces the author (and a champion who was the author), credits the original\nauthor in a Provenance section, resets updated, and runs the adopt hooks.\nDrafts updated within review.stale-days need --force.",
+			Run:  func(ctx context.Context, args []string) error { return adoptCommand(args) }},
+		{Name: "doctor", Usage: "[--fix]", Summary: "Check the repository for inconsistencies, and repair the safe ones",
+			Help: "Reports orphan files, index rows and links without files, duplicate numbers,\none-sided or contradictory supersession links, and stale updated dates.\n--fix repairs the index, one-sided links, and updated dates.",
+			Run: func(ctx context.Context, args []string) error {
+				if len(args) > 1 || (len(args) == 1 && args[0] != "--fix") {
+					return errorf(exitUsage, "Usage: zdp doctor [--fix]")
+				}
+				return doctor(len(args) == 1)
+			}},
+		{Name: "expire", Usage: "[--notify | --apply]", Summary: "List, notify, or withdraw documents Deferred for too long",
+			Help: "Documents Deferred for more than deferral.max-days are listed. --notify records\nan expiry notice on them; --apply withdraws those notified at least\ndeferral.grace-days ago.",
+			Run:  func(ctx context.Context, args []string) error { return expireCommand(args) }},
+		{Name: "churn", Usage: "[--limit N]", Summary: "List the Final documents edited most since they became Final",
+			Help: "Documents over review.final-edit-limit are marked; validate warns about them too.",
+			Run: func(ctx context.Context, args []string) error {
+				limit := 0
+				for i := 0; i < len(args); i++ {
+					value, ok, err := flagValue(args, &i, "--limit")
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return errorf(exitUsage, "Usage: zdp churn [--limit N]")
+					}
+					n, err := strconv.Atoi(value)
+					if err != nil || n < 1 {
+						return errorf(exitUsage, "Invalid --limit value \"%s\"", value)
+					}
+					limit = n
+				}
+				churnReport(limit)
+				return nil
+			}},
+		{Name: "whoami", Summary: "Show the identity used for authors, claims, votes, and acknowledgments",
+			Help: "Resolves the identity from git config, then .mailmap, then identity.aliases\nin .zdp.yaml. The global --as flag overrides it.",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("whoami", args, 0); err != nil {
+					return err
+				}
+				whoami()
+				return nil
+			}},
+		{Name: "next", Summary: "Show documents needing your attention",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("next", args, 0); err != nil {
+					return err
+				}
+				nextCommand()
+				return nil
+			}},
+		{Name: "review", Usage: "suggest <doc.md|number> [--count N] [--format text|json]", Summary: "Suggest reviewers with the lightest load",
+			Help: "Candidates are the reviewers review.components lists for the document's\ncomponents, or else people who wrote, championed, or reviewed documents in\nthem. Authors, current reviewers, and anyone at review.max-load open\nreviews (3 by default) are left out.",
+			Run:  func(ctx context.Context, args []string) error { return reviewCommand(args) }},
+		{Name: "blind", Usage: "<doc.md|number>... [--out dir] | unseal <mapping.sealed> --key private.pem", Summary: "Export anonymized copies for blind review",
+			Help: "Writes each document to blind-review/ (or --out) as proposal-A.md,\nproposal-B.md, ..., in random order, without author, champion, or dates,\nand with their names, emails, and handles redacted. The mapping back to\nthe documents is sealed in mapping.sealed for the public keys under\nreview.maintainer-keys; \"blind unseal\" opens it with a private key.",
+			Run:  func(ctx context.Context, args []string) error { return blindCommand(args) }},
+		{Name: "comments", Usage: "[add|resolve|unresolved|import] <doc.md|number> ...", Summary: "List, add, resolve, or import review comments",
+			Help: "  zdp comments <doc.md|number>\n  zdp comments add <doc.md|number> <text> [--quote text]\n  zdp comments resolve <doc.md|number> <id>\n  zdp comments unresolved <doc.md|number> [--format text|json]\n  zdp comments import [<doc.md|number>] --pr <url>",
+			Run:  func(ctx context.Context, args []string) error { return commentsCommand(ctx, args) }},
+		{Name: "roadmap", Usage: "--quarter YYYYQN [--out path]", Summary: "Write a roadmap of planned documents",
+			Run: func(ctx context.Context, args []string) error { return roadmapCommand(args) }},
+		{Name: "report", Usage: "annual --year YYYY [--out path]", Summary: "Write the skeleton of a yearly design retrospective",
+			Run: func(ctx context.Context, args []string) error { return reportCommand(ctx, args) }},
+		{Name: "breaking", Usage: "[--since <release>] [--format text|json]", Summary: "List accepted breaking changes for the migration guide",
+			Run: func(ctx context.Context, args []string) error { return breakingCommand(args) }},
+		{Name: "release-check", Usage: "<release> [--tag]", Summary: "List open documents targeted at a release",
+			Run: func(ctx context.Context, args []string) error { return releaseCheckCommand(args) }},
+		{Name: "versions", Usage: "<doc|number> [--tags pattern]", Summary: "Show the document's state in each release snapshot tag",
+			Help: "Tags default to the release.tag-prefix snapshots made by release-check --tag\n(design-* unless configured). --tags takes any git tag pattern, such as \"v*\".",
+			Run:  func(ctx context.Context, args []string) error { return versionsCommand(args) }},
+		{Name: "chain", Usage: "<doc|number> | --fix", Summary: "Show a document's supersession lineage, or fix one-sided links",
+			Help: "--fix fills in supersedes or superseded-by wherever only the other side of\na link records it. Conflicting values are left alone; validate reports them.",
+			Run:  func(ctx context.Context, args []string) error { return chainCommand(args) }},
+		{Name: "lineage", Usage: "<doc|number> [--format text|json]", Summary: "Print every supersession path through a document, flagging broken links",
+			Help: "Walks supersedes and superseded-by in both directions and prints each path\nfrom the oldest document to the newest, e.g. 0003 → 0017 → 0042. Missing\ndocuments, contradicted links, and cycles are flagged and exit with status 1;\none-sided links and forks are warnings. chain draws the same lineage as a tree.",
+			Run:  func(ctx context.Context, args []string) error { return lineageCommand(args) }},
+		{Name: "graph", Usage: "[--format dot|json]", Summary: "Export the supersession and dependency graph",
+			Help: "Prints a Graphviz digraph with a node per document, colored by state, and\nedges from each document to those it supersedes (solid) or depends on\n(dashed). Render it with: zdp graph | dot -Tsvg > graph.svg",
+			Run:  func(ctx context.Context, args []string) error { return graphCommand(args) }},
+		{Name: "guard", Usage: "install [--force] | uninstall | check [<message-file>]", Summary: "Block commits editing Superseded, Rejected, or Withdrawn documents",
+			Help: "install writes a commit-msg hook running \"zdp guard check\". Commits changing the\nbody of a frozen document are refused unless the message contains the\noverride token (guard.override-token, \"[allow-frozen-edit]\" by default).",
+			Run:  func(ctx context.Context, args []string) error { return guardCommand(args) }},
+		{Name: "assets", Usage: "add <doc> <file> [--alt text] | dedup [--apply] | gc [--apply]", Summary: "Store images once by content hash and remove unused ones",
+			Help: "add stores a file under assets/ and appends an image referencing it to the\ndocument. dedup moves the images documents already use into the store; gc lists\nor removes stored assets no document references.",
+			Run:  func(ctx context.Context, args []string) error { return assetsCommand(args) }},
+		{Name: "search", Usage: "<query> [--state name] [--tag tag] [--rebuild]", Summary: "Search titles, frontmatter and bodies of all documents",
+			Help: "Lists documents containing every word of the query, best matches first.\nMatching ignores case. --state limits the search to one state's directory;\n--tag to documents whose tags field lists the tag. Results are ranked from\nan index in .zdp/index/ that is updated as documents change; --rebuild\nrecreates it.",
+			Run:  func(ctx context.Context, args []string) error { return searchCommand(ctx, args) }},
+		{Name: "federate", Usage: "list | search <term> | stats | export [--out path]", Summary: "Combine documents across design repositories",
+			Run: func(ctx context.Context, args []string) error { return federateCommand(args) }},
+		{Name: "decisions", Usage: "[--out DECISIONS.md] [--json decisions.json]", Summary: "Extract decisions into a decision log",
+			Run: func(ctx context.Context, args []string) error { return decisionsCommand(args) }},
+		{Name: "assemble-spec", Usage: "[--out SPEC.md] [--check]", Summary: "Assemble the language specification from Final documents",
+			Help: "Each chapter listed under spec.chapters in .zdp.yaml takes sections of one\nFinal document, or its whole body. The result is written to spec.output\n(SPEC.md by default) or --out. --check exits with status 1 if the file is\nout of date, without writing it.",
+			Run:  func(ctx context.Context, args []string) error { return assembleSpecCommand(args) }},
+		{Name: "get", Usage: "<doc|number> <field>", Summary: "Print a frontmatter field of a document",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("get", args, 2); err != nil {
+					return err
+				}
+				return getField(args[0], args[1])
+			}},
+		{Name: "set", Usage: "<doc|number> <field> <value>", Summary: "Set a frontmatter field of a document",
+			Help: "Values are quoted when YAML needs it. Setting title, updated, or\nsuperseded-by also updates the document's row in the index. Use\n\"zdp transition\" to change the state.",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("set", args, 3); err != nil {
+					return err
+				}
+				return setField(args[0], args[1], args[2])
+			}},
+		{Name: "unblock", Usage: "[--apply] [--format text|json]", Summary: "List Accepted documents waiting on blocked-by, or make the ready ones Active",
+			Help: "An Accepted document listing proposals in blocked-by can't become Active until\nall of them are Accepted (or Active, Final, or Superseded). --apply makes each\ndocument with nothing left to wait for Active.",
+			Run:  func(ctx context.Context, args []string) error { return unblockCommand(args) }},
+		{Name: "short-ids", Usage: "[--assign] [--format text|json]", Summary: "List short IDs, or give one to every document without one",
+			Help: "A short ID such as tail-calls can be used wherever a document number can,\nincluding wikilinks. new and add give documents one; --assign gives one to\nevery older document, made from its file name.",
+			Run:  func(ctx context.Context, args []string) error { return shortIDsCommand(args) }},
+		{Name: "show", Usage: "<doc|number> [--format text|json]", Summary: "Show a document's metadata and lifecycle history",
+			Run: func(ctx context.Context, args []string) error { return showCommand(ctx, args) }},
+		{Name: "read", Usage: "<doc.md|number> [--no-pager]", Summary: "Render a document in the terminal",
+			Run: func(ctx context.Context, args []string) error { return readCommand(args) }},
+		{Name: "compare", Usage: "<doc|number> <doc|number>", Summary: "Compare two documents section by section",
+			Run: func(ctx context.Context, args []string) error { return compareCommand(args) }},
+		{Name: "supersede", Usage: "<old> <new>", Summary: "Mark a document as superseded by another, linking both",
+			Help: "Sets superseded-by on <old> and adds <old> to supersedes on <new>, transitions\n<old> to Superseded, and refreshes both index entries. Nothing is changed if\nany step fails.",
+			Run: func(ctx context.Context, args []string) error {
+				if err := exactArgs("supersede", args, 2); err != nil {
+					return err
+				}
+				return supersedeDocument(args[0], args[1])
+			}},
+		{Name: "decide", Usage: "--group <n,n,...> --winner <n> [--rationale text]", Summary: "Accept one competing proposal and reject the rest",
+			Run: func(ctx context.Context, args []string) error { return decideCommand(args) }},
+		{Name: "champion", Usage: "[<doc> <person>]", Summary: "Assign a champion, or report missing and inactive ones",
+			Run: func(ctx context.Context, args []string) error { return championCommand(args) }},
+		{Name: "ack", Usage: "<doc> | --report [<doc>]", Summary: "Acknowledge a Final document, or list who hasn't",
+			Run: func(ctx context.Context, args []string) error { return ackCommand(args) }},
+		{Name: "heatmap", Usage: "[--quarters N] [--svg path]", Summary: "Show design activity per component per quarter",
+			Run: func(ctx context.Context, args []string) error { return heatmapCommand(ctx, args) }},
+		{Name: "ide", Usage: "--stdio", Summary: "Serve the JSON editor protocol on stdin/stdout",
+			Run: func(ctx context.Context, args []string) error { return ideCommand(args) }},
+		{Name: "watch", Usage: "[--interval 30s] [--pull] [--notify]", Summary: "Report teammates' new documents and transitions",
+			Run: func(ctx context.Context, args []string) error { return watchCommand(ctx, args) }},
+		{Name: "export", Usage: "[--format html|<renderer>] [--out dir]", Summary: "Export as a static HTML site or a custom format",
+			Run: func(ctx context.Context, args []string) error { return exportCommand(ctx, args) }},
+		{Name: "serve", Usage: "[--addr :8080] [--dir site] [--auto-export] [--interval 30s] [--pull]", Summary: "Serve the site; POST /export regenerates it, GET /health reports health",
+			Run: func(ctx context.Context, args []string) error { return serveCommand(ctx, args) }},
+		{Name: "replace", Usage: "--term <old> --with <new> [--scope body,headings,code] [--partial] [--apply]", Summary: "Replace a term across all documents",
+			Run: func(ctx context.Context, args []string) error { return replaceCommand(args) }},
+		{Name: "terms", Usage: "[--include-code]", Summary: "Report documents using renamed terminology",
+			Run: func(ctx context.Context, args []string) error { return termsCommand(args) }},
+		{Name: "verify-quotes", Usage: "[<doc|number>...] [--fix]", Summary: "Check code blocks copied from other files for drift",
+			Help: "A code block after <!-- zdp:source path#fragment --> is compared with the\nfile it was copied from, relative to the repository root. The fragment is a\ngrammar rule name or a line range such as L10-L20; without one, the whole\nfile. --fix replaces drifted blocks with the source text.",
+			Run:  func(ctx context.Context, args []string) error { return verifyQuotesCommand(args) }},
+		{Name: "conformance", Usage: "[--format text|json]", Summary: "Check linked conformance tests, and list Final documents without any",
+			Help: "Each entry of a document's tests field must name a test in the main\nrepository (conformance.repo, ../zylisp by default): a path or glob, a\npath#ID whose file mentions the ID, or a bare ID mentioned in a file under\nconformance.dir. Missing tests exit with status 1; Final documents without\ntests are warnings, which fail under --strict.",
+			Run:  func(ctx context.Context, args []string) error { return conformanceCommand(args) }},
+		{Name: "effort", Usage: "[--by component|milestone] [--all]", Summary: "Total estimates of open documents per component",
+			Run: func(ctx context.Context, args []string) error { return effortCommand(args) }},
+		{Name: "risks", Usage: "[--out RISKS.md]", Summary: "Collect risks of Active documents into a register",
+			Run: func(ctx context.Context, args []string) error { return risksCommand(args) }},
+		{Name: "deprecations", Usage: "[--out DEPRECATIONS.md]", Summary: "List deprecated language features and their removal plans",
+			Run: func(ctx context.Context, args []string) error { return deprecationsCommand(args) }},
+		{Name: "health", Usage: "[--format text|json] [--badge file.svg] [--min N]", Summary: "Score the repository's health and write a badge",
+			Help: "The score starts at 100 and drops for index problems, validation findings,\nstale drafts, and SLA breaches (review.sla-days, deferral.max-days).\n--min fails the command when the score is lower.",
+			Run:  func(ctx context.Context, args []string) error { return healthCommand(ctx, args) }},
+		{Name: "validate", Usage: "[<doc>...] [--format text|json] [--write-baseline | --no-baseline] | --rules", Summary: "Check documents against built-in rules and policies",
+			Help: "Each finding carries a stable code; --rules lists them. Findings can be\nsuppressed with <!-- zdp:disable CODE --> in a document, or under\nvalidation.suppress in .zdp.yaml. --write-baseline records the current\nfindings in .zdp/baseline.json; later runs report only new ones unless\n--no-baseline is given.",
+			Run:  func(ctx context.Context, args []string) error { return validateCommand(ctx, args) }},
+		{Name: "simulate", Usage: "--config <file> [--format text|json]", Summary: "Check every document against a proposed configuration",
+			Help: "Reports documents whose state the proposed .zdp.yaml removes (stranded), whose\ndirectory it changes (misplaced), and allowed transitions it drops, plus the\nvalidate findings it adds or clears. Nothing is changed. Exits with status 1\nif any document is stranded or misplaced, or a new error is found.",
+			Run:  func(ctx context.Context, args []string) error { return simulateCommand(ctx, args) }},
+		{Name: "repl", Summary: "Run commands interactively, staging changes until commit",
+			Help: "Each line is a zdp command without \"zdp\", run in this process with the\ndocuments kept in memory. Commands that change documents work on a scratch\ncopy, so later commands see their changes; \"status\" shows them, \"commit\"\napplies them as one git commit, and \"discard\" drops them. Hooks run after\nthe commit. Lines can also be piped in; the session then exits with status 4\nif it ends with uncommitted changes, or with the first failed command's\nstatus.",
+			Run:  func(ctx context.Context, args []string) error { return replCommand(args) }},
+		{Name: "journal", Usage: "[<doc|number>] [--action add|renumber|transition|index-sync] [--actor who] [--since YYYY-MM-DD] [--until YYYY-MM-DD] [--format text|json]", Summary: "Query the audit log of adds, renumbers, transitions, and index syncs",
+			Help: "Reads .zdp/journal.jsonl, where add, transition, and update-index append each\nchange they make with the time, the actor (the git user, or --as), and the\npaths it touched. Entries are listed oldest first.",
+			Run:  func(ctx context.Context, args []string) error { return journalCommand(args) }},
+		{Name: "events", Usage: "[--since <ref|YYYY-MM-DD>] [--follow] [--interval 30s]", Summary: "Stream lifecycle events as JSON lines",
+			Run: func(ctx context.Context, args []string) error { return eventsCommand(ctx, args) }},
+		{Name: "bench", Usage: "[--docs 1000,10000] [--keep]", Summary: "Time core operations on synthetic corpora",
+			Run: func(ctx context.Context, args []string) error { return benchCommand(ctx, args) }},
+	}
+}
+
+// findCommand returns the subcommand with the given name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// exactArgs returns a usage error unless the command got n arguments
+func exactArgs(name string, args []string, n int) error {
+	if len(args) != n {
+		cmd := findCommand(name)
+		return errorf(exitUsage, "Usage: zdp %s", strings.TrimSpace(cmd.Name+" "+cmd.Usage))
+	}
+	return nil
+}
+
+// singleDocArg returns the document path named by the only argument of a
+// command, or a usage error
+func singleDocArg(name string, args []string) (string, error) {
+	if err := exactArgs(name, args, 1); err != nil {
+		return "", err
+	}
+	return docPathArg(args[0])
+}
+
+// looksLikeDocument reports whether a command-line word names a document
+// rather than a command, for the older "zdp <doc.md|number> [<new-state>]"
+// forms
+func looksLikeDocument(arg string) bool {
+	if strings.HasSuffix(arg, ".md") || strings.ContainsRune(arg, filepath.Separator) || docNumberArgRe.MatchString(arg) {
+		return true
+	}
+	_, err := os.Stat(repoPath(arg))
+	return err == nil
+}
+
+// runCommand dispatches the command line to its subcommand. A command
+// name always wins, so "zdp index doc.md" can't be read as a transition
+// of a document called "index".
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		opResult.Command = "list"
+		listDocuments()
+		return nil
+	}
+
+	switch args[0] {
+	case "help", "--help", "-h":
+		opResult.Command = "help"
+		return helpCommand(args[1:])
+	}
+
+	if cmd := findCommand(args[0]); cmd != nil {
+		if containsString(args[1:], "--help") || containsString(args[1:], "-h") {
+			printCommandHelp(cmd)
+			return nil
+		}
+		opResult.Command = cmd.Name
+		return cmd.Run(ctx, args[1:])
+	}
+
+	// The older positional forms still work, and say what replaces them
+	if looksLikeDocument(args[0]) && len(args) <= 2 {
+		name := "move"
+		if len(args) == 2 {
+			name = "transition"
+		}
+		shown := make([]string, len(args))
+		for i, arg := range args {
+			shown[i] = arg
+			if strings.ContainsAny(arg, " \t") {
+				shown[i] = strconv.Quote(arg)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Note: \"zdp %s\" is now \"zdp %s %s\"\n", strings.Join(shown, " "), name, strings.Join(shown, " "))
+		opResult.Command = name
+		return findCommand(name).Run(ctx, args)
+	}
+
+	var similar []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.Name, args[0]) || strings.HasPrefix(args[0], cmd.Name) {
+			similar = append(similar, cmd.Name)
+		}
+	}
+	if len(similar) > 0 {
+		return errorf(exitUsage, "Unknown command \"%s\". Did you mean: %s?\nRun \"zdp help\" for a list of commands.", args[0], strings.Join(similar, ", "))
+	}
+	return errorf(exitUsage, "Unknown command \"%s\". Run \"zdp help\" for a list of commands.", args[0])
+}
+
+// helpCommand prints the command list, or the help of one command
+func helpCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage(stdout)
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || len(args) > 1 {
+		return errorf(exitUsage, "Unknown command \"%s\". Run \"zdp help\" for a list of commands.", strings.Join(args, " "))
+	}
+	printCommandHelp(cmd)
+	return nil
+}
+
+// printCommandHelp prints the usage, summary, and details of one command
+func printCommandHelp(cmd *command) {
+	fmt.Fprintf(stdout, "Usage: zdp %s\n\n%s.\n", strings.TrimSpace(cmd.Name+" "+cmd.Usage), cmd.Summary)
+	if cmd.Help != "" {
+		fmt.Fprintf(stdout, "\n%s\n", cmd.Help)
+	}
+	fmt.Fprintln(stdout, "\nGlobal flags (--timeout, --output, --strict) apply too; see \"zdp help\".")
+}
+
+// printUsage prints every command with its summary, and the global flags
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: zdp <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		line := "  " + strings.TrimSpace(cmd.Name+" "+cmd.Usage)
+		if len(line) > 34 {
+			fmt.Fprintln(w, line)
+			line = ""
+		}
+		fmt.Fprintf(w, "%-34s - %s\n", line, cmd.Summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	fmt.Fprintln(w, "  --timeout <duration>             - Abort bulk operations after duration (e.g. 30s)")
+	fmt.Fprintln(w, "  --output <result.json>           - Write a JSON record of what the command changed")
+	fmt.Fprintln(w, "  --strict                         - Treat warnings (skipped files, bad headers) as failures")
+	fmt.Fprintln(w, "  --as <person>                    - Act on someone else's behalf, recording that you did")
+	fmt.Fprintln(w, "  --dry-run                        - Show what a command that edits documents would change")
+	fmt.Fprintln(w, "  --stage                          - Show every change, then commit them all after confirming")
+	fmt.Fprintln(w, "  --yes                            - Commit --stage changes without asking")
+	fmt.Fprintln(w, "  --storage <source>               - Read the repository from somewhere other than the working tree")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"zdp help <command>\" or \"zdp <command> --help\" for details.")
+}